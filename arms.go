@@ -0,0 +1,133 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// armList is an if/else-if/else chain, flattened as its arms in order. Each
+// if arm is a copy of the original without its else, and a final else is its
+// block. This lets nodes match "else $*_" against any number of arms, as in
+// "$*_ else if err != nil { $*_ } else $*_", and "else $_" against one.
+type armList []ast.Stmt
+
+func (l armList) len() int                { return len(l) }
+func (l armList) at(i int) ast.Node       { return l[i] }
+func (l armList) slice(i, j int) nodeList { return l[i:j] }
+func (l armList) Pos() token.Pos          { return l[0].Pos() }
+func (l armList) End() token.Pos          { return l[len(l)-1].End() }
+
+// armsWild returns the wildcard in place of arms which an if statement was
+// parsed from, if any.
+func (m *matcher) armsWild(stmt *ast.IfStmt) *ast.Ident {
+	id, ok := stmt.Cond.(*ast.Ident)
+	if !ok || !m.info(fromWildName(id.Name)).arms {
+		return nil
+	}
+	return id
+}
+
+// hasArmsWild reports whether a pattern is an if/else chain with a wildcard
+// in place of any of its arms.
+func (m *matcher) hasArmsWild(stmt *ast.IfStmt) bool {
+	for {
+		if m.armsWild(stmt) != nil {
+			return true
+		}
+		next, ok := stmt.Else.(*ast.IfStmt)
+		if !ok {
+			return false
+		}
+		stmt = next
+	}
+}
+
+// flattenArms flattens an if/else chain into an armList. In a pattern, a
+// wildcard in place of arms is kept as its ident, which nodes knows how to
+// match.
+func (m *matcher) flattenArms(stmt *ast.IfStmt) armList {
+	var arms armList
+	for {
+		if id := m.armsWild(stmt); id != nil {
+			arms = append(arms, &ast.ExprStmt{X: id})
+		} else {
+			arm := *stmt
+			arm.Else = nil
+			arms = append(arms, &arm)
+		}
+		switch x := stmt.Else.(type) {
+		case *ast.IfStmt:
+			stmt = x
+			continue
+		case *ast.BlockStmt:
+			arms = append(arms, x)
+		}
+		return arms
+	}
+}
+
+// armsMatch matches a pattern which hasArmsWild against a node. Only whole
+// chains match, so that a chain doesn't match again as any of its else ifs.
+func (m *matcher) armsMatch(stmt *ast.IfStmt, node ast.Node) bool {
+	y, ok := node.(*ast.IfStmt)
+	if !ok {
+		return false
+	}
+	if parent, ok := m.parentOf(y).(*ast.IfStmt); ok && parent.Else == y {
+		return false
+	}
+	return m.nodesMatch(m.flattenArms(stmt), m.flattenArms(y))
+}
+
+// fillArms replaces an if/else chain in a pattern by one with the arms
+// matched by its wildcards in their place. The other arms are left to
+// fillValues.
+func (m *matcher) fillArms(stmt *ast.IfStmt, values map[string]ast.Node) ast.Stmt {
+	var arms armList
+	for _, arm := range m.flattenArms(stmt) {
+		es, ok := arm.(*ast.ExprStmt)
+		if !ok {
+			arms = append(arms, arm)
+			continue
+		}
+		info := m.info(fromWildNode(es.X))
+		switch prev := values[info.name].(type) {
+		case armList:
+			arms = append(arms, prev...)
+		case *ast.IfStmt, *ast.BlockStmt:
+			arms = append(arms, prev.(ast.Stmt))
+		default:
+			m.failf(es.X, "$%s didn't match arms of an if", info.name)
+		}
+	}
+	if len(arms) == 0 {
+		m.failf(stmt, "no arms of the if left")
+	}
+	return m.rebuildArms(arms)
+}
+
+// rebuildArms joins the arms of an armList back into an if/else chain. The
+// arms are copied, as the list may still be used elsewhere.
+func (m *matcher) rebuildArms(arms armList) ast.Stmt {
+	var chain ast.Stmt
+	for i := len(arms) - 1; i >= 0; i-- {
+		switch x := arms[i].(type) {
+		case *ast.IfStmt:
+			arm := *x
+			arm.Else = chain
+			chain = &arm
+		case *ast.BlockStmt:
+			if chain != nil {
+				m.failf(x, "an else block must be the last arm of an if")
+			}
+			chain = x
+		}
+	}
+	if _, ok := chain.(*ast.BlockStmt); ok {
+		m.failf(chain, "an else block can't be the first arm of an if")
+	}
+	return chain
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// unsafeFunc groups the unsafe conversions within a func, or at the package
+// level if decl is nil.
+type unsafeFunc struct {
+	decl    *ast.FuncDecl
+	fn      *types.Func
+	uses    []ast.Node
+	callers []ast.Node
+}
+
+// printUnsafe reports the unsafe conversions in the packages, such as those
+// to or from unsafe.Pointer or to reflect.SliceHeader, along with the calls
+// to the unsafe funcs like unsafe.Slice. They are grouped by their enclosing
+// func, listing its receiver and its direct callers in the loaded packages.
+func (m *matcher) printUnsafe(pkgs []loadPkg) {
+	var funcs []*unsafeFunc
+	byFunc := make(map[*types.Func]*unsafeFunc)
+	pkgLevel := make(map[string]*unsafeFunc)
+	for i := range pkgs {
+		info := &pkgs[i].info
+		for _, root := range pkgs[i].nodes {
+			var decl *ast.FuncDecl
+			inspect(root, func(node ast.Node) bool {
+				if d, ok := node.(*ast.FuncDecl); ok {
+					decl = d
+				}
+				call, ok := node.(*ast.CallExpr)
+				if !ok || !unsafeCall(info, call) {
+					return true
+				}
+				var uf *unsafeFunc
+				if decl != nil && decl.Pos() <= call.Pos() && call.End() <= decl.End() {
+					fn, _ := info.Defs[decl.Name].(*types.Func)
+					if uf = byFunc[fn]; uf == nil {
+						uf = &unsafeFunc{decl: decl, fn: fn}
+						byFunc[fn] = uf
+						funcs = append(funcs, uf)
+					}
+				} else if uf = pkgLevel[pkgs[i].path]; uf == nil {
+					uf = &unsafeFunc{}
+					pkgLevel[pkgs[i].path] = uf
+					funcs = append(funcs, uf)
+				}
+				uf.uses = append(uf.uses, call)
+				return false // nested conversions are part of this one
+			})
+		}
+	}
+	m.parents = make(map[ast.Node]ast.Node) // to find the callers' funcs
+	for i := range pkgs {
+		info := &pkgs[i].info
+		m.fillParents(pkgs[i].nodes...)
+		for _, root := range pkgs[i].nodes {
+			inspect(root, func(node ast.Node) bool {
+				call, ok := node.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				fn, _ := calleeObj(info, call).(*types.Func)
+				if uf := byFunc[fn]; uf != nil && fn != nil {
+					uf.callers = append(uf.callers, call)
+				}
+				return true
+			})
+		}
+	}
+	sort.Slice(funcs, func(i, j int) bool {
+		return funcs[i].uses[0].Pos() < funcs[j].uses[0].Pos()
+	})
+	for i, uf := range funcs {
+		if i > 0 {
+			fmt.Fprintln(m.out)
+		}
+		if uf.decl == nil {
+			fmt.Fprintf(m.out, "%v: package level\n", m.position(uf.uses[0].Pos()))
+		} else {
+			fmt.Fprintf(m.out, "%v: func %s\n", m.position(uf.decl.Pos()), uf.fn.FullName())
+			if recv := uf.fn.Type().(*types.Signature).Recv(); recv != nil {
+				fmt.Fprintf(m.out, "  receiver: %s\n", recv.Type())
+			}
+			callers := make([]string, len(uf.callers))
+			for i, call := range uf.callers {
+				callers[i] = m.callerString(call)
+			}
+			if len(callers) == 0 {
+				callers = append(callers, "none")
+			}
+			fmt.Fprintf(m.out, "  callers: %s\n", strings.Join(callers, ", "))
+		}
+		for _, use := range uf.uses {
+			fmt.Fprintf(m.out, "  %v: %s\n", m.position(use.Pos()), singleLinePrint(use))
+		}
+	}
+}
+
+// callerString describes a call by its position and enclosing func.
+func (m *matcher) callerString(call ast.Node) string {
+	pos := m.position(call.Pos()).String()
+	if decl, ok := m.enclosingDecl(call).(*ast.FuncDecl); ok {
+		return pos + " (" + decl.Name.Name + ")"
+	}
+	return pos
+}
+
+// unsafeCall reports whether a call is an unsafe conversion or a call to one
+// of the unsafe funcs which work with pointers, like unsafe.Slice. Sizeof,
+// Alignof, and Offsetof are not unsafe, as they don't touch any memory.
+func unsafeCall(info *types.Info, call *ast.CallExpr) bool {
+	if tv, ok := info.Types[call.Fun]; ok && tv.IsType() {
+		if len(call.Args) != 1 {
+			return false
+		}
+		return isUnsafeType(tv.Type) || isUnsafeType(info.TypeOf(call.Args[0]))
+	}
+	sel, ok := astutil.Unparen(call.Fun).(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	pkg, ok := info.Uses[x].(*types.PkgName)
+	if !ok || pkg.Imported().Path() != "unsafe" {
+		return false
+	}
+	switch sel.Sel.Name {
+	case "Sizeof", "Alignof", "Offsetof":
+		return false
+	}
+	return true
+}
+
+// isUnsafeType reports whether a type is unsafe.Pointer, or a pointer to
+// one of the reflect headers describing the memory of slices and strings.
+func isUnsafeType(t types.Type) bool {
+	if b, ok := t.(*types.Basic); ok {
+		return b.Kind() == types.UnsafePointer
+	}
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	if obj.Pkg() == nil || obj.Pkg().Path() != "reflect" {
+		return false
+	}
+	return obj.Name() == "SliceHeader" || obj.Name() == "StringHeader"
+}
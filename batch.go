@@ -0,0 +1,236 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// runBatched runs a query on a few packages at a time, printing the matches
+// of each batch before loading the next one, so that the syntax trees and
+// type information of the packages already done can be freed.
+//
+// With -checkpoint, one package is matched at a time, and each batch is
+// recorded in the checkpoint file once done. With -resume, the packages
+// recorded by a previous run of the same query are skipped, and their output
+// is printed again. The file is removed once all the packages are done.
+//
+// With -max-mem, batches grow as long as the memory used by the packages
+// loaded so far stays within the limit.
+//
+// With -max-time, no more batches are matched once the time is up, and the
+// packages left are reported as an error, marking the results as partial.
+// Packages are matched in the order of the config file's priority list, so
+// that the most relevant results come first.
+//
+// With -cache, one package is matched at a time, and the results of each are
+// cached by a hash of its source and the query. Packages with cached results
+// aren't loaded at all, and their output is printed again.
+//
+// As a batch only sees its own packages, commands which need all of them at
+// once, like -refs or -callers, can't be batched.
+func (m *matcher) runBatched(cmds []exprCmd, args, paths []string) error {
+	flagName := "-checkpoint"
+	switch {
+	case m.maxMem > 0:
+		flagName = "-max-mem"
+	case m.cacheDir != "" && m.checkpointPath == "":
+		flagName = "-cache"
+	case m.maxTime > 0 && m.checkpointPath == "":
+		flagName = "-max-time"
+	}
+	switch {
+	case m.resume && m.checkpointPath == "":
+		return fmt.Errorf("-resume needs -checkpoint")
+	case m.recursive:
+		return fmt.Errorf("cannot use %s with -r", flagName)
+	case m.sarif || m.rdjson || m.rdjsonl || m.ctags != "" || m.etags != "" ||
+		m.countBy != "" || m.planPath != "" || m.profile || m.cloneSize > 0 || m.unsafeReport:
+		return fmt.Errorf("%s only supports printing matches and rule matches", flagName)
+	}
+	if cmd := m.wholeProgramCmd(cmds); cmd != nil {
+		// each batch would only see its own packages
+		return fmt.Errorf("cannot use %s with -%s, as it needs all the packages at once",
+			flagName, cmd.name)
+	}
+	if !reflect.DeepEqual(args[len(args)-len(paths):], paths) {
+		return fmt.Errorf("%s needs the packages after all flags and commands", flagName)
+	}
+	for _, path := range paths {
+		if isBuildLabel(path) {
+			return fmt.Errorf("cannot use %s with build labels", flagName)
+		}
+	}
+	query := checkpointQuery(args)
+	cp := checkpoint{Args: query, Done: []checkpointDone{}}
+	if m.resume {
+		var err error
+		if cp, err = readCheckpoint(m.checkpointPath, query); err != nil {
+			return err
+		}
+	}
+	done := make(map[string]bool)
+	exitCode := 0
+	for _, d := range cp.Done {
+		for _, pkg := range d.Packages {
+			done[pkg] = true
+		}
+		io.WriteString(m.out, d.Output)
+		for _, msg := range d.Errors {
+			m.pkgErrs = append(m.pkgErrs, errors.New(msg))
+		}
+		if d.ExitCode > exitCode {
+			exitCode = d.ExitCode
+		}
+	}
+	var left []string
+	for _, pkg := range m.loader.importPaths(paths) {
+		if !done[pkg] {
+			left = append(left, pkg)
+		}
+	}
+	m.reportSkipped()
+	left = m.prioritize(left)
+	total := len(done) + len(left)
+	cpPath, maxMem, cacheDir := m.checkpointPath, m.maxMem, m.cacheDir
+	start, maxTime := time.Now(), m.maxTime
+	flags := query[:len(query)-len(paths)]
+	out := m.out
+	defer func() { m.out = out }()
+	m.batching = true
+	defer func() { m.batching = false }()
+	size := 1
+	base := heapInUse()
+	for len(left) > 0 {
+		if maxTime > 0 && time.Since(start) >= maxTime {
+			m.exitCode = exitCode
+			m.pkgErrs = append(m.pkgErrs, fmt.Errorf(
+				"partial results: %d of %d packages left unmatched after -max-time %v",
+				len(left), total, maxTime))
+			return nil // keep the checkpoint, to resume later
+		}
+		if size > len(left) {
+			size = len(left)
+		}
+		if cacheDir != "" {
+			size = 1
+		}
+		batch := left[:size]
+		left = left[size:]
+		key := ""
+		if cacheDir != "" {
+			// if the key can't be computed, such as when the package
+			// can't be found, match it without caching
+			key, _ = m.cacheKey(flags, batch[0])
+		}
+		entry, cached := checkpointDone{}, false
+		if key != "" {
+			entry, cached = readCacheEntry(cacheDir, key)
+		}
+		if cached {
+			entry.Packages = batch
+			io.WriteString(out, entry.Output)
+			for _, msg := range entry.Errors {
+				m.pkgErrs = append(m.pkgErrs, errors.New(msg))
+			}
+		} else {
+			var buf bytes.Buffer
+			m.out = out
+			if cpPath != "" || key != "" {
+				m.out = io.MultiWriter(out, &buf)
+			}
+			errs := m.pkgErrs
+			m.pkgErrs = nil
+			if err := m.searchArgs(append(flags[:len(flags):len(flags)], batch...)); err != nil {
+				return err
+			}
+			entry = checkpointDone{
+				Packages: batch,
+				Output:   buf.String(),
+				Errors:   errorStrings(m.pkgErrs),
+				ExitCode: m.exitCode,
+			}
+			m.pkgErrs = append(errs, m.pkgErrs...)
+			if key != "" {
+				if err := writeCacheEntry(cacheDir, key, entry); err != nil {
+					return err
+				}
+			}
+		}
+		if entry.ExitCode > exitCode {
+			exitCode = entry.ExitCode
+		}
+		if cpPath != "" {
+			cp.Done = append(cp.Done, entry)
+			if err := writeCheckpoint(cpPath, cp); err != nil {
+				return err
+			}
+		}
+		if cached {
+			continue
+		}
+		if maxMem > 0 {
+			// size the next batch by how much memory each
+			// package of this one used, leaving some room; if
+			// the heap shrank, there's nothing to go by
+			if now := heapInUse(); now > base {
+				perPkg := (now - base) / uint64(len(batch))
+				size = 1
+				if perPkg > 0 && maxMem > base {
+					size = int((maxMem - base) / perPkg * 3 / 4)
+				}
+				if size < 1 {
+					size = 1
+				}
+			}
+		}
+		m.pkgs, m.loader = nil, nodeLoader{}
+	}
+	m.exitCode = exitCode
+	if cpPath != "" {
+		return os.Remove(cpPath)
+	}
+	return nil
+}
+
+// heapInUse returns the bytes used by live heap objects.
+func heapInUse() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// memFlag parses a memory size given to -max-mem, such as "512MiB" or "2G",
+// where the units are powers of 1024.
+type memFlag struct {
+	size *uint64
+}
+
+func (f *memFlag) String() string { return "" }
+func (f *memFlag) Set(val string) error {
+	s := strings.TrimSuffix(strings.TrimSuffix(strings.ToUpper(val), "B"), "I")
+	shift := uint(0)
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+			shift = uint(i+1) * 10
+			s = s[:n-1]
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return fmt.Errorf("memory size %q is not like 512MiB or 2G", val)
+	}
+	*f.size = n << shift
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// bazelCmd is the Bazel command queried for the Go targets of build labels.
+var bazelCmd = "bazel"
+
+// buildTarget is a Go target of a build system, loaded as a package made of
+// exactly its source files.
+type buildTarget struct {
+	label      string
+	importPath string
+	files      []string
+}
+
+// isBuildLabel reports whether an argument is a Bazel label, such as
+// "//pkg/foo:go_default_library", "//pkg/...", or "@repo//pkg", instead of
+// a Go package pattern or file.
+func isBuildLabel(arg string) bool {
+	return strings.HasPrefix(arg, "//") || strings.HasPrefix(arg, "@")
+}
+
+// bazelQuery is the subset of the XML output of "bazel query" needed to find
+// the sources of Go targets.
+type bazelQuery struct {
+	Rules []struct {
+		Class string `xml:"class,attr"`
+		Name  string `xml:"name,attr"`
+		Lists []struct {
+			Name    string     `xml:"name,attr"`
+			Labels  []xmlValue `xml:"label"`
+			Strings []xmlValue `xml:"string"`
+		} `xml:"list"`
+		Strings []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"string"`
+	} `xml:"rule"`
+}
+
+type xmlValue struct {
+	Value string `xml:"value,attr"`
+}
+
+// buildTargets takes the build labels out of args, querying Bazel for the Go
+// libraries, binaries, and tests they match, and records their sources to be
+// loaded along with the rest of args. Source files are kept if they would be
+// compiled, following their build constraints plus the -tags given to the
+// target's gc_goopts. Generated sources and those in external repositories
+// are skipped, as they aren't in the workspace.
+func (l *nodeLoader) buildTargets(args []string) ([]string, error) {
+	var rest, labels []string
+	for _, arg := range args {
+		if isBuildLabel(arg) {
+			labels = append(labels, arg)
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	l.targets = nil
+	if len(labels) == 0 {
+		return args, nil
+	}
+	root, err := l.bazel("info", "workspace")
+	if err != nil {
+		return nil, err
+	}
+	root = strings.TrimSpace(root)
+	expr := fmt.Sprintf(`kind("go_(library|binary|test) rule", set(%s))`, strings.Join(labels, " "))
+	out, err := l.bazel("query", "--output=xml", expr)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(out, "<?xml") {
+		// bazel declares XML 1.1, which encoding/xml refuses
+		out = out[strings.Index(out, "?>")+2:]
+	}
+	var query bazelQuery
+	if err := xml.Unmarshal([]byte(out), &query); err != nil {
+		return nil, fmt.Errorf("cannot parse bazel query output: %v", err)
+	}
+	for _, rule := range query.Rules {
+		target := buildTarget{label: rule.Name, importPath: rule.Name}
+		for _, s := range rule.Strings {
+			if s.Name == "importpath" && s.Value != "" {
+				target.importPath = s.Value
+			}
+		}
+		ctx := *l.ctx
+		var srcs []string
+		for _, list := range rule.Lists {
+			switch list.Name {
+			case "srcs":
+				for _, label := range list.Labels {
+					srcs = append(srcs, label.Value)
+				}
+			case "gc_goopts":
+				for _, opt := range list.Strings {
+					ctx.BuildTags = append(ctx.BuildTags, goTags(opt.Value)...)
+				}
+			}
+		}
+		for _, src := range srcs {
+			path, ok := labelPath(root, src)
+			if !ok || !strings.HasSuffix(path, ".go") {
+				continue
+			}
+			if _, err := os.Stat(path); err != nil {
+				continue // a generated file
+			}
+			if match, err := ctx.MatchFile(filepath.Dir(path), filepath.Base(path)); err != nil || !match {
+				continue
+			}
+			target.files = append(target.files, path)
+		}
+		if len(target.files) > 0 {
+			l.targets = append(l.targets, target)
+		}
+	}
+	if len(l.targets) == 0 {
+		return nil, fmt.Errorf("no Go targets with sources found in %s", strings.Join(labels, " "))
+	}
+	var kept []buildTarget
+	for _, target := range l.targets {
+		if l.shard.keeps(target.label) {
+			kept = append(kept, target)
+		}
+	}
+	l.targets = kept
+	return rest, nil
+}
+
+// bazel runs a Bazel command in the working directory, returning its output.
+func (l *nodeLoader) bazel(args ...string) (string, error) {
+	cmd := exec.Command(bazelCmd, args...)
+	cmd.Dir = l.wd
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if i := strings.LastIndexByte(msg, '\n'); i >= 0 {
+			msg = msg[i+1:] // bazel prints its progress first
+		}
+		return "", fmt.Errorf("%s %s: %v: %s", bazelCmd, args[0], err, msg)
+	}
+	return string(out), nil
+}
+
+// labelPath returns the path of a source file label within a workspace, such
+// as root/pkg/foo/a.go for "//pkg/foo:a.go". Labels in external
+// repositories have no path.
+func labelPath(root, label string) (string, bool) {
+	if !strings.HasPrefix(label, "//") {
+		return "", false
+	}
+	label = label[2:]
+	pkg, name := label, ""
+	if i := strings.IndexByte(label, ':'); i >= 0 {
+		pkg, name = label[:i], label[i+1:]
+	}
+	if name == "" {
+		return "", false
+	}
+	return filepath.Join(root, filepath.FromSlash(pkg), filepath.FromSlash(name)), true
+}
+
+// goTags returns the build tags given by a compiler option like "-tags=a,b".
+func goTags(opt string) []string {
+	opt = strings.TrimLeft(opt, "-")
+	if !strings.HasPrefix(opt, "tags=") {
+		return nil
+	}
+	opt = opt[len("tags="):]
+	return strings.FieldsFunc(opt, func(r rune) bool { return r == ',' || r == ' ' })
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// operandList is a chain of binary expressions with the same operator, such
+// as a + b + c, flattened as its operands in order. This lets nodes match
+// $*_ against any number of operands, as in `$*_ + "secret" + $*_`, which
+// nested binary expressions can't express as each holds two operands.
+type operandList struct {
+	op   token.Token
+	list []ast.Expr
+}
+
+func (l operandList) len() int                { return len(l.list) }
+func (l operandList) at(i int) ast.Node       { return l.list[i] }
+func (l operandList) slice(i, j int) nodeList { return operandList{l.op, l.list[i:j]} }
+func (l operandList) Pos() token.Pos          { return l.list[0].Pos() }
+func (l operandList) End() token.Pos          { return l.list[len(l.list)-1].End() }
+
+// flattenOperands flattens a binary expression into an operandList. As the
+// operators are left-associative, only its left operand may be another of
+// the chain; any operand in parentheses is kept as is.
+func flattenOperands(expr *ast.BinaryExpr) operandList {
+	var list []ast.Expr
+	for {
+		list = append(list, expr.Y)
+		x, ok := expr.X.(*ast.BinaryExpr)
+		if !ok || x.Op != expr.Op {
+			list = append(list, expr.X)
+			break
+		}
+		expr = x
+	}
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+	return operandList{expr.Op, list}
+}
+
+// hasOperandsWild reports whether a pattern is a chain of binary expressions
+// with a $*name in place of any of its operands.
+func (m *matcher) hasOperandsWild(expr *ast.BinaryExpr) bool {
+	for _, operand := range flattenOperands(expr).list {
+		if m.info(fromWildNode(operand)).any {
+			return true
+		}
+	}
+	return false
+}
+
+// operandsMatch matches a pattern which hasOperandsWild against a node. Only
+// whole chains match, so that a + b + c doesn't match again as its a + b.
+func (m *matcher) operandsMatch(expr *ast.BinaryExpr, node ast.Node) bool {
+	y, ok := node.(*ast.BinaryExpr)
+	if !ok || expr.Op != y.Op {
+		return false
+	}
+	if parent, ok := m.parentOf(y).(*ast.BinaryExpr); ok && parent.Op == y.Op && parent.X == y {
+		return false
+	}
+	return m.nodesMatch(flattenOperands(expr), flattenOperands(y))
+}
+
+// fillOperands replaces a chain of binary expressions in a pattern by one
+// with the operands matched by its $*name wildcards in their place. The
+// other operands are left to fillValues.
+func (m *matcher) fillOperands(expr *ast.BinaryExpr, values map[string]ast.Node) ast.Expr {
+	var list []ast.Expr
+	for _, operand := range flattenOperands(expr).list {
+		info := m.info(fromWildNode(operand))
+		if !info.any {
+			list = append(list, operand)
+			continue
+		}
+		prev, ok := values[info.name].(operandList)
+		if !ok || prev.op != expr.Op {
+			m.failf(operand, "$*%s didn't match operands of %s", info.name, expr.Op)
+		}
+		list = append(list, prev.list...)
+	}
+	if len(list) == 0 {
+		m.failf(expr, "no operands of %s left", expr.Op)
+	}
+	x := list[0]
+	for _, operand := range list[1:] {
+		x = &ast.BinaryExpr{X: x, Op: expr.Op, Y: operand}
+	}
+	return x
+}
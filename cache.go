@@ -0,0 +1,189 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// cacheVersion is part of every cache key, to be bumped whenever the format
+// of the entries or what goes into their keys changes.
+const cacheVersion = "gogrep cache v1"
+
+// cacheKey returns the key of a package's results for a query given via
+// -cache, which is a hash of everything that the results depend on: the
+// query and the files it reads, such as those given to -rules and the config
+// file, the build context, the gogrep binary, and the package's source
+// files. If the query needs type information, the files of the package's
+// dependencies are included too, except for those in GOROOT, as they're
+// covered by the Go version.
+//
+// The packages importing it aren't included, so the results of commands
+// looking at them, like -refs or -callers, would go stale. runBatched
+// refuses to run those with -cache, so they never reach the cache.
+func (m *matcher) cacheKey(flags []string, path string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	field := func(s string) {
+		fmt.Fprintf(h, "%d:%s\n", len(s), s)
+	}
+	field(cacheVersion)
+	field(runtime.Version())
+	if exe, err := os.Executable(); err == nil {
+		if info, err := os.Stat(exe); err == nil {
+			field(fmt.Sprint(info.Size(), info.ModTime().UnixNano()))
+		}
+	}
+	ctx := m.ctx
+	field(fmt.Sprint(ctx.GOOS, ctx.GOARCH, ctx.CgoEnabled, ctx.BuildTags, m.typed))
+	field(wd)
+	var files []string // the config, rules, and command files
+	if m.config != nil {
+		files = append(files, m.config.path)
+	}
+	if m.rulesPath != "" {
+		files = append(files, strings.Split(m.rulesPath, ",")...)
+	}
+	for i, arg := range flags {
+		field(arg)
+		switch {
+		case (arg == "-f" || arg == "--f") && i+1 < len(flags):
+			files = append(files, flags[i+1])
+		case strings.HasPrefix(arg, "-f="), strings.HasPrefix(arg, "--f="):
+			files = append(files, arg[strings.IndexByte(arg, '=')+1:])
+		}
+	}
+	for _, name := range files {
+		field(name)
+		if err := hashFile(h, name); err != nil {
+			return "", err
+		}
+	}
+	field(path)
+	done := make(map[string]bool)
+	var addPkg func(path, srcDir string, direct bool) error // to recurse into self
+	addPkg = func(path, srcDir string, direct bool) error {
+		pkg, err := ctx.Import(path, srcDir, 0)
+		if err != nil {
+			if _, ok := err.(*build.NoGoError); ok || !direct {
+				// the loader reports it, if it matters
+				field("error: " + err.Error())
+				return nil
+			}
+			return err
+		}
+		if done[pkg.Dir] || (pkg.Goroot && !direct) {
+			return nil
+		}
+		done[pkg.Dir] = true
+		field(pkg.ImportPath)
+		var names []string
+		for _, list := range [...][]string{
+			pkg.GoFiles, pkg.CgoFiles, pkg.IgnoredGoFiles,
+			pkg.TestGoFiles, pkg.XTestGoFiles,
+		} {
+			names = append(names, list...)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			field(name)
+			if err := hashFile(h, filepath.Join(pkg.Dir, name)); err != nil {
+				return err
+			}
+		}
+		if !m.typed {
+			return nil
+		}
+		imports := pkg.Imports
+		if direct {
+			imports = append(imports, pkg.TestImports...)
+			imports = append(imports, pkg.XTestImports...)
+		}
+		for _, ipath := range imports {
+			if ipath == "C" || ipath == "unsafe" {
+				continue
+			}
+			if err := addPkg(ipath, pkg.Dir, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := addPkg(path, wd, true); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// cachePath returns where the entry with a key is stored. Like with Go's
+// build cache, entries are spread over subdirectories by their first byte.
+func cachePath(dir, key string) string {
+	return filepath.Join(dir, key[:2], key+".json")
+}
+
+// readCacheEntry returns the results cached for a package, which are like
+// the batches of a checkpoint, and whether there were any.
+func readCacheEntry(dir, key string) (checkpointDone, bool) {
+	var entry checkpointDone
+	data, err := ioutil.ReadFile(cachePath(dir, key))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return entry, false // treat a corrupt entry as missing
+	}
+	return entry, true
+}
+
+// writeCacheEntry caches the results for a package, such that concurrent
+// runs never see a partially written entry.
+func writeCacheEntry(dir, key string, entry checkpointDone) error {
+	path := cachePath(dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
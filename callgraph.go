@@ -0,0 +1,198 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/ssa"
+	"golang.org/x/tools/go/ssa/ssautil"
+)
+
+// program returns the SSA form of the loaded program, building it the first
+// time it's needed. Debug information is kept, so that syntax trees can be
+// mapped to SSA values. Only the function bodies of the packages being
+// matched are built, as building all of the dependencies would be slow.
+func (l *nodeLoader) program() *ssa.Program {
+	if l.ssaProg == nil {
+		l.ssaProg = ssautil.CreateProgram(l.prog, ssa.GlobalDebug)
+		for _, tpkg := range l.matched {
+			l.ssaProg.Package(tpkg).Build()
+		}
+	}
+	return l.ssaProg
+}
+
+// callGraph returns the call graph of the loaded program, building it the
+// first time it's needed. It uses class hierarchy analysis, so that dynamic
+// calls via interfaces and func values are included, even if some of them
+// might never happen.
+func (l *nodeLoader) callGraph() (*ssa.Program, *callgraph.Graph) {
+	if l.graph == nil {
+		l.graph = cha.CallGraph(l.program())
+	}
+	return l.ssaProg, l.graph
+}
+
+// cmdCalls replaces each submatch referring to a function with the
+// declarations of its callers or callees, depending on the command. The
+// command's value is the maximum depth to follow, with 0 meaning no limit.
+func (m *matcher) cmdCalls(cmd exprCmd, subs []submatch) []submatch {
+	prog, graph := m.loader.callGraph()
+	depth := cmd.value.(int)
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	for _, sub := range subs {
+		fn, ok := m.objectOf(sub.node).(*types.Func)
+		if !ok {
+			continue
+		}
+		start := graph.Nodes[prog.FuncValue(fn)]
+		if start == nil {
+			continue
+		}
+		visited := map[*callgraph.Node]bool{start: true}
+		level := []*callgraph.Node{start}
+		for i := 0; len(level) > 0 && (depth == 0 || i < depth); i++ {
+			var next []*callgraph.Node
+			for _, node := range level {
+				edges := node.Out
+				if cmd.name == "callers" {
+					edges = node.In
+				}
+				for _, edge := range edges {
+					other := edge.Callee
+					if cmd.name == "callers" {
+						other = edge.Caller
+					}
+					if visited[other] {
+						continue
+					}
+					visited[other] = true
+					next = append(next, other)
+					syntax := m.funcSyntax(other.Func)
+					if syntax == nil {
+						continue // synthetic or without source
+					}
+					hash := posHash(syntax)
+					if seen[hash] {
+						continue
+					}
+					seen[hash] = true
+					matches = append(matches, submatch{
+						node:   syntax,
+						values: valsCopy(sub.values),
+					})
+				}
+			}
+			level = next
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].node.Pos() < matches[j].node.Pos()
+	})
+	return matches
+}
+
+// cmdReach discards the submatches which aren't within a function reachable
+// from the roots, which are the functions matching the command's pattern
+// anywhere in the loaded packages. Init functions are always roots, and nodes
+// outside of any function are always kept.
+func (m *matcher) cmdReach(cmd exprCmd, subs []submatch) []submatch {
+	prog, _ := m.loader.callGraph()
+	reachable := m.reachableFrom(cmd)
+	var matches []submatch
+	for _, sub := range subs {
+		fn := m.ssaFunc(prog, sub.node)
+		if fn == nil {
+			matches = append(matches, sub)
+			continue
+		}
+		// func literals are reachable if their parents are
+		for ; fn != nil; fn = fn.Parent() {
+			if reachable[fn] {
+				matches = append(matches, sub)
+				break
+			}
+		}
+	}
+	return matches
+}
+
+// reachableFrom returns the functions reachable from the roots of a -reach
+// command. They're found the first time they're needed, as the roots are
+// searched for in all the loaded packages.
+func (m *matcher) reachableFrom(cmd exprCmd) map[*ssa.Function]bool {
+	if reachable := m.loader.reachable[cmd.src]; reachable != nil {
+		return reachable
+	}
+	prog, graph := m.loader.callGraph()
+	reachable := map[*ssa.Function]bool{}
+	var queue []*ssa.Function
+	addRoot := func(fn *ssa.Function) {
+		if fn != nil && !reachable[fn] {
+			reachable[fn] = true
+			queue = append(queue, fn)
+		}
+	}
+	// the roots are matched like -x, one package at a time, which
+	// mustn't affect the package being matched
+	info, parents, pkgFiles := m.Info, m.parents, m.pkgFiles
+	pattern := []exprCmd{{name: "x", src: cmd.src, value: cmd.value}}
+	for _, pkg := range m.pkgs {
+		m.Info = pkg.info
+		roots, errs := m.tryMatchSubs(pattern, pkg.nodes)
+		m.reportMatchErrors(errs)
+		for _, sub := range roots {
+			addRoot(m.ssaFunc(prog, sub.node))
+		}
+	}
+	m.Info, m.parents, m.pkgFiles = info, parents, pkgFiles
+	for _, tpkg := range m.loader.matched {
+		addRoot(prog.Package(tpkg).Func("init"))
+	}
+	for len(queue) > 0 {
+		fn := queue[0]
+		queue = queue[1:]
+		node := graph.Nodes[fn]
+		if node == nil {
+			continue
+		}
+		for _, edge := range node.Out {
+			addRoot(edge.Callee.Func)
+		}
+	}
+	if m.loader.reachable == nil {
+		m.loader.reachable = make(map[string]map[*ssa.Function]bool)
+	}
+	m.loader.reachable[cmd.src] = reachable
+	return reachable
+}
+
+// funcSyntax returns the *ast.FuncDecl or *ast.FuncLit declaring a function.
+// The SSA functions only keep their syntax trees in debug mode, so we find
+// them by position instead.
+func (m *matcher) funcSyntax(fn *ssa.Function) ast.Node {
+	if fn.Synthetic != "" {
+		return nil
+	}
+	f := m.fileAt(fn.Pos())
+	if f == nil {
+		return nil
+	}
+	path, _ := astutil.PathEnclosingInterval(f, fn.Pos(), fn.Pos())
+	for _, node := range path {
+		switch node.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+			m.ensureParents(f)
+			return node
+		}
+	}
+	return nil
+}
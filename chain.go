@@ -0,0 +1,134 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// chainList is a chain of selectors and method calls such as
+// b.Foo(1).Bar().Close(), flattened as its base, b, followed by each of its
+// links, like .Foo(1). This lets nodes match $*_ against any number of links,
+// as in "$x.$*_.Close()".
+//
+// The links are copies of the originals whose X is a placeholder, so that
+// they match and print on their own. A wildcard's value is a slice of the
+// list, which cmdSubst rebuilds into a chain.
+type chainList []ast.Node
+
+func (l chainList) len() int                { return len(l) }
+func (l chainList) at(i int) ast.Node       { return l[i] }
+func (l chainList) slice(i, j int) nodeList { return l[i:j] }
+func (l chainList) Pos() token.Pos          { return l[0].Pos() }
+func (l chainList) End() token.Pos          { return l[len(l)-1].End() }
+
+// hasChainWild reports whether a pattern is a chain of selectors and method
+// calls with a $*name in place of a selector, as in "$x.$*_.Close()", or in
+// place of its base, as in "$*path.Port", which matches the selector paths
+// of any depth ending in Port.
+func (m *matcher) hasChainWild(expr ast.Node) bool {
+	for {
+		switch x := expr.(type) {
+		case *ast.SelectorExpr:
+			if m.info(fromWildName(x.Sel.Name)).any {
+				return true
+			}
+			expr = x.X
+		case *ast.CallExpr:
+			sel, ok := x.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return false
+			}
+			expr = sel.X
+		default:
+			return m.info(fromWildNode(x)).any
+		}
+	}
+}
+
+// flattenChain flattens an expression into a chainList. In a pattern, a
+// $*name selector is kept as its wildcard ident, which nodes knows how to
+// match. An expression which isn't a chain is a list of just itself.
+func (m *matcher) flattenChain(expr ast.Node) chainList {
+	var links []ast.Node
+	for {
+		var link ast.Node
+		switch x := expr.(type) {
+		case *ast.SelectorExpr:
+			if m.info(fromWildName(x.Sel.Name)).any {
+				link = x.Sel
+			} else {
+				link = chainLinkCopy(x, nil)
+			}
+			expr = x.X
+		case *ast.CallExpr:
+			if sel, ok := x.Fun.(*ast.SelectorExpr); ok {
+				link = chainLinkCopy(sel, x)
+				expr = sel.X
+			}
+		}
+		if link == nil {
+			break
+		}
+		links = append(links, link)
+	}
+	chain := chainList{expr}
+	for i := len(links) - 1; i >= 0; i-- {
+		chain = append(chain, links[i])
+	}
+	return chain
+}
+
+// chainLinkCopy returns a copy of a selector, or of a method call on it if
+// call is non-nil, on a placeholder instead of its X.
+func chainLinkCopy(sel *ast.SelectorExpr, call *ast.CallExpr) ast.Expr {
+	// placed at the dot, so that the link has a valid position
+	placeholder := &ast.Ident{NamePos: sel.Sel.Pos() - 1}
+	link := &ast.SelectorExpr{X: placeholder, Sel: sel.Sel}
+	if call == nil {
+		return link
+	}
+	callCopy := *call
+	callCopy.Fun = link
+	return &callCopy
+}
+
+// rebuildChain joins the nodes of a chainList back into an expression,
+// where the links are applied to the expression before them, starting at x.
+func rebuildChain(x ast.Expr, list chainList) ast.Expr {
+	for _, node := range list {
+		if !isChainLink(node) {
+			x = node.(ast.Expr) // the base, if $*name matched it too
+			continue
+		}
+		switch link := node.(type) {
+		case *ast.SelectorExpr:
+			x = &ast.SelectorExpr{X: x, Sel: link.Sel}
+		case *ast.CallExpr:
+			call := *link
+			call.Fun = &ast.SelectorExpr{X: x, Sel: link.Fun.(*ast.SelectorExpr).Sel}
+			x = &call
+		}
+	}
+	return x
+}
+
+// isChainLink reports whether a node of a chainList is one of its links,
+// rather than its base.
+func isChainLink(node ast.Node) bool {
+	switch x := node.(type) {
+	case *ast.SelectorExpr:
+		return isChainPlaceholder(x.X)
+	case *ast.CallExpr:
+		sel, ok := x.Fun.(*ast.SelectorExpr)
+		return ok && isChainPlaceholder(sel.X)
+	}
+	return false
+}
+
+func isChainPlaceholder(expr ast.Expr) bool {
+	id, ok := expr.(*ast.Ident)
+	return ok && id.Name == ""
+}
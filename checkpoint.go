@@ -0,0 +1,72 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+)
+
+// checkpoint records the packages that a run given -checkpoint has matched so
+// far, along with what was printed for each batch of them, so that an
+// interrupted run can be resumed via -resume.
+type checkpoint struct {
+	Args []string         `json:"args"`
+	Done []checkpointDone `json:"done"`
+}
+
+type checkpointDone struct {
+	Packages []string `json:"packages"`
+	Output   string   `json:"output,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+	ExitCode int      `json:"exit_code,omitempty"`
+}
+
+// checkpointQuery returns the args of a query as recorded in its checkpoint,
+// which are the same for the run that is resumed and the one resuming it.
+func checkpointQuery(args []string) []string {
+	var query []string
+	for _, arg := range args {
+		switch arg {
+		case "-resume", "--resume", "-resume=true", "--resume=true":
+		default:
+			query = append(query, arg)
+		}
+	}
+	return query
+}
+
+// readCheckpoint reads a checkpoint file, which must have been made for the
+// same query.
+func readCheckpoint(path string, query []string) (checkpoint, error) {
+	var cp checkpoint
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return cp, fmt.Errorf("%s: %v", path, err)
+	}
+	if !reflect.DeepEqual(cp.Args, query) {
+		return cp, fmt.Errorf("%s: made for other arguments: %q", path, cp.Args)
+	}
+	return cp, nil
+}
+
+// writeCheckpoint replaces a checkpoint file, such that an interruption
+// leaves either the old or the new checkpoint.
+func writeCheckpoint(path string, cp checkpoint) error {
+	data, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0o666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,128 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// cloneFrame holds the state of a node while its structural hash is being
+// computed.
+type cloneFrame struct {
+	node ast.Node
+	hash uint64
+	size int
+	// hashes of the children, in order
+	children []uint64
+}
+
+// printClones reports the groups of structurally equal syntax trees with at
+// least m.cloneSize nodes. Identifiers and literals are abstracted away, so
+// two trees are equal if they only differ in names and values. Groups that
+// are entirely contained within a larger group are omitted.
+func (m *matcher) printClones(pkgs []loadPkg) {
+	groups := make(map[uint64][]ast.Node)
+	hashes := make(map[ast.Node]uint64)
+	parents := make(map[ast.Node]ast.Node)
+	var stack []*cloneFrame
+	for _, pkg := range pkgs {
+		for _, root := range pkg.nodes {
+			ast.Inspect(root, func(node ast.Node) bool {
+				switch node.(type) {
+				case *ast.CommentGroup, *ast.Comment:
+					return false
+				case nil:
+					frame := stack[len(stack)-1]
+					stack = stack[:len(stack)-1]
+					h := fnv.New64a()
+					h.Write([]byte(cloneKind(frame.node)))
+					for _, child := range frame.children {
+						h.Write([]byte(strconv.FormatUint(child, 16)))
+					}
+					frame.hash = h.Sum64()
+					hashes[frame.node] = frame.hash
+					if len(stack) > 0 {
+						parent := stack[len(stack)-1]
+						parent.children = append(parent.children, frame.hash)
+						parent.size += frame.size
+						parents[frame.node] = parent.node
+					}
+					if frame.size >= m.cloneSize && cloneCandidate(frame.node) {
+						groups[frame.hash] = append(groups[frame.hash], frame.node)
+					}
+					return true
+				}
+				stack = append(stack, &cloneFrame{node: node, size: 1})
+				return true
+			})
+		}
+	}
+	var list [][]ast.Node
+	for _, nodes := range groups {
+		if len(nodes) < 2 || subsumedClones(nodes, parents, hashes) {
+			continue
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			return nodes[i].Pos() < nodes[j].Pos()
+		})
+		list = append(list, nodes)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i][0].Pos() < list[j][0].Pos()
+	})
+	for i, nodes := range list {
+		if i > 0 {
+			fmt.Fprintln(m.out)
+		}
+		for _, n := range nodes {
+			fmt.Fprintf(m.out, "%v: %s\n", m.position(n.Pos()), singleLinePrint(n))
+		}
+	}
+}
+
+// cloneKind returns the part of a node's structural hash that doesn't depend
+// on its children. This is its type, plus any operators or keywords.
+func cloneKind(node ast.Node) string {
+	kind := reflect.TypeOf(node).String()
+	val := reflect.ValueOf(node).Elem()
+	for i := 0; i < val.NumField(); i++ {
+		if tok, ok := val.Field(i).Interface().(token.Token); ok {
+			kind += " " + tok.String()
+		}
+	}
+	return kind
+}
+
+// cloneCandidate reports whether a node can be reported as a clone.
+func cloneCandidate(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.File, *ast.Ident, *ast.BasicLit:
+		return false
+	case ast.Expr, ast.Stmt, ast.Decl, ast.Spec:
+		return true
+	}
+	return false
+}
+
+// subsumedClones reports whether the parents of a group of clones are clones
+// of each other too.
+func subsumedClones(nodes []ast.Node, parents map[ast.Node]ast.Node, hashes map[ast.Node]uint64) bool {
+	first := parents[nodes[0]]
+	if first == nil {
+		return false
+	}
+	for _, node := range nodes[1:] {
+		parent := parents[node]
+		if parent == nil || hashes[parent] != hashes[first] {
+			return false
+		}
+	}
+	return true
+}
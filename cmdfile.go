@@ -0,0 +1,103 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// fileCmdFlag reads commands from a file, as if they had been given in place
+// of the -f flag. For example:
+//
+//	# returning an error unchanged
+//	-x
+//		if $err != nil {
+//			return $*_, $err
+//		}
+//	-a type(error)
+//
+// Each command starts at the beginning of a line with its name, optionally
+// followed by its argument. Any indented lines that follow are added to the
+// argument, so patterns can span multiple lines. Lines starting with '#' are
+// comments.
+type fileCmdFlag struct {
+	flagSet *flag.FlagSet
+}
+
+func (o *fileCmdFlag) String() string { return "" }
+func (o *fileCmdFlag) Set(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	args, err := parseCmdFile(string(data))
+	if err != nil {
+		return fmt.Errorf("%s:%v", path, err)
+	}
+	for _, arg := range args {
+		f := o.flagSet.Lookup(arg.name)
+		if f == nil || arg.name == "f" {
+			return fmt.Errorf("%s:%d: unknown command -%s", path, arg.line, arg.name)
+		}
+		val := arg.val
+		if !arg.hasVal {
+			if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); !ok || !bf.IsBoolFlag() {
+				return fmt.Errorf("%s:%d: -%s needs an argument", path, arg.line, arg.name)
+			}
+			val = "true"
+		}
+		if err := o.flagSet.Set(arg.name, val); err != nil {
+			return fmt.Errorf("%s:%d: -%s: %v", path, arg.line, arg.name, err)
+		}
+	}
+	return nil
+}
+
+// fileArg is a command read from a file, such as "-x" and its pattern.
+type fileArg struct {
+	line   int
+	name   string
+	val    string
+	hasVal bool
+}
+
+func parseCmdFile(src string) ([]fileArg, error) {
+	var args []fileArg
+	var cur *fileArg
+	var lines []string
+	finish := func() {
+		if cur == nil {
+			return
+		}
+		cur.val = strings.TrimSpace(strings.Join(lines, "\n"))
+		cur.hasVal = cur.val != ""
+		args = append(args, *cur)
+		cur, lines = nil, nil
+	}
+	for i, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(line, "-"):
+			finish()
+			name, rest := line[1:], ""
+			if j := strings.IndexAny(name, " \t"); j >= 0 {
+				name, rest = name[:j], name[j+1:]
+			}
+			cur = &fileArg{line: i + 1, name: name}
+			lines = append(lines, rest)
+		case trimmed == "" || line != trimmed:
+			if cur != nil {
+				lines = append(lines, line)
+			}
+		default:
+			return nil, fmt.Errorf("%d: wanted a command or an indented line", i+1)
+		}
+	}
+	finish()
+	return args, nil
+}
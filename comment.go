@@ -0,0 +1,329 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/build/constraint"
+	"go/types"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// cmdComment finds all the comment groups within each submatch whose text
+// matches a regular expression.
+func (m *matcher) cmdComment(cmd exprCmd, subs []submatch) []submatch {
+	rx := cmd.value.(*regexp.Regexp)
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	for _, sub := range subs {
+		for _, cg := range m.commentsWithin(sub.node) {
+			if !rx.MatchString(cg.Text()) {
+				continue
+			}
+			hash := posHash(cg)
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			matches = append(matches, submatch{
+				node:   cg,
+				values: valsCopy(sub.values),
+			})
+		}
+	}
+	return matches
+}
+
+// commentNear reports whether a comment matching a regular expression is
+// within a number of lines of a node, or attached to its enclosing statement.
+func (m *matcher) commentNear(node ast.Node, check commentCheck) bool {
+	f := m.fileOf(node)
+	if f == nil {
+		return false
+	}
+	fset := m.loader.fset
+	from := fset.PositionFor(node.Pos(), false).Line - check.lines
+	to := fset.PositionFor(node.End(), false).Line + check.lines
+	var attached []*ast.CommentGroup
+	for n := node; n != nil; n = m.parentOf(n) {
+		if _, ok := n.(ast.Stmt); ok {
+			attached = m.commentMap(f)[n]
+			break
+		}
+	}
+	for _, cg := range f.Comments {
+		if !check.rx.MatchString(cg.Text()) {
+			continue
+		}
+		if fset.PositionFor(cg.End(), false).Line >= from &&
+			fset.PositionFor(cg.Pos(), false).Line <= to {
+			return true
+		}
+		for _, cg2 := range attached {
+			if cg == cg2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// commentsWithin returns the comment groups found within a node's range.
+// Unlike ast.Inspect, it also includes those comments which aren't attached
+// to any node.
+func (m *matcher) commentsWithin(node ast.Node) []*ast.CommentGroup {
+	if f, ok := node.(*ast.File); ok {
+		// also include the comments before the package clause
+		return f.Comments
+	}
+	f := m.fileOf(node)
+	if f == nil {
+		return nil
+	}
+	var cgs []*ast.CommentGroup
+	for _, cg := range f.Comments {
+		if cg.Pos() >= node.Pos() && cg.End() <= node.End() {
+			cgs = append(cgs, cg)
+		}
+	}
+	return cgs
+}
+
+// fileOf returns the file containing a node, if any.
+func (m *matcher) fileOf(node ast.Node) *ast.File {
+	for node != nil {
+		if f, ok := node.(*ast.File); ok {
+			return f
+		}
+		node = m.parentOf(node)
+	}
+	return nil
+}
+
+func (m *matcher) docApplies(node ast.Node, check docCheck) bool {
+	if check.op == "directive" {
+		for _, dir := range m.directives(node) {
+			if check.rx.MatchString(dir) {
+				return true
+			}
+		}
+		return false
+	}
+	doc := m.docOf(node)
+	if doc == nil {
+		return false
+	}
+	text := doc.Text()
+	switch check.op {
+	case "docname":
+		name := declName(node)
+		if name == "" {
+			return false
+		}
+		for _, article := range [...]string{"A ", "An ", "The "} {
+			text = strings.TrimPrefix(text, article)
+		}
+		return strings.HasPrefix(text, name+" ") ||
+			strings.HasPrefix(text, name+"\n")
+	}
+	return check.rx == nil || check.rx.MatchString(text)
+}
+
+var rxDirective = regexp.MustCompile(`^//[a-z0-9]+:[a-z0-9]`)
+
+// directives returns the directives such as "go:noinline" found in a node's
+// doc comment, without their leading slashes. For files, all of the
+// directives within the file are returned.
+func (m *matcher) directives(node ast.Node) []string {
+	var cgs []*ast.CommentGroup
+	if f, ok := node.(*ast.File); ok {
+		cgs = f.Comments
+	} else if doc := m.docOf(node); doc != nil {
+		cgs = append(cgs, doc)
+	}
+	var dirs []string
+	for _, cg := range cgs {
+		for _, c := range cg.List {
+			if rxDirective.MatchString(c.Text) {
+				dirs = append(dirs, c.Text[2:])
+			}
+		}
+	}
+	return dirs
+}
+
+// docOf returns the doc comment of a node. Declarations, specs and fields
+// have their doc comments directly attached, but for any other node we fall
+// back to the comments associated with it that precede it.
+func (m *matcher) docOf(node ast.Node) *ast.CommentGroup {
+	switch x := node.(type) {
+	case *ast.FuncDecl:
+		return x.Doc
+	case *ast.GenDecl:
+		return x.Doc
+	case *ast.TypeSpec:
+		if x.Doc == nil {
+			return m.parentDoc(x)
+		}
+		return x.Doc
+	case *ast.ValueSpec:
+		if x.Doc == nil {
+			return m.parentDoc(x)
+		}
+		return x.Doc
+	case *ast.Field:
+		return x.Doc
+	case *ast.DeclStmt:
+		return m.docOf(x.Decl)
+	}
+	f := m.fileOf(node)
+	if f == nil {
+		return nil
+	}
+	var doc *ast.CommentGroup
+	for _, cg := range m.commentMap(f)[node] {
+		if cg.End() < node.Pos() {
+			doc = cg
+		}
+	}
+	return doc
+}
+
+// parentDoc returns the doc comment of a spec's declaration, as long as the
+// declaration isn't grouped.
+func (m *matcher) parentDoc(spec ast.Spec) *ast.CommentGroup {
+	gd, ok := m.parentOf(spec).(*ast.GenDecl)
+	if !ok || gd.Lparen.IsValid() {
+		return nil
+	}
+	return gd.Doc
+}
+
+func (m *matcher) commentMap(f *ast.File) ast.CommentMap {
+	if m.commentMaps == nil {
+		m.commentMaps = make(map[*ast.File]ast.CommentMap)
+	}
+	cmap, ok := m.commentMaps[f]
+	if !ok {
+		cmap = ast.NewCommentMap(m.loader.fset, f, f.Comments)
+		m.commentMaps[f] = cmap
+	}
+	return cmap
+}
+
+// declName returns the name being declared by a node, if any.
+func declName(node ast.Node) string {
+	switch x := node.(type) {
+	case *ast.FuncDecl:
+		return x.Name.Name
+	case *ast.GenDecl:
+		if len(x.Specs) == 1 {
+			return declName(x.Specs[0])
+		}
+	case *ast.TypeSpec:
+		return x.Name.Name
+	case *ast.ValueSpec:
+		return x.Names[0].Name
+	case *ast.Field:
+		if len(x.Names) > 0 {
+			return x.Names[0].Name
+		}
+	case *ast.DeclStmt:
+		return declName(x.Decl)
+	}
+	return ""
+}
+
+// deprecated reports whether an object's declaration has a doc comment with a
+// paragraph starting with "Deprecated: ".
+func (m *matcher) deprecated(obj types.Object) bool {
+	decl := m.declOf(obj)
+	if decl == nil {
+		return false
+	}
+	doc := m.docOf(decl)
+	if doc == nil {
+		return false
+	}
+	for _, para := range strings.Split(doc.Text(), "\n\n") {
+		if strings.HasPrefix(para, "Deprecated: ") {
+			return true
+		}
+	}
+	return false
+}
+
+// declOf returns the node declaring an object, as long as it's part of the
+// loaded files. This is a declaration, a spec, a field, or a statement such
+// as a short variable declaration.
+func (m *matcher) declOf(obj types.Object) ast.Node {
+	pos := obj.Pos()
+	if !pos.IsValid() {
+		return nil
+	}
+	f := m.fileAt(pos)
+	if f == nil {
+		return nil
+	}
+	m.ensureParents(f)
+	path, _ := astutil.PathEnclosingInterval(f, pos, pos)
+	for _, node := range path {
+		switch node.(type) {
+		case *ast.FuncDecl, *ast.TypeSpec, *ast.ValueSpec,
+			*ast.ImportSpec, *ast.Field, *ast.AssignStmt,
+			*ast.RangeStmt, *ast.LabeledStmt, *ast.TypeSwitchStmt:
+			return node
+		}
+	}
+	return nil
+}
+
+// buildApplies checks the build constraints of the file containing a node.
+// Files without constraints satisfy any set of tags.
+func (m *matcher) buildApplies(node ast.Node, check buildCheck) bool {
+	f := m.fileOf(node)
+	if f == nil {
+		return false
+	}
+	expr := buildConstraint(f)
+	if check.tags == nil {
+		return expr != nil
+	}
+	return expr == nil || expr.Eval(func(tag string) bool {
+		return check.tags[tag]
+	})
+}
+
+// buildConstraint returns a file's build constraint, if any. A //go:build
+// line is preferred over any // +build lines.
+func buildConstraint(f *ast.File) constraint.Expr {
+	var plus constraint.Expr
+	for _, cg := range f.Comments {
+		if cg.Pos() > f.Package {
+			break
+		}
+		for _, c := range cg.List {
+			if constraint.IsGoBuild(c.Text) {
+				expr, err := constraint.Parse(c.Text)
+				if err == nil {
+					return expr
+				}
+			} else if constraint.IsPlusBuild(c.Text) {
+				expr, err := constraint.Parse(c.Text)
+				if err != nil {
+					continue
+				}
+				if plus == nil {
+					plus = expr
+				} else {
+					plus = &constraint.AndExpr{X: plus, Y: expr}
+				}
+			}
+		}
+	}
+	return plus
+}
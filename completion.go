@@ -0,0 +1,313 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// subcommands lists the subcommands, in the order they're completed.
+var subcommands = []string{"search", "rewrite", "apply", "rules", "run", "diff", "merge", "migrate", "fix", "serve", "gen-analyzer", "test", "completion"}
+
+// flagArgs describes the argument taken by each flag which isn't a boolean,
+// used as a hint when completing it.
+var flagArgs = map[string]string{
+	"f":               "file",
+	"x":               "pattern",
+	"or":              "pattern",
+	"g":               "pattern",
+	"v":               "pattern",
+	"s":               "pattern",
+	"taint":           "pattern",
+	"concat":          "pattern",
+	"reach":           "pattern",
+	"a":               "attribute",
+	"p":               "number",
+	"comment":         "regexp",
+	"nolint":          "directive",
+	"plan":            "file",
+	"checkpoint":      "file",
+	"max-mem":         "size",
+	"cache":           "dir",
+	"max-time":        "duration",
+	"clones":          "nodes",
+	"fuzzy":           "edits",
+	"j":               "jobs",
+	"snap":            "unit",
+	"only-in":         "context",
+	"not-in":          "context",
+	"import":          "package",
+	"shard":           "i/n",
+	"skip":            "packages",
+	"package-timeout": "duration",
+	"rules":           "files",
+	"pack":            "packs",
+	"test-src":        "file",
+	"ctags":           "wildcard",
+	"etags":           "wildcard",
+	"count-by":        "wildcard",
+	"group":           "unit",
+}
+
+// attrNames lists the attributes for -a, with a trailing "(" if they take
+// arguments.
+var attrNames = []string{
+	"addr", "alignof", "anon", "asgn(", "badformat", "base64", "build", "build(", "comment(", "comp", "constarg", "constarg(", "conv(", "count(",
+	"depth(", "deprecated", "directive(", "dirname", "dropsctx", "doc", "doc(", "docname", "entropy", "is(", "loopvar", "obj(", "recv(",
+	"rx(", "sizeof", "targeted", "type(", "unlocked",
+}
+
+// complFlag is a flag as completed by the shells.
+type complFlag struct {
+	name, usage string
+	arg         string // empty if the flag takes no argument
+	cmd         bool   // whether it's a command, which can be repeated
+}
+
+// complFlags returns all the flags accepted when matching, sorted by name.
+func complFlags() []complFlag {
+	var m matcher
+	var cmds []exprCmd
+	flagSet := flag.NewFlagSet("gogrep", flag.ContinueOnError)
+	m.globalFlags(flagSet)
+	global := make(map[string]bool)
+	flagSet.VisitAll(func(f *flag.Flag) { global[f.Name] = true })
+	cmdFlags(flagSet, &cmds)
+	var flags []complFlag
+	flagSet.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, complFlag{f.Name, f.Usage, flagArgs[f.Name], !global[f.Name]})
+	})
+	sort.Slice(flags, func(i, j int) bool { return flags[i].name < flags[j].name })
+	return flags
+}
+
+// completionArgs implements "gogrep completion shell", printing a script to
+// complete the subcommands and flags in bash, zsh, or fish. The scripts run
+// "gogrep completion names kind" to complete the aliases from the config
+// file, which can change between uses.
+func (m *matcher) completionArgs(args []string) error {
+	if len(args) == 2 && args[0] == "names" {
+		return m.completionNames(args[1])
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gogrep completion bash|zsh|fish")
+	}
+	var buf bytes.Buffer
+	switch args[0] {
+	case "bash":
+		bashCompletion(&buf)
+	case "zsh":
+		zshCompletion(&buf)
+	case "fish":
+		fishCompletion(&buf)
+	default:
+		return fmt.Errorf("unsupported shell %q; available: bash, zsh, fish", args[0])
+	}
+	_, err := m.out.Write(buf.Bytes())
+	return err
+}
+
+// completionNames prints the names of a kind, one per line. The kinds are
+// "aliases", the named commands in the config file, and the built-in "packs".
+func (m *matcher) completionNames(kind string) error {
+	var names []string
+	switch kind {
+	case "aliases":
+		if m.config != nil {
+			for name := range m.config.aliases {
+				names = append(names, name)
+			}
+		}
+	case "packs":
+		names = packNames()
+	default:
+		return fmt.Errorf("unknown kind %q; available: aliases, packs", kind)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintln(m.out, name)
+	}
+	return nil
+}
+
+func bashCompletion(buf *bytes.Buffer) {
+	var all, files, others []string
+	for _, f := range complFlags() {
+		all = append(all, "-"+f.name)
+		switch f.arg {
+		case "", "attribute", "packs":
+		case "file", "files":
+			files = append(files, "-"+f.name)
+		default:
+			others = append(others, "-"+f.name)
+		}
+	}
+	fmt.Fprintf(buf, `# bash completion for gogrep; load it via:
+#   source <(gogrep completion bash)
+
+_gogrep() {
+	local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+	case $prev in
+	%s)
+		COMPREPLY=($(compgen -f -- "$cur"))
+		return
+		;;
+	-a)
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+		;;
+	-pack)
+		COMPREPLY=($(compgen -W "$(gogrep completion names packs 2>/dev/null)" -- "$cur"))
+		return
+		;;
+	%s)
+		# patterns and numbers can't be completed
+		return
+		;;
+	esac
+	if [[ $COMP_CWORD -eq 1 && $cur != -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur") $(compgen -d -- "$cur"))
+		return
+	fi
+	if [[ $COMP_CWORD -eq 2 ]]; then
+		case ${COMP_WORDS[1]} in
+		run)
+			COMPREPLY=($(compgen -W "$(gogrep completion names aliases 2>/dev/null)" -- "$cur"))
+			return
+			;;
+		rules)
+			COMPREPLY=($(compgen -W "list test" -- "$cur"))
+			return
+			;;
+		completion)
+			COMPREPLY=($(compgen -W "bash zsh fish" -- "$cur"))
+			return
+			;;
+		esac
+	fi
+	if [[ $cur == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+	COMPREPLY=($(compgen -d -- "$cur"))
+}
+
+complete -F _gogrep gogrep
+`, strings.Join(files, "|"),
+		strings.Join(attrNames, " "), strings.Join(others, "|"),
+		strings.Join(subcommands, " "), strings.Join(all, " "))
+}
+
+// zshQuote escapes a description for an _arguments spec.
+var zshQuote = strings.NewReplacer(`'`, `'\''`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+
+// attrWords returns attrNames as a list of words for zsh and fish, which
+// would otherwise treat the parentheses as syntax.
+func attrWords() string {
+	return strings.Replace(strings.Join(attrNames, " "), "(", `\(`, -1)
+}
+
+func zshCompletion(buf *bytes.Buffer) {
+	buf.WriteString(`#compdef gogrep
+# zsh completion for gogrep; load it via:
+#   source <(gogrep completion zsh)
+
+_gogrep_names() {
+	local -a names
+	names=(${(f)"$(gogrep completion names $1 2>/dev/null)"})
+	_describe $1 names
+}
+
+_gogrep() {
+	local -a flags
+	flags=(
+`)
+	for _, f := range complFlags() {
+		usage := zshQuote.Replace(f.usage)
+		spec := fmt.Sprintf("-%s[%s]", f.name, usage)
+		if f.cmd {
+			spec = "*" + spec
+		}
+		switch f.arg {
+		case "":
+		case "file", "files":
+			spec += ":" + f.arg + ":_files"
+		case "pattern":
+			spec += ":pattern (Go code with $wildcards and $*wildcards): "
+		case "attribute":
+			spec += ":attribute:(" + attrWords() + ")"
+		case "packs":
+			spec += ":packs:_gogrep_names packs"
+		default:
+			spec += ":" + f.arg + ": "
+		}
+		fmt.Fprintf(buf, "\t\t'%s'\n", spec)
+	}
+	fmt.Fprintf(buf, `	)
+	if (( CURRENT == 2 )) && [[ $words[2] != -* ]]; then
+		local -a subcommands
+		subcommands=(%s)
+		_describe subcommand subcommands
+		_files -/
+		return
+	fi
+	case $words[2] in
+	run)
+		if (( CURRENT == 3 )); then
+			_gogrep_names aliases
+			return
+		fi
+		;;
+	rules)
+		if (( CURRENT == 3 )); then
+			compadd list test
+			return
+		fi
+		;;
+	completion)
+		compadd bash zsh fish
+		return
+		;;
+	esac
+	_arguments -s $flags '*:package:_files -/'
+}
+
+compdef _gogrep gogrep
+`, strings.Join(subcommands, " "))
+}
+
+func fishCompletion(buf *bytes.Buffer) {
+	buf.WriteString(`# fish completion for gogrep; load it via:
+#   gogrep completion fish | source
+
+complete -c gogrep -f
+`)
+	fmt.Fprintf(buf, "complete -c gogrep -n __fish_use_subcommand -a '%s'\n",
+		strings.Join(subcommands, " "))
+	buf.WriteString(`complete -c gogrep -n '__fish_seen_subcommand_from run' -a '(gogrep completion names aliases 2>/dev/null)'
+complete -c gogrep -n '__fish_seen_subcommand_from rules' -a 'list test'
+complete -c gogrep -n '__fish_seen_subcommand_from completion' -a 'bash zsh fish'
+`)
+	for _, f := range complFlags() {
+		fmt.Fprintf(buf, "complete -c gogrep -o %s -d '%s'", f.name,
+			strings.Replace(f.usage, "'", `\'`, -1))
+		switch f.arg {
+		case "":
+		case "file", "files":
+			buf.WriteString(" -r -F")
+		case "attribute":
+			fmt.Fprintf(buf, " -x -a '%s'", attrWords())
+		case "packs":
+			buf.WriteString(" -x -a '(gogrep completion names packs 2>/dev/null)'")
+		default:
+			buf.WriteString(" -x") // a pattern or number, which can't be completed
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("complete -c gogrep -a '(__fish_complete_directories)'\n")
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const configName = ".gogrep.yaml"
+
+// config is a project's configuration, read from a .gogrep.yaml file such as:
+//
+//	flags: [-r]
+//	aliases:
+//	  unchecked-errors: -x '$f($*_)' -a 'type(error)'
+//	  println:
+//	    - -x
+//	    - println($*_)
+//
+//	rules:
+//	  println:
+//	    disable: [internal/legacy]
+//	sqli:
+//	  sources: [$_.Param($_)]
+//	  sinks: [$_.MustExec($*_)]
+//	skip: [./internal/generated/...]
+//	priority: [./internal/auth/..., recent]
+//
+// The flags are added before the arguments of every invocation, and each
+// alias can be run via "gogrep run name [packages]". Arguments can be given
+// as a list, or as a single string split like a shell would. The path globs
+// scoping each rule, relative to the config file's directory, are added to
+// those in the rule's own file. The sqli patterns are added to those of the
+// sqli pack. The packages matching the skip patterns, with directories
+// relative to the config file's directory, are never loaded, like those
+// given to -skip. When matching one package at a time, such as with
+// -max-time, those matching the priority patterns go first, in order; the
+// "recent" entry matches all packages, ordering them by their last change in
+// git.
+type config struct {
+	path     string
+	flags    []string
+	aliases  map[string][]string
+	scopes   map[string]ruleScope
+	sqli     taintPreset
+	skip     []string
+	priority []string
+}
+
+// taintPreset holds the patterns of the sources and sinks of a pack built
+// on -concat.
+type taintPreset struct {
+	sources, sinks []string
+}
+
+// findConfig looks for a config file in dir and its parent directories. If
+// none is found, it returns nil and no error.
+func findConfig(dir string) (*config, error) {
+	for {
+		path := filepath.Join(dir, configName)
+		data, err := ioutil.ReadFile(path)
+		if err == nil {
+			cfg, err := parseConfig(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			cfg.path = path
+			absDir, err := filepath.Abs(dir)
+			if err != nil {
+				return nil, err
+			}
+			for name, scope := range cfg.scopes {
+				scope.Enable = absGlobs(absDir, scope.Enable)
+				scope.Disable = absGlobs(absDir, scope.Disable)
+				cfg.scopes[name] = scope
+			}
+			for _, patterns := range [][]string{cfg.skip, cfg.priority} {
+				for i, pattern := range patterns {
+					if build.IsLocalImport(pattern) {
+						patterns[i] = filepath.Join(absDir, pattern)
+					}
+				}
+			}
+			return cfg, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// configFile is the layout of a config file, as documented in config.
+type configFile struct {
+	Flags   yamlArgs            `yaml:"flags"`
+	Aliases map[string]yamlArgs `yaml:"aliases"`
+	Rules   map[string]struct {
+		Enable  yamlArgs `yaml:"enable"`
+		Disable yamlArgs `yaml:"disable"`
+	} `yaml:"rules"`
+	Sqli struct {
+		Sources yamlArgs `yaml:"sources"`
+		Sinks   yamlArgs `yaml:"sinks"`
+	} `yaml:"sqli"`
+	Skip     yamlArgs `yaml:"skip"`
+	Priority yamlArgs `yaml:"priority"`
+}
+
+func parseConfig(data []byte) (*config, error) {
+	var file configFile
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&file); err != nil && err != io.EOF {
+		return nil, err
+	}
+	cfg := &config{
+		flags:    file.Flags,
+		aliases:  make(map[string][]string),
+		scopes:   make(map[string]ruleScope),
+		sqli:     taintPreset{sources: file.Sqli.Sources, sinks: file.Sqli.Sinks},
+		skip:     file.Skip,
+		priority: file.Priority,
+	}
+	for name, args := range file.Aliases {
+		cfg.aliases[name] = args
+	}
+	for name, rule := range file.Rules {
+		scope := ruleScope{Enable: rule.Enable, Disable: rule.Disable}
+		if err := checkGlobs(append(scope.Enable, scope.Disable...)); err != nil {
+			return nil, fmt.Errorf("rules: %s: %v", name, err)
+		}
+		cfg.scopes[name] = scope
+	}
+	return cfg, nil
+}
+
+// yamlArgs is a list of arguments, given either as a list of strings or as a
+// single string to be split into words.
+type yamlArgs []string
+
+func (a *yamlArgs) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		words, err := splitWords(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", node.Line, err)
+		}
+		*a = words
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// splitWords splits a string into words like a shell would, supporting
+// single and double quotes as well as backslash escapes.
+func splitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// joinWords is the inverse of splitWords, quoting the words which need it.
+func joinWords(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		if word != "" && !strings.ContainsAny(word, " \t\n'\"\\$`*?()[]{}|&;<>#~!") {
+			quoted[i] = word
+			continue
+		}
+		quoted[i] = "'" + strings.Replace(word, "'", `'\''`, -1) + "'"
+	}
+	return strings.Join(quoted, " ")
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// paramArgs returns the arguments passed as a func's parameter at each of the
+// func's call sites in the loaded packages. It returns false if the node
+// isn't a parameter of a declared func, if the func is never called, or if
+// it's used as a value, as then it may be called anywhere.
+func (m *matcher) paramArgs(node ast.Node) ([]types.TypeAndValue, bool) {
+	ident, ok := node.(*ast.Ident)
+	if !ok {
+		return nil, false
+	}
+	param, ok := m.objectOf(ident).(*types.Var)
+	if !ok || param.IsField() {
+		return nil, false
+	}
+	f := m.fileAt(param.Pos())
+	if f == nil {
+		return nil, false
+	}
+	path, _ := astutil.PathEnclosingInterval(f, param.Pos(), param.Pos())
+	var decl *ast.FuncDecl
+	for _, node := range path {
+		if _, ok := node.(*ast.BlockStmt); ok {
+			return nil, false // a local variable
+		}
+		if decl, ok = node.(*ast.FuncDecl); ok {
+			break
+		}
+	}
+	if decl == nil {
+		return nil, false
+	}
+	fn, ok := m.Info.Defs[decl.Name].(*types.Func)
+	if !ok {
+		return nil, false
+	}
+	sig := fn.Type().(*types.Signature)
+	index := -1
+	for i := 0; i < sig.Params().Len(); i++ {
+		if sig.Params().At(i) == param {
+			index = i
+		}
+	}
+	if index < 0 || (sig.Variadic() && index == sig.Params().Len()-1) {
+		return nil, false // not a parameter, or a variadic one
+	}
+	var args []types.TypeAndValue
+	uses := 0
+	for i := range m.pkgs {
+		info := &m.pkgs[i].info
+		for _, obj := range info.Uses {
+			if obj == fn {
+				uses++
+			}
+		}
+		for _, node := range m.pkgs[i].nodes {
+			inspect(node, func(node ast.Node) bool {
+				call, ok := node.(*ast.CallExpr)
+				if ok && calleeObj(info, call) == fn && index < len(call.Args) {
+					args = append(args, info.Types[call.Args[index]])
+				}
+				return true
+			})
+		}
+	}
+	if len(args) == 0 || len(args) != uses {
+		return nil, false
+	}
+	return args, true
+}
+
+// calleeObj returns the object of the func or method called by a call
+// expression, if it's called directly by its name.
+func calleeObj(info *types.Info, call *ast.CallExpr) types.Object {
+	switch x := astutil.Unparen(call.Fun).(type) {
+	case *ast.Ident:
+		return info.Uses[x]
+	case *ast.SelectorExpr:
+		return info.Uses[x.Sel]
+	}
+	return nil
+}
+
+// constArgApplies reports whether a parameter is passed a constant at every
+// call site of its func, which must equal the check's value if it has one.
+func (m *matcher) constArgApplies(node ast.Node, check constArgCheck) bool {
+	args, ok := m.paramArgs(node)
+	if !ok {
+		return false
+	}
+	for _, arg := range args {
+		switch {
+		case check.nilArg:
+			if !arg.IsNil() {
+				return false
+			}
+		case arg.Value == nil:
+			return false
+		case check.want != nil && !constEqual(arg.Value, check.want):
+			return false
+		}
+	}
+	return true
+}
+
+// constEqual is like constant.Compare with token.EQL, but it reports false
+// instead of panicking when the values are of different kinds, such as a
+// string and a number.
+func constEqual(x, y constant.Value) bool {
+	numeric := func(k constant.Kind) bool {
+		return k == constant.Int || k == constant.Float || k == constant.Complex
+	}
+	if kx, ky := x.Kind(), y.Kind(); kx != ky && !(numeric(kx) && numeric(ky)) {
+		return false
+	}
+	return constant.Compare(x, token.EQL, y)
+}
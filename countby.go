@@ -0,0 +1,84 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"sort"
+	"strings"
+)
+
+// printCountBy prints how many matches there are for each value of the
+// wildcard given to -count-by, as a histogram with the most common values
+// first. Matches without a value for it are skipped.
+func (m *matcher) printCountBy(subs []submatch) error {
+	name := strings.TrimPrefix(m.countBy, "$")
+	if name == "" || name == "_" {
+		return fmt.Errorf("count-by: need a wildcard name like $name, not %q", m.countBy)
+	}
+	counts := make(map[string]int)
+	for _, sub := range subs {
+		val, ok := sub.values[name]
+		if !ok || val == nil {
+			continue
+		}
+		counts[m.countKey(val)]++
+	}
+	keys := make([]string, 0, len(counts))
+	width := 0
+	for key, n := range counts {
+		keys = append(keys, key)
+		if w := len(fmt.Sprint(n)); w > width {
+			width = w
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ni, nj := counts[keys[i]], counts[keys[j]]; ni != nj {
+			return ni > nj
+		}
+		return keys[i] < keys[j]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(m.out, "%*d %s\n", width, counts[key], key)
+	}
+	return nil
+}
+
+// countKey returns the key under which a wildcard's value is counted. Values
+// naming a func or a package-level object are keyed by it if there is type
+// information, so that x.Get and y.Get are the same method if x and y are of
+// the same type. Any other value is keyed by its source, on one line.
+func (m *matcher) countKey(val ast.Node) string {
+	if id := countIdent(val); id != nil {
+		for _, info := range m.allInfos() {
+			obj := info.Uses[id]
+			if obj == nil {
+				obj = info.Defs[id]
+			}
+			if fn, ok := obj.(*types.Func); ok {
+				return fn.FullName()
+			}
+			if obj != nil && obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope() {
+				return obj.Pkg().Path() + "." + obj.Name()
+			}
+		}
+	}
+	return strings.Join(strings.Fields(singleLinePrint(val)), " ")
+}
+
+// countIdent returns the ident naming what a value refers to, if it's a name
+// or a selector like x.Get.
+func countIdent(val ast.Node) *ast.Ident {
+	switch x := val.(type) {
+	case *ast.Ident:
+		return x
+	case *ast.SelectorExpr:
+		return x.Sel
+	case *ast.ParenExpr:
+		return countIdent(x.X)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// matchTag is a tag for a match, named after the value of a wildcard and
+// located where that value is.
+type matchTag struct {
+	name string
+	pos  token.Position
+	end  int    // the offset at which the value ends
+	path string // as printed, relative to the working directory
+}
+
+// matchTags returns the tags for the matches, named after the values of the
+// wildcard given to -ctags or -etags. Matches without a value for it are
+// skipped.
+func (m *matcher) matchTags(subs []submatch, wildcard string) ([]matchTag, error) {
+	name := strings.TrimPrefix(wildcard, "$")
+	if name == "" || name == "_" {
+		return nil, fmt.Errorf("tags: need a wildcard name like $name, not %q", wildcard)
+	}
+	var tags []matchTag
+	seen := make(map[matchTag]bool)
+	for _, sub := range subs {
+		val, ok := sub.values[name]
+		if !ok || val == nil || !val.Pos().IsValid() {
+			continue
+		}
+		tag := matchTag{
+			name: tagName(val),
+			pos:  m.loader.fset.PositionFor(val.Pos(), false),
+			end:  m.loader.fset.PositionFor(val.End(), false).Offset,
+			path: m.position(val.Pos()).Filename,
+		}
+		if tag.name == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
+
+// tagName returns the name of a tag for a wildcard's value, which is the
+// value of string literals and the source of any other node, on one line.
+func tagName(val ast.Node) string {
+	if lit, ok := val.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+		if s, err := strconv.Unquote(lit.Value); err == nil {
+			val = &ast.Ident{Name: s}
+		}
+	}
+	name := singleLinePrint(val)
+	if id, ok := val.(*ast.Ident); ok {
+		name = id.Name
+	}
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// printCtags prints the tags for the matches as a ctags file, sorted by name
+// as Vim expects.
+func (m *matcher) printCtags(subs []submatch) error {
+	tags, err := m.matchTags(subs, m.ctags)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		if tags[i].name != tags[j].name {
+			return tags[i].name < tags[j].name
+		}
+		if tags[i].path != tags[j].path {
+			return tags[i].path < tags[j].path
+		}
+		return tags[i].pos.Offset < tags[j].pos.Offset
+	})
+	fmt.Fprintf(m.out, "!_TAG_FILE_FORMAT\t2\t/extended format/\n")
+	fmt.Fprintf(m.out, "!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n")
+	for _, tag := range tags {
+		fmt.Fprintf(m.out, "%s\t%s\t%d\n", tag.name, tag.path, tag.pos.Line)
+	}
+	return nil
+}
+
+// printEtags prints the tags for the matches as an etags file, with a section
+// per file as Emacs expects. Each tag has its line up to the end of the
+// wildcard's value, which Emacs searches for near the line.
+func (m *matcher) printEtags(subs []submatch) error {
+	tags, err := m.matchTags(subs, m.etags)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(tags, func(i, j int) bool {
+		if tags[i].path != tags[j].path {
+			return tags[i].path < tags[j].path
+		}
+		return tags[i].pos.Offset < tags[j].pos.Offset
+	})
+	for i := 0; i < len(tags); {
+		path := tags[i].path
+		src, err := ioutil.ReadFile(tags[i].pos.Filename)
+		if err != nil {
+			return err
+		}
+		var section bytes.Buffer
+		for ; i < len(tags) && tags[i].path == path; i++ {
+			tag := tags[i]
+			if tag.end > len(src) {
+				continue
+			}
+			start := bytes.LastIndexByte(src[:tag.pos.Offset], '\n') + 1
+			end := tag.end
+			if j := bytes.IndexByte(src[start:end], '\n'); j >= 0 {
+				end = start + j
+			}
+			fmt.Fprintf(&section, "%s\x7f%s\x01%d,%d\n", src[start:end],
+				tag.name, tag.pos.Line, start)
+		}
+		fmt.Fprintf(m.out, "\x0c\n%s,%d\n", path, section.Len())
+		if _, err := m.out.Write(section.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
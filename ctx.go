@@ -0,0 +1,75 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// ctxCheck checks whether a call passes a new context, from
+// context.Background or context.TODO, while a context parameter of an
+// enclosing func is in scope.
+type ctxCheck struct{}
+
+// dropsContext reports whether a call, or a statement made of one, passes
+// context.Background() or context.TODO() as an argument while a parameter
+// of type context.Context is in scope, noting its name.
+func (m *matcher) dropsContext(node ast.Node) bool {
+	if stmt, ok := node.(*ast.ExprStmt); ok {
+		node = stmt.X
+	}
+	call, ok := node.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	fresh := false
+	for _, arg := range call.Args {
+		inner, ok := arg.(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		fn, ok := m.objectOf(inner.Fun).(*types.Func)
+		if ok && fn.Pkg() != nil && fn.Pkg().Path() == "context" &&
+			(fn.Name() == "Background" || fn.Name() == "TODO") {
+			fresh = true
+		}
+	}
+	if !fresh {
+		return false
+	}
+	for p := m.parentOf(call); p != nil; p = m.parentOf(p) {
+		var ftype *ast.FuncType
+		switch x := p.(type) {
+		case *ast.FuncLit:
+			ftype = x.Type
+		case *ast.FuncDecl:
+			ftype = x.Type
+		default:
+			continue
+		}
+		for _, field := range ftype.Params.List {
+			for _, name := range field.Names {
+				if name.Name != "_" && isContext(m.Info.TypeOf(name)) {
+					m.notes[posHash(node)] = "dropsctx: " + name.Name + " is in scope"
+					return true
+				}
+			}
+		}
+		if _, ok := p.(*ast.FuncDecl); ok {
+			break
+		}
+	}
+	return false
+}
+
+// isContext reports whether a type is context.Context.
+func isContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// diffMatch is a match found when running a query on a revision.
+type diffMatch struct {
+	pos  token.Position
+	text string
+}
+
+// key is what identifies a match across revisions. Positions aren't part of
+// it, as unrelated changes can move matches around.
+func (d diffMatch) key() string {
+	return d.pos.Filename + ":" + normalizeLine(d.text)
+}
+
+// diffArgs runs a query on two revisions, as given by "gogrep diff REV1 REV2
+// commands [packages]", and prints the matches that were removed or added.
+func (m *matcher) diffArgs(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: gogrep diff REV1 REV2 commands [packages]")
+	}
+	old, err := m.revMatches(args[0], args[2:])
+	if err != nil {
+		return err
+	}
+	new, err := m.revMatches(args[1], args[2:])
+	if err != nil {
+		return err
+	}
+	left := make(map[string]int)
+	for _, dm := range old {
+		left[dm.key()]++
+	}
+	var added []diffMatch
+	for _, dm := range new {
+		if left[dm.key()] > 0 {
+			left[dm.key()]--
+		} else {
+			added = append(added, dm)
+		}
+	}
+	for _, dm := range old {
+		if left[dm.key()] > 0 {
+			left[dm.key()]--
+			fmt.Fprintf(m.out, "-%v: %s\n", dm.pos, dm.text)
+		}
+	}
+	for _, dm := range added {
+		fmt.Fprintf(m.out, "+%v: %s\n", dm.pos, dm.text)
+	}
+	return nil
+}
+
+// revMatches runs a query on a revision, which is either a directory or a
+// git revision. Positions are relative to the root of the revision.
+func (m *matcher) revMatches(rev string, args []string) ([]diffMatch, error) {
+	dir, err := filepath.Abs(rev)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(rev); err != nil || !info.IsDir() {
+		if dir, err = ioutil.TempDir("", "gogrep-diff"); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+		if err := gitExport(rev, dir); err != nil {
+			return nil, err
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return nil, err
+	}
+	defer os.Chdir(wd)
+	subs, err := m.matchArgs(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", rev, err)
+	}
+	matches := make([]diffMatch, len(subs))
+	for i, sub := range subs {
+		matches[i] = diffMatch{
+			pos:  m.position(sub.span().pos),
+			text: singleLinePrint(sub.node),
+		}
+	}
+	return matches, nil
+}
+
+// gitExport extracts the files of the current directory at a git revision
+// into dir.
+func gitExport(rev, dir string) error {
+	cmd := exec.Command("git", "archive", "--format=tar", rev)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	tr := tar.NewReader(out)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cmd.Wait()
+			return err
+		}
+		path := filepath.Join(dir, hdr.Name)
+		if !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue // don't write outside of dir
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(path, 0777)
+		case tar.TypeReg:
+			err = writeFile(path, tr)
+		}
+		if err != nil {
+			cmd.Wait()
+			return err
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git archive %s: %v: %s", rev, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func writeFile(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
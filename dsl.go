@@ -0,0 +1,219 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+)
+
+// dslMethods maps each of the methods that can be used in Go rules files to
+// the command it adds, if any.
+var dslMethods = map[string]string{
+	"Match":    "-x",
+	"Or":       "-or",
+	"Filter":   "-g",
+	"Exclude":  "-v",
+	"Attr":     "-a",
+	"Suggest":  "-s",
+	"Parents":  "-p",
+	"Severity": "",
+	"Report":   "",
+	"Docs":     "",
+}
+
+// loadGoRules reads the rules in a Go source file. Each func declares a rule
+// named after it, with a chain of method calls on its single parameter:
+//
+//	func nilCompare(m gogrep.Matcher) {
+//		m.Match(`$x == nil`).Exclude(`err == nil`).Report("nil check")
+//	}
+//
+// The file is never compiled, so it should be excluded from builds with a
+// build constraint. Its imports and types are not checked.
+//
+// String constants and variables, either at the top level or declared via
+// ":=" within a func, are macros which the arguments can use by name, or as
+// "${name}" within a string.
+func (m *matcher) loadGoRules(path string) ([]rule, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	macros := make(ruleMacros)
+	for _, decl := range f.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok || (gd.Tok != token.CONST && gd.Tok != token.VAR) {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if err := dslMacros(macros, vs.Names, vs.Values); err != nil {
+				return nil, fmt.Errorf("%v: %v", fset.Position(err.pos), err.msg)
+			}
+		}
+	}
+	var rules []rule
+	for _, decl := range f.Decls {
+		fd, ok := decl.(*ast.FuncDecl)
+		if !ok || fd.Body == nil {
+			continue
+		}
+		params := fd.Type.Params.List
+		if len(params) != 1 || len(params[0].Names) != 1 {
+			return nil, fmt.Errorf("%v: rule funcs must have a single parameter",
+				fset.Position(fd.Pos()))
+		}
+		recv := params[0].Names[0].Name
+		local := make(ruleMacros, len(macros))
+		for name, value := range macros {
+			local[name] = value
+		}
+		for _, stmt := range fd.Body.List {
+			if as, ok := stmt.(*ast.AssignStmt); ok && as.Tok == token.DEFINE {
+				var names []*ast.Ident
+				for _, lhs := range as.Lhs {
+					id, ok := lhs.(*ast.Ident)
+					if !ok {
+						return nil, fmt.Errorf("%v: expected a name",
+							fset.Position(lhs.Pos()))
+					}
+					names = append(names, id)
+				}
+				if err := dslMacros(local, names, as.Rhs); err != nil {
+					return nil, fmt.Errorf("%v: %v", fset.Position(err.pos), err.msg)
+				}
+				continue
+			}
+			r := rule{Name: fd.Name.Name}
+			if err := dslRule(&r, recv, stmt, local); err != nil {
+				return nil, fmt.Errorf("%v: %v", fset.Position(err.pos), err.msg)
+			}
+			if err := local.expandRule(&r); err != nil {
+				return nil, fmt.Errorf("%v: %v", fset.Position(stmt.Pos()), err)
+			}
+			if err := m.compileRule(&r); err != nil {
+				return nil, fmt.Errorf("%v: %v", fset.Position(stmt.Pos()), err)
+			}
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+// dslError is an error at a position in a Go rules file.
+type dslError struct {
+	pos token.Pos
+	msg string
+}
+
+// dslMacros adds the macros declared with names and values, such as in
+// "const call, ret = `$f($*_)`, `return $*_`".
+func dslMacros(macros ruleMacros, names []*ast.Ident, values []ast.Expr) *dslError {
+	if len(names) != len(values) {
+		return &dslError{names[0].Pos(), "macros must be declared with a value each"}
+	}
+	for i, id := range names {
+		value, err := dslString(macros, values[i])
+		if err != nil {
+			return err
+		}
+		if id.Name != "_" {
+			macros[id.Name] = value
+		}
+	}
+	return nil
+}
+
+// dslString returns the value of a string expression, which may be a string
+// literal, the name of a macro, or a concatenation of those via "+".
+func dslString(macros ruleMacros, expr ast.Expr) (string, *dslError) {
+	switch expr := expr.(type) {
+	case *ast.BasicLit:
+		if expr.Kind == token.STRING {
+			s, _ := strconv.Unquote(expr.Value)
+			return s, nil
+		}
+	case *ast.Ident:
+		if value, ok := macros[expr.Name]; ok {
+			return value, nil
+		}
+		return "", &dslError{expr.Pos(), fmt.Sprintf("undefined macro %q", expr.Name)}
+	case *ast.ParenExpr:
+		return dslString(macros, expr.X)
+	case *ast.BinaryExpr:
+		if expr.Op == token.ADD {
+			x, err := dslString(macros, expr.X)
+			if err != nil {
+				return "", err
+			}
+			y, err := dslString(macros, expr.Y)
+			return x + y, err
+		}
+	}
+	return "", &dslError{expr.Pos(), "expected a string literal or macro"}
+}
+
+// dslRule fills a rule from a statement like "m.Match(`foo`).Report(`bar`)",
+// where recv is "m". The arguments can use macros.
+func dslRule(r *rule, recv string, stmt ast.Stmt, macros ruleMacros) *dslError {
+	es, ok := stmt.(*ast.ExprStmt)
+	if !ok {
+		return &dslError{stmt.Pos(), "rule statements must be method calls"}
+	}
+	// unwind the chain, which is nested from the last call to the first
+	var calls []*ast.CallExpr
+	expr := es.X
+	for {
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return &dslError{expr.Pos(), "expected a method call"}
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return &dslError{call.Pos(), "expected a method call"}
+		}
+		calls = append([]*ast.CallExpr{call}, calls...)
+		if id, ok := sel.X.(*ast.Ident); ok && id.Name == recv {
+			break
+		}
+		expr = sel.X
+	}
+	for _, call := range calls {
+		sel := call.Fun.(*ast.SelectorExpr)
+		name := sel.Sel.Name
+		flag, ok := dslMethods[name]
+		if !ok {
+			return &dslError{sel.Sel.Pos(), fmt.Sprintf("unknown rule method %q", name)}
+		}
+		if len(call.Args) != 1 {
+			return &dslError{call.Pos(), fmt.Sprintf("%s takes one argument", name)}
+		}
+		var arg string
+		if lit, ok := call.Args[0].(*ast.BasicLit); ok && lit.Kind == token.INT {
+			arg = lit.Value
+		} else {
+			s, err := dslString(macros, call.Args[0])
+			if err != nil {
+				return err
+			}
+			arg = s
+		}
+		switch name {
+		case "Severity":
+			r.Severity = arg
+		case "Report":
+			r.Message = arg
+		case "Docs":
+			r.Docs = arg
+		default:
+			r.Pipeline = append(r.Pipeline, flag, arg)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// loadCost is how much source would be loaded for some packages.
+type loadCost struct {
+	pkgs, files, cgoFiles int
+	bytes                 int64
+}
+
+func (c *loadCost) add(dir string, names []string, cgo bool) {
+	for _, name := range names {
+		c.files++
+		if cgo {
+			c.cgoFiles++
+		}
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			c.bytes += info.Size()
+		}
+	}
+}
+
+func (c loadCost) String() string {
+	s := fmt.Sprintf("%d packages, %d files, %s", c.pkgs, c.files, formatSize(c.bytes))
+	if c.cgoFiles > 0 {
+		s += fmt.Sprintf(", %d using cgo", c.cgoFiles)
+	}
+	return s
+}
+
+// formatSize formats a number of bytes like 1.5MiB, where the units are
+// powers of 1024 as with -max-mem.
+func formatSize(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	size, unit := float64(n)/1024, 0
+	for size >= 1024 && unit < 3 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", size, []string{"KiB", "MiB", "GiB", "TiB"}[unit])
+}
+
+// printEstimate implements -estimate, which prints how much would be loaded
+// to run the commands on the packages, without loading them. The packages
+// and files are found as when loading them, but no file is parsed. If type
+// information is needed, the dependencies are counted too, as they would be
+// type-checked from source. If load is false, only the build targets are
+// counted, as when the rules are disabled in all of the packages.
+func (m *matcher) printEstimate(cmds []exprCmd, paths []string, load bool) error {
+	l := &m.loader
+	var matched, deps loadCost
+	for _, target := range l.targets {
+		matched.pkgs++
+		matched.add("", target.files, false)
+	}
+	if load {
+		paths = l.importPaths(paths)
+	} else {
+		paths = nil
+	}
+	groups := l.moduleGroups(paths)
+	split := groups != nil // as done by loadModules
+	if !split {
+		groups = map[string][]string{"": paths}
+	}
+	// count the matched packages before any dependencies, as a matched
+	// package may also be imported by another one
+	type imported struct {
+		ctx *build.Context
+		pkg *build.Package
+	}
+	var roots []imported
+	done := make(map[string]bool)
+	count := func(pkg *build.Package, match, direct bool) {
+		done[pkg.Dir] = true
+		cost := &deps
+		if match {
+			cost = &matched
+		}
+		if direct || !m.typed {
+			// only parsed, so all files are loaded
+			cost.add(pkg.Dir, pkg.TestGoFiles, false)
+			cost.add(pkg.Dir, pkg.XTestGoFiles, false)
+		}
+		if !m.typed {
+			cost.add(pkg.Dir, pkg.IgnoredGoFiles, false)
+		}
+		cost.pkgs++
+		cost.add(pkg.Dir, pkg.GoFiles, false)
+		cost.add(pkg.Dir, pkg.CgoFiles, true)
+	}
+	files := false
+	for root, paths := range groups {
+		ctx := *l.ctx
+		if split {
+			ctx.Dir = root
+			if root == "" {
+				ctx.Dir = l.wd // outside of any module
+			}
+		}
+		for _, path := range paths {
+			if strings.HasSuffix(path, ".go") {
+				// the files given as args make up a single package
+				if !files {
+					matched.pkgs++
+					files = true
+				}
+				matched.add("", []string{path}, false)
+				continue
+			}
+			pkg, err := ctx.Import(path, l.wd, 0)
+			if err != nil {
+				m.pkgErrs = append(m.pkgErrs, err)
+				continue
+			}
+			if !done[pkg.Dir] {
+				count(pkg, true, true)
+				roots = append(roots, imported{&ctx, pkg})
+			}
+		}
+	}
+	var addImports func(ctx *build.Context, pkg *build.Package, imports []string) // to recurse into self
+	addImports = func(ctx *build.Context, pkg *build.Package, imports []string) {
+		for _, path := range imports {
+			if path == "C" || path == "unsafe" {
+				continue
+			}
+			ipkg, err := ctx.Import(path, pkg.Dir, 0)
+			if err != nil || done[ipkg.Dir] {
+				continue // the loader reports it, if it matters
+			}
+			// skipped packages are still type-checked
+			count(ipkg, m.recursive && !l.skipListed(path), false)
+			addImports(ctx, ipkg, ipkg.Imports)
+		}
+	}
+	if m.typed || m.recursive {
+		for _, imp := range roots {
+			imports := append(imp.pkg.Imports, imp.pkg.TestImports...)
+			imports = append(imports, imp.pkg.XTestImports...)
+			addImports(imp.ctx, imp.pkg, imports)
+		}
+	}
+	m.reportSkipped()
+	fmt.Fprintf(m.out, "matched:       %v\n", matched)
+	if !m.typed {
+		fmt.Fprintf(m.out, "type-checking: not needed\n")
+	} else {
+		fmt.Fprintf(m.out, "type-checking: needed, also loading %v\n", deps)
+	}
+	switch cmd := m.wholeProgramCmd(cmds); {
+	case cmd == nil:
+	case split && len(groups) > 1:
+		fmt.Fprintf(m.out, "whole program: needed for -%s, but the packages are in %d modules\n",
+			cmd.name, len(groups))
+	default:
+		fmt.Fprintf(m.out, "whole program: needed for -%s, matching all the packages at once\n",
+			cmd.name)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import "go/ast"
+
+// etaReduced returns f if a node is a func literal which only calls f with
+// all of its parameters in order, like "func(x T) R { return f(x) }", such
+// that it's equivalent to f. With ~, the two match each other, so that a
+// search for a func passed as a callback also finds the closures wrapping
+// it. It returns nil for any other node, or if f isn't the name of a func or
+// a method value like s.handle, as calling anything else could have side
+// effects.
+func (m *matcher) etaReduced(node ast.Node) ast.Expr {
+	lit, ok := node.(*ast.FuncLit)
+	if !ok || len(lit.Body.List) != 1 {
+		return nil
+	}
+	var call *ast.CallExpr
+	switch stmt := lit.Body.List[0].(type) {
+	case *ast.ReturnStmt:
+		if lit.Type.Results == nil || len(stmt.Results) != 1 {
+			return nil
+		}
+		call, _ = stmt.Results[0].(*ast.CallExpr)
+	case *ast.ExprStmt:
+		if lit.Type.Results != nil {
+			return nil
+		}
+		call, _ = stmt.X.(*ast.CallExpr)
+	}
+	if call == nil {
+		return nil
+	}
+	params := make(map[string]bool)
+	var names []string
+	variadic := false
+	for _, field := range lit.Type.Params.List {
+		if len(field.Names) == 0 {
+			return nil // unnamed, so it can't be passed on
+		}
+		for _, id := range field.Names {
+			name := m.paramName(id)
+			if name == "_" || name == "$_" {
+				return nil
+			}
+			params[name] = true
+			names = append(names, name)
+		}
+		_, variadic = field.Type.(*ast.Ellipsis)
+	}
+	if len(call.Args) != len(names) || call.Ellipsis.IsValid() != variadic {
+		return nil
+	}
+	for i, arg := range call.Args {
+		if id, ok := arg.(*ast.Ident); !ok || m.paramName(id) != names[i] {
+			return nil
+		}
+	}
+	for fun := call.Fun; ; {
+		switch x := fun.(type) {
+		case *ast.Ident:
+			if params[m.paramName(x)] {
+				return nil
+			}
+			return call.Fun
+		case *ast.SelectorExpr:
+			fun = x.X
+		default:
+			return nil
+		}
+	}
+}
+
+// paramName returns the name of a parameter, where each use of a wildcard in
+// a pattern has its own ident, so that $x is the same param in all of them.
+func (m *matcher) paramName(id *ast.Ident) string {
+	if info := m.info(fromWildName(id.Name)); info.name != "" {
+		return "$" + info.name
+	}
+	return id.Name
+}
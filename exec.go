@@ -0,0 +1,97 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parseExec removes "-exec cmd args... ;" from args, recording the command
+// in m.execArgs. The flag package can't parse a variable number of
+// arguments, so this is done before it.
+func (m *matcher) parseExec(args []string) ([]string, error) {
+	m.execArgs = nil
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg != "-exec" && arg != "--exec" {
+			continue
+		}
+		for j := i + 1; j < len(args); j++ {
+			if args[j] == ";" {
+				m.execArgs = append([]string{}, args[i+1:j]...)
+				if len(m.execArgs) == 0 {
+					return nil, fmt.Errorf("-exec needs a command")
+				}
+				rest := append(args[:i:i], args[j+1:]...)
+				return rest, nil
+			}
+		}
+		return nil, fmt.Errorf(`-exec must be terminated by ";"`)
+	}
+	return args, nil
+}
+
+// execMatches runs the -exec command once for each match, in order. All
+// matches are run even if some commands fail.
+func (m *matcher) execMatches(subs []submatch) error {
+	failed := 0
+	for _, sub := range subs {
+		span := sub.span()
+		pos, end := m.position(span.pos), m.position(span.end)
+		vars := map[string]string{
+			"{}":        pos.String(),
+			"{file}":    pos.Filename,
+			"{line}":    strconv.Itoa(pos.Line),
+			"{endline}": strconv.Itoa(end.Line),
+			"{col}":     strconv.Itoa(pos.Column),
+			"{src}":     singleLinePrint(sub.node),
+		}
+		env := append(os.Environ(),
+			"GOGREP_FILE="+pos.Filename,
+			"GOGREP_LINE="+vars["{line}"],
+			"GOGREP_ENDLINE="+vars["{endline}"],
+			"GOGREP_COL="+vars["{col}"],
+			"GOGREP_SRC="+vars["{src}"],
+		)
+		var names []string
+		for name := range sub.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			src := singleLinePrint(sub.values[name])
+			vars["{$"+name+"}"] = src
+			env = append(env, "GOGREP_VAR_"+name+"="+src)
+		}
+		var pairs []string
+		for key, val := range vars {
+			pairs = append(pairs, key, val)
+		}
+		repl := strings.NewReplacer(pairs...)
+		args := make([]string, len(m.execArgs))
+		for i, arg := range m.execArgs {
+			args[i] = repl.Replace(arg)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = env
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = m.out
+		cmd.Stderr = m.errOut
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(m.errOut, "%v: %s: %v\n", pos, args[0], err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("-exec: %d of %d commands failed", failed, len(subs))
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// explainCmds prints how each of the commands was parsed, instead of
+// running them. Patterns are shown as the syntax tree they became, along
+// with their kind and wildcards, and attributes are described.
+func (m *matcher) explainCmds(cmds []exprCmd) {
+	if m.aggressive {
+		fmt.Fprintln(m.out, "aggressive mode: equivalent code may match too")
+	}
+	for _, cmd := range cmds {
+		if cmd.src == "" {
+			fmt.Fprintf(m.out, "-%s\n", cmd.name)
+		} else {
+			fmt.Fprintf(m.out, "-%s %s\n", cmd.name, joinWords([]string{cmd.src}))
+		}
+		switch x := cmd.value.(type) {
+		case ast.Node:
+			fmt.Fprintf(m.out, "  kind: %s\n", patternKind(x))
+			for _, wild := range m.wildcards(x) {
+				fmt.Fprintf(m.out, "  wildcard: %s\n", wild)
+			}
+			fmt.Fprintln(m.out, "  tree:")
+			m.explainTree(reflect.ValueOf(x), "", 2)
+		case attribute:
+			if x != nil {
+				fmt.Fprintf(m.out, "  attribute: %s\n", m.attrString(x))
+			}
+		}
+	}
+}
+
+// patternKind describes what kind of source a pattern was parsed as.
+func patternKind(node ast.Node) string {
+	switch x := node.(type) {
+	case *ast.File:
+		return "file"
+	case ast.Decl:
+		return "declaration"
+	case stmtList:
+		return fmt.Sprintf("%d statements", len(x))
+	case anchoredList:
+		var at []string
+		if x.start {
+			at = append(at, "start")
+		}
+		if x.end {
+			at = append(at, "end")
+		}
+		return fmt.Sprintf("%d statements, anchored at the %s of a block",
+			len(x.stmtList), strings.Join(at, " and "))
+	case exprList:
+		return fmt.Sprintf("%d expressions", len(x))
+	case ast.Stmt:
+		return fmt.Sprintf("statement (%T)", x)
+	case ast.Expr:
+		return fmt.Sprintf("expression (%T)", x)
+	case *ast.ImportSpec:
+		return "import spec"
+	case *ast.ValueSpec:
+		return "value spec"
+	case *ast.Field:
+		return "struct field"
+	}
+	return fmt.Sprintf("%T", node)
+}
+
+// wildcards returns the wildcards in a pattern, in the order they appear.
+func (m *matcher) wildcards(node ast.Node) []string {
+	var wilds []string
+	seen := make(map[string]bool)
+	inspect(node, func(node ast.Node) bool {
+		id, ok := node.(*ast.Ident)
+		if !ok || fromWildName(id.Name) < 0 {
+			return true
+		}
+		info := m.info(fromWildName(id.Name))
+		wild := "$" + info.name + " matches any single node"
+		if info.any {
+			wild = "$*" + info.name + " matches any number of nodes"
+		}
+		if info.name != "_" {
+			wild += ", the same each time"
+		}
+		if !seen[wild] {
+			seen[wild] = true
+			wilds = append(wilds, wild)
+		}
+		return true
+	})
+	return wilds
+}
+
+var tokenType = reflect.TypeOf(token.ILLEGAL)
+
+// explainTree prints a syntax tree, one node per line, omitting positions
+// and empty fields. Wildcards are printed as they were written.
+func (m *matcher) explainTree(v reflect.Value, prefix string, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if v.Kind() == reflect.Interface {
+			m.explainTree(v.Elem(), prefix, depth)
+			return
+		}
+	}
+	switch x := v.Interface().(type) {
+	case *ast.Ident:
+		fmt.Fprintf(m.out, "%s%sIdent %s\n", indent, prefix, m.patternString(x))
+		return
+	case *ast.BasicLit:
+		fmt.Fprintf(m.out, "%s%sBasicLit %s\n", indent, prefix, x.Value)
+		return
+	case exprList, stmtList:
+		for i := 0; i < v.Len(); i++ {
+			m.explainTree(v.Index(i), "", depth)
+		}
+		return
+	case anchoredList:
+		m.explainTree(reflect.ValueOf(x.stmtList), prefix, depth)
+		return
+	}
+	if v.Kind() == reflect.Slice {
+		if v.Len() == 0 {
+			return
+		}
+		fmt.Fprintf(m.out, "%s%s\n", indent, strings.TrimSuffix(prefix, " "))
+		for i := 0; i < v.Len(); i++ {
+			m.explainTree(v.Index(i), "", depth+1)
+		}
+		return
+	}
+	elem := v
+	if v.Kind() == reflect.Ptr {
+		elem = v.Elem()
+	}
+	if elem.Kind() != reflect.Struct {
+		return
+	}
+	fmt.Fprintf(m.out, "%s%s%s\n", indent, prefix, elem.Type().Name())
+	for i := 0; i < elem.NumField(); i++ {
+		field, ftype := elem.Field(i), elem.Type().Field(i)
+		switch ftype.Type {
+		case posType, objectType:
+			continue
+		case tokenType:
+			if tok := field.Interface().(token.Token); tok != token.ILLEGAL {
+				fmt.Fprintf(m.out, "%s  %s: %s\n", indent, ftype.Name, tok)
+			}
+			continue
+		}
+		switch field.Kind() {
+		case reflect.Bool:
+			if field.Bool() {
+				fmt.Fprintf(m.out, "%s  %s: true\n", indent, ftype.Name)
+			}
+		case reflect.Interface, reflect.Ptr, reflect.Slice:
+			if ftype.Type == reflect.TypeOf((*ast.CommentGroup)(nil)) {
+				continue
+			}
+			m.explainTree(field, ftype.Name+": ", depth+1)
+		}
+	}
+}
+
+// attrString describes an attribute given to -a.
+func (m *matcher) attrString(attr attribute) string {
+	switch x := attr.(type) {
+	case negAttr:
+		return "not " + m.attrString(x.attr)
+	case typeCheck:
+		typ := singleLinePrint(x.expr)
+		switch x.op {
+		case "type":
+			return "type constraint: the type is identical to " + typ
+		case "asgn":
+			return "type constraint: the type is assignable to " + typ
+		default: // "conv"
+			return "type constraint: the type is convertible to " + typ
+		}
+	case typUnderlying:
+		return "type constraint: the underlying type is a " + string(x)
+	case typProperty:
+		switch x {
+		case "comp":
+			return "type constraint: the type is comparable"
+		default: // "addr"
+			return "the node is addressable"
+		}
+	case objProperty:
+		return "the object is " + string(x)
+	case constArgCheck:
+		switch {
+		case x.nilArg:
+			return "the parameter is passed nil at every call site"
+		case x.want != nil:
+			return "the parameter is passed the constant " + x.want.ExactString() + " at every call site"
+		}
+		return "the parameter is passed a constant at every call site"
+	case objCheck:
+		return "the object or its package is one of " + strings.Join(x, ", ")
+	case *regexp.Regexp:
+		return "the identifier's name matches " + x.String()
+	case countCheck:
+		return fmt.Sprintf("the number of matches of %s is %s %g",
+			m.patternString(x.expr), x.op, x.n)
+	case depthCheck:
+		return fmt.Sprintf("the nesting depth of %s is %s %g", x.kind, x.op, x.n)
+	case entropyCheck:
+		return fmt.Sprintf("the string's entropy in bits per character is %s %g", x.op, x.n)
+	case sizeCheck:
+		what := "size"
+		if x.align {
+			what = "alignment"
+		}
+		return fmt.Sprintf("the type's %s in bytes is %s %g", what, x.op, x.n)
+	case base64Check:
+		return "the string looks like a base64 or hex encoded token"
+	case ctxCheck:
+		return "the call passes a new context while a context parameter is in scope"
+	case unlockCheck:
+		return "the lock is unlocked on every path to a return, or by a defer"
+	case loopVarCheck:
+		return "the func literal run by go or defer captures a loop variable"
+	case formatCheck:
+		return "the call's printf format doesn't agree with its arguments"
+	case anonCheck:
+		return "the type is anonymous"
+	case labelCheck:
+		return "the label is targeted by a break, continue, or goto"
+	case pkgDirCheck:
+		return "the package is named after its directory"
+	case recvCheck:
+		return "within a method with a receiver type matching " + m.patternString(x.expr)
+	case commentCheck:
+		return fmt.Sprintf("a comment within %d lines matches %s", x.lines, x.rx)
+	case buildCheck:
+		if x.tags == nil {
+			return "the file has build constraints"
+		}
+		var tags []string
+		for tag := range x.tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		return "the file's build constraints use " + strings.Join(tags, ", ")
+	case docCheck:
+		switch {
+		case x.op == "docname":
+			return "the doc comment starts with the declared name"
+		case x.rx == nil:
+			return "the declaration has a doc comment"
+		default:
+			return fmt.Sprintf("the %s comment matches %s", x.op, x.rx)
+		}
+	}
+	return fmt.Sprintf("%v", attr)
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/parser"
 	"go/scanner"
 	"go/token"
@@ -14,12 +15,20 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"unicode"
 )
 
-func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
+// listAnchors records whether a statement list pattern must be at the start
+// or the end of its block, as written with a leading "^" or a trailing "$".
+type listAnchors struct {
+	start, end bool
+}
+
+func (m *matcher) transformSource(expr string) (string, []posOffset, listAnchors, error) {
+	var anchors listAnchors
 	toks, err := m.tokenize([]byte(expr))
 	if err != nil {
-		return "", nil, fmt.Errorf("cannot tokenize expr: %v", err)
+		return "", nil, anchors, fmt.Errorf("cannot tokenize expr: %v", withCaret(err, expr))
 	}
 	var offs []posOffset
 	lbuf := lineColBuffer{line: 1, col: 1}
@@ -37,6 +46,14 @@ func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
 	}
 	lastLit := false
 	for _, t := range toks {
+		switch t.tok {
+		case tokAnchorStart:
+			anchors.start = true
+			continue
+		case tokAnchorEnd:
+			anchors.end = true
+			continue
+		}
 		if lbuf.offs >= t.pos.Offset && lastLit && t.lit != "" {
 			lbuf.WriteString(" ")
 		}
@@ -56,19 +73,34 @@ func (m *matcher) transformSource(expr string) (string, []posOffset, error) {
 		lbuf.WriteString(t.lit)
 		lastLit = strings.TrimSpace(t.lit) != ""
 	}
-	// trailing newlines can cause issues with commas
-	return strings.TrimSpace(lbuf.String()), offs, nil
+	// trailing newlines can cause issues with commas; leading space is
+	// kept, so that error positions are the same as in the pattern
+	return strings.TrimRightFunc(lbuf.String(), unicode.IsSpace), offs, anchors, nil
 }
 
 func (m *matcher) parseExpr(expr string) (ast.Node, error) {
-	exprStr, offs, err := m.transformSource(expr)
+	exprStr, offs, anchors, err := m.transformSource(expr)
 	if err != nil {
 		return nil, err
 	}
 	node, err := parseDetectingNode(exprStr)
 	if err != nil {
 		err = subPosOffsets(err, offs...)
-		return nil, fmt.Errorf("cannot parse expr: %v", err)
+		return nil, fmt.Errorf("cannot parse expr: %v", withCaret(err, expr))
+	}
+	if anchors.start || anchors.end {
+		var list stmtList
+		switch x := node.(type) {
+		case stmtList:
+			list = x
+		case ast.Stmt:
+			list = stmtList{x}
+		case ast.Expr:
+			list = stmtList{&ast.ExprStmt{X: x}}
+		default:
+			return nil, fmt.Errorf("anchors only apply to statements, not %T", node)
+		}
+		return anchoredList{list, anchors}, nil
 	}
 	return node, nil
 }
@@ -106,6 +138,9 @@ var tmplType = template.Must(template.New("").Parse(`` +
 var tmplValSpec = template.Must(template.New("").Parse(`` +
 	`package p; var {{ . }}`))
 
+var tmplField = template.Must(template.New("").Parse(`` +
+	`package p; type _ struct { {{ . }} }`))
+
 func execTmpl(tmpl *template.Template, src string) string {
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, src); err != nil {
@@ -148,6 +183,11 @@ func parseDetectingNode(src string) (ast.Node, error) {
 	asDecl := execTmpl(tmplDecl, src)
 	if f, err := parser.ParseFile(fset, "", asDecl, 0); err == nil {
 		if dc := f.Decls[0]; noBadNodes(dc) {
+			if gd, ok := dc.(*ast.GenDecl); ok && gd.Tok == token.IMPORT &&
+				!gd.Lparen.IsValid() && len(gd.Specs) == 1 {
+				// match each import, even if grouped
+				return gd.Specs[0], nil
+			}
 			return dc, nil
 		}
 	}
@@ -198,9 +238,108 @@ func parseDetectingNode(src string) (ast.Node, error) {
 			return vs, nil
 		}
 	}
+
+	// struct fields, such as those with tags
+	asField := execTmpl(tmplField, src)
+	if f, err := parser.ParseFile(fset, "", asField, 0); err == nil {
+		ts := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec)
+		fields := ts.Type.(*ast.StructType).Fields.List
+		if len(fields) == 1 && noBadNodes(fields[0]) {
+			return fields[0], nil
+		}
+	}
 	return nil, mainErr
 }
 
+// withCaret adds the line in a pattern where a parse error is to its message,
+// with a caret under the error's column and a hint about brackets if there's
+// one. Errors after the end of the pattern, such as ones about the braces
+// wrapping it when parsing, are moved to its end.
+func withCaret(err error, src string) error {
+	var first scanner.Error
+	more := 0
+	switch x := err.(type) {
+	case scanner.ErrorList:
+		if len(x) == 0 {
+			return err
+		}
+		first, more = *x[0], len(x)-1
+	case *scanner.Error:
+		first = *x
+	default:
+		return err
+	}
+	lines := strings.Split(src, "\n")
+	if first.Pos.Line < 1 {
+		return err
+	}
+	if first.Pos.Line > len(lines) {
+		first.Pos.Line, first.Pos.Column = len(lines), len(lines[len(lines)-1])+1
+	}
+	line := lines[first.Pos.Line-1]
+	if first.Pos.Column > len(line) {
+		first.Pos.Column = len(line) + 1
+		if i := strings.Index(first.Msg, ", found "); i >= 0 {
+			first.Msg = first.Msg[:i] + ", found end of pattern"
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString(first.Error())
+	if more > 0 {
+		fmt.Fprintf(&buf, " (and %d more errors)", more)
+	}
+	buf.WriteString("\n\t" + line + "\n\t")
+	for _, r := range line[:first.Pos.Column-1] {
+		if r == '\t' {
+			buf.WriteByte('\t')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteString("^")
+	if hint := bracketHint(src); hint != "" {
+		buf.WriteString(" " + hint)
+	}
+	return fmt.Errorf("%s", buf.String())
+}
+
+// bracketHint returns a hint about the first closing bracket in a pattern
+// without an opening one, or the last opening bracket left unclosed.
+func bracketHint(src string) string {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, []byte(src), func(token.Position, string) {}, 0)
+	closing := map[token.Token]token.Token{
+		token.RPAREN: token.LPAREN,
+		token.RBRACK: token.LBRACK,
+		token.RBRACE: token.LBRACE,
+	}
+	type open struct {
+		tok token.Token
+		pos token.Position
+	}
+	var stack []open
+	for {
+		pos, tok, _ := s.Scan()
+		switch tok {
+		case token.EOF:
+			if len(stack) > 0 {
+				last := stack[len(stack)-1]
+				return fmt.Sprintf("unclosed '%s' at %v", last.tok, last.pos)
+			}
+			return ""
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			stack = append(stack, open{tok, fset.Position(pos)})
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			if len(stack) == 0 || stack[len(stack)-1].tok != closing[tok] {
+				return fmt.Sprintf("unmatched '%s' at %v", tok, fset.Position(pos))
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
 type posOffset struct {
 	atLine, atCol int
 	offset        int
@@ -229,6 +368,8 @@ func subPosOffsets(err error, offs ...posOffset) error {
 const (
 	_ token.Token = -iota
 	tokAggressive
+	tokAnchorStart
+	tokAnchorEnd
 )
 
 type fullToken struct {
@@ -246,7 +387,6 @@ const (
 )
 
 func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
-	m.typed = false
 	var s scanner.Scanner
 	fset := token.NewFileSet()
 	file := fset.AddFile("", fset.Base(), len(src))
@@ -257,7 +397,7 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 		case `illegal character U+0024 '$'`:
 		case `illegal character U+007E '~'`:
 		default:
-			err = fmt.Errorf("%v: %s", pos, msg)
+			err = &scanner.Error{Pos: pos, Msg: msg}
 		}
 	}
 
@@ -265,17 +405,56 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 	// enable some features such as regexes.
 	s.Init(file, src, onError, scanner.ScanComments)
 
+	var peeked []fullToken
 	next := func() fullToken {
+		if len(peeked) > 0 {
+			t := peeked[0]
+			peeked = peeked[1:]
+			return t
+		}
 		pos, tok, lit := s.Scan()
 		return fullToken{fset.Position(pos), tok, lit}
 	}
 
 	caseStat := caseNone
 
+	// whether each wildcard name was used as $*name
+	wildAny := make(map[string]bool)
+
 	var toks []fullToken
 	for t := next(); t.tok != token.EOF; t = next() {
+		if t.tok == token.XOR && (len(toks) == 0 || toks[len(toks)-1].tok == tokAggressive) {
+			// "^" as a leading statement of its own is an anchor,
+			// but "^x" is an expression
+			t2 := next()
+			if t2.tok == token.SEMICOLON && t2.lit == ";" {
+				toks = append(toks, fullToken{t.pos, tokAnchorStart, ""})
+				continue
+			}
+			peeked = append(peeked, t2)
+			if t2.pos.Line > t.pos.Line {
+				toks = append(toks, fullToken{t.pos, tokAnchorStart, ""})
+				continue
+			}
+		}
+		if t.tok == token.TILDE {
+			t.lit = "~" // an operator since type sets, rather than illegal
+		}
 		switch t.lit {
 		case "$": // continues below
+			if last := len(toks) - 1; last >= 0 && toks[last].tok == token.SEMICOLON {
+				after := []fullToken{next()}
+				if t2 := after[0]; t2.tok == token.SEMICOLON && t2.lit == "\n" {
+					after = append(after, next()) // likely inserted at EOF
+				}
+				if after[len(after)-1].tok == token.EOF {
+					// "$" as a trailing statement of its own
+					// is an anchor, not a wildcard
+					toks = append(toks, fullToken{t.pos, tokAnchorEnd, ""})
+					continue
+				}
+				peeked = append(peeked, after...)
+			}
 		case "~":
 			toks = append(toks, fullToken{t.pos, tokAggressive, ""})
 			continue
@@ -293,9 +472,37 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 			toks = append(toks, t)
 			continue
 		}
-		wt, err := m.wildcard(t.pos, next)
-		if err != nil {
-			return nil, err
+		wt, split, werr := m.wildcard(t.pos, next)
+		if werr != nil {
+			return nil, werr
+		}
+		if len(split) > 1 {
+			// "$1.Error" scans as the malformed number "1.E"
+			// followed by rror, which isn't an error here
+			sel := split[1]
+			if serr, ok := err.(*scanner.Error); ok && serr.Pos.Offset > t.pos.Offset &&
+				serr.Pos.Offset <= sel.pos.Offset+len(sel.lit) {
+				err = nil
+			}
+		}
+		peeked = append(split, peeked...)
+		if info := m.vars[len(m.vars)-1]; info.name != "_" {
+			if any, ok := wildAny[info.name]; ok && any != info.any {
+				return nil, &scanner.Error{Pos: t.pos, Msg: fmt.Sprintf(
+					"$%s and $*%s can't be mixed, as it's either one node or any number of them",
+					info.name, info.name)}
+			}
+			wildAny[info.name] = info.any
+		}
+		t2 := next()
+		peeked = append([]fullToken{t2}, peeked...)
+		if last := len(toks) - 1; last >= 0 && toks[last].tok == token.ELSE || t2.tok == token.ELSE {
+			// "else $*x" and "$*x else" stand for arms of an
+			// if/else chain, which parse as "if $*x {}"
+			m.vars[len(m.vars)-1].arms = true
+			toks = append(toks, fullToken{wt.pos, token.IF, "if"}, wt,
+				fullToken{wt.pos, token.LBRACE, ""}, fullToken{wt.pos, token.RBRACE, ""})
+			continue
 		}
 		if caseStat == caseHere {
 			toks = append(toks, fullToken{wt.pos, token.IDENT, "case"})
@@ -309,7 +516,9 @@ func (m *matcher) tokenize(src []byte) ([]fullToken, error) {
 	return toks, err
 }
 
-func (m *matcher) wildcard(pos token.Position, next func() fullToken) (fullToken, error) {
+// wildcard parses a wildcard after its "$". Any tokens which had to be split
+// from its name, like the ".f" in "$1.f", are returned to be scanned next.
+func (m *matcher) wildcard(pos token.Position, next func() fullToken) (fullToken, []fullToken, error) {
 	wt := fullToken{pos, token.IDENT, wildPrefix}
 	t := next()
 	var info varInfo
@@ -317,15 +526,54 @@ func (m *matcher) wildcard(pos token.Position, next func() fullToken) (fullToken
 		t = next()
 		info.any = true
 	}
+	var split []fullToken
+	if t.tok == token.FLOAT || t.tok == token.IMAG {
+		split = splitWildNumber(&t, next)
+	}
+	if t.tok == token.INT && isWildNumber(t.lit) {
+		t.tok = token.IDENT // a numbered wildcard like $1
+	}
 	if t.tok != token.IDENT {
-		return wt, fmt.Errorf("%v: $ must be followed by ident, got %v",
-			t.pos, t.tok)
+		return wt, nil, &scanner.Error{Pos: t.pos,
+			Msg: fmt.Sprintf("$ must be followed by ident or number, got %v", t.tok)}
 	}
 	id := len(m.vars)
 	wt.lit += strconv.Itoa(id)
 	info.name = t.lit
 	m.vars = append(m.vars, info)
-	return wt, nil
+	return wt, split, nil
+}
+
+// splitWildNumber splits a numbered wildcard from a selector on it, as
+// "$1.f" scans as the float "1." followed by f, and "$1.imag" as the
+// imaginary "1.i" followed by mag. The token is replaced by the number, and
+// the tokens after it are returned. Other numbers are left as they are.
+func splitWildNumber(t *fullToken, next func() fullToken) []fullToken {
+	i := strings.IndexByte(t.lit, '.')
+	if i < 0 || !isWildNumber(t.lit[:i]) {
+		return nil
+	}
+	sel := t.lit[i+1:]
+	if sel != "" && !token.IsIdentifier(sel) {
+		return nil
+	}
+	dot := t.pos
+	dot.Offset += i
+	dot.Column += i
+	t.tok, t.lit = token.INT, t.lit[:i]
+	split := []fullToken{{dot, token.PERIOD, ""}}
+	if sel == "" {
+		return split
+	}
+	id := fullToken{dot, token.IDENT, sel}
+	id.pos.Offset++
+	id.pos.Column++
+	t2 := next()
+	if t2.tok == token.IDENT && t2.pos.Offset == id.pos.Offset+len(sel) {
+		id.lit += t2.lit // the rest of the name, as in "imag"
+		return append(split, id)
+	}
+	return append(split, id, t2)
 }
 
 type typeCheck struct {
@@ -339,7 +587,133 @@ type typProperty string
 
 type typUnderlying string
 
+type objProperty string
+
+// constArgCheck checks whether a parameter is passed a constant at every call
+// site of its func. If want is non-nil, or if nilArg is set, the constant
+// must be that value.
+type constArgCheck struct {
+	want   constant.Value
+	nilArg bool
+}
+
+// objCheck checks whether a node refers to an object from any of a number of
+// packages, given by path, or to a specific object as "path.Name".
+type objCheck []string
+
+// commentCheck checks whether there is a comment matching a regular
+// expression near a node, or attached to its statement.
+type commentCheck struct {
+	rx    *regexp.Regexp
+	lines int // how far from the node to look
+}
+
+// buildCheck checks a file's build constraints. If tags is nil, it checks
+// whether there are any constraints at all.
+type buildCheck struct {
+	tags map[string]bool
+}
+
+// countCheck checks whether the number of matches of a pattern within a
+// node, such as a file, compares to a number with an operator like ">".
+type countCheck struct {
+	expr ast.Node
+	numCmp
+}
+
+// depthCheck checks how deeply a node is nested, counting the enclosing
+// nodes of a kind; see depthKinds.
+type depthCheck struct {
+	kind string
+	numCmp
+}
+
+// depthKinds lists the kinds of nesting that depth can count. "block" counts
+// all of "if", "loop", and "switch", which includes selects. Those stop at
+// the enclosing function, while "func" counts function literals and the
+// declaration around them.
+var depthKinds = map[string]bool{
+	"block": true, "if": true, "loop": true, "switch": true, "func": true,
+}
+
+// numCmp compares a number to n with an operator like ">".
+type numCmp struct {
+	op token.Token
+	n  float64
+}
+
+func (c numCmp) holds(n float64) bool {
+	switch c.op {
+	case token.EQL:
+		return n == c.n
+	case token.NEQ:
+		return n != c.n
+	case token.LSS:
+		return n < c.n
+	case token.LEQ:
+		return n <= c.n
+	case token.GTR:
+		return n > c.n
+	default: // token.GEQ
+		return n >= c.n
+	}
+}
+
+// entropyCheck checks the Shannon entropy of a string literal's value, in
+// bits per character.
+type entropyCheck struct {
+	numCmp
+}
+
+// sizeCheck checks the size or the alignment of a node's type in bytes, as
+// given by "sizeof" or "alignof", on the target platform.
+type sizeCheck struct {
+	align bool
+	numCmp
+}
+
+// base64Check checks whether a string literal's value looks like a base64 or
+// hex encoded token, such as a key.
+type base64Check struct{}
+
+// pkgDirCheck checks whether a file's package is named after its directory,
+// allowing a "_test" suffix.
+type pkgDirCheck struct{}
+
+// anonCheck checks whether a struct, interface, or func type is anonymous,
+// as opposed to being declared with a name.
+type anonCheck struct{}
+
+// labelCheck checks whether a labeled statement is the target of a break,
+// continue, or goto.
+type labelCheck struct{}
+
+// recvCheck checks whether a node is within a method whose receiver type
+// matches a pattern, such as "*Server".
+type recvCheck struct {
+	expr ast.Node
+}
+
+// negAttr is an attribute that applies when the one it wraps doesn't.
+type negAttr struct {
+	attr attribute
+}
+
+type docCheck struct {
+	op string         // "doc", "docname", "directive"
+	rx *regexp.Regexp // optional for "doc"
+}
+
 func (m *matcher) parseAttrs(src string) (attribute, error) {
+	if trimmed := strings.TrimLeft(src, " \t"); strings.HasPrefix(trimmed, "!") {
+		// replace the "!" with a space, to keep positions intact
+		n := len(src) - len(trimmed)
+		attr, err := m.parseAttrs(src[:n] + " " + trimmed[1:])
+		if err != nil {
+			return nil, err
+		}
+		return negAttr{attr}, nil
+	}
 	toks, err := m.tokenize([]byte(src))
 	if err != nil {
 		return nil, err
@@ -352,39 +726,263 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 		}
 		return fullToken{tok: token.EOF, pos: t.pos}
 	}
+	// wantEOF checks that the next token ends the attribute.
+	wantEOF := func() error {
+		if t = next(); t.tok != token.SEMICOLON {
+			return fmt.Errorf("%v: wanted EOF, got %v", t.pos, t.tok)
+		}
+		return nil
+	}
+	// cmpNum parses a comparison with a number, such as "> 2", which must
+	// end the attribute.
+	cmpNum := func(what string) (numCmp, error) {
+		var cmp numCmp
+		switch t = next(); t.tok {
+		case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			cmp.op = t.tok
+		default:
+			return cmp, fmt.Errorf("%v: wanted a comparison, got %v", t.pos, t.tok)
+		}
+		t = next()
+		n, err := strconv.ParseFloat(t.lit, 64)
+		if err != nil || n < 0 {
+			return cmp, fmt.Errorf("%v: wanted %s", t.pos, what)
+		}
+		cmp.n = n
+		if err := wantEOF(); err != nil {
+			return cmp, err
+		}
+		return cmp, nil
+	}
 	t = next()
 	op := t.lit
 	switch op { // the ones that don't take args
 	case "comp", "addr":
 		m.typed = true
-		if t = next(); t.tok != token.SEMICOLON {
-			return nil, fmt.Errorf("%v: wanted EOF, got %v", t.pos, t.tok)
+		if err := wantEOF(); err != nil {
+			return nil, err
 		}
 		return typProperty(op), nil
+	case "deprecated":
+		m.typed = true
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return objProperty(op), nil
+	case "dirname":
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return pkgDirCheck{}, nil
+	case "targeted":
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return labelCheck{}, nil
+	case "anon":
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return anonCheck{}, nil
+	case "dropsctx":
+		m.typed = true
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return ctxCheck{}, nil
+	case "unlocked":
+		m.typed = true
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return unlockCheck{}, nil
+	case "loopvar":
+		m.typed = true
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return loopVarCheck{}, nil
+	case "badformat":
+		m.typed = true
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return formatCheck{}, nil
+	case "base64":
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return base64Check{}, nil
+	case "sizeof", "alignof":
+		m.typed = true
+		cmp, err := cmpNum("a number of bytes")
+		if err != nil {
+			return nil, err
+		}
+		return sizeCheck{op == "alignof", cmp}, nil
+	case "entropy":
+		cmp, err := cmpNum("a number of bits")
+		if err != nil {
+			return nil, err
+		}
+		return entropyCheck{cmp}, nil
+	case "constarg":
+		m.typed = true
+		if i+1 < len(toks) && toks[i+1].tok == token.LPAREN {
+			break // constarg(value), handled below
+		}
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return constArgCheck{}, nil
+	case "build":
+		if i+1 < len(toks) && toks[i+1].tok == token.LPAREN {
+			break // build(tags), handled below
+		}
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return buildCheck{}, nil
+	case "doc", "docname":
+		if op == "doc" && i+1 < len(toks) && toks[i+1].tok == token.LPAREN {
+			break // doc(rx), handled below
+		}
+		if err := wantEOF(); err != nil {
+			return nil, err
+		}
+		return docCheck{op: op}, nil
 	}
 	opPos := t.pos
 	if t = next(); t.tok != token.LPAREN {
 		return nil, fmt.Errorf("%v: wanted (", t.pos)
 	}
+	// parenSrc returns the source up to the matching closing parenthesis,
+	// which is left as the next token.
+	parenSrc := func() string {
+		start, end := len(src), len(src)
+		if i+1 < len(toks) {
+			start = toks[i+1].pos.Offset
+		}
+		for open := 1; i+1 < len(toks); i++ {
+			switch toks[i+1].tok {
+			case token.LPAREN:
+				open++
+			case token.RPAREN:
+				open--
+			}
+			if open == 0 {
+				end = toks[i+1].pos.Offset
+				break
+			}
+		}
+		return string(src[start:end])
+	}
 	var attr attribute
 	switch op {
+	case "constarg":
+		var check constArgCheck
+		neg := false
+		if t = next(); t.tok == token.SUB {
+			neg = true
+			t = next()
+		}
+		switch t.tok {
+		case token.INT, token.FLOAT, token.IMAG, token.CHAR, token.STRING:
+			check.want = constant.MakeFromLiteral(t.lit, t.tok, 0)
+		case token.IDENT:
+			switch t.lit {
+			case "true", "false":
+				check.want = constant.MakeBool(t.lit == "true")
+			case "nil":
+				check.nilArg = true
+			}
+		}
+		valid := check.want != nil || check.nilArg
+		if neg {
+			valid = check.want != nil && (check.want.Kind() == constant.Int ||
+				check.want.Kind() == constant.Float)
+		}
+		if !valid {
+			return nil, fmt.Errorf("%v: wanted a constant", t.pos)
+		}
+		if neg {
+			check.want = constant.UnaryOp(token.SUB, check.want, 0)
+		}
+		attr = check
+	case "obj":
+		var check objCheck
+		for {
+			t = next()
+			path, err := strconv.Unquote(t.lit)
+			if t.tok != token.STRING || err != nil {
+				return nil, fmt.Errorf("%v: wanted a quoted path", t.pos)
+			}
+			check = append(check, path)
+			if i+1 >= len(toks) || toks[i+1].tok != token.COMMA {
+				break
+			}
+			next()
+		}
+		attr = check
+		m.typed = true
 	case "rx":
 		t = next()
 		rxStr, err := strconv.Unquote(t.lit)
 		if err != nil {
 			return nil, fmt.Errorf("%v: %v", t.pos, err)
 		}
-		if !strings.HasPrefix(rxStr, "^") {
-			rxStr = "^" + rxStr
+		// the entire name must match, even with alternations
+		// like "a|b"
+		rx, err := regexp.Compile("^(?:" + rxStr + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		attr = rx
+	case "doc", "directive":
+		t = next()
+		rxStr, err := strconv.Unquote(t.lit)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		rx, err := regexp.Compile(rxStr)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", t.pos, err)
 		}
-		if !strings.HasSuffix(rxStr, "$") {
-			rxStr = rxStr + "$"
+		attr = docCheck{op, rx}
+	case "comment":
+		t = next()
+		rxStr, err := strconv.Unquote(t.lit)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", t.pos, err)
 		}
 		rx, err := regexp.Compile(rxStr)
 		if err != nil {
 			return nil, fmt.Errorf("%v: %v", t.pos, err)
 		}
-		attr = rx
+		check := commentCheck{rx: rx}
+		if i+1 < len(toks) && toks[i+1].tok == token.COMMA {
+			next()
+			t = next()
+			n, err := strconv.Atoi(t.lit)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%v: wanted a number of lines", t.pos)
+			}
+			check.lines = n
+		}
+		attr = check
+	case "build":
+		t = next()
+		tagsStr, err := strconv.Unquote(t.lit)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", t.pos, err)
+		}
+		tags := make(map[string]bool)
+		for _, tag := range strings.Split(tagsStr, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags[tag] = true
+			}
+		}
+		attr = buildCheck{tags}
 	case "type", "asgn", "conv":
 		t = next()
 		start := t.pos.Offset
@@ -407,6 +1005,39 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 		attr = typeCheck{op, typeExpr}
 		m.typed = true
 		i -= 2 // since we went past RPAREN above
+	case "recv":
+		expr, err := m.parseExpr(parenSrc())
+		if err != nil {
+			return nil, err
+		}
+		attr = recvCheck{expr}
+	case "count":
+		expr, err := m.parseExpr(parenSrc())
+		if err != nil {
+			return nil, err
+		}
+		if t = next(); t.tok != token.RPAREN {
+			return nil, fmt.Errorf("%v: expected ) to close (", t.pos)
+		}
+		cmp, err := cmpNum("a number of matches")
+		if err != nil {
+			return nil, err
+		}
+		return countCheck{expr, cmp}, nil
+	case "depth":
+		t = next()
+		if !depthKinds[t.lit] {
+			return nil, fmt.Errorf("%v: unknown depth kind: %q", t.pos, t.lit)
+		}
+		kind := t.lit
+		if t = next(); t.tok != token.RPAREN {
+			return nil, fmt.Errorf("%v: wanted ), got %v", t.pos, t.tok)
+		}
+		cmp, err := cmpNum("a depth")
+		if err != nil {
+			return nil, err
+		}
+		return depthCheck{kind, cmp}, nil
 	case "is":
 		switch t = next(); t.lit {
 		case "basic", "array", "slice", "struct", "interface",
@@ -423,8 +1054,8 @@ func (m *matcher) parseAttrs(src string) (attribute, error) {
 	if t = next(); t.tok != token.RPAREN {
 		return nil, fmt.Errorf("%v: wanted ), got %v", t.pos, t.tok)
 	}
-	if t = next(); t.tok != token.SEMICOLON {
-		return nil, fmt.Errorf("%v: wanted EOF, got %v", t.pos, t.tok)
+	if err := wantEOF(); err != nil {
+		return nil, err
 	}
 	return attr, nil
 }
@@ -436,6 +1067,19 @@ func isWildName(name string) bool {
 	return strings.HasPrefix(name, wildPrefix)
 }
 
+// isWildNumber reports whether a wildcard's name is a number, as in $1 and
+// $2, which saves naming each wildcard in quick one-off queries. They're
+// otherwise like any other wildcard, so "$1" in -s is replaced by the value
+// of $1 in -x.
+func isWildNumber(name string) bool {
+	for _, r := range name {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return name != ""
+}
+
 func fromWildName(s string) int {
 	if !isWildName(s) {
 		return -1
@@ -0,0 +1,155 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// fixEdit is an edit printed by "gogrep fix -edit" or returned by the
+// rewritePreview method of "gogrep serve", replacing the source
+// between two positions with new text. Offsets are in bytes, and columns
+// are in bytes starting at 1.
+type fixEdit struct {
+	File      string `json:"file"`
+	Rule      string `json:"rule,omitempty"`
+	Offset    int    `json:"offset"`
+	End       int    `json:"end"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	NewText   string `json:"new_text"`
+}
+
+// fixArgs implements "gogrep fix [-edit] file:line:col [flags] [packages]",
+// which runs the rules on the packages and applies the substitution of the
+// innermost rule match covering the position. The rewritten file is printed,
+// or with -edit, only the replaced range and its new source as JSON. The
+// file itself is left untouched, so that editors can apply the change.
+func (m *matcher) fixArgs(args []string) error {
+	const usage = "usage: gogrep fix [-edit] file:line:col [flags] [packages]"
+	asEdit := false
+	if len(args) > 0 && (args[0] == "-edit" || args[0] == "--edit") {
+		asEdit = true
+		args = args[1:]
+	}
+	if len(args) < 1 {
+		return fmt.Errorf(usage)
+	}
+	path, line, col, err := parseFilePos(args[0])
+	if err != nil {
+		return err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return err
+	}
+	m.fixing = true
+	defer func() { m.fixing = false }()
+	if _, err := m.matchArgs(args[1:]); err != nil {
+		return err
+	}
+	m.exitCode = 0
+	if len(m.rules) == 0 {
+		return fmt.Errorf("fix: no rules given via -rules, -pack, or the config")
+	}
+	var best *ruleMatch
+	bestSize := 0
+	for i := range m.ruleMatches {
+		rm := &m.ruleMatches[i]
+		if !rm.sub.orig.pos.IsValid() {
+			continue // the rule has no substitution
+		}
+		start := m.loader.fset.PositionFor(rm.sub.orig.pos, false)
+		end := m.loader.fset.PositionFor(rm.sub.orig.end, false)
+		if abs, err := filepath.Abs(start.Filename); err != nil || abs != path {
+			continue
+		}
+		if line < start.Line || line == start.Line && col < start.Column ||
+			line > end.Line || line == end.Line && col > end.Column {
+			continue
+		}
+		if size := end.Offset - start.Offset; best == nil || size < bestSize {
+			best, bestSize = rm, size
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("%s: no rule match with a substitution", args[0])
+	}
+	file := m.loadedFile(best.sub.orig.pos)
+	if file == nil {
+		return fmt.Errorf("%s: file not loaded", args[0])
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !asEdit {
+		out, err := m.spliceSubsts(file, src, []submatch{best.sub})
+		if err != nil {
+			return err
+		}
+		_, err = m.out.Write(out)
+		return err
+	}
+	repl, err := m.substSrc(file, src, best.sub)
+	if err != nil {
+		return err
+	}
+	start := m.loader.fset.PositionFor(best.sub.orig.pos, false)
+	end := m.loader.fset.PositionFor(best.sub.orig.end, false)
+	enc := json.NewEncoder(m.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fixEdit{
+		File:      m.position(best.sub.orig.pos).Filename,
+		Rule:      best.rule.Name,
+		Offset:    start.Offset,
+		End:       end.Offset,
+		Line:      start.Line,
+		Column:    start.Column,
+		EndLine:   end.Line,
+		EndColumn: end.Column,
+		NewText:   string(repl),
+	})
+}
+
+// parseFilePos parses a position of the form "file:line:col".
+func parseFilePos(s string) (path string, line, col int, err error) {
+	bad := fmt.Errorf("%q is not a position like file.go:12:3", s)
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return "", 0, 0, bad
+	}
+	j := strings.LastIndexByte(s[:i], ':')
+	if j <= 0 {
+		return "", 0, 0, bad
+	}
+	if line, err = strconv.Atoi(s[j+1 : i]); err != nil || line < 1 {
+		return "", 0, 0, bad
+	}
+	if col, err = strconv.Atoi(s[i+1:]); err != nil || col < 1 {
+		return "", 0, 0, bad
+	}
+	return s[:j], line, col, nil
+}
+
+// loadedFile returns the file containing a position among the packages being
+// matched, which are loaded without types too, unlike with fileAt.
+func (m *matcher) loadedFile(pos token.Pos) *ast.File {
+	for _, pkg := range m.pkgs {
+		for _, node := range pkg.nodes {
+			if file, ok := node.(*ast.File); ok && file.Pos() <= pos && pos < file.End() {
+				return file
+			}
+		}
+	}
+	return nil
+}
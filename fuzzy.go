@@ -0,0 +1,178 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var (
+	nodeType   = reflect.TypeOf((*ast.Node)(nil)).Elem()
+	objectType = reflect.TypeOf((*ast.Object)(nil))
+	scopeType  = reflect.TypeOf((*ast.Scope)(nil))
+)
+
+// fuzzyNode reports whether node is a near-match of the pattern expr, within
+// m.fuzzy edits. Each edit is a node that differs, is missing, or is extra.
+// What differed is recorded in m.notes.
+func (m *matcher) fuzzyNode(expr, node ast.Node) ast.Node {
+	if _, ok := expr.(nodeList); ok {
+		return nil // only single nodes, for now
+	}
+	if reflect.TypeOf(expr) != reflect.TypeOf(node) {
+		return nil
+	}
+	dist, diffs := m.fuzzyDist(expr, node, m.fuzzy)
+	if dist == 0 || dist > m.fuzzy {
+		return nil
+	}
+	m.notes[posHash(node)] = "fuzzy: " + strings.Join(diffs, "; ")
+	return node
+}
+
+// fuzzyDist returns the number of edits needed for node to match the pattern
+// expr, along with a description of each of them. Once the distance is
+// known to be larger than budget, it may stop early.
+func (m *matcher) fuzzyDist(expr, node ast.Node, budget int) (int, []string) {
+	if expr == nil && node == nil {
+		return 0, nil
+	}
+	if expr != nil && node != nil {
+		values := valsCopy(m.values)
+		if m.node(expr, node) {
+			return 0, nil
+		}
+		m.values = values
+	}
+	if expr == nil {
+		return 1, []string{"extra " + singleLinePrint(node)}
+	}
+	if node == nil {
+		return 1, []string{"missing " + m.patternString(expr)}
+	}
+	if reflect.TypeOf(expr) != reflect.TypeOf(node) {
+		return 1, []string{m.fuzzyChange(expr, node)}
+	}
+	dist, leafDiff := 0, false
+	var diffs []string
+	v1, v2 := reflect.ValueOf(expr).Elem(), reflect.ValueOf(node).Elem()
+	for i := 0; i < v1.NumField() && dist <= budget; i++ {
+		f1, f2 := v1.Field(i), v2.Field(i)
+		var d int
+		var ds []string
+		switch t := f1.Type(); {
+		case t == posType, t == objectType, t == scopeType,
+			t == commentGroupType:
+		case t.Implements(nodeType):
+			d, ds = m.fuzzyDist(fieldNode(f1), fieldNode(f2), budget-dist)
+		case t.Kind() == reflect.Slice && t.Elem().Implements(nodeType):
+			d, ds = m.fuzzyList(f1, f2, budget-dist)
+		default:
+			if !reflect.DeepEqual(f1.Interface(), f2.Interface()) {
+				leafDiff = true
+			}
+		}
+		dist += d
+		diffs = append(diffs, ds...)
+	}
+	if leafDiff {
+		// different names, values, or operators; count the entire
+		// node as a single edit
+		return 1, []string{m.fuzzyChange(expr, node)}
+	}
+	return dist, diffs
+}
+
+// fuzzyList is like fuzzyDist, but for two lists of nodes. It computes their
+// edit distance, where a "$*_" wildcard in the pattern list can take any
+// number of nodes for free.
+func (m *matcher) fuzzyList(l1, l2 reflect.Value, budget int) (int, []string) {
+	type cell struct {
+		dist  int
+		diffs []string
+	}
+	values := m.values
+	defer func() { m.values = values }()
+	n1, n2 := l1.Len(), l2.Len()
+	table := make([][]cell, n1+1)
+	for i := range table {
+		table[i] = make([]cell, n2+1)
+	}
+	with := func(c cell, dist int, diffs ...string) cell {
+		all := make([]string, 0, len(c.diffs)+len(diffs))
+		all = append(all, c.diffs...)
+		all = append(all, diffs...)
+		return cell{dist: c.dist + dist, diffs: all}
+	}
+	for i := 0; i <= n1; i++ {
+		for j := 0; j <= n2; j++ {
+			if i == 0 && j == 0 {
+				continue
+			}
+			best := cell{dist: budget + 1}
+			try := func(c cell) {
+				if c.dist < best.dist {
+					best = c
+				}
+			}
+			var expr, node ast.Node
+			if i > 0 {
+				expr = fieldNode(l1.Index(i - 1))
+			}
+			if j > 0 {
+				node = fieldNode(l2.Index(j - 1))
+			}
+			if i > 0 {
+				try(with(table[i-1][j], 1, "missing "+m.patternString(expr)))
+			}
+			if j > 0 {
+				try(with(table[i][j-1], 1, "extra "+singleLinePrint(node)))
+			}
+			if i > 0 && m.wildAnyIdent(expr) != nil {
+				try(table[i-1][j])
+				if j > 0 {
+					try(table[i][j-1])
+				}
+			}
+			if i > 0 && j > 0 {
+				// each pair is compared separately, so wildcard
+				// values can't be carried over
+				m.values = valsCopy(values)
+				d, ds := m.fuzzyDist(expr, node, budget)
+				try(with(table[i-1][j-1], d, ds...))
+			}
+			table[i][j] = best
+		}
+	}
+	last := table[n1][n2]
+	return last.dist, last.diffs
+}
+
+func (m *matcher) fuzzyChange(expr, node ast.Node) string {
+	return "want " + m.patternString(expr) + ", got " + singleLinePrint(node)
+}
+
+func fieldNode(v reflect.Value) ast.Node {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Interface().(ast.Node)
+}
+
+var rxWildName = regexp.MustCompile(wildPrefix + `[0-9]+`)
+
+// patternString prints a pattern node on a single line, with its wildcards
+// as they were written by the user.
+func (m *matcher) patternString(expr ast.Node) string {
+	return rxWildName.ReplaceAllStringFunc(singleLinePrint(expr), func(name string) string {
+		info := m.info(fromWildName(name))
+		if info.any {
+			return "$*" + info.name
+		}
+		return "$" + info.name
+	})
+}
@@ -0,0 +1,378 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// genRule is a rule as embedded in a generated analyzer.
+type genRule struct {
+	Name, Message string
+	Match         string
+	Filters       []genFilter
+	Subst         string
+}
+
+// genFilter is a -g or -v pattern of a generated rule.
+type genFilter struct {
+	Pattern string
+	Keep    bool
+}
+
+// genArgs implements "gogrep gen-analyzer [-golangci] rules -o dir", which
+// writes a Go package to dir with an analysis.Analyzer running the rules in a
+// rules file. The package only depends on golang.org/x/tools/go/analysis, so
+// it can be vendored and run via go vet without gogrep. With -golangci, the
+// package also registers the analyzer as a golangci-lint module plugin.
+func (m *matcher) genArgs(args []string) error {
+	const usage = "usage: gogrep gen-analyzer [-golangci] rules -o dir"
+	flagSet := flag.NewFlagSet("gogrep gen-analyzer", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	dir := flagSet.String("o", "", "")
+	golangci := flagSet.Bool("golangci", false, "")
+	var path string
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		path, args = args[0], args[1:]
+	}
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if path == "" && flagSet.NArg() > 0 {
+		// flags like -golangci may also come before the rules file
+		path = flagSet.Arg(0)
+		if err := flagSet.Parse(flagSet.Args()[1:]); err != nil {
+			return err
+		}
+	}
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf(usage)
+	}
+	if path == "" || *dir == "" {
+		return fmt.Errorf(usage)
+	}
+	name := filepath.Base(filepath.Clean(*dir))
+	if !token.IsIdentifier(name) || token.Lookup(name).IsKeyword() {
+		return fmt.Errorf("%s is not a valid package name", name)
+	}
+	rules, err := m.loadRules(path)
+	if err != nil {
+		return err
+	}
+	var genRules []genRule
+	for _, r := range rules {
+		gr, err := generatedRule(r)
+		if err != nil {
+			return fmt.Errorf("%s: rule %q: %v", path, r.Name, err)
+		}
+		genRules = append(genRules, gr)
+	}
+	var buf bytes.Buffer
+	err = analyzerTmpl.Execute(&buf, struct {
+		Package, Source string
+		Rules           []genRule
+	}{name, filepath.Base(path), genRules})
+	if err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(*dir, 0o777); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(*dir, "analyzer.go"), src, 0o666); err != nil {
+		return err
+	}
+	if *golangci {
+		return writePlugin(*dir, name, filepath.Base(path))
+	}
+	return nil
+}
+
+// generatedRule converts a rule to be embedded in a generated analyzer. Only
+// the commands that the analyzer's small matcher supports may be used: a -x
+// pattern, followed by any -g and -v patterns, and at most one -s at the end
+// as a suggested fix. Wildcards can't have a "*" or a regular expression.
+func generatedRule(r rule) (genRule, error) {
+	gr := genRule{Name: r.Name, Message: r.Message}
+	for i, cmd := range r.cmds {
+		if strings.Contains(cmd.src, "$*") || strings.Contains(cmd.src, "$(") {
+			return gr, fmt.Errorf("-%s %s: only simple wildcards like $x are supported", cmd.name, cmd.src)
+		}
+		switch {
+		case i == 0 && cmd.name == "x":
+			gr.Match = cmd.src
+		case i > 0 && gr.Subst == "" && (cmd.name == "g" || cmd.name == "v"):
+			gr.Filters = append(gr.Filters, genFilter{cmd.src, cmd.name == "g"})
+		case i > 0 && i == len(r.cmds)-1 && cmd.name == "s":
+			gr.Subst = cmd.src
+		default:
+			return gr, fmt.Errorf("-%s is not supported; use -x, then -g, -v, or a final -s", cmd.name)
+		}
+	}
+	return gr, nil
+}
+
+var analyzerTmpl = template.Must(template.New("").Delims("<<", ">>").Parse(`// Code generated by gogrep gen-analyzer from <<.Source>>; DO NOT EDIT.
+
+// Package <<.Package>> provides an analyzer running the gogrep rules in
+// <<.Source>>. To run it via go vet, build a main package calling
+// golang.org/x/tools/go/analysis/unitchecker.Main(<<.Package>>.Analyzer), and
+// give it to go vet via -vettool.
+package <<.Package>>
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+var Analyzer = &analysis.Analyzer{
+	Name: <<printf "%q" .Package>>,
+	Doc:  <<printf "%q" (printf "runs the gogrep rules in %s" .Source)>>,
+	Run:  run,
+}
+
+type rule struct {
+	name, message string
+	match         ast.Node
+	filters       []filter
+	subst         string
+}
+
+// filter is a -g pattern if keep is true, or a -v pattern otherwise.
+type filter struct {
+	pattern ast.Node
+	keep    bool
+}
+
+var rules = []rule{
+<<- range .Rules>>
+	{
+		name:    <<printf "%q" .Name>>,
+		message: <<printf "%q" .Message>>,
+		match:   mustParse(<<printf "%q" .Match>>),
+		<<- if .Filters>>
+		filters: []filter{
+		<<- range .Filters>>
+			{mustParse(<<printf "%q" .Pattern>>), <<.Keep>>},
+		<<- end>>
+		},
+		<<- end>>
+		<<- if .Subst>>
+		subst: <<printf "%q" .Subst>>,
+		<<- end>>
+	},
+<<- end>>
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, file := range pass.Files {
+		ast.Inspect(file, func(node ast.Node) bool {
+			if node == nil {
+				return false
+			}
+			for _, r := range rules {
+				vals := make(map[string]ast.Node)
+				if !matchNode(r.match, node, vals) || !r.keeps(node, vals) {
+					continue
+				}
+				diag := analysis.Diagnostic{
+					Pos:      node.Pos(),
+					End:      node.End(),
+					Category: r.name,
+					Message:  r.report(pass, node, vals),
+				}
+				if r.subst != "" {
+					text := rxWild.ReplaceAllStringFunc(r.subst, func(s string) string {
+						if val, ok := vals[s[1:]]; ok {
+							return render(pass.Fset, val)
+						}
+						return s
+					})
+					diag.SuggestedFixes = []analysis.SuggestedFix{{
+						Message:   "replace with " + text,
+						TextEdits: []analysis.TextEdit{{Pos: node.Pos(), End: node.End(), NewText: []byte(text)}},
+					}}
+				}
+				pass.Report(diag)
+			}
+			return true
+		})
+	}
+	return nil, nil
+}
+
+// keeps reports whether a match is kept by all the rule's filters.
+func (r rule) keeps(node ast.Node, vals map[string]ast.Node) bool {
+	for _, f := range r.filters {
+		found := false
+		ast.Inspect(node, func(n ast.Node) bool {
+			if n == nil || found {
+				return false
+			}
+			found = matchNode(f.pattern, n, copyVals(vals))
+			return !found
+		})
+		if found != f.keep {
+			return false
+		}
+	}
+	return true
+}
+
+var rxInterp = regexp.MustCompile(` + "`" + `\{\{\s*(type\s+)?\$(\w+)\s*\}\}` + "`" + `)
+
+// report returns a match's message, with the values of wildcards as {{$x}}
+// and their types as {{type $x}} replaced.
+func (r rule) report(pass *analysis.Pass, node ast.Node, vals map[string]ast.Node) string {
+	if r.message == "" {
+		return render(pass.Fset, node)
+	}
+	return rxInterp.ReplaceAllStringFunc(r.message, func(s string) string {
+		sm := rxInterp.FindStringSubmatch(s)
+		val, ok := vals[sm[2]]
+		if !ok {
+			return s
+		}
+		if sm[1] == "" {
+			return render(pass.Fset, val)
+		}
+		expr, ok := val.(ast.Expr)
+		if !ok || pass.TypesInfo.TypeOf(expr) == nil {
+			return "?"
+		}
+		return types.TypeString(pass.TypesInfo.TypeOf(expr), func(pkg *types.Package) string {
+			return pkg.Name()
+		})
+	})
+}
+
+func render(fset *token.FileSet, node ast.Node) string {
+	var buf bytes.Buffer
+	printer.Fprint(&buf, fset, node)
+	return buf.String()
+}
+
+const wildPrefix = "gogrep_"
+
+var rxWild = regexp.MustCompile(` + "`" + `\$(\w+)` + "`" + `)
+
+// mustParse parses a pattern, which is an expression or a statement.
+func mustParse(src string) ast.Node {
+	src = rxWild.ReplaceAllString(src, wildPrefix+"$1")
+	if expr, err := parser.ParseExpr(src); err == nil {
+		return expr
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", "package p; func _() {\n"+src+"\n}", 0)
+	if err != nil {
+		panic(err)
+	}
+	body := f.Decls[0].(*ast.FuncDecl).Body
+	if len(body.List) != 1 {
+		panic("pattern is not a single expression or statement: " + src)
+	}
+	return body.List[0]
+}
+
+func copyVals(vals map[string]ast.Node) map[string]ast.Node {
+	vals2 := make(map[string]ast.Node, len(vals))
+	for name, val := range vals {
+		vals2[name] = val
+	}
+	return vals2
+}
+
+func matchNode(pattern, node ast.Node, vals map[string]ast.Node) bool {
+	return matchValue(reflect.ValueOf(&pattern).Elem(), reflect.ValueOf(&node).Elem(), vals)
+}
+
+var (
+	posType      = reflect.TypeOf(token.NoPos)
+	objectType   = reflect.TypeOf((*ast.Object)(nil))
+	scopeType    = reflect.TypeOf((*ast.Scope)(nil))
+	commentsType = reflect.TypeOf((*ast.CommentGroup)(nil))
+)
+
+// matchValue compares a pattern with a node field by field, ignoring
+// positions, comments, and objects. A wildcard matches any node, but the
+// same one each time it appears, except for $_.
+func matchValue(pattern, node reflect.Value, vals map[string]ast.Node) bool {
+	switch pattern.Type() {
+	case posType, objectType, scopeType, commentsType:
+		return true
+	}
+	if pattern.Kind() == reflect.Interface {
+		if pattern.IsNil() || node.IsNil() {
+			return pattern.IsNil() && node.IsNil()
+		}
+		pattern, node = pattern.Elem(), node.Elem()
+	}
+	if id, ok := pattern.Interface().(*ast.Ident); ok && id != nil && strings.HasPrefix(id.Name, wildPrefix) {
+		if node.Kind() != reflect.Ptr || node.IsNil() {
+			return false
+		}
+		val, ok := node.Interface().(ast.Node)
+		if !ok {
+			return false
+		}
+		name := id.Name[len(wildPrefix):]
+		if name == "_" {
+			return true
+		}
+		if prev, ok := vals[name]; ok {
+			return matchNode(prev, val, vals)
+		}
+		vals[name] = val
+		return true
+	}
+	if pattern.Type() != node.Type() {
+		return false
+	}
+	switch pattern.Kind() {
+	case reflect.Ptr:
+		if pattern.IsNil() || node.IsNil() {
+			return pattern.IsNil() && node.IsNil()
+		}
+		return matchValue(pattern.Elem(), node.Elem(), vals)
+	case reflect.Struct:
+		for i := 0; i < pattern.NumField(); i++ {
+			if !matchValue(pattern.Field(i), node.Field(i), vals) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if pattern.Len() != node.Len() {
+			return false
+		}
+		for i := 0; i < pattern.Len(); i++ {
+			if !matchValue(pattern.Index(i), node.Index(i), vals) {
+				return false
+			}
+		}
+		return true
+	}
+	return pattern.Interface() == node.Interface()
+}
+`))
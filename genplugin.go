@@ -0,0 +1,66 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"go/format"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+)
+
+// writePlugin writes plugin.go to the directory of a generated analysis
+// package, registering its Analyzer as a golangci-lint module plugin named
+// after the package. That lets golangci-lint run a project's rules with its
+// own caching and nolint handling, once built via "golangci-lint custom".
+func writePlugin(dir, pkg, source string) error {
+	var buf bytes.Buffer
+	if err := pluginTmpl.Execute(&buf, struct{ Package, Source string }{pkg, source}); err != nil {
+		return err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "plugin.go"), src, 0o666)
+}
+
+var pluginTmpl = template.Must(template.New("").Delims("<<", ">>").Parse(`// Code generated by gogrep gen-analyzer from <<.Source>>; DO NOT EDIT.
+
+package <<.Package>>
+
+import (
+	"github.com/golangci/plugin-module-register/register"
+	"golang.org/x/tools/go/analysis"
+)
+
+// The analyzer is registered as a golangci-lint module plugin, so that it can
+// be built into golangci-lint via "golangci-lint custom" and enabled as the
+// <<printf "%q" .Package>> linter.
+func init() {
+	register.Plugin(<<printf "%q" .Package>>, newPlugin)
+}
+
+type plugin struct{}
+
+// newPlugin creates the plugin. It has no settings, as the rules are fixed
+// when generating the package.
+func newPlugin(settings any) (register.LinterPlugin, error) {
+	if _, err := register.DecodeSettings[struct{}](settings); err != nil {
+		return nil, err
+	}
+	return plugin{}, nil
+}
+
+func (plugin) BuildAnalyzers() ([]*analysis.Analyzer, error) {
+	return []*analysis.Analyzer{Analyzer}, nil
+}
+
+// GetLoadMode needs type information, as messages may include the types of
+// wildcards.
+func (plugin) GetLoadMode() string {
+	return register.LoadModeTypesInfo
+}
+`))
@@ -3,4 +3,5 @@ module mvdan.cc/gogrep
 require (
 	github.com/kisielk/gotool v1.0.0
 	golang.org/x/tools v0.0.0-20180831211245-7ca132754999
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,176 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"path/filepath"
+)
+
+// groupFlag parses what -group nests the matches under, which is either
+// "func" or "type".
+type groupFlag struct {
+	group *string
+}
+
+func (f *groupFlag) String() string { return "" }
+func (f *groupFlag) Set(val string) error {
+	switch val {
+	case "func", "type":
+	default:
+		return fmt.Errorf("unknown group %q; want func or type", val)
+	}
+	*f.group = val
+	return nil
+}
+
+// matchGroup is the matches within a func or type declaration, or outside of
+// any if pos isn't valid.
+type matchGroup struct {
+	title string
+	pos   token.Pos // of the declaration
+	subs  []submatch
+}
+
+// printGroups prints the matches nested under their enclosing func or type
+// declarations, as per -group, with the number of matches in each. The
+// groups are in the order of their first matches. With "type", the methods
+// of a type are grouped with its declaration.
+func (m *matcher) printGroups(subs []submatch) {
+	var files []*ast.File
+	typeSpecs := make(map[string]token.Pos)
+	for _, pkg := range m.pkgs {
+		for _, node := range pkg.nodes {
+			f, ok := node.(*ast.File)
+			if !ok {
+				continue
+			}
+			files = append(files, f)
+			for _, decl := range f.Decls {
+				if gd, ok := decl.(*ast.GenDecl); ok && gd.Tok == token.TYPE {
+					for _, spec := range gd.Specs {
+						ts := spec.(*ast.TypeSpec)
+						typeSpecs[m.typeKey(f, ts.Name.Name)] = ts.Pos()
+					}
+				}
+			}
+		}
+	}
+	var groups []*matchGroup
+	byKey := make(map[string]*matchGroup)
+	for _, sub := range subs {
+		pos := sub.node.Pos()
+		var file *ast.File
+		for _, f := range files {
+			if pos >= f.Pos() && pos < f.End() {
+				file = f
+				break
+			}
+		}
+		key, group := m.enclosingGroup(file, pos)
+		if g := byKey[key]; g != nil {
+			group = g
+		} else {
+			if pos, ok := typeSpecs[key]; ok {
+				// the type's declaration, even if the first
+				// match is in one of its methods
+				group.pos = pos
+			}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.subs = append(group.subs, sub)
+	}
+	if g := byKey[""]; g != nil {
+		// matches outside of any declaration go last
+		for i, group := range groups {
+			if group == g {
+				groups = append(append(groups[:i:i], groups[i+1:]...), g)
+				break
+			}
+		}
+	}
+	for _, group := range groups {
+		count := fmt.Sprintf("%d matches", len(group.subs))
+		if len(group.subs) == 1 {
+			count = "1 match"
+		}
+		if !group.pos.IsValid() || m.normalized {
+			fmt.Fprintf(m.out, "%s: %s\n", group.title, count)
+		} else {
+			fmt.Fprintf(m.out, "%v: %s: %s\n", m.position(group.pos), group.title, count)
+		}
+		for _, sub := range group.subs {
+			m.printMatch("\t", sub)
+		}
+	}
+}
+
+// enclosingGroup returns the group for a position in a file, along with the
+// key which identifies it among all the files. A func's group is keyed by
+// its position, while a type's group is keyed by its package's directory and
+// its name, so that its methods in other files are grouped with it.
+func (m *matcher) enclosingGroup(file *ast.File, pos token.Pos) (string, *matchGroup) {
+	outside := &matchGroup{title: "outside of funcs and types"}
+	if file == nil {
+		return "", outside
+	}
+	typeGroup := func(name string, declPos token.Pos) (string, *matchGroup) {
+		return m.typeKey(file, name), &matchGroup{title: "type " + name, pos: declPos}
+	}
+	for _, decl := range file.Decls {
+		if pos < decl.Pos() || pos >= decl.End() {
+			continue
+		}
+		switch x := decl.(type) {
+		case *ast.FuncDecl:
+			if x.Recv != nil && len(x.Recv.List) == 1 && m.group == "type" {
+				if name := recvTypeName(x.Recv.List[0].Type); name != "" {
+					return typeGroup(name, x.Pos())
+				}
+			}
+			title := "func " + x.Name.Name
+			if x.Recv != nil && len(x.Recv.List) == 1 {
+				title = fmt.Sprintf("func (%s).%s", singleLinePrint(x.Recv.List[0].Type), x.Name.Name)
+			}
+			return fmt.Sprint(m.position(x.Pos())), &matchGroup{title: title, pos: x.Pos()}
+		case *ast.GenDecl:
+			for _, spec := range x.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if ok && pos >= ts.Pos() && pos < ts.End() {
+					return typeGroup(ts.Name.Name, ts.Pos())
+				}
+			}
+		}
+	}
+	return "", outside
+}
+
+// typeKey returns the key of a type's group, given a file in its package.
+func (m *matcher) typeKey(file *ast.File, name string) string {
+	return filepath.Dir(m.position(file.Pos()).Filename) + "." + name
+}
+
+// recvTypeName returns the name of a method's receiver type, such as T for
+// *T or for T[K].
+func recvTypeName(expr ast.Expr) string {
+	for {
+		switch x := expr.(type) {
+		case *ast.Ident:
+			return x.Name
+		case *ast.StarExpr:
+			expr = x.X
+		case *ast.ParenExpr:
+			expr = x.X
+		case *ast.IndexExpr:
+			expr = x.X
+		case *ast.IndexListExpr:
+			expr = x.X
+		default:
+			return ""
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// layerList is the index, star and paren expressions wrapping an
+// expression, such as the [k], * and () around m in (*m)[k], from the
+// outermost in. This lets "$*w(m)" match m wrapped in any number of them,
+// with $*w being the list.
+//
+// The layers are copies of the originals whose X is a placeholder, so that
+// they match and print on their own. cmdSubst rebuilds them around the
+// expression given to the wildcard.
+type layerList []ast.Expr
+
+func (l layerList) len() int                { return len(l) }
+func (l layerList) at(i int) ast.Node       { return l[i] }
+func (l layerList) slice(i, j int) nodeList { return l[i:j] }
+func (l layerList) Pos() token.Pos          { return l[0].Pos() }
+func (l layerList) End() token.Pos          { return l[len(l)-1].End() }
+
+// layerInner returns the expression which a node wraps, if it is an index,
+// star or paren expression.
+func layerInner(node ast.Node) ast.Expr {
+	switch x := node.(type) {
+	case *ast.IndexExpr:
+		return x.X
+	case *ast.StarExpr:
+		return x.X
+	case *ast.ParenExpr:
+		return x.X
+	}
+	return nil
+}
+
+// layerCopy returns a copy of a layer, with a placeholder as what it wraps.
+func layerCopy(layer ast.Expr) ast.Expr {
+	placeholder := &ast.Ident{NamePos: layerInner(layer).Pos()}
+	switch x := layer.(type) {
+	case *ast.IndexExpr:
+		c := *x
+		c.X = placeholder
+		return &c
+	case *ast.StarExpr:
+		c := *x
+		c.X = placeholder
+		return &c
+	default:
+		c := *x.(*ast.ParenExpr)
+		c.X = placeholder
+		return &c
+	}
+}
+
+// layersWild returns the wildcard of a pattern like "$*w(m)", which matches
+// m wrapped in any number of layers, if the call is one.
+func (m *matcher) layersWild(call *ast.CallExpr) *ast.Ident {
+	id, ok := call.Fun.(*ast.Ident)
+	if !ok || len(call.Args) != 1 || call.Ellipsis.IsValid() ||
+		!m.info(fromWildName(id.Name)).any {
+		return nil
+	}
+	return id
+}
+
+// layersMatch matches a pattern which has layersWild against a node, peeling
+// its layers until what they wrap matches, starting from the innermost. Only
+// the outermost layer matches, so that (*m)[k] doesn't match again as *m.
+func (m *matcher) layersMatch(call *ast.CallExpr, node ast.Node) bool {
+	expr, ok := node.(ast.Expr)
+	if !ok || layerInner(m.parentOf(node)) == node {
+		return false
+	}
+	var layers []ast.Expr
+	for ; expr != nil; expr = layerInner(expr) {
+		layers = append(layers, expr)
+	}
+	info := m.info(fromWildNode(call.Fun))
+	for i := len(layers) - 1; i >= 0; i-- {
+		values := valsCopy(m.values)
+		if m.node(call.Args[0], layers[i]) {
+			list := make(layerList, i)
+			for j, layer := range layers[:i] {
+				list[j] = layerCopy(layer)
+			}
+			if info.name == "_" {
+				return true
+			}
+			prev, ok := m.values[info.name]
+			if !ok {
+				m.values[info.name] = list
+				return true
+			}
+			if m.node(prev, list) {
+				return true
+			}
+		}
+		m.values = values
+	}
+	return false
+}
+
+// rebuildLayers wraps an expression in the layers of a layerList.
+func rebuildLayers(x ast.Expr, list layerList) ast.Expr {
+	for i := len(list) - 1; i >= 0; i-- {
+		switch layer := list[i].(type) {
+		case *ast.IndexExpr:
+			c := *layer
+			c.X = x
+			x = &c
+		case *ast.StarExpr:
+			c := *layer
+			c.X = x
+			x = &c
+		case *ast.ParenExpr:
+			c := *layer
+			c.X = x
+			x = &c
+		}
+	}
+	return x
+}
+
+// fillLayers replaces a pattern like "$*w(m)" by m wrapped in the layers
+// matched by $*w. Any wildcards within m are left to fillValues.
+func (m *matcher) fillLayers(call *ast.CallExpr, values map[string]ast.Node) ast.Expr {
+	info := m.info(fromWildNode(call.Fun))
+	list, ok := values[info.name].(layerList)
+	if !ok {
+		m.failf(call, "$*%s didn't match index, star or paren layers", info.name)
+	}
+	return rebuildLayers(call.Args[0], list)
+}
@@ -4,22 +4,122 @@
 package main
 
 import (
+	"fmt"
 	"go/ast"
 	"go/build"
 	"go/parser"
+	"go/scanner"
 	"go/token"
 	"go/types"
 	"path/filepath"
 	"strings"
 
 	"github.com/kisielk/gotool"
+	"golang.org/x/tools/go/callgraph"
 	"golang.org/x/tools/go/loader"
+	"golang.org/x/tools/go/ssa"
 )
 
 type nodeLoader struct {
 	wd   string
 	ctx  *build.Context
 	fset *token.FileSet
+
+	// all the files loaded when type-checking, including those from
+	// dependencies that aren't being matched
+	files []*ast.File
+
+	// the whole program and its call graph, the latter built lazily
+	prog    *loader.Program
+	matched []*types.Package
+	ssaProg *ssa.Program
+	graph   *callgraph.Graph
+
+	// the funcs reachable from the roots of each -reach pattern, as
+	// they're the same for all the packages
+	reachable map[string]map[*ssa.Function]bool
+
+	// the SSA packages and funcs by where they are in the source, built
+	// lazily along with the SSA program
+	ssaIndex *ssaIndex
+
+	// the packages given to -import, loaded along with the program so
+	// that type constraints can use them, and their scopes by name
+	imports      []extraImport
+	importScopes map[string]*types.Scope
+
+	// strict makes loading stop at the first error; otherwise, the
+	// packages and files that fail to load are skipped and their
+	// errors are kept in errs
+	strict bool
+	errs   []error
+
+	// the files with syntax errors, which are still matched as far as
+	// they could be parsed, by filename
+	degraded map[string]bool
+
+	// the build system targets given as labels, each loaded as a package
+	targets []buildTarget
+
+	// the shard of the packages to load, given via -shard
+	shard shardSpec
+
+	// the package patterns of the skip list, given via -skip and the
+	// config file, and the packages left out as per them
+	skips   []string
+	skipped []string
+
+	// the root of the module being loaded on its own, if any, and how
+	// many modules were loaded on their own, as the packages were in more
+	// than one of them
+	module  string
+	modules int
+}
+
+// skip records an error which made a package or file be skipped, returning
+// it if loading must stop instead.
+func (l *nodeLoader) skip(err error) error {
+	if l.strict {
+		return err
+	}
+	l.errs = append(l.errs, err)
+	return nil
+}
+
+// extraImport is a package given to -import, as "path" or "path=alias".
+type extraImport struct {
+	path, alias string
+}
+
+// importFlag collects the packages given to -import.
+type importFlag struct {
+	imports *[]extraImport
+}
+
+func (f *importFlag) String() string { return "" }
+func (f *importFlag) Set(val string) error {
+	imp := extraImport{path: val}
+	if i := strings.Index(val, "="); i >= 0 {
+		imp.path, imp.alias = val[:i], val[i+1:]
+		if !token.IsIdentifier(imp.alias) {
+			return fmt.Errorf("invalid import alias %q", imp.alias)
+		}
+	}
+	if imp.path == "" {
+		return fmt.Errorf("empty import path in %q", val)
+	}
+	*f.imports = append(*f.imports, imp)
+	return nil
+}
+
+// degrade records the files with syntax errors in an error list.
+func (l *nodeLoader) degrade(err error) {
+	if l.degraded == nil {
+		l.degraded = make(map[string]bool)
+	}
+	for _, e := range err.(scanner.ErrorList) {
+		l.degraded[e.Pos.Filename] = true
+	}
 }
 
 type loadPkg struct {
@@ -28,15 +128,21 @@ type loadPkg struct {
 	info  types.Info
 }
 
-func (l nodeLoader) untyped(args []string, recurse bool) ([]loadPkg, error) {
-	gctx := gotool.Context{BuildContext: *l.ctx}
-	paths := gctx.ImportPaths(args)
+func (l *nodeLoader) untyped(args []string, recurse bool) ([]loadPkg, error) {
+	paths := l.importPaths(args)
+	if pkgs, ok, err := l.loadModules(paths, recurse, (*nodeLoader).untyped); ok {
+		return pkgs, err
+	}
 	var pkgs []loadPkg
 	var cur loadPkg
 	addFile := func(path string) error {
-		f, err := parser.ParseFile(l.fset, path, nil, parser.ParseComments)
-		if err != nil {
-			return err
+		f, err := parser.ParseFile(l.fset, path, nil, parser.ParseComments|parser.AllErrors)
+		if _, ok := err.(scanner.ErrorList); ok && f != nil && !l.strict {
+			// match what could be parsed, but still report it
+			l.errs = append(l.errs, err)
+			l.degrade(err)
+		} else if err != nil {
+			return l.skip(err)
 		}
 		cur.nodes = append(cur.nodes, f)
 		return nil
@@ -48,13 +154,16 @@ func (l nodeLoader) untyped(args []string, recurse bool) ([]loadPkg, error) {
 			return nil
 		}
 		done[path] = true
+		if l.skipListed(path) {
+			return nil
+		}
 		if len(cur.nodes) > 0 {
 			pkgs = append(pkgs, cur)
-			cur = loadPkg{path: path}
 		}
+		cur = loadPkg{path: path}
 		pkg, err := l.ctx.Import(path, l.wd, 0)
 		if err != nil {
-			return err
+			return l.skip(err)
 		}
 		for _, names := range [...][]string{
 			pkg.GoFiles, pkg.CgoFiles, pkg.IgnoredGoFiles,
@@ -93,32 +202,128 @@ func (l nodeLoader) untyped(args []string, recurse bool) ([]loadPkg, error) {
 			return nil, err
 		}
 	}
+	for _, target := range l.targets {
+		if len(cur.nodes) > 0 {
+			pkgs = append(pkgs, cur)
+		}
+		cur = loadPkg{path: target.label}
+		for _, path := range target.files {
+			if err := addFile(path); err != nil {
+				return nil, err
+			}
+		}
+	}
 	if len(cur.nodes) > 0 {
 		pkgs = append(pkgs, cur)
 	}
 	return pkgs, nil
 }
 
-func (l nodeLoader) typed(args []string, recurse bool) ([]loadPkg, error) {
+// importPaths expands the package patterns in args, keeping those in the
+// shard and not in the skip list. Unlike with gotool, no args only means the current directory if
+// there are no build targets.
+func (l *nodeLoader) importPaths(args []string) []string {
+	if len(args) == 0 && len(l.targets) > 0 {
+		return nil
+	}
 	gctx := gotool.Context{BuildContext: *l.ctx}
-	paths := gctx.ImportPaths(args)
-	conf := loader.Config{Fset: l.fset, Cwd: l.wd, Build: l.ctx}
+	var paths []string
+	for _, path := range gctx.ImportPaths(args) {
+		if l.shard.keeps(path) && !l.skipListed(path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func (l *nodeLoader) typed(args []string, recurse bool) ([]loadPkg, error) {
+	paths := l.importPaths(args)
+	if len(paths) == 0 && len(l.targets) == 0 {
+		return nil, nil // all in other shards
+	}
+	if pkgs, ok, err := l.loadModules(paths, recurse, (*nodeLoader).typed); ok {
+		return pkgs, err
+	}
+	conf := loader.Config{
+		Fset:        l.fset,
+		Cwd:         l.wd,
+		Build:       l.ctx,
+		ParserMode:  parser.ParseComments | parser.AllErrors,
+		AllowErrors: !l.strict,
+	}
 	if _, err := conf.FromArgs(paths, true); err != nil {
 		return nil, err
 	}
-	var terr error
-	conf.TypeChecker.Error = func(err error) {
-		if terr == nil {
-			terr = err
+	for _, target := range l.targets {
+		conf.CreateFromFilenames(target.importPath, target.files...)
+	}
+	// the packages only loaded for -import aren't matched
+	extra := make(map[string]bool)
+	for _, imp := range l.imports {
+		if _, ok := conf.ImportPkgs[imp.path]; !ok {
+			extra[imp.path] = true
+			conf.Import(imp.path)
 		}
 	}
+	var terrs []error
+	conf.TypeChecker.Error = func(err error) {
+		terrs = append(terrs, err)
+	}
 	prog, err := conf.Load()
 	if err != nil {
-		if terr != nil {
-			return nil, terr
+		if len(terrs) > 0 {
+			return nil, terrs[0]
 		}
 		return nil, err
 	}
+	// only keep the first error of each package, as the rest are
+	// often caused by it
+	broken := make(map[*types.Package]bool)
+	first, inPkg := make(map[string]bool), make(map[string]bool)
+	for _, pkg := range prog.AllPackages {
+		for _, err := range pkg.Errors {
+			inPkg[err.Error()] = true
+		}
+		if len(pkg.Errors) == 0 {
+			continue
+		}
+		first[pkg.Errors[0].Error()] = true
+		// packages with syntax errors are still matched, as their
+		// type errors are often caused by the syntax ones
+		syntax := false
+		for _, err := range pkg.Errors {
+			if _, ok := err.(scanner.ErrorList); ok {
+				l.degrade(err)
+				syntax = true
+			}
+		}
+		if !syntax {
+			broken[pkg.Pkg] = true
+		}
+	}
+	for _, err := range terrs {
+		// packages that couldn't be created at all have no errors
+		if msg := err.Error(); first[msg] || !inPkg[msg] {
+			l.errs = append(l.errs, err)
+			delete(first, msg)
+		}
+	}
+	l.prog = prog
+	for _, pkg := range prog.AllPackages {
+		l.files = append(l.files, pkg.Files...)
+	}
+	l.importScopes = make(map[string]*types.Scope)
+	for _, imp := range l.imports {
+		pkg := prog.Package(imp.path)
+		if pkg == nil {
+			return nil, fmt.Errorf("cannot find -import package %q", imp.path)
+		}
+		name := imp.alias
+		if name == "" {
+			name = pkg.Pkg.Name()
+		}
+		l.importScopes[name] = pkg.Pkg.Scope()
+	}
 	var pkgs []loadPkg
 	done := map[string]bool{}
 	var addPkg func(tpkg *types.Package) // to recurse into self
@@ -129,11 +334,18 @@ func (l nodeLoader) typed(args []string, recurse bool) ([]loadPkg, error) {
 		}
 		done[path] = true
 		pkg := prog.Package(path)
+		if broken[tpkg] {
+			return // its errors were already recorded
+		}
+		if l.skipListed(path) {
+			return
+		}
 		lpkg := loadPkg{path: path, info: pkg.Info}
 		for _, file := range pkg.Files {
 			lpkg.nodes = append(lpkg.nodes, file)
 		}
 		pkgs = append(pkgs, lpkg)
+		l.matched = append(l.matched, tpkg)
 		if !recurse {
 			return
 		}
@@ -143,7 +355,9 @@ func (l nodeLoader) typed(args []string, recurse bool) ([]loadPkg, error) {
 		}
 	}
 	for _, pkg := range prog.InitialPackages() {
-		addPkg(pkg.Pkg)
+		if !extra[pkg.Pkg.Path()] {
+			addPkg(pkg.Pkg)
+		}
 	}
 	return pkgs, nil
 }
@@ -5,8 +5,17 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -14,7 +23,8 @@ import (
 func TestLoad(t *testing.T) {
 	ctx := build.Default
 	ctx.GOPATH = "testdata"
-	m := matcher{ctx: &ctx}
+	m := matcher{ctx: &ctx, errOut: ioutil.Discard}
+	bazelCmd = "./testdata/bazel/bazel.sh"
 	tests := []struct {
 		args []string
 		want interface{}
@@ -78,6 +88,59 @@ func TestLoad(t *testing.T) {
 				testdata/src/p1/testp/file1.go:3:1: var _ = "file1"
 			`,
 		},
+		{
+			[]string{"-j", "4", "-x", "println($x)", "./testdata/scope/api", "./testdata/scope", "./testdata/config"},
+			`
+				testdata/config/a.go:4:2: println("a")
+				testdata/config/a.go:5:2: println("")
+				testdata/scope/a.go:4:2: println("scope")
+				testdata/scope/api/a.go:4:2: println("api")
+			`,
+		},
+		{
+			[]string{"-x", "println($x)", "-only-in", "tests", "./testdata/onlyin"},
+			`testdata/onlyin/main_test.go:6:2: println("test")`,
+		},
+		{
+			[]string{"-x", "println($x)", "-only-in", "func init(), func main()", "./testdata/onlyin"},
+			`
+				testdata/onlyin/main.go:4:2: println("init")
+				testdata/onlyin/main.go:8:2: println("main")
+			`,
+		},
+		{
+			[]string{"-x", "println($x)", "-only-in", "package main", "-only-in", "tests", "testdata/onlyin/main.go"},
+			`
+				testdata/onlyin/main.go:4:2: println("init")
+				testdata/onlyin/main.go:8:2: println("main")
+				testdata/onlyin/main.go:12:2: println("helper")
+			`,
+		},
+		{
+			[]string{"-x", "println($x)", "-only-in", "tests", "./testdata/config"},
+			``,
+		},
+		{
+			[]string{"-x", "println($x)", "-only-in", "test files", "./testdata/onlyin"},
+			`
+				testdata/onlyin/main_test.go:6:2: println("test")
+				testdata/onlyin/main_test.go:10:2: println("not a test")
+			`,
+		},
+		{
+			[]string{"-x", "println($x)", "-not-in", "test files", "-not-in", "func main()", "./testdata/onlyin"},
+			`
+				testdata/onlyin/main.go:4:2: println("init")
+				testdata/onlyin/main.go:12:2: println("helper")
+			`,
+		},
+		{
+			[]string{"-x", "$_.$_", "-a", `obj("crypto/md5")`, "./testdata/crypto"},
+			`
+				testdata/crypto/crypto.go:16:2: hash.Sum
+				testdata/crypto/crypto_test.go:8:30: md5.Sum
+			`,
+		},
 		{
 			[]string{"-x", "var _ = $x", "-r", "p1"},
 			`
@@ -111,33 +174,1746 @@ func TestLoad(t *testing.T) {
 			[]string{"-x", "1, 2, 3, 4, 5", "testdata/exprlist.go"},
 			`testdata/exprlist.go:3:13: 1, 2, 3, 4, 5`,
 		},
-	}
-	for i, tc := range tests {
-		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
-			var buf bytes.Buffer
-			m.out = &buf
-			err := m.fromArgs(tc.args)
-			switch x := tc.want.(type) {
-			case error:
-				if err == nil {
-					t.Fatalf("wanted error %q, got none", x)
-				}
-				want, got := x.Error(), err.Error()
-				if !strings.Contains(got, want) {
-					t.Fatalf("wanted error %q, got %q", want, got)
-				}
-			case string:
-				if err != nil {
-					t.Fatalf("didn't want error, but got %q", err)
-				}
-				want := strings.TrimSpace(strings.Replace(x, "\t", "", -1))
-				got := strings.TrimSpace(buf.String())
-				if want != got {
-					t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
-				}
-			default:
-				t.Fatalf("unknown want type %T", x)
-			}
-		})
+		{
+			[]string{"-comment", "(?i)todo|fixme", "testdata/comments.go"},
+			`
+				testdata/comments.go:3:1: // TODO: document this
+				testdata/comments.go:7:2: // FIXME: remove
+			`,
+		},
+		{
+			[]string{"-x", "func _() { $*_ }", "-comment", ".", "testdata/comments.go"},
+			`
+				testdata/comments.go:7:2: // FIXME: remove
+				testdata/comments.go:8:8: // nothing to see here
+			`,
+		},
+		{
+			[]string{"-x", "func $_() {}", "-a", "doc", "testdata/docs.go"},
+			`
+				testdata/docs.go:4:1: func Documented() { }
+				testdata/docs.go:7:1: func Undocumented() { }
+			`,
+		},
+		{
+			[]string{"-x", "func $_() {}", "-a", "!docname", "testdata/docs.go"},
+			`
+				testdata/docs.go:7:1: func Undocumented() { }
+				testdata/docs.go:9:1: func NoDoc() { }
+			`,
+		},
+		{
+			[]string{"-x", "func $_() {}", "-a", `doc("convention")`, "testdata/docs.go"},
+			`testdata/docs.go:7:1: func Undocumented() { }`,
+		},
+		{
+			[]string{"-x", "type $_ int", "-a", "docname", "testdata/docs.go"},
+			`testdata/docs.go:12:1: type Thing int`,
+		},
+		{
+			[]string{"-x", "type $_ int", "-a", "!doc", "testdata/docs.go"},
+			`testdata/docs.go:14:1: type Other int`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "deprecated", "testdata/deprecated.go"},
+			`
+				testdata/deprecated.go:14:2: Old()
+				testdata/deprecated.go:16:2: ioutil.ReadAll(nil)
+			`,
+		},
+		{
+			[]string{"-x", "func $_() {}", "-a", "!deprecated", "testdata/deprecated.go"},
+			`testdata/deprecated.go:11:1: func New() { }`,
+		},
+		{
+			[]string{"-x", "func $_() {}", "-a", `directive("go:noinline")`, "testdata/directives.go"},
+			`testdata/directives.go:8:1: func noinline() { }`,
+		},
+		{
+			[]string{"-x", "func $_() int64", "-a", `directive("^go:linkname .* runtime\\.")`, "testdata/directives.go"},
+			`testdata/directives.go:13:1: func linked() int64`,
+		},
+		{
+			[]string{"-x", "import $alias \"$_\"", "testdata/imports.go"},
+			`
+				testdata/imports.go:5:2: str "strings"
+				testdata/imports.go:7:2: _ "github.com/org/internal/driver"
+				testdata/imports.go:8:2: . "github.com/org/internal/dsl"
+			`,
+		},
+		{
+			[]string{"-x", "import $*_ \"$(_ /github.com/org/internal/.*/)\"", "testdata/imports.go"},
+			`
+				testdata/imports.go:7:2: _ "github.com/org/internal/driver"
+				testdata/imports.go:8:2: . "github.com/org/internal/dsl"
+			`,
+		},
+		{
+			[]string{"-x", "import \"os\"", "testdata/imports.go"},
+			`testdata/imports.go:12:8: "os"`,
+		},
+		{
+			[]string{"-pkg", "-v", "import $*_ \"testing\"", "./testdata/files/..."},
+			`testdata/files/two/a.go:1:1: package two`,
+		},
+		{
+			[]string{"-x", "package $_", "-a", "!dirname", "./testdata/pkgname/..."},
+			`
+				testdata/pkgname/main.go:1:1: package main
+				testdata/pkgname/util/a.go:1:1: package helpers
+			`,
+		},
+		{
+			[]string{"-a", `rx(".*_.*")`, "./testdata/pkgname/..."},
+			`testdata/pkgname/good/a_test.go:1:1: package good_test`,
+		},
+		{
+			[]string{"-a", "count(func init() {}) > 1", "./testdata/files/..."},
+			`testdata/files/one/a.go:1:1: package one`,
+		},
+		{
+			[]string{"-x", "func init() {}", "-file", "./testdata/files/..."},
+			`
+				testdata/files/one/a.go:1:1: package one
+				testdata/files/one/b.go:1:1: package one
+				testdata/files/two/a.go:1:1: package two
+			`,
+		},
+		{
+			[]string{"-pkg", "-v", "testing.$_", "./testdata/files/..."},
+			`testdata/files/two/a.go:1:1: package two`,
+		},
+		{
+			[]string{"-pkg", "-a", "count(func init() {}) == 3", "-file", "./testdata/files/..."},
+			`
+				testdata/files/one/a.go:1:1: package one
+				testdata/files/one/b.go:1:1: package one
+			`,
+		},
+		{
+			[]string{"-x", "$_.mu.Lock()", "-a", "!recv(*Server)", "testdata/recv.go"},
+			`
+				testdata/recv.go:21:2: c.s.mu.Lock()
+				testdata/recv.go:27:2: s.mu.Lock()
+			`,
+		},
+		{
+			[]string{"-x", "$_.n++", "-a", "recv($T)", "-a", "recv(*$T)", "testdata/recv.go"},
+			``,
+		},
+		{
+			[]string{"-x", "$_.n++", "-a", "recv(*$T)", "-a", "!recv($T)", "testdata/recv.go"},
+			`testdata/recv.go:12:2: s.n++`,
+		},
+		{
+			[]string{"-x", "var $x $_", "-a", "asgn(lib.Doer)", "testdata/importflag/main.go"},
+			``,
+		},
+		{
+			[]string{"-nolint", "nolint", "-rules", "testdata/rules.json", "testdata/nolint.go"},
+			`
+				testdata/nolint.go:7:2: info: avoid println (println)
+				testdata/nolint.go:8:2: info: avoid println (println)
+				testdata/nolint.go:9:2: info: avoid println (println)
+				testdata/nolint.go:10:2: info: avoid println (println)
+			`,
+		},
+		{
+			[]string{"-nolint", "nolint", "-x", "println()", "testdata/nolint.go"},
+			`
+				testdata/nolint.go:5:2: println()
+				testdata/nolint.go:6:2: println()
+				testdata/nolint.go:7:2: println()
+				testdata/nolint.go:8:2: println()
+				testdata/nolint.go:9:2: println()
+				testdata/nolint.go:10:2: println()
+				testdata/nolint.go:15:2: println()
+			`,
+		},
+		{
+			[]string{"-x", "foo()", "testdata/ignore.go", "testdata/ignorefile.go"},
+			`
+				testdata/ignore.go:5:2: foo()
+				testdata/ignore.go:10:2: foo()
+			`,
+		},
+		{
+			[]string{"-ignores", "testdata/ignore.go", "testdata/ignorefile.go"},
+			`
+				testdata/ignore.go:4:8: line //gogrep:ignore
+				testdata/ignore.go:5:8: line //gogrep:ignore other
+				testdata/ignore.go:6:2: block //gogrep:ignore gogrep some reason
+				testdata/ignore.go:13:1: block //gogrep:ignore
+				testdata/ignorefile.go:1:1: file //gogrep:ignore * generated
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", "build", "testdata/build/linux.go", "testdata/build/windows.go", "testdata/build/none.go"},
+			`
+				testdata/build/linux.go:5:1: var _ = "linux"
+				testdata/build/windows.go:5:1: var _ = "windows"
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", `build("linux")`, "testdata/build/linux.go", "testdata/build/windows.go", "testdata/build/none.go"},
+			`
+				testdata/build/linux.go:5:1: var _ = "linux"
+				testdata/build/none.go:3:1: var _ = "none"
+			`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", `build("windows,amd64")`, "-a", "build", "testdata/build/linux.go", "testdata/build/windows.go", "testdata/build/none.go"},
+			`testdata/build/windows.go:5:1: var _ = "windows"`,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-a", `build("windows,arm")`, "testdata/build/windows.go"},
+			``,
+		},
+		{
+			[]string{"-x", "time.Sleep($_)", "-a", `!comment(".")`, "testdata/sleep.go"},
+			`testdata/sleep.go:11:2: time.Sleep(time.Second)`,
+		},
+		{
+			[]string{"-x", "time.Sleep($_)", "-a", `comment("wait")`, "testdata/sleep.go"},
+			`testdata/sleep.go:7:2: time.Sleep(time.Second)`,
+		},
+		{
+			[]string{"-x", "time.Sleep($_)", "-a", `!comment(".", 2)`, "testdata/sleep.go"},
+			``,
+		},
+		{
+			[]string{"-norm", "-x", "var _ = $_", "testdata/norm.go"},
+			`var _ = struct { a int; bcde string; }{a: 1, bcde: "x  y"}`,
+		},
+		{
+			[]string{"-x", "var global = $_", "-refs", "testdata/refs.go"},
+			`
+				testdata/refs.go:6:13: global
+				testdata/refs.go:10:9: global
+			`,
+		},
+		{
+			[]string{"-x", "fn($_)", "-refs", "-p", "1", "testdata/refs.go"},
+			`
+				testdata/refs.go:10:6: fn(global)
+				testdata/refs.go:11:6: fn(2)
+			`,
+		},
+		{
+			[]string{"-x", "fn($_)", "-def", "testdata/refs.go"},
+			`testdata/refs.go:5:1: func fn(x int) int { return x + global; }`,
+		},
+		{
+			[]string{"-x", "return $_ + $_", "-x", "x", "-def", "testdata/refs.go"},
+			`testdata/refs.go:5:9: x int`,
+		},
+		{
+			[]string{"-x", "var global = $_", "-uses", "testdata/refs.go", "testdata/uses.go"},
+			`testdata/refs.go:3:1: var global = 1 // uses: 4, files: 2, packages: 1`,
+		},
+		{
+			[]string{"-verbose", "-x", "func $_($_ $_) int { $*_ }", "-uses", "testdata/refs.go", "testdata/uses.go"},
+			`
+				testdata/refs.go:5:1: func fn(x int) int { return x + global; } // uses: 3, files: 2, packages: 1
+				  testdata/refs.go: 2
+				  testdata/uses.go: 1
+			`,
+		},
+		{
+			[]string{"-x", "func useGlobal() int { $*_ }", "-uses", "testdata/refs.go", "testdata/uses.go"},
+			`testdata/uses.go:3:1: func useGlobal() int { return global + fn(global); } // uses: 0, files: 0, packages: 0`,
+		},
+		{
+			[]string{"-x", "global", "-def", "testdata/refs.go"},
+			`testdata/refs.go:3:1: var global = 1`,
+		},
+		{
+			[]string{"-rules", "testdata/rules.json", "testdata/rules.go"},
+			`
+				testdata/rules.go:4:2: info: avoid println (println)
+				testdata/rules.go:5:5: warning: p == nil (nil-compare)
+			`,
+		},
+		{
+			[]string{"-rules", "testdata/rules_msg.json", "testdata/rules_msg.go"},
+			`testdata/rules_msg.go:6:2: error: call to os.Remove ignores its error (func(name string) error) (unchecked)`,
+		},
+		{
+			[]string{"-rules", "testdata/scope/rules.json", "./testdata/scope/..."},
+			`
+				testdata/scope/a.go:4:2: warning: println("scope") (println)
+				testdata/scope/api/a.go:4:2: warning: println("api") (api-println)
+				testdata/scope/api/a.go:4:2: warning: println("api") (println)
+			`,
+		},
+		{
+			[]string{"-rules", "testdata/rules.json,testdata/rules.json", "testdata/rules.go"},
+			fmt.Errorf(`rule "println" already defined in testdata/rules.json`),
+		},
+		{
+			[]string{"rules", "list", "-rules", "testdata/rules.json", "-pack", "errors"},
+			`
+				println               info     -x 'println($*_)'
+				nil-compare           warning  -x '$x == nil' -v 'err == nil'
+				unchecked-error       warning  -x '$f($*_);' -x '$f($*_)' -a 'type(error)'
+				discarded-error       info     -x '_ = $x' -x '$x' -a 'type(error)'
+				error-string-compare  warning  -x '$x.Error() == $_'
+			`,
+		},
+		{
+			[]string{"rules", "list", "-json", "-rules", "testdata/rules.json"},
+			`
+				[
+				  {
+				    "name": "println",
+				    "pipeline": [
+				      "-x",
+				      "println($*_)"
+				    ],
+				    "severity": "info",
+				    "message": "avoid println",
+				    "docs": "https://example.com/rules/println"
+				  },
+				  {
+				    "name": "nil-compare",
+				    "pipeline": [
+				      "-x",
+				      "$x == nil",
+				      "-v",
+				      "err == nil"
+				    ],
+				    "severity": "warning",
+				    "message": ""
+				  }
+				]
+			`,
+		},
+		{
+			[]string{"rules", "show"},
+			fmt.Errorf("usage: gogrep rules list"),
+		},
+		{
+			[]string{"search", "-x", "global", "-def", "testdata/refs.go"},
+			`testdata/refs.go:3:1: var global = 1`,
+		},
+		{
+			[]string{"rewrite", "-x", "global", "testdata/refs.go"},
+			fmt.Errorf("rewrite: need at least one -s command"),
+		},
+		{
+			[]string{"rewrite", "-x", "global", "-s", "other", "-w", "testdata/refs.go"},
+			fmt.Errorf("rewrite: -w is implied"),
+		},
+		{
+			[]string{"rules", "test", "testdata/ruletest/pass"},
+			`
+				ok   println
+				ok   nil-compare
+				?    self-assign [no test cases]
+			`,
+		},
+		{
+			[]string{"-explain", "-x", "foo($x, $*_)", "-a", "!type(error)", "-g", "$x; $x"},
+			`
+				-x 'foo($x, $*_)'
+				  kind: expression (*ast.CallExpr)
+				  wildcard: $x matches any single node, the same each time
+				  wildcard: $*_ matches any number of nodes
+				  tree:
+				    CallExpr
+				      Fun: Ident foo
+				      Args:
+				        Ident $x
+				        Ident $*_
+				-a '!type(error)'
+				  attribute: not type constraint: the type is identical to error
+				-g '$x; $x'
+				  kind: 2 statements
+				  wildcard: $x matches any single node, the same each time
+				  tree:
+				    ExprStmt
+				      X: Ident $x
+				    ExprStmt
+				      X: Ident $x
+			`,
+		},
+		{
+			[]string{"-x", "foo($x, $*y)", "-test-src", "foo(1, 2, 3); bar(); foo(a, b)"},
+			`
+				match: foo(1, 2, 3)
+				  $x = 1
+				  $y = 2, 3
+				match: foo(a, b)
+				  $x = a
+				  $y = b
+			`,
+		},
+		{
+			[]string{"-x", "bar($x)", "-test-src", "foo(1)"},
+			`no match`,
+		},
+		{
+			[]string{"-x", "foo", "-refs", "-test-src", "foo(1)"},
+			fmt.Errorf("-test-src can't be used with type information"),
+		},
+		{
+			[]string{"-x", "bar($*_)", "-or", "foo(a, $x)", "testdata/fuzzy.go"},
+			`
+				testdata/fuzzy.go:4:2: foo(a, b)
+				testdata/fuzzy.go:5:2: foo(a, c)
+				testdata/fuzzy.go:8:2: bar(x, y)
+				testdata/fuzzy.go:9:2: foo(a, b+1)
+			`,
+		},
+		{
+			[]string{"-f", "testdata/errreturn.gg", "testdata/errreturn.go"},
+			`testdata/errreturn.go:5:2: if err != nil { return 0, err; }`,
+		},
+		{
+			[]string{"-x", "foo(a, $x)", "-exec", "echo", "{}", "{$x}", "{line}-{endline}", ";", "testdata/fuzzy.go"},
+			`
+				testdata/fuzzy.go:4:2 b 4-4
+				testdata/fuzzy.go:5:2 c 5-5
+				testdata/fuzzy.go:9:2 b + 1 9-9
+			`,
+		},
+		{
+			[]string{"-x", "foo(a, $x)", "-exec", "false", ";", "testdata/fuzzy.go"},
+			fmt.Errorf("-exec: 3 of 3 commands failed"),
+		},
+		{
+			[]string{"-x", "foo(a, $x)", "-exec", "echo", "testdata/fuzzy.go"},
+			fmt.Errorf(`-exec must be terminated by ";"`),
+		},
+		{
+			[]string{"test", "testdata/ruletest/pass"},
+			`
+				ok   println
+				ok   nil-compare
+				?    self-assign [no test cases]
+			`,
+		},
+		{
+			[]string{"test", "testdata/ruletest/fail"},
+			fmt.Errorf("2 of 3 rules failed"),
+		},
+		{
+			[]string{"-rules", "testdata/badrules.json", "testdata/rules.go"},
+			fmt.Errorf(`rule "bad": unknown severity "fatal"`),
+		},
+		{
+			[]string{"-pack", "errors,concurrency", "testdata/packs.go"},
+			`
+				testdata/packs.go:12:3: warning: deferred calls only run when the func returns, not at the end of each iteration (defer-in-loop)
+				testdata/packs.go:15:9: warning: sync.Mutex copied by value (mutex-copy)
+				testdata/packs.go:16:8: warning: sync.Mutex copied by value (mutex-copy-assign)
+				testdata/packs.go:17:6: warning: sync.Mutex copied by value (mutex-copy-assign)
+				testdata/packs.go:18:2: warning: error result is not checked (unchecked-error)
+				testdata/packs.go:19:6: info: error result is explicitly discarded (discarded-error)
+				testdata/packs.go:20:31: warning: errors should be compared directly, not via their messages (error-string-compare)
+				testdata/packs.go:46:8: warning: sync.Mutex copied by value (mutex-copy)
+				testdata/packs.go:47:7: warning: sync.Mutex copied by value (mutex-copy-assign)
+				testdata/packs.go:48:6: warning: sync.Mutex copied by value (mutex-copy-assign)
+			`,
+		},
+		{
+			[]string{"-pack", "crypto", "./testdata/crypto"},
+			`
+				testdata/crypto/crypto.go:16:2: warning: MD5 and SHA-1 are broken; use SHA-256 or better for security (weak-hash)
+				testdata/crypto/crypto.go:18:2: warning: DES and RC4 are broken; use AES-GCM or ChaCha20-Poly1305 (weak-cipher)
+				testdata/crypto/crypto.go:25:2: warning: math/rand is predictable; use crypto/rand for keys and tokens (insecure-rand-read)
+				testdata/crypto/crypto.go:27:17: warning: math/rand is predictable; use crypto/rand for keys and tokens (insecure-rand-key)
+				testdata/crypto/crypto.go:35:21: error: TLS certificate verification is disabled (insecure-skip-verify)
+				testdata/crypto/crypto.go:36:6: error: TLS certificate verification is disabled (insecure-skip-verify)
+				testdata/crypto/crypto.go:43:37: warning: math/rand is predictable; use crypto/rand for keys and tokens (insecure-rand-key)
+			`,
+		},
+		{
+			[]string{"-pack", "nope", "testdata/packs.go"},
+			fmt.Errorf(`unknown pack "nope"; available: concurrency, crypto, errors, sqli`),
+		},
+		{
+			[]string{"-rules", "testdata/rules_dsl.go", "testdata/rules.go"},
+			`
+				testdata/rules.go:4:2: info: avoid println (noPrintln)
+				testdata/rules.go:5:5: warning: p == nil (nilCompare)
+				testdata/rules.go:8:2: info: avoid println (noPrintln)
+			`,
+		},
+		{
+			[]string{"-rules", "testdata/badrules_dsl.go", "testdata/rules.go"},
+			fmt.Errorf(`badrules_dsl.go:6:17: unknown rule method "Foo"`),
+		},
+		{
+			[]string{"-snap", "line", "-x", "g($_)", "./testdata/snap"},
+			`
+				testdata/snap/snap.go:4:2: x := g(1) + g(2)
+				testdata/snap/snap.go:5:5: g(3) > 0
+			`,
+		},
+		{
+			[]string{"-snap", "stmt", "-x", "g($_)", "./testdata/snap"},
+			`
+				testdata/snap/snap.go:4:2: x := g(1) + g(2)
+				testdata/snap/snap.go:5:2: if g(3) > 0 { _ = x; }
+			`,
+		},
+		{
+			[]string{"-rules", "testdata/rules_macros.json", "testdata/rules_macros.go"},
+			`
+				testdata/rules_macros.go:6:2: warning: error handled without returning (unchecked)
+				testdata/rules_macros.go:10:2: warning: os.ExpandEnv("${HOME}") (env)
+			`,
+		},
+		{
+			[]string{"-rules", "testdata/rules_macros_dsl.go", "testdata/rules_macros.go"},
+			`testdata/rules_macros.go:6:2: warning: error handled without returning (unchecked)`,
+		},
+		{
+			[]string{"-rules", "testdata/badrules_macros.json", "testdata/rules_macros.go"},
+			fmt.Errorf(`rule "loop": macro "a" uses itself via a -> b -> a`),
+		},
+		{
+			[]string{"fix", "-edit", "testdata/fix/fix.go:6:35", "-rules", "testdata/fix/rules.json", "./testdata/fix"},
+			`
+				{
+				  "file": "testdata/fix/fix.go",
+				  "rule": "error-string",
+				  "offset": 87,
+				  "end": 98,
+				  "line": 6,
+				  "column": 32,
+				  "end_line": 6,
+				  "end_column": 43,
+				  "new_text": "err"
+				}
+			`,
+		},
+		{
+			[]string{"fix", "-edit", "testdata/fix/fix.go:10:30", "-rules", "testdata/fix/rules.json", "./testdata/fix"},
+			`
+				{
+				  "file": "testdata/fix/fix.go",
+				  "rule": "empty-slice",
+				  "offset": 157,
+				  "end": 168,
+				  "line": 10,
+				  "column": 24,
+				  "end_line": 10,
+				  "end_column": 35,
+				  "new_text": "b == nil"
+				}
+			`,
+		},
+		{
+			[]string{"-x", "func $_() $_ { $*_ }", "//foo:foo", "//bar:all"},
+			`
+				testdata/bazel/bar/bar_test.go:3:1: func helper() string { return "bar"; }
+				testdata/bazel/foo/a.go:3:1: func A() int { return 1; }
+				testdata/bazel/foo/tagged.go:5:1: func Tagged() int { return 2; }
+			`,
+		},
+		{
+			[]string{"-x", "return $x", "-x", "$x", "-a", "type(int)", "//foo/..."},
+			`
+				testdata/bazel/foo/a.go:3:23: 1
+				testdata/bazel/foo/tagged.go:5:28: 2
+			`,
+		},
+		{
+			[]string{"-rdjsonl", "-rules", "testdata/fix/rules.json", "./testdata/fix"},
+			`
+				{"message":"fmt.Errorf(\"wrap: %s\", err)","location":{"path":"testdata/fix/fix.go","range":{"start":{"line":6,"column":9},"end":{"line":6,"column":44}}},"severity":"WARNING","source":{"name":"gogrep"},"code":{"value":"no-fix"}}
+				{"message":"err","location":{"path":"testdata/fix/fix.go","range":{"start":{"line":6,"column":32},"end":{"line":6,"column":43}}},"severity":"WARNING","source":{"name":"gogrep"},"code":{"value":"error-string"},"suggestions":[{"range":{"start":{"line":6,"column":32},"end":{"line":6,"column":43}},"text":"err"}]}
+				{"message":"a == nil","location":{"path":"testdata/fix/fix.go","range":{"start":{"line":10,"column":9},"end":{"line":10,"column":20}}},"severity":"WARNING","source":{"name":"gogrep"},"code":{"value":"empty-slice"},"suggestions":[{"range":{"start":{"line":10,"column":9},"end":{"line":10,"column":20}},"text":"a == nil"}]}
+				{"message":"b == nil","location":{"path":"testdata/fix/fix.go","range":{"start":{"line":10,"column":24},"end":{"line":10,"column":35}}},"severity":"WARNING","source":{"name":"gogrep"},"code":{"value":"empty-slice"},"suggestions":[{"range":{"start":{"line":10,"column":24},"end":{"line":10,"column":35}},"text":"b == nil"}]}
+			`,
+		},
+		{
+			[]string{"fix", "testdata/fix/fix.go:6:9", "-rules", "testdata/fix/rules.json", "./testdata/fix"},
+			fmt.Errorf("fix.go:6:9: no rule match with a substitution"),
+		},
+		{
+			[]string{"fix", "testdata/fix/fix.go", "-rules", "testdata/fix/rules.json", "./testdata/fix"},
+			fmt.Errorf(`"testdata/fix/fix.go" is not a position like file.go:12:3`),
+		},
+		{
+			[]string{"gen-analyzer", "testdata/fix/rules.json", "-o", "testdata/out/checks"},
+			fmt.Errorf(`rules.json: rule "no-fix": -x fmt.Errorf($*_): only simple wildcards like $x are supported`),
+		},
+		{
+			[]string{"gen-analyzer", "testdata/fix/rules.json", "-o", "testdata/out/my-checks"},
+			fmt.Errorf("my-checks is not a valid package name"),
+		},
+		{
+			[]string{"gen-analyzer", "testdata/fix/rules.json"},
+			fmt.Errorf("usage: gogrep gen-analyzer [-golangci] rules -o dir"),
+		},
+		{
+			[]string{"-x", "$_.HandleFunc($path, $_)", "-ctags", "$_", "./testdata/ctags"},
+			fmt.Errorf(`tags: need a wildcard name like $name, not "$_"`),
+		},
+		{
+			[]string{"-x", "$_.HandleFunc($path, $_)", "-ctags", "path", "-etags", "path", "./testdata/ctags"},
+			fmt.Errorf("cannot use -ctags and -etags at once"),
+		},
+		{
+			[]string{"-x", "$f(context.TODO(), $*_)", "-count-by", "$f", "./testdata/countby"},
+			`
+				2 fetch
+				1 c.get
+				1 c.put
+				1 other.get
+			`,
+		},
+		{
+			[]string{"-x", "$f($c, $*_)", "-x", "$c", "-a", `obj("context.TODO")`, "-count-by", "f", "./testdata/countby"},
+			`
+				2 (./testdata/countby.client).get
+				2 ./testdata/countby.fetch
+				1 (./testdata/countby.client).put
+			`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-count-by", "$_", "./testdata/countby"},
+			fmt.Errorf(`count-by: need a wildcard name like $name, not "$_"`),
+		},
+		{
+			[]string{"-x", "foo($_)", "./testdata/linedir"},
+			`
+				testdata/linedir/parser.go:7:2: foo(s)
+				testdata/linedir/parser.go:12:2: foo(s)
+				testdata/linedir/parser.go:17:2: foo(s)
+			`,
+		},
+		{
+			[]string{"-linedirs", "-x", "foo($_)", "./testdata/linedir"},
+			`
+				testdata/linedir/parser.go:7:2: foo(s) // from testdata/linedir/parser.y:13
+				testdata/linedir/parser.go:12:2: foo(s) // from testdata/linedir/parser.y:41:2
+				testdata/linedir/parser.go:17:2: foo(s) // from testdata/linedir/parser.go:2
+			`,
+		},
+		{
+			[]string{"diff", "testdata/diff/old", "testdata/diff/new", "-x", "foo($_)", "a.go"},
+			`
+				-a.go:4:2: foo(1)
+				-a.go:5:2: foo(2)
+				+a.go:5:2: foo(3)
+			`,
+		},
+		{
+			[]string{"migrate"},
+			fmt.Errorf("usage: gogrep migrate [-from rev] migrations [packages]"),
+		},
+		{
+			[]string{"-shard", "2/2", "-x", "len($s) == 0", "./testdata/fix"},
+			``,
+		},
+		{
+			[]string{"-shard", "1/2", "-x", "len($s) == 0", "./testdata/fix"},
+			`
+				testdata/fix/fix.go:10:9: len(a) == 0
+				testdata/fix/fix.go:10:24: len(b) == 0
+			`,
+		},
+		{
+			[]string{"-skip", "./testdata/fix", "-x", "len($s) == 0", "./testdata/fix"},
+			``,
+		},
+		{
+			[]string{"-skip", "./testdata/f...", "-x", "len($s) == 0", "./testdata/fix", "testdata/two/file1.go"},
+			``,
+		},
+		{
+			[]string{"-skip", "mvdan.cc/gogrep/...", "-x", "len($s) == 0", "./testdata/fix"},
+			`
+				testdata/fix/fix.go:10:9: len(a) == 0
+				testdata/fix/fix.go:10:24: len(b) == 0
+			`,
+		},
+		{
+			[]string{"-package-timeout", "1ns", "-x", "println($x)", "."},
+			fmt.Errorf(".: timed out after 1ns; skipped"),
+		},
+		{
+			[]string{"-package-timeout", "1ns", "-rules", "testdata/rules.json", "."},
+			fmt.Errorf(".: timed out after 1ns; skipped"),
+		},
+		{
+			[]string{"-package-timeout", "1m", "-x", "len($s) == 0", "./testdata/fix"},
+			`
+				testdata/fix/fix.go:10:9: len(a) == 0
+				testdata/fix/fix.go:10:24: len(b) == 0
+			`,
+		},
+		{
+			[]string{"-resume", "-x", "foo", "./testdata/fix"},
+			fmt.Errorf("-resume needs -checkpoint"),
+		},
+		{
+			[]string{"-checkpoint", "cp.json", "-r", "-x", "foo", "./testdata/fix"},
+			fmt.Errorf("cannot use -checkpoint with -r"),
+		},
+		{
+			[]string{"-max-mem", "1", "-x", "len($s) == 0", "-or", "foo($_)", "./testdata/fix", "./testdata/linedir"},
+			`
+				testdata/fix/fix.go:10:9: len(a) == 0
+				testdata/fix/fix.go:10:24: len(b) == 0
+				testdata/linedir/parser.go:7:2: foo(s)
+				testdata/linedir/parser.go:12:2: foo(s)
+				testdata/linedir/parser.go:17:2: foo(s)
+			`,
+		},
+		{
+			[]string{"-max-mem", "1G", "-sarif", "-x", "foo", "./testdata/fix"},
+			fmt.Errorf("-max-mem only supports printing matches and rule matches"),
+		},
+		{
+			[]string{"-max-time", "1h", "-x", "func Leaf() {}", "-callers", "xpkg/a", "xpkg/b"},
+			fmt.Errorf("cannot use -max-time with -callers, as it needs all the packages at once"),
+		},
+		{
+			[]string{"-checkpoint", "cp.json", "-x", "Leaf", "-a", "!deprecated", "xpkg/a", "xpkg/b"},
+			fmt.Errorf("cannot use -checkpoint with -a, as it needs all the packages at once"),
+		},
+		{
+			[]string{"-cache", "testdata/nonexistent", "-x", "foo", "-s", "bar", "-w", "./testdata/fix"},
+			fmt.Errorf("cannot use -cache with -w"),
+		},
+		{
+			[]string{"-cache", "testdata/nonexistent", "-x", "Leaf", "-refs", "xpkg/a", "xpkg/b"},
+			fmt.Errorf("cannot use -cache with -refs, as it needs all the packages at once"),
+		},
+		{
+			[]string{"merge"},
+			fmt.Errorf("usage: gogrep merge files..."),
+		},
+		{
+			[]string{"diff", "testdata/diff/old"},
+			fmt.Errorf("usage: gogrep diff"),
+		},
+		{
+			[]string{"-fuzzy", "1", "-x", "foo(a, $x)", "testdata/fuzzy.go"},
+			`
+				testdata/fuzzy.go:4:2: foo(a, b)
+				testdata/fuzzy.go:5:2: foo(a, c)
+				testdata/fuzzy.go:6:2: foo(a) // fuzzy: missing $x
+				testdata/fuzzy.go:7:2: foo(a, b, d) // fuzzy: extra d
+				testdata/fuzzy.go:9:2: foo(a, b+1)
+			`,
+		},
+		{
+			[]string{"-fuzzy", "1", "-x", "foo(a, b)", "testdata/fuzzy.go"},
+			`
+				testdata/fuzzy.go:4:2: foo(a, b)
+				testdata/fuzzy.go:5:2: foo(a, c) // fuzzy: want b, got c
+				testdata/fuzzy.go:6:2: foo(a) // fuzzy: missing b
+				testdata/fuzzy.go:7:2: foo(a, b, d) // fuzzy: extra d
+				testdata/fuzzy.go:9:2: foo(a, b+1) // fuzzy: want b, got b + 1
+			`,
+		},
+		{
+			[]string{"-clones", "10", "testdata/clones.go"},
+			`
+				testdata/clones.go:4:2: if x > 10 { x = x * 2; return x + 1; }
+				testdata/clones.go:12:2: if y > 20 { y = y * 3; return y + 1; }
+			`,
+		},
+		{
+			[]string{"-clones", "20", "testdata/clones.go"},
+			``,
+		},
+		{
+			[]string{"-x", "go $f()", "-or", "defer $f()", "-a", "loopvar", "./testdata/loopvar", "./testdata/loopvar/newer"},
+			`
+				testdata/loopvar/loopvar.go:7:3: go func() { work(x); }() // loopvar: captures x
+				testdata/loopvar/loopvar.go:11:3: defer func() { work(i); }() // loopvar: captures i
+				testdata/loopvar/loopvar.go:16:4: go func() { defer func() { work(x + j); }(); }() // loopvar: captures x
+				testdata/loopvar/loopvar.go:17:5: defer func() { work(x + j); }() // loopvar: captures x
+			`,
+		},
+		{
+			[]string{"-x", "$mu.Lock()", "-or", "$mu.RLock()", "-a", "!unlocked", "testdata/lock.go"},
+			`
+				testdata/lock.go:23:2: s.mu.Lock()
+				testdata/lock.go:32:2: s.mu.Lock()
+				testdata/lock.go:53:2: s.mu.Lock()
+			`,
+		},
+		{
+			[]string{"-x", "$mu.Lock()", "-or", "$mu.RLock()", "-a", "unlocked", "testdata/lock.go"},
+			`
+				testdata/lock.go:11:2: s.mu.RLock()
+				testdata/lock.go:17:2: s.mu.Lock()
+				testdata/lock.go:38:3: s.mu.Lock()
+				testdata/lock.go:49:3: s.mu.Lock()
+			`,
+		},
+		{
+			[]string{"-unsafe", "testdata/unsafe.go"},
+			`
+				testdata/unsafe.go:8:14: package level
+				  testdata/unsafe.go:8:14: uintptr(unsafe.Pointer(&x))
+
+				testdata/unsafe.go:14:1: func (*p1.buf).str
+				  receiver: *p1.buf
+				  callers: testdata/unsafe.go:29:6 (use), testdata/unsafe.go:31:6 (use)
+				  testdata/unsafe.go:15:10: (*string)(unsafe.Pointer(&b.data))
+
+				testdata/unsafe.go:18:1: func p1.header
+				  callers: testdata/unsafe.go:30:6 (use)
+				  testdata/unsafe.go:19:7: (*reflect.SliceHeader)(unsafe.Pointer(&s))
+
+				testdata/unsafe.go:24:1: func p1.bytesOf
+				  callers: none
+				  testdata/unsafe.go:25:9: unsafe.Slice(unsafe.StringData(s), len(s))
+			`,
+		},
+		{
+			[]string{"-x", "println($_)", "-reach", "func main() { $*_ }", "testdata/reach.go"},
+			`
+				testdata/reach.go:8:15: println("a")
+				testdata/reach.go:10:16: println("b")
+				testdata/reach.go:14:15: println("d")
+			`,
+		},
+		{
+			[]string{"-x", "source()", "-taint", "sink($_)", "testdata/taint.go"},
+			`
+				testdata/taint.go:9:2: sink(s)
+				testdata/taint.go:15:2: sink(t)
+				testdata/taint.go:27:2: sink(x.f)
+			`,
+		},
+		{
+			[]string{"-interproc", "-x", "source()", "-taint", "sink($_)", "testdata/taint.go"},
+			`
+				testdata/taint.go:9:2: sink(s)
+				testdata/taint.go:15:2: sink(t)
+				testdata/taint.go:27:2: sink(x.f)
+				testdata/taint.go:30:25: sink(s)
+			`,
+		},
+		{
+			[]string{"-x", "$p", "-a", "constarg", "testdata/constarg.go"},
+			`
+				testdata/constarg.go:5:11: addr
+				testdata/constarg.go:5:24: timeout
+				testdata/constarg.go:7:12: n
+				testdata/constarg.go:13:22: path
+				testdata/constarg.go:13:35: limit
+			`,
+		},
+		{
+			[]string{"-x", "timeout", "-a", "constarg(0)", "testdata/constarg.go"},
+			`testdata/constarg.go:5:24: timeout`,
+		},
+		{
+			[]string{"-x", "$p", "-a", "constarg(-1)", "testdata/constarg.go"},
+			`testdata/constarg.go:13:35: limit`,
+		},
+		{
+			[]string{"-x", "source()", "-concat", "sink($_)", "-or", "sink2($_)", "testdata/taint.go"},
+			`
+				testdata/taint.go:15:2: sink(t)
+				testdata/taint.go:39:2: sink2(s + "!")
+			`,
+		},
+		{
+			[]string{"-x", "func leaf() {}", "-callers", "testdata/calls.go"},
+			`
+				testdata/calls.go:5:1: func mid() { leaf(); }
+				testdata/calls.go:7:1: func top() { mid(); leaf(); }
+				testdata/calls.go:12:1: func unused() { top(); }
+			`,
+		},
+		{
+			[]string{"-x", "func leaf() {}", "-callers=1", "testdata/calls.go"},
+			`
+				testdata/calls.go:5:1: func mid() { leaf(); }
+				testdata/calls.go:7:1: func top() { mid(); leaf(); }
+			`,
+		},
+		{
+			[]string{"-x", "func unused() { $*_ }", "-callees=1", "testdata/calls.go"},
+			`
+				testdata/calls.go:7:1: func top() { mid(); leaf(); }
+			`,
+		},
+		{
+			[]string{"-x", "func unused() { $*_ }", "-callees", "testdata/calls.go"},
+			`
+				testdata/calls.go:3:1: func leaf() { }
+				testdata/calls.go:5:1: func mid() { leaf(); }
+				testdata/calls.go:7:1: func top() { mid(); leaf(); }
+			`,
+		},
+		{
+			[]string{"-x", "foo", "-callers=0"},
+			fmt.Errorf(`invalid -callers depth: "0"`),
+		},
+		{
+			[]string{"-x", "Shape", "-impls", "testdata/impls.go"},
+			`
+				testdata/impls.go:7:1: type Square struct{ side float64 }
+				testdata/impls.go:11:1: type Circle struct{ r float64 }
+			`,
+		},
+		{
+			[]string{"-x", "Area", "-impls", "testdata/impls.go"},
+			`
+				testdata/impls.go:9:1: func (s Square) Area() float64 { return s.side * s.side; }
+				testdata/impls.go:13:1: func (c *Circle) Area() float64 { return 3 * c.r * c.r; }
+			`,
+		},
+		{
+			[]string{"-comment", "(", "testdata/comments.go"},
+			fmt.Errorf("cannot parse comment regex"),
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			testLoad(t, &m, tc.args, tc.want)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	m := matcher{ctx: &build.Default, configDir: "testdata/config", errOut: ioutil.Discard}
+	tests := []struct {
+		args []string
+		want interface{}
+	}{
+		{
+			[]string{"run", "println", "testdata/config/a.go"},
+			`
+				println("a")
+				println("")
+			`,
+		},
+		{
+			[]string{"run", "nonempty", "testdata/config/a.go"},
+			`println("a")`,
+		},
+		{
+			[]string{"-x", "println($x)", "-g", `""`, "testdata/config/a.go"},
+			`println("")`,
+		},
+		{
+			[]string{"-rules", "testdata/config/rules.json", "./testdata/config/..."},
+			`
+				testdata/config/a.go:4:2: warning: println("a") (println)
+				testdata/config/a.go:5:2: warning: println("") (println)
+			`,
+		},
+		{
+			[]string{"-x", "println($x)", "./testdata/config/..."},
+			`
+				println("a")
+				println("")
+				println("legacy")
+			`,
+		},
+		{
+			[]string{"-max-time", "1m", "-x", "println($x)", "./testdata/config/..."},
+			`
+				println("legacy")
+				println("a")
+				println("")
+			`,
+		},
+		{
+			[]string{"-max-time", "1ns", "-x", "println($x)", "./testdata/config/..."},
+			fmt.Errorf("partial results: 3 of 3 packages left unmatched after -max-time 1ns"),
+		},
+		{
+			[]string{"-pack", "sqli", "./testdata/config/sqli"},
+			`
+				testdata/config/sqli/sqli.go:16:2: error: SQL query built from untrusted input; pass it as a query argument instead (sql-injection)
+				testdata/config/sqli/sqli.go:19:2: error: SQL query built from untrusted input; pass it as a query argument instead (sql-injection)
+				testdata/config/sqli/sqli.go:20:2: error: SQL query built from untrusted input; pass it as a query argument instead (sql-injection)
+				testdata/config/sqli/sqli.go:27:14: error: SQL query built from untrusted input; pass it as a query argument instead (sql-injection)
+				testdata/config/sqli/sqli.go:29:2: error: SQL query built from untrusted input; pass it as a query argument instead (sql-injection)
+			`,
+		},
+		{
+			[]string{"run", "nope"},
+			fmt.Errorf(`unknown alias "nope"`),
+		},
+		{
+			[]string{"run"},
+			fmt.Errorf(`usage: gogrep run alias`),
+		},
+		{
+			[]string{"completion", "names", "aliases"},
+			`
+				nonempty
+				println
+			`,
+		},
+		{
+			[]string{"completion", "names", "nope"},
+			fmt.Errorf(`unknown kind "nope"`),
+		},
+		{
+			[]string{"-x", "$f()", "-a", "type(error)", "./testdata/modules/..."},
+			`util.Do()`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "./testdata/modules/..."},
+			`
+				util.Do()
+				fmt.Println("x")
+			`,
+		},
+		{
+			[]string{"-x", "util.Do()", "-callers", "./testdata/modules/..."},
+			fmt.Errorf("cannot use -callers with packages in 2 modules"),
+		},
+		{
+			[]string{"-estimate", "-x", "$f()", "./testdata/modules/..."},
+			`
+				matched:       3 packages, 3 files, 163B
+				type-checking: not needed
+			`,
+		},
+		{
+			[]string{"-estimate", "-x", "$f()", "testdata/importflag/main.go"},
+			`
+				matched:       1 packages, 1 files, 65B
+				type-checking: not needed
+			`,
+		},
+		{
+			[]string{"-import", "./testdata/importflag/lib", "-x", "var $x $_", "-x", "$x",
+				"-a", "asgn(lib.Doer)", "testdata/importflag/main.go"},
+			`t`,
+		},
+		{
+			[]string{"-import", "./testdata/importflag/lib=l", "-x", "var $x $_", "-x", "$x",
+				"-a", "!asgn(l.Doer)", "testdata/importflag/main.go"},
+			`n`,
+		},
+		{
+			[]string{"completion", "tcsh"},
+			fmt.Errorf(`unsupported shell "tcsh"`),
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			testLoad(t, &m, tc.args, tc.want)
+		})
+	}
+}
+
+func TestMatchErrors(t *testing.T) {
+	tests := []struct {
+		args             []string
+		wantOut, wantErr string
+	}{
+		{
+			[]string{"-x", "$x", "-a", "type(f())", "testdata/recv.go"},
+			"", "testdata/recv.go:5:6: unsupported type: *ast.CallExpr",
+		},
+		{
+			[]string{"-x", "var _ = $x", "testdata/two/file1.go", "noexist.go", "noexist2.go"},
+			"testdata/two/file1.go:3:1: var _ = \"file1\"\n",
+			"2 packages or files failed:\n\topen noexist.go: no such file or directory" +
+				"\n\topen noexist2.go: no such file or directory",
+		},
+		{
+			[]string{"-strict", "-x", "var _ = $x", "testdata/two/file1.go", "noexist.go"},
+			"", "open noexist.go: no such file or directory",
+		},
+		{
+			[]string{"-w", "-x", "foo", "testdata/recv.go"},
+			"", "-w must be the last command",
+		},
+		{
+			[]string{"-x", "println($x)", "testdata/syntax.go"},
+			"testdata/syntax.go:4:2: println(1) // degraded: the file has syntax errors\n",
+			"testdata/syntax.go:6:1: expected operand, found '}' (and 3 more errors)",
+		},
+		{
+			[]string{"-strict", "-x", "println($x)", "testdata/syntax.go"},
+			"", "testdata/syntax.go:6:1: expected operand, found '}' (and 3 more errors)",
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			var buf bytes.Buffer
+			m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+			err := m.fromArgs(tc.args)
+			if err == nil || err.Error() != tc.wantErr {
+				t.Fatalf("wanted error %q, got %v", tc.wantErr, err)
+			}
+			if got := buf.String(); got != tc.wantOut {
+				t.Fatalf("wanted output:\n%sgot:\n%s", tc.wantOut, got)
+			}
+		})
+	}
+}
+
+func TestDirnameInPkgDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("testdata/pkgname/good"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var buf bytes.Buffer
+	m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+	if err := m.fromArgs([]string{"-x", "package $_", "-a", "dirname", "."}); err != nil {
+		t.Fatal(err)
+	}
+	want := "a.go:1:1: package good\na_test.go:1:1: package good_test\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("wanted output:\n%sgot:\n%s", want, got)
+	}
+}
+
+func TestRulesSARIF(t *testing.T) {
+	m := matcher{ctx: &build.Default}
+	var buf bytes.Buffer
+	m.out = &buf
+	args := []string{"-sarif", "-rules", "testdata/rules_msg.json", "testdata/rules_msg.go"}
+	if err := m.fromArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	if want := 3; m.exitCode != want {
+		t.Fatalf("wanted exit code %d, got %d", want, m.exitCode)
+	}
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Fatalf("wanted one run with one result, got:\n%s", buf.String())
+	}
+	res := log.Runs[0].Results[0]
+	want := sarifResult{
+		RuleID:  "unchecked",
+		Level:   "error",
+		Message: sarifMessage{"call to os.Remove ignores its error (func(name string) error)"},
+		Locations: []sarifLocation{{sarifPhysicalLocation{
+			ArtifactLocation: sarifArtifact{"testdata/rules_msg.go"},
+			Region:           sarifRegion{6, 2, 6, 18},
+		}}},
+	}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("wanted %#v, got %#v", want, res)
+	}
+}
+
+func TestServe(t *testing.T) {
+	requests := []string{
+		`{"jsonrpc": "2.0", "id": 1, "method": "query", "params": {"args": ["-x", "foo"]}}`,
+		`{"jsonrpc": "2.0", "id": 2, "method": "loadTargets", "params": {"packages": ["./testdata/fix"]}}`,
+		`{"jsonrpc": "2.0", "id": 3, "method": "rewritePreview", "params": {"args": ["-x", "len($s) == 0", "-s", "$s == nil"]}}`,
+		`{"jsonrpc": "2.0", "id": 4, "method": "query", "params": {"args": ["-x", "len($s) == 0", "-x", "$s", "-a", "type([]int)"]}}`,
+		`{"jsonrpc": "2.0", "method": "query", "params": {"args": ["-x", "foo"]}}`,
+		`{"jsonrpc": "2.0", "id": 5, "method": "query", "params": {"args": ["-bad"]}}`,
+		`{"jsonrpc": "2.0", "id": 6, "method": "other"}`,
+		`{"jsonrpc": "2.0", "id": 7, "method": "query", "params": {"args": ["-skip", "./testdata/fix", "-x", "foo"]}}`,
+		`{"jsonrpc": "2.0", "id": 8, "method": "query", "params": {"args": ["-snap", "stmt", "-x", "len(a)"]}}`,
+		`not json`,
+	}
+	var buf bytes.Buffer
+	m := matcher{
+		ctx: &build.Default, out: &buf, errOut: ioutil.Discard,
+		in: strings.NewReader(strings.Join(requests, "\n")),
+	}
+	if err := m.fromArgs([]string{"serve"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"no packages loaded; use loadTargets first"}}`,
+		`{"jsonrpc":"2.0","id":2,"result":{"packages":["./testdata/fix"]}}`,
+		`{"jsonrpc":"2.0","id":3,"result":{"edits":[` +
+			`{"file":"testdata/fix/fix.go","offset":142,"end":153,"line":10,"column":9,"end_line":10,"end_column":20,"new_text":"a == nil"},` +
+			`{"file":"testdata/fix/fix.go","offset":157,"end":168,"line":10,"column":24,"end_line":10,"end_column":35,"new_text":"b == nil"}]}}`,
+		`{"jsonrpc":"2.0","id":4,"result":{"matches":[` +
+			`{"file":"testdata/fix/fix.go","line":10,"column":13,"end_line":10,"end_column":14,"text":"a"},` +
+			`{"file":"testdata/fix/fix.go","line":10,"column":28,"end_line":10,"end_column":29,"text":"b"}]}}`,
+		`{"jsonrpc":"2.0","id":5,"error":{"code":-32000,"message":"flag provided but not defined: -bad"}}`,
+		`{"jsonrpc":"2.0","id":6,"error":{"code":-32601,"message":"unknown method \"other\""}}`,
+		`{"jsonrpc":"2.0","id":7,"error":{"code":-32000,"message":"cannot use -r, -import, -shard, -skip, or batching options like -max-time, as the packages are loaded by loadTargets"}}`,
+		`{"jsonrpc":"2.0","id":8,"result":{"matches":[` +
+			`{"file":"testdata/fix/fix.go","line":10,"column":2,"end_line":10,"end_column":35,"text":"return len(a) == 0 || len(b) == 0"}]}}`,
+		`{"jsonrpc":"2.0","id":null,"error":{"code":-32700,"message":"invalid character 'o' in literal null (expecting 'u')"}}`,
+	}
+	if got := strings.Split(strings.TrimSpace(buf.String()), "\n"); !reflect.DeepEqual(got, want) {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+	rec := httptest.NewRecorder()
+	m.metrics.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	wantMetrics := []string{
+		`gogrep_requests_total{method="loadTargets",outcome="ok"} 1`,
+		`gogrep_requests_total{method="query",outcome="error"} 3`,
+		`gogrep_requests_total{method="query",outcome="ok"} 3`,
+		`gogrep_requests_total{method="rewritePreview",outcome="ok"} 1`,
+		`gogrep_requests_total{method="unknown",outcome="error"} 2`,
+		`gogrep_packages_loaded 1`,
+		`gogrep_cache_hits_total 4`,
+		`gogrep_cache_misses_total 2`,
+		`gogrep_match_duration_seconds_bucket{le="+Inf"} 4`,
+		`gogrep_match_duration_seconds_count 4`,
+	}
+	var gotMetrics []string
+	for _, line := range strings.Split(rec.Body.String(), "\n") {
+		if strings.HasPrefix(line, "gogrep_") && !strings.Contains(line, "seconds_sum") &&
+			(!strings.Contains(line, "_bucket") || strings.Contains(line, "+Inf")) {
+			gotMetrics = append(gotMetrics, line)
+		}
+	}
+	if !reflect.DeepEqual(gotMetrics, wantMetrics) {
+		t.Fatalf("wanted metrics:\n%s\ngot:\n%s", strings.Join(wantMetrics, "\n"),
+			strings.Join(gotMetrics, "\n"))
+	}
+}
+
+func TestGenAnalyzer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "checks")
+	m := matcher{ctx: &build.Default, out: ioutil.Discard, errOut: ioutil.Discard}
+	args := []string{"gen-analyzer", "-golangci", "testdata/genanalyzer/rules.json", "-o", dir}
+	if err := m.fromArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "analyzer.go")
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.Name != "checks" {
+		t.Fatalf("wanted package checks, got %s", f.Name.Name)
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`name:    "error-string"`,
+		`match:   mustParse("$e.Error()")`,
+		`subst:   "$e"`,
+		`{mustParse("err"), false}`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("wanted generated source to contain %q", want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "plugin.go")); err != nil {
+		t.Errorf("wanted -golangci to generate a plugin: %v", err)
+	}
+}
+
+func TestGenAnalyzerBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go build of the generated analyzer in short mode")
+	}
+	mod := t.TempDir()
+	m := matcher{ctx: &build.Default, out: ioutil.Discard, errOut: ioutil.Discard}
+	args := []string{"gen-analyzer", "-golangci", "testdata/genanalyzer/rules.json", "-o", filepath.Join(mod, "checks")}
+	if err := m.fromArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"go.mod": "module example.com/checks\n\ngo 1.21\n",
+		"main.go": `package main
+
+import (
+	_ "example.com/checks/checks"
+	"github.com/golangci/plugin-module-register/register"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+// main runs the analyzer as golangci-lint would find it, via the plugin.
+func main() {
+	newPlugin, err := register.GetPlugin("checks")
+	if err != nil {
+		panic(err)
+	}
+	plugin, err := newPlugin(nil)
+	if err != nil {
+		panic(err)
+	}
+	analyzers, err := plugin.BuildAnalyzers()
+	if err != nil {
+		panic(err)
+	}
+	singlechecker.Main(analyzers[0])
+}
+`,
+		"target/target.go": `package target
+
+import "errors"
+
+func f(p *int) string {
+	err := errors.New("x")
+	if err == nil || p == nil {
+		return ""
+	}
+	return err.Error()
+}
+`,
+	}
+	for name, src := range files {
+		path := filepath.Join(mod, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(src), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	goCmd := func(modFlag string, args ...string) ([]byte, error) {
+		cmd := exec.Command("go", args...)
+		cmd.Dir = mod
+		cmd.Env = append(os.Environ(), "GOFLAGS="+modFlag)
+		return cmd.CombinedOutput()
+	}
+	// the x/tools in our go.mod is too old to have go/analysis, so fetch
+	// fixed versions of the packages the generated code imports, along
+	// with all of their dependencies; this needs the network or a module
+	// cache which already has them
+	if out, err := goCmd("-mod=mod", "get",
+		"golang.org/x/tools/go/analysis/singlechecker@v0.47.0",
+		"github.com/golangci/plugin-module-register/register@v0.1.2",
+	); err != nil {
+		t.Skipf("could not get the analyzer's dependencies: %v: %s", err, out)
+	}
+	if out, err := goCmd("-mod=readonly", "vet", "./..."); err != nil {
+		t.Fatalf("go vet of the generated analyzer: %v: %s", err, out)
+	}
+	if out, err := goCmd("-mod=readonly", "build", "-o", "checks.bin", "."); err != nil {
+		t.Fatalf("go build of the generated analyzer: %v: %s", err, out)
+	}
+	cmd := exec.Command(filepath.Join(mod, "checks.bin"), "./target")
+	cmd.Dir = mod
+	out, _ := cmd.CombinedOutput()
+	want := `
+		target/target.go:7:19: p == nil
+		target/target.go:10:9: needless err.Error() of error
+	`
+	want = strings.TrimSpace(strings.Replace(want, "\t", "", -1))
+	got := strings.TrimSpace(strings.Replace(string(out), mod+string(filepath.Separator), "", -1))
+	if want != got {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-x", "$_.HandleFunc($path, $h)", "-ctags", "$path", "./testdata/ctags"},
+			"!_TAG_FILE_FORMAT\t2\t/extended format/\n" +
+				"!_TAG_FILE_SORTED\t1\t/0=unsorted, 1=sorted, 2=foldcase/\n" +
+				"/admin\ttestdata/ctags/handlers.go\t7\n" +
+				"/users\ttestdata/ctags/handlers.go\t6\n" +
+				"/users\ttestdata/ctags/handlers.go\t8\n",
+		},
+		{
+			[]string{"-x", "$_.HandleFunc($path, $h)", "-etags", "h", "./testdata/ctags"},
+			"\x0c\ntestdata/ctags/handlers.go,131\n" +
+				"\tmux.HandleFunc(\"/users\", users\x7fusers\x016,73\n" +
+				"\tmux.HandleFunc(\"/admin\", admin\x7fadmin\x017,106\n" +
+				"\tmux.HandleFunc(\"/users\", users\x7fusers\x018,139\n",
+		},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+		if err := m.fromArgs(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("wanted:\n%q\ngot:\n%q", tc.want, got)
+		}
+	}
+}
+
+func TestGroup(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-x", "log.Printf", "-group", "func", "./testdata/group"},
+			"testdata/group/handlers.go:5:1: func (Server).handle: 1 match\n" +
+				"\ttestdata/group/handlers.go:6:2: log.Printf\n" +
+				"testdata/group/server.go:9:1: func (*Server).Start: 2 matches\n" +
+				"\ttestdata/group/server.go:10:2: log.Printf\n" +
+				"\ttestdata/group/server.go:11:2: log.Printf\n" +
+				"testdata/group/server.go:14:1: func (*Server).Stop: 1 match\n" +
+				"\ttestdata/group/server.go:15:2: log.Printf\n" +
+				"testdata/group/server.go:18:1: func main: 1 match\n" +
+				"\ttestdata/group/server.go:19:2: log.Printf\n" +
+				"outside of funcs and types: 1 match\n" +
+				"\ttestdata/group/server.go:22:9: log.Printf\n",
+		},
+		{
+			[]string{"-x", "log.Printf", "-group", "type", "./testdata/group"},
+			"testdata/group/server.go:5:6: type Server: 4 matches\n" +
+				"\ttestdata/group/handlers.go:6:2: log.Printf\n" +
+				"\ttestdata/group/server.go:10:2: log.Printf\n" +
+				"\ttestdata/group/server.go:11:2: log.Printf\n" +
+				"\ttestdata/group/server.go:15:2: log.Printf\n" +
+				"testdata/group/server.go:18:1: func main: 1 match\n" +
+				"\ttestdata/group/server.go:19:2: log.Printf\n" +
+				"outside of funcs and types: 1 match\n" +
+				"\ttestdata/group/server.go:22:9: log.Printf\n",
+		},
+		{
+			[]string{"-x", "log.Printf($s)", "-group", "type", "-norm", "./testdata/group"},
+			"type Server: 4 matches\n" +
+				"\tlog.Printf(\"handling\")\n" +
+				"\tlog.Printf(\"starting\")\n" +
+				"\tlog.Printf(\"started\")\n" +
+				"\tlog.Printf(\"stopping\")\n" +
+				"func main: 1 match\n" +
+				"\tlog.Printf(\"main\")\n",
+		},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+		if err := m.fromArgs(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("wanted:\n%q\ngot:\n%q", tc.want, got)
+		}
+	}
+}
+
+func TestWholeProgram(t *testing.T) {
+	gopath, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GO111MODULE", "off")
+	ctx := build.Default
+	ctx.GOPATH = gopath
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-x", "Leaf", "-refs", "xpkg/a", "xpkg/b"},
+			"testdata/src/xpkg/a/a.go:5:17: Leaf\n" +
+				"testdata/src/xpkg/b/b.go:5:19: Leaf\n",
+		},
+		{
+			[]string{"-j", "1", "-x", "Leaf", "-refs", "xpkg/a", "xpkg/b"},
+			"testdata/src/xpkg/a/a.go:5:17: Leaf\n" +
+				"testdata/src/xpkg/b/b.go:5:19: Leaf\n",
+		},
+		{
+			[]string{"-x", "Leaf", "-def", "xpkg/a", "xpkg/b"},
+			"testdata/src/xpkg/a/a.go:3:1: func Leaf() { }\n",
+		},
+		{
+			[]string{"-x", "Grower", "-impls", "xpkg/a", "xpkg/b"},
+			"testdata/src/xpkg/b/b.go:7:1: type Tree struct{}\n",
+		},
+		{
+			[]string{"-x", "Grower", "-impls", "-uses", "xpkg/a", "xpkg/b"},
+			"testdata/src/xpkg/b/b.go:7:1: type Tree struct{} // uses: 2, files: 1, packages: 1\n",
+		},
+		{
+			[]string{"-x", "func $_() { $*_ }", "-reach", "func Caller() { $*_ }", "xpkg/a", "xpkg/b"},
+			"testdata/src/xpkg/a/a.go:3:1: func Leaf() { }\n" +
+				"testdata/src/xpkg/b/b.go:5:1: func Caller() { a.Leaf(); }\n",
+		},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		m := matcher{ctx: &ctx, out: &buf, errOut: ioutil.Discard}
+		if err := m.fromArgs(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		if got := buf.String(); got != tc.want {
+			t.Errorf("%v: wanted:\n%s\ngot:\n%s", tc.args, tc.want, got)
+		}
+	}
+}
+
+func TestShardMerge(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		var buf bytes.Buffer
+		m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+		args = append(args, "-rules", "testdata/fix/rules.json",
+			"./testdata/fix", "./testdata/linedir", "./testdata/ctags")
+		if err := m.fromArgs(args); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	for _, format := range []string{"-sarif", "-rdjson", "-rdjsonl"} {
+		var paths []string
+		for i := 1; i <= 3; i++ {
+			path := filepath.Join(dir, fmt.Sprintf("shard%d%s", i, format))
+			out := run(format, "-shard", fmt.Sprintf("%d/3", i))
+			if err := ioutil.WriteFile(path, []byte(out), 0o666); err != nil {
+				t.Fatal(err)
+			}
+			paths = append(paths, path)
+		}
+		var buf bytes.Buffer
+		m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+		// a shard given twice is merged once
+		if err := m.fromArgs(append([]string{"merge", paths[0]}, paths...)); err != nil {
+			t.Fatal(err)
+		}
+		if want, got := run(format), buf.String(); want != got {
+			t.Fatalf("%s: wanted:\n%s\ngot:\n%s", format, want, got)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	query := []string{"-checkpoint", path, "-x", "len($s) == 0", "-or", "foo($_)",
+		"./testdata/fix", "./testdata/linedir"}
+	run := func(args ...string) string {
+		var buf bytes.Buffer
+		m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+		if err := m.fromArgs(args); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("wanted the checkpoint to be removed, got %v", err)
+		}
+		return buf.String()
+	}
+	want := `testdata/fix/fix.go:10:9: len(a) == 0
+testdata/fix/fix.go:10:24: len(b) == 0
+testdata/linedir/parser.go:7:2: foo(s)
+testdata/linedir/parser.go:12:2: foo(s)
+testdata/linedir/parser.go:17:2: foo(s)
+`
+	if got := run(query...); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+	// an interrupted run which only got through the first package
+	err := writeCheckpoint(path, checkpoint{Args: query, Done: []checkpointDone{
+		{Packages: []string{"./testdata/fix"}, Output: "done before\n"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "done before\n" + want[strings.Index(want, "testdata/linedir"):]
+	if got := run(append([]string{"-resume"}, query...)...); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	run := func(args ...string) string {
+		var buf bytes.Buffer
+		m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+		if err := m.fromArgs(append([]string{"-cache", dir}, args...)); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	query := []string{"-x", "len($s) == 0", "-a", "type(bool)", "./testdata/fix"}
+	want := `testdata/fix/fix.go:10:9: len(a) == 0
+testdata/fix/fix.go:10:24: len(b) == 0
+`
+	if got := run(query...); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+	entries, err := filepath.Glob(filepath.Join(dir, "*", "*.json"))
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("wanted one cache entry, got %v, %v", entries, err)
+	}
+	// the package isn't matched again, so a changed entry is printed
+	entry := checkpointDone{Output: "cached\n"}
+	key := strings.TrimSuffix(filepath.Base(entries[0]), ".json")
+	if err := writeCacheEntry(dir, key, entry); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(query...); got != "cached\n" {
+		t.Fatalf("wanted the cached output, got:\n%s", got)
+	}
+	// other queries aren't cached yet
+	want = "testdata/fix/fix.go:10:9: len(a) == 0\n"
+	if got := run("-x", "len(a) == 0", "./testdata/fix"); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	repo := t.TempDir()
+	src := "package lib\n\nfunc f() error {\n\treturn g()\n}\n\nfunc g() error { return nil }\n"
+	if err := ioutil.WriteFile(filepath.Join(repo, "lib.go"), []byte(src), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "lib.go"},
+		{"-c", "user.name=gogrep", "-c", "user.email=gogrep@localhost", "commit", "--quiet", "-m", "lib"},
+		{"tag", "v1.0.0"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v: %s", args[0], err, out)
+		}
+	}
+	var buf bytes.Buffer
+	m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+	if err := m.fromArgs([]string{"-x", "$f()", "-a", "type(error)", "file://" + repo + "@v1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	want := repo + "@v1.0.0/lib.go:4:9: g()\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+	}
+	if m.remotes != nil {
+		t.Fatalf("wanted the clones to be removed")
+	}
+	err := m.fromArgs([]string{"-x", "$f()", "file://" + repo + "@v2.0.0"})
+	if err == nil || !strings.Contains(err.Error(), "couldn't find remote ref v2.0.0") {
+		t.Fatalf("wanted a missing ref error, got %v", err)
+	}
+	err = m.fromArgs([]string{"-x", "$f()", "file://" + repo + "@--upload-pack=touch"})
+	if err == nil || !strings.Contains(err.Error(), "URL and ref cannot start with -") {
+		t.Fatalf("wanted an invalid ref error, got %v", err)
+	}
+}
+
+func TestRulesProfile(t *testing.T) {
+	var out, errOut bytes.Buffer
+	m := matcher{ctx: &build.Default, out: &out, errOut: &errOut}
+	args := []string{"-profile", "-rules", "testdata/rules.json", "testdata/rules.go"}
+	if err := m.fromArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(errOut.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("wanted a header and two rules, got:\n%s", errOut.String())
+	}
+	wantMatched := map[string]string{"println": "2", "nil-compare": "1"}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			t.Fatalf("unexpected profile line: %q", line)
+		}
+		name, visited, matched := fields[0], fields[2], fields[3]
+		if want := wantMatched[name]; matched != want {
+			t.Errorf("wanted %s to match %s nodes, got %s", name, want, matched)
+		}
+		if visited == "0" {
+			t.Errorf("wanted %s to visit some nodes", name)
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{
+			[]string{"-x", "foo(a, $x)", "testdata/fuzzy.go"},
+			`
+				trace: -x 'foo(a, $x)' got closest to matching:
+				  testdata/fuzzy.go:6:2: foo(a): missing $x
+				  testdata/fuzzy.go:7:2: foo(a, b, d): extra d
+				  testdata/fuzzy.go:8:2: bar(x, y): want foo, got bar; want a, got x
+			`,
+		},
+		{
+			[]string{"-x", "$f($*_)", "-a", "type(error)", "testdata/packs.go"},
+			`
+				trace: -a 'type(error)' discarded:
+				  testdata/packs.go:12:9: println(i): type is ()
+				  testdata/packs.go:20:31: err.Error(): type is string
+				  testdata/packs.go:30:3: func() { g.mu.Lock(); defer g.mu.Unlock(); }(): type is ()
+				  testdata/packs.go:31:4: g.mu.Lock(): type is ()
+				  testdata/packs.go:32:10: g.mu.Unlock(): type is ()
+			`,
+		},
+		{
+			[]string{"-x", "foo($x, 1)", "-test-src", "foo(a, 2); foo(b, 1, 3)"},
+			`
+				trace: -x 'foo($x, 1)' got closest to matching:
+				  foo(a, 2): want 1, got 2
+				  foo(b, 1, 3): extra 3
+			`,
+		},
+	}
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%02d", i), func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			m := matcher{ctx: &build.Default, out: &out, errOut: &errOut}
+			if err := m.fromArgs(append([]string{"-trace"}, tc.args...)); err != nil {
+				t.Fatal(err)
+			}
+			want := strings.TrimSpace(strings.Replace(tc.want, "\t", "", -1))
+			got := strings.TrimSpace(errOut.String())
+			if want != got {
+				t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+			}
+		})
+	}
+}
+
+func TestGenPlugin(t *testing.T) {
+	dir := t.TempDir()
+	if err := writePlugin(dir, "checks", "rules.json"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "plugin.go")
+	f, err := parser.ParseFile(token.NewFileSet(), path, nil, parser.ImportsOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name.Name != "checks" {
+		t.Fatalf("wanted package checks, got %s", f.Name.Name)
+	}
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `register.Plugin("checks", newPlugin)`; !strings.Contains(string(src), want) {
+		t.Errorf("wanted generated plugin to contain %q", want)
+	}
+}
+
+func testLoad(t *testing.T, m *matcher, args []string, want interface{}) {
+	var buf bytes.Buffer
+	m.out = &buf
+	err := m.fromArgs(args)
+	switch x := want.(type) {
+	case error:
+		if err == nil {
+			t.Fatalf("wanted error %q, got none", x)
+		}
+		want, got := x.Error(), err.Error()
+		if !strings.Contains(got, want) {
+			t.Fatalf("wanted error %q, got %q", want, got)
+		}
+	case string:
+		if err != nil {
+			t.Fatalf("didn't want error, but got %q", err)
+		}
+		want := strings.TrimSpace(strings.Replace(x, "\t", "", -1))
+		got := strings.TrimSpace(buf.String())
+		if want != got {
+			t.Fatalf("wanted:\n%s\ngot:\n%s", want, got)
+		}
+	default:
+		t.Fatalf("unknown want type %T", x)
 	}
 }
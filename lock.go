@@ -0,0 +1,111 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/ssa"
+)
+
+// unlockCheck checks whether a call to Lock or RLock is followed by a call to
+// Unlock or RUnlock on the same value on every path to a return, or by a
+// deferred one on that path.
+type unlockCheck struct{}
+
+// unlockedOnReturn reports whether a Lock or RLock call, or a statement made
+// of one, is always paired with its unlock before the enclosing func returns.
+// The paths are followed in the func's SSA control flow graph, and the
+// values are compared by their source, so that two mentions of s.mu are the
+// same mutex.
+func (m *matcher) unlockedOnReturn(node ast.Node) bool {
+	if stmt, ok := node.(*ast.ExprStmt); ok {
+		node = stmt.X
+	}
+	call, ok := node.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	var unlock string
+	switch sel.Sel.Name {
+	case "Lock":
+		unlock = "Unlock"
+	case "RLock":
+		unlock = "RUnlock"
+	default:
+		return false
+	}
+	recv := singleLinePrint(sel.X)
+	fn := m.ssaFunc(m.loader.program(), call)
+	if fn == nil || fn.Syntax() == nil {
+		return false
+	}
+	// the calls in the func by their position, to find their receivers
+	calls := make(map[token.Pos]*ast.CallExpr)
+	inspect(fn.Syntax(), func(node ast.Node) bool {
+		if call, ok := node.(*ast.CallExpr); ok {
+			calls[call.Lparen] = call
+		}
+		return true
+	})
+	isUnlock := func(common *ssa.CallCommon) bool {
+		call := calls[common.Pos()]
+		if call == nil {
+			return false
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		return ok && sel.Sel.Name == unlock && singleLinePrint(sel.X) == recv
+	}
+	var start *ssa.BasicBlock
+	startIndex := 0
+	for _, b := range fn.Blocks {
+		for i, instr := range b.Instrs {
+			if x, ok := instr.(*ssa.Call); ok && x.Pos() == call.Lparen {
+				start, startIndex = b, i+1
+			}
+		}
+	}
+	if start == nil {
+		return false
+	}
+	// walk the paths from the lock, stopping at each unlock, including
+	// deferred ones, as a defer before the lock or on another path
+	// doesn't unlock it
+	seen := make(map[*ssa.BasicBlock]bool)
+	var unlocked func(b *ssa.BasicBlock, from int) bool
+	unlocked = func(b *ssa.BasicBlock, from int) bool {
+		for _, instr := range b.Instrs[from:] {
+			switch x := instr.(type) {
+			case *ssa.Call:
+				if isUnlock(&x.Call) {
+					return true
+				}
+			case *ssa.Defer:
+				if isUnlock(&x.Call) {
+					return true
+				}
+			case *ssa.Return:
+				return false
+			case *ssa.Panic:
+				return true // the func doesn't return
+			}
+		}
+		for _, succ := range b.Succs {
+			if seen[succ] {
+				continue
+			}
+			seen[succ] = true
+			if !unlocked(succ, 0) {
+				return false
+			}
+		}
+		return true
+	}
+	return unlocked(start, startIndex)
+}
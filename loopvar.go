@@ -0,0 +1,135 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// loopVarCheck checks whether a func literal run by a go or defer statement
+// captures a variable declared by an enclosing loop, which is shared by all
+// the iterations before Go 1.22.
+type loopVarCheck struct{}
+
+// loopVarCaptured reports whether a func literal, or a go or defer statement
+// calling one, captures a loop variable, noting which one. Since Go 1.22,
+// each iteration has its own variables, so files in modules requiring Go
+// 1.22 or later are never affected.
+func (m *matcher) loopVarCaptured(node ast.Node) bool {
+	var lit *ast.FuncLit
+	switch x := node.(type) {
+	case *ast.FuncLit:
+		lit = x
+	case *ast.GoStmt:
+		lit, _ = x.Call.Fun.(*ast.FuncLit)
+	case *ast.DeferStmt:
+		lit, _ = x.Call.Fun.(*ast.FuncLit)
+	}
+	if lit == nil {
+		return false
+	}
+	var stmt ast.Node
+	for p := m.parentOf(lit); p != nil && stmt == nil; p = m.parentOf(p) {
+		switch p.(type) {
+		case *ast.GoStmt, *ast.DeferStmt:
+			stmt = p
+		case *ast.FuncDecl:
+			return false
+		}
+	}
+	if stmt == nil {
+		return false
+	}
+	loopVars := make(map[types.Object]bool)
+	addVars := func(exprs ...ast.Expr) {
+		for _, expr := range exprs {
+			if id, ok := expr.(*ast.Ident); ok && m.Info.Defs[id] != nil {
+				loopVars[m.Info.Defs[id]] = true
+			}
+		}
+	}
+	for p := m.parentOf(stmt); p != nil; p = m.parentOf(p) {
+		switch x := p.(type) {
+		case *ast.RangeStmt:
+			if x.Tok == token.DEFINE {
+				addVars(x.Key, x.Value)
+			}
+		case *ast.ForStmt:
+			if init, ok := x.Init.(*ast.AssignStmt); ok && init.Tok == token.DEFINE {
+				addVars(init.Lhs...)
+			}
+		}
+	}
+	if len(loopVars) == 0 || m.perIterationLoops(lit.Pos()) {
+		return false
+	}
+	var captured *ast.Ident
+	inspect(lit.Body, func(node ast.Node) bool {
+		if id, ok := node.(*ast.Ident); ok && captured == nil && loopVars[m.Info.Uses[id]] {
+			captured = id
+		}
+		return captured == nil
+	})
+	if captured == nil {
+		return false
+	}
+	m.notes[posHash(node)] = "loopvar: captures " + captured.Name
+	return true
+}
+
+// perIterationLoops reports whether the file at a position is in a module
+// requiring Go 1.22 or later, where each loop iteration has its own
+// variables. Files outside of modules, such as snippets, use the old
+// semantics.
+func (m *matcher) perIterationLoops(pos token.Pos) bool {
+	name := m.loader.fset.PositionFor(pos, false).Filename
+	if name == "" {
+		return false
+	}
+	dir, err := filepath.Abs(filepath.Dir(name))
+	if err != nil {
+		return false
+	}
+	for {
+		if minor, ok := goModMinor(filepath.Join(dir, "go.mod")); ok {
+			return minor >= 22
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// goModMinor returns the minor Go version in a go.mod file's go directive,
+// such as 21 for "go 1.21.3".
+func goModMinor(path string) (int, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "go" {
+			continue
+		}
+		parts := strings.Split(fields[1], ".")
+		if len(parts) < 2 || parts[0] != "1" {
+			break
+		}
+		minor, err := strconv.Atoi(parts[1])
+		return minor, err == nil
+	}
+	return 16, true // a module without a go directive is Go 1.16
+}
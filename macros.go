@@ -0,0 +1,62 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// rxMacro matches the uses of macros in the pipelines of rules, like
+// "${call}". As "$" must be followed by a name or "*" in patterns, they can't
+// be mistaken for wildcards.
+var rxMacro = regexp.MustCompile(`\$\{(\w+)\}`)
+
+// ruleMacros are the pattern fragments defined in a rules file, by name, so
+// that many rules can share them instead of each having its own copy. Their
+// values may use other macros too.
+type ruleMacros map[string]string
+
+// expand returns s with the macros used in it replaced by their values.
+// Unknown macros are left as is, as "${x}" could be part of a string literal
+// in a pattern.
+func (ms ruleMacros) expand(s string) (string, error) {
+	return ms.expandWithin(s, nil)
+}
+
+// expandWithin is like expand, where stack holds the macros whose values are
+// being expanded, to catch those which use themselves.
+func (ms ruleMacros) expandWithin(s string, stack []string) (string, error) {
+	var err error
+	expanded := rxMacro.ReplaceAllStringFunc(s, func(use string) string {
+		name := use[2 : len(use)-1]
+		value, ok := ms[name]
+		if !ok || err != nil {
+			return use
+		}
+		for i, outer := range stack {
+			if outer == name {
+				err = fmt.Errorf("macro %q uses itself via %s", name,
+					strings.Join(append(stack[i:], name), " -> "))
+				return use
+			}
+		}
+		value, err = ms.expandWithin(value, append(stack, name))
+		return value
+	})
+	return expanded, err
+}
+
+// expandRule replaces the macros in a rule's pipeline.
+func (ms ruleMacros) expandRule(r *rule) error {
+	for i, arg := range r.Pipeline {
+		expanded, err := ms.expand(arg)
+		if err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+		r.Pipeline[i] = expanded
+	}
+	return nil
+}
@@ -13,28 +13,159 @@ import (
 	"go/token"
 	"go/types"
 	"io"
+	"io/ioutil"
 	"os"
+	"reflect"
 	"regexp"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 )
 
 var usage = func() {
-	fmt.Fprint(os.Stderr, `usage: gogrep commands [packages]
+	fmt.Fprint(os.Stderr, `usage: gogrep [search] commands [packages]
+       gogrep rewrite commands [packages]
+       gogrep apply plan
+       gogrep rules list [-json] [flags]
+       gogrep rules test dir
+       gogrep run alias [packages]
+       gogrep diff REV1 REV2 commands [packages]
+       gogrep merge files...
+       gogrep migrate [-from rev] migrations [packages]
+       gogrep fix [-edit] file:line:col [flags] [packages]
+       gogrep serve [-metrics addr]
+       gogrep gen-analyzer [-golangci] rules -o dir
+       gogrep completion bash|zsh|fish
 
-gogrep performs a query on the given Go packages.
+gogrep performs a query on the given Go packages. All subcommands accept the
+flags below, and those loading packages accept the commands too.
 
-  -r   match all dependencies recursively too
+  -r            match all dependencies recursively too
+  -nolint name  skip rule matches with comments like //name or //name:rule,
+                and other matches with //name
+  -ignores      list the //gogrep:ignore directives instead of matching
+  -plan file    record the edits that -w would make in a file, to be made
+                later by "gogrep apply", instead of writing them
+  -checkpoint file
+                match one package at a time, recording those done and their
+                output in a file, which is removed once all are done
+  -resume       skip the packages done in the -checkpoint file by an
+                interrupted run of the same query, printing their output
+  -max-mem size match the packages in batches, such that those loaded use up
+                to size memory, like 512MiB or 2G, freeing each batch once
+                its matches are printed; as freed packages aren't parsed
+                again, queries needing all of them at once aren't supported
+  -cache dir    cache the output of each package in a directory, keyed by a
+                hash of the query and the package's source, printing it
+                again without loading the package when neither changed
+  -max-time d   match one package at a time, stopping after a duration such
+                as "10m" and reporting the results as partial; packages are
+                matched in the order of the config file's priority list
+  -estimate     print how many packages, files, and bytes would be loaded,
+                and whether type-checking is needed, instead of matching
+  -norm         print matches on normalized lines, without positions
+  -linedirs     also print the original position that //line directives map
+                each match to, such as in a yacc grammar
+  -interproc    follow tainted values into the funcs they're passed to
+  -clones n     report groups of equal code of at least n nodes, ignoring
+                names and values, instead of matching
+  -unsafe       report the unsafe.Pointer and reflect header conversions,
+                grouped by func with its receiver and callers, instead of
+                matching
+  -fuzzy n      also report near-matches within n differing, missing, or
+                extra nodes, noting what differed
+  -verbose      print extra details, such as where -uses found each use
+  -rules files  run the rules in comma-separated JSON or Go files, along with
+                any commands
+  -pack names   run the built-in rule packs, such as "errors,concurrency"
+  -sarif        print the rule matches as a SARIF log
+  -rdjson       print the rule matches in reviewdog's diagnostic format, with
+                the substitutions of -s as suggested fixes
+  -rdjsonl      like -rdjson, but with one diagnostic per line
+  -profile      print how long each rule took, and how many nodes it visited
+                and matched, to standard error
+  -explain      print how each command was parsed, such as a pattern's syntax
+                tree and wildcards, instead of matching
+  -test-src src match the Go source snippet, or standard input if "-", instead
+                of packages, printing each match and its captures
+  -ctags $name  print a ctags file instead of the matches, with a tag for each
+                match named after the value of the wildcard, or the contents
+                if it's a string literal
+  -etags $name  like -ctags, but print an etags file for Emacs
+  -count-by $name
+                print how many matches there are for each value of the
+                wildcard instead of the matches, most common first
+  -trace        print the nodes which came closest to matching each pattern,
+                and the first ones discarded by each filter, to standard error
+  -j n          match up to n packages at once; defaults to the number of CPUs
+  -sort         sort the matches by file and position; on by default, and
+                disabled via -sort=false
+  -only-in ctx  only report matches within a context, which is one of "tests",
+                "test files", "func init()", "func main()", and "package main";
+                it can be repeated or comma-separated to allow any of many
+                contexts
+  -not-in ctx   skip the matches within a context, like -only-in
+  -import path  make a package available to type constraints as its name, or
+                as alias if given as path=alias; it can be repeated
+  -shard i/n    only match the packages in shard i of n, from 1/n to n/n,
+                as split by a hash of their paths
+  -skip pkgs    skip the packages matching comma-separated import paths or
+                directories like "./gen/...", where "..." matches any string,
+                as well as those in the config file's skip list
+  -package-timeout d
+                skip and report the packages which take longer than a
+                duration such as "30s" to match, or which panic
+  -outermost    only keep the matches of -x and -or which aren't within
+                another match, such as the outer call in f(f(x))
+  -innermost    only keep the matches of -x and -or which don't contain another
+                match, such as the inner call in f(f(x))
+  -all          keep all nested matches; the default
+  -snap unit    expand each match to its enclosing statement if unit is
+                "stmt", or to the outermost node on the same lines if unit is
+                "line", before any -s and before printing
+  -group unit   print the matches nested under their enclosing func or type
+                declarations, with how many there are in each; methods are
+                nested under their types if unit is "type" instead of "func"
+  -strict       stop at the first package or file that fails to load or match,
+                instead of skipping it
+  -exec cmd ;   run a command for each match instead of printing it, where {}
+                is replaced by the match's position, and {file}, {line},
+                {endline}, {col}, {src}, and {$name} by its parts
+
+Matches within code annotated with a //gogrep:ignore comment are skipped. The
+comment can be followed by a name, to only skip matches for a rule, and by a
+reason. If the name is "*" or missing, it applies to all matches. The comment
+applies to the rest of its line, to the declaration or statement that follows
+it, or to the entire file if placed before the package clause.
 
 A command is one of the following:
 
+  -f file       read commands from a file, with one per line and patterns
+                spanning multiple indented lines
   -x pattern    find all nodes matching a pattern
+  -or pattern   also find the nodes matching a pattern, like the preceding -x
   -g pattern    discard nodes not matching a pattern
   -v pattern    discard nodes matching a pattern
   -a attribute  discard nodes without an attribute
   -s pattern    substitute with a given syntax tree
   -p number     navigate up a number of node parents
+  -file         replace the nodes with the files containing them
+  -pkg          replace the nodes with the package containing them
+  -comment rx   find all comments matching a regular expression
+  -refs         find all references to the matched objects
+  -def          find the declarations of the matched objects
+  -uses         count the uses of the matched objects across files and
+                packages
+  -impls        find the implementations of the matched interfaces
+  -taint sink   find the nodes matching a pattern that use matched values
+  -concat sink  like -taint, but only if the values are concatenated or
+                formatted into strings on the way, such as to build a query
+  -reach root   discard nodes not reachable from funcs matching a pattern
+  -callers[=n]  find the funcs calling the matched funcs, up to depth n
+  -callees[=n]  find the funcs called by the matched funcs, up to depth n
   -w            write the entire source code back
 
 A pattern is a piece of Go code which may include dollar expressions. It can be
@@ -50,34 +181,633 @@ If '*' is before the name, it will match any number of nodes. Example:
 
        -x 'fmt.Fprintf(os.Stdout, $*_)' # all Fprintfs on stdout
 
+As the last argument of a call, it also matches the slice spread by a variadic
+call like f(xs...), and keeps spreading it when substituted via -s. To have a
+call pattern match regardless of whether the calls spread a slice, begin it
+with "~", which also matches other equivalent code. Example:
+
+       -x '~ append($x, $y)' # appends of a single element or a whole slice
+
+With "~", a func value also matches the func literals which only call it with
+their parameters in order, like func(x T) R { return f(x) }, and vice versa.
+Example:
+
+       -x '~ http.HandleFunc($_, handle)' # handle, directly or wrapped
+
+In place of a selector, it matches any number of selectors and method calls in
+a chain, such as those of a builder. Example:
+
+       -x '$x.$*_.Close()' # Close at the end of any chain of calls
+
+At the start of a chain, it matches the chain's base too, so that selector
+paths of any depth can be matched, such as both config.Port and
+config.Server.HTTP.Port. Example:
+
+       -x '$*path.Port' -s '$*path.Addr' # rename a field at any depth
+
+In place of an operand, it matches any number of operands in a chain of the
+same binary operator, such as a + b + c. Only whole chains match, and operands
+in parentheses are kept as one. Example:
+
+       -x '$*_ + secret + $*_' # concatenations using secret anywhere
+
+Right after or before an "else", it matches any number of arms in an
+if/else-if/else chain, where each "if" and the final "else" is an arm, and
+without '*' it matches a single arm. Only whole chains match. Example:
+
+       -x '$*_ else if err != nil { $*_ } else $*_' # an arm checking err
+       -x '$_ else $_ else $_ else $*_'            # chains of 3 or more arms
+
+Called with one argument, it matches that expression wrapped in any number of
+index, star, and paren layers, like the [k], * and () around m in (*m)[k]. Only
+the outermost layer matches, and -s can wrap another expression in the same
+layers. Example:
+
+       -x '$*_(m[$_])' # indexes of m, even if indexed or dereferenced further
+
+The name can also be a number, such as $1 and $2, for quick queries where
+naming each capture isn't worth it. They work like any other name, including
+in -s and in the {$name} of -exec. Example:
+
+       -x '$1 + $2' -s '$2 + $1' # swap the operands of additions
+
+Each command works on the results of the previous one, so a second -x searches
+within the matches of the first. To find the matches of any of many patterns in
+a single pass, use -or:
+
+       -x 'fmt.Print($*_)' -or 'fmt.Println($*_)' # matches are sorted and unique
+
+The first command works on each of the files, so -g, -v, and -a can discard
+entire files, and -pkg can turn them into packages. The count attribute compares
+the number of matches of a pattern within each node. Example:
+
+       -a 'count(func init() { $*_ }) > 1' # files with many init funcs
+
+The depth attribute compares how deeply a match is nested, counting the
+enclosing nodes of a kind: if, loop, switch, block for any of those three, or
+func. All but func stop counting at the enclosing function. Example:
+
+       -x 'return $*_' -a 'depth(loop) > 1' # returns within nested loops
+
+The rx attribute matches names against a regular expression, which must match
+the entire name, so ".*" is needed to match within names. Unicode classes like
+\pL and \p{Lu} match any letter and any upper case letter, unlike \w and
+[[:upper:]], which only match ASCII. Example:
+
+       -x 'var $x $_' -x '$x' -a '!rx("[\\x00-\\x7f]*")' # non-ASCII var names
+
+The entropy attribute compares the Shannon entropy of a string literal, in bits
+per character, where random tokens tend to be above 4. The base64 attribute
+keeps strings which look like base64 or hex encoded tokens. Along with the
+context of a pattern, they help find hardcoded secrets. Example:
+
+       -x 'Password: $x' -x '$x' -a 'entropy > 3.5' # hardcoded passwords
+
+The badformat attribute keeps calls to printf-like funcs, whose last parameters
+are a format string and a ...interface{}, where the format's verbs don't agree
+with the number or types of the arguments. The pattern picks which funcs to
+check, such as a project's own logging wrappers. What is wrong gets noted after
+each match. Example:
+
+       -x '$_.Debugf($*_)' -a 'badformat' # bad formats in logging
+
+The obj attribute keeps the nodes referring to an object from any of the given
+packages, or to a specific object given as "path.Name", regardless of the name
+the package is imported as. Example:
+
+       -x '$_.$_' -a 'obj("crypto/md5", "crypto/sha1")' # weak hashes
+
+The loopvar attribute keeps the func literals run by go or defer statements,
+or the statements themselves, which capture a variable declared by an
+enclosing for loop. Before Go 1.22, all iterations share the same variables,
+so files in modules requiring Go 1.22 or later are never matched. Example:
+
+       -x 'go $f()' -a 'loopvar' # goroutines capturing loop variables
+
+The dropsctx attribute keeps the calls passing context.Background() or
+context.TODO() while a context.Context parameter of an enclosing func is in
+scope, which should likely be passed instead. Example:
+
+       -x '$_($*_)' -a 'dropsctx' # calls dropping the ctx parameter
+
+The unlocked attribute keeps the calls to Lock or RLock which are followed by
+Unlock or RUnlock on the same value on every path to a return, or by a
+deferred one on that path. Example:
+
+       -x '$mu.Lock()' -a '!unlocked' # locks which may be left held
+
+The constarg attribute keeps the parameters of declared funcs which are passed
+a constant at every call site in the loaded packages, or a specific constant if
+given one, such as 0 or nil. Funcs which are never called, or which are used as
+values, are skipped. Example:
+
+       -x 'timeout' -a 'constarg(0)' # timeouts which are always zero
+
+The sizeof and alignof attributes compare the size or the alignment in bytes
+of a node's type, as laid out by the gc compiler for the target GOARCH. They
+apply to values, type expressions, and type declarations. Example:
+
+       -x '$_.Put($x)' -x '$x' -a 'sizeof > 64' # large values in a sync.Pool
+
+An attribute can be negated with "!", keeping the nodes without it instead.
+The doc attribute keeps the declarations with a doc comment, or with one
+matching a regular expression if given as doc(rx), and docname keeps those
+whose doc comment starts with the declared name, optionally after "A", "An",
+or "The". Example:
+
+       -x 'func $_($*_) $*_ { $*_ }' -a '!docname' # funcs not documented by name
+
+The deprecated attribute keeps the nodes which declare or refer to an object
+whose doc comment has a paragraph starting with "Deprecated: ". Example:
+
+       -x '$f($*_)' -a 'deprecated' # calls to deprecated funcs
+
+The directive attribute keeps the nodes with a directive such as
+//go:noinline in their doc comment, or anywhere within them for files,
+matching a regular expression. Example:
+
+       -x 'func $_($*_) $*_' -a 'directive("^go:linkname")' # linknamed funcs
+
+The build attribute keeps the nodes in files with build constraints. Given
+comma-separated tags, as in build("linux,amd64"), it instead keeps the nodes
+in files which would be built with those tags set, including files without
+any constraints. Example:
+
+       -x 'package $_' -a '!build("windows")' # files not built on windows
+
+The comment attribute keeps the nodes with a comment matching a regular
+expression within a number of lines of them, zero if not given as in
+comment(rx, n), or attached to their statement. Example:
+
+       -x 'time.Sleep($_)' -a '!comment(".", 1)' # sleeps with no comment nearby
+
+The recv attribute keeps the nodes within a method whose receiver type matches
+a pattern, which may capture wildcards. Example:
+
+       -x '$_.mu' -a '!recv(*Server)' # s.mu outside of *Server methods
+
+A pattern with only a package clause matches each file in the package, whose
+name can be checked with the rx attribute, or with dirname to check that it
+matches the directory's name. Example:
+
+       -x 'package $_' -a '!dirname' # packages not named after their dirs
+
+A pattern of statements matches any of the consecutive statements in a block.
+To only match those at the start or the end of a block, begin the pattern with
+a "^" statement, or end it with a "$" statement. The anchors only apply to the
+pattern's own statements, so they have no effect inside its nested blocks.
+Example:
+
+       -x '^; $x.Lock(); defer $x.Unlock()' # blocks starting with a lock
+
+Labels may be dollar expressions too, and $*_ matches any label or none. Since
+"$l: $x" alone is a key-value pair, end a labeled statement with ";". The
+targeted attribute checks that a label is used by a break, continue, or goto.
+Example:
+
+       -x '$l: $_;' -a '!targeted' # labels that are never used
+
+A single expression on the right of a multi-value assignment is either a call
+or a comma-ok form, such as a map index, a receive, or a type assertion. The
+type of a comma-ok form is that of its first value. Example:
+
+       -x '$_, $_ = $x' -x '$x' -a 'type(int)' # two-value forms of ints
+
+Fields in struct and interface types are a list like any other, so $*_ can
+match any number of them. A field with a type but no name is only an embedded
+field. Example:
+
+       -x 'struct{ $*_; sync.Mutex; $*_ }' # structs embedding a mutex
+
+The anon attribute only keeps struct, interface, and func types which aren't
+declared with a name, nor the signature of a func. Example:
+
+       -x 'func($_, $_, $_, $*_) $*_' -a anon # long anonymous func types
+
+Struct tags in field patterns are matched key by key, and their values may
+contain dollar expressions, including ones with a regular expression. Example:
+
+       -x '$_ $_ `+"`"+`json:"$(_ /.*_.*/)"`+"`"+`' # json keys with underscores
+
+An import pattern matches each import, even if grouped, and its path may
+contain dollar expressions in the same way. To match any name, including a
+missing one, use $*_. Example:
+
+       -x 'import $*_ "$(_ /github.com/org/internal/.*/)"' # internal deps
+
 By default, the resulting nodes will be printed one per line to standard output.
-To update the input files, use -w.
+To update the input files, use -w, or "gogrep rewrite" which implies it. Only
+the source replaced by each -s is rewritten, such as from the first to the last
+of a number of statements, and the rest of the file is kept as is.
+To review a rewrite before making it, use -plan file along with -w. The edits
+are recorded in the file, with the SHA-256 of each file's source, and the files
+are left untouched. Then, to make the edits, use:
+
+       gogrep apply file
+
+The edits are only made if none of the files changed since the plan was made.
+To run a command for each match instead, use -exec. The command also gets the
+match in the environment, as GOGREP_FILE, GOGREP_LINE, GOGREP_ENDLINE,
+GOGREP_COL, GOGREP_SRC, and GOGREP_VAR_name for each wildcard. Its arguments
+end at an argument that is ";". Example:
+
+       -x 'panic($*_)' -exec code -g {} ';' # open each panic in an editor
+
+To make the matches navigable in an editor, use -ctags or -etags with the
+wildcard whose value names each tag. Example:
+
+       -x 'http.HandleFunc($path, $_)' -ctags '$path' ./... >tags
+
+To see which values of a wildcard are the most common, use -count-by with it.
+The values are counted by their source, or by the func or package-level object
+they name if the type information is loaded, such as with a type filter.
+Example:
+
+       -x '$f(context.TODO(), $*_)' -count-by '$f' ./... # callers of TODO
+
+Packages may also be given as Bazel labels, such as //pkg/foo/... or
+//cmd/app:app. Bazel is queried for the Go libraries, binaries, and tests they
+match, and each target is loaded as a package made of its source files, as
+filtered by their build constraints and the -tags in its gc_goopts. Generated
+sources and external repositories are skipped.
+
+Packages may also be given as remote git repositories, such as
+https://github.com/org/repo@v1.2.0, with an optional branch, tag, or commit
+after the @. Each is shallow-cloned to a temporary directory, all of its
+packages are matched, and the directory is removed at the end. Its files are
+shown as part of the repository, like github.com/org/repo@v1.2.0/foo.go.
+
+When the packages are in many modules, such as in a monorepo with many go.mod
+files and no go.work file, the packages of each module are loaded on their
+own, resolving their imports from the module's root, and the results are
+gathered. Commands needing the whole program, such as -callers and -taint,
+then need a go.work file instead.
+
+Packages and files which fail to load, such as those with type errors, are
+skipped. So are the matches in a file which fails to match, such as when a type
+can't be resolved. Files with syntax errors are matched as far as they could be
+parsed, noting their matches as degraded. All of the errors are printed at the
+end, and gogrep then exits with status 1.
+
+A rules file holds named pipelines, each with a severity and a message:
+
+       {"rules": [{"name": "nil-compare", "pipeline": ["-x", "$x == nil"],
+                   "severity": "info", "message": "comparison with nil"}]}
+
+The severity is one of error, warning, and info, which make gogrep exit with
+status 3, 2, and 0 respectively when found. The rule names can be used in
+//gogrep:ignore directives and suppression comments. Messages can include the
+source of wildcard values as {{$x}}, and their types as {{type $x}}. A rule can
+link to its documentation via "docs", and be scoped to package directories via
+path globs, relative to the rules file:
+
+       "enable": ["pkg/api"], "disable": ["internal/legacy/..."]
+
+Matches within any of the contexts in "not_in", such as "test files", are
+skipped like with -not-in.
+
+Pattern fragments shared by many rules can be defined once as macros, which
+the pipelines use as ${name}. A macro's value can use other macros too:
+
+       "macros": {"call": "$f($*_)", "errCall": "$_, err := ${call}"}
+
+Rules can also be written as Go funcs, named after each rule, in a file that is
+read but never compiled:
+
+       func nilCompare(m gogrep.Matcher) {
+               m.Match("$x == nil").Exclude("err == nil").Report("nil check")
+       }
+
+The methods are Match, Or, Filter, Exclude, Attr, Suggest, and Parents, which
+add the -x, -or, -g, -v, -a, -s, and -p commands respectively, plus Severity,
+Report, and Docs. Their arguments can also be the names of string constants,
+of variables declared via ":=" in the func, or concatenations of them, which
+act as macros.
+
+A .gogrep.yaml file in the current directory or any of its parents can define
+default flags and aliases for commands, which are run via "gogrep run":
+
+       flags: [-r]
+       aliases:
+         unchecked-errors: -x '$f($*_)' -a 'type(error)'
+       rules:
+         nil-compare:
+           disable: [internal/legacy]
+
+       gogrep run unchecked-errors ./...
+
+The crypto pack reports the uses of weak primitives, such as MD5, DES, and
+math/rand for keys, found by their package paths, except in test files.
+
+The sqli pack reports the SQL queries built by concatenating or formatting
+untrusted input, such as request parameters, environment variables, and flags,
+using -concat. The config file can add patterns to its sources and sinks, such
+as a project's own wrappers running queries:
+
+       sqli:
+         sources: [$_.Param($_)]
+         sinks: [$_.MustExec($*_)]
+
+To compare the matches between two git revisions or directories, use:
+
+       gogrep diff REV1 REV2 commands [packages]
+
+Matches only found in REV1 are printed with a leading "-", and matches only
+found in REV2 with a leading "+". Matches are compared by file and source.
+
+To split a scan across machines, give each of them a -shard from 1/n to n/n,
+and print the rule matches with -sarif, -rdjson, or -rdjsonl. Then, to merge
+the results into one, removing those found by more than one shard, use:
+
+       gogrep merge files...
+
+To migrate the code after upgrading a module in go.mod, use:
+
+       gogrep migrate [-from rev] migrations [packages]
+
+The migrations file lists the rules files to apply to each module when its
+version in go.mod changes to one meeting a constraint, compared with its
+version at the git revision, which defaults to HEAD:
+
+       {"migrations": [{
+         "name": "foo-v2", "module": "example.com/foo",
+         "from": "<v2", "to": ">=v2.0.0,<v3", "rules": "foo-v2.json"
+       }]}
+
+The rules with a -s command are rewritten, and the matches of the rest are
+printed as the code left to migrate by hand.
+
+To apply the substitution of the rule match at a position, as editors do for
+a single fix, use:
+
+       gogrep fix [-edit] file:line:col [flags] [packages]
+
+The innermost match covering the position, among those of rules with a -s
+command, is substituted, and the rewritten file is printed without writing to
+it. With -edit, only the replaced range is printed as JSON, with its byte
+offsets, lines and columns, and new text.
+
+To answer many queries on the same packages without loading them each time,
+such as from an editor, use:
+
+       gogrep serve [-metrics addr]
+
+It reads JSON-RPC 2.0 requests from standard input, one per line, and writes
+one response per line. The methods are loadTargets, with params like
+{"packages": ["./..."]}, and query and rewritePreview, with params like
+{"args": ["-x", "$x.Error()", "-s", "$x"]}. The matches and edits are returned
+as JSON, and the files are never written to. With -metrics, such as
+-metrics=:9090, metrics in the Prometheus format are served over HTTP at
+/metrics: the requests by method and outcome, the packages loaded, the cache
+hits and misses, which are queries answered from the loaded packages and
+times they were loaded, and a histogram of how long matching took.
+
+To generate a Go package with an analysis.Analyzer running the rules in a
+file, so that they can be run via go vet without gogrep, use:
+
+       gogrep gen-analyzer [-golangci] rules -o dir
+
+The package is named after dir, and only depends on x/tools/go/analysis. Its
+matcher only supports a -x pattern per rule, followed by any -g and -v
+patterns, and a final -s as a suggested fix, with wildcards like $x and $_.
+
+With -golangci, the package also registers the analyzer as a golangci-lint
+module plugin named after dir, which depends on
+github.com/golangci/plugin-module-register. To build it into golangci-lint,
+list the package's module in .custom-gcl.yml and run "golangci-lint custom".
+
+To test the rules files in a directory, use:
+
+       gogrep rules test dir
+
+The rules are run on the packages in dir/testdata, where each line expected to
+match has a comment like "// want rule-name", listing a name once per match.
+
+To list the rules given by -rules and -pack, including those in the config file's
+default flags, use:
+
+       gogrep rules list [-json] [flags]
+
+To complete the subcommands and flags in a shell, including the aliases and
+packs for "gogrep run" and -pack, load the script printed by:
+
+       gogrep completion bash|zsh|fish
 `)
 }
 
 func main() {
 	m := matcher{
-		out: os.Stdout,
-		ctx: &build.Default,
+		out:    os.Stdout,
+		errOut: os.Stderr,
+		ctx:    &build.Default,
 	}
 	err := m.fromArgs(os.Args[1:])
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	os.Exit(m.exitCode)
 }
 
 type matcher struct {
 	out io.Writer
+	in  io.Reader // standard input if nil, for "gogrep serve"
 	ctx *build.Context
 
 	loader nodeLoader
+	pkgs   []loadPkg
 
 	parents map[ast.Node]ast.Node
 
+	// the files of the package being matched, for -pkg
+	pkgFiles fileList
+
 	recursive         bool
 	typed, aggressive bool
 
+	// if non-empty, the suppression comment directive to honor when
+	// reporting matches, such as "nolint"
+	nolint string
+
+	// list the //gogrep:ignore directives instead of matching
+	listIgnores bool
+
+	// print matches as normalized lines, without positions
+	normalized bool
+
+	// also print the positions that //line directives map matches to
+	lineDirs bool
+
+	// follow tainted values across function boundaries
+	interproc bool
+
+	// if positive, report clones of at least this many nodes instead of
+	// matching
+	cloneSize int
+
+	// report the unsafe conversions grouped by func instead of matching
+	unsafeReport bool
+
+	// if positive, also report near-matches within this many edits, and
+	// what differed in each of them
+	fuzzy int
+
+	// print extra details, such as where objects are used
+	verbose bool
+
+	// the directory to look for a config file from, defaulting to the
+	// current directory, and the default flags found in it
+	configDir    string
+	config       *config
+	defaultFlags []string
+	ruleScopes   map[string]ruleScope
+
+	// whether to write the substitutions back, for "gogrep rewrite"
+	rewrite bool
+
+	// if non-empty, the file to record the packages matched so far in,
+	// and whether to resume from it
+	checkpointPath string
+	resume         bool
+
+	// if positive, how much memory in bytes the loaded packages may use,
+	// matching them in batches that fit
+	maxMem uint64
+
+	// if non-empty, the directory caching the results of each package
+	cacheDir string
+
+	// if positive, how long to match packages for before stopping, with
+	// partial results
+	maxTime time.Duration
+
+	// searching is set while the matches are to be printed, and batching
+	// while each batch of packages is matched for -checkpoint, -max-mem,
+	// -cache, or -max-time
+	searching bool
+	batching  bool
+
+	// if non-empty, the file to record the edits that -w would make in,
+	// and the plan recording them while matching
+	planPath string
+	plan     *rewritePlan
+
+	// print how the commands were parsed instead of running them
+	explain bool
+
+	// print what would be loaded instead of loading and matching it
+	estimate bool
+
+	// if non-empty, the command to run for each match instead of printing
+	// it, as given via "-exec cmd args... ;"
+	execArgs []string
+
+	// if non-empty, the snippet to match instead of packages
+	testSrc string
+
+	// if non-empty, the wildcard naming the tags printed for the matches
+	// in a ctags or etags file, instead of printing the matches
+	ctags, etags string
+
+	// if non-empty, the wildcard whose values the matches are counted by,
+	// instead of printing the matches
+	countBy string
+
+	// record where the commands failed to match, printed to errOut
+	trace  bool
+	traces []*cmdTrace
+
+	// how many packages to match at once, and whether to sort the matches
+	// by file and position
+	jobs   int
+	sorted bool
+
+	// if non-empty, only report matches within these contexts, such as
+	// "tests"
+	onlyIn []string
+
+	// contexts in which matches aren't reported, such as "test files"
+	notIn []string
+
+	// the packages given to -import
+	imports []extraImport
+
+	// the shard of the packages to match, given via -shard i/n
+	shard shardSpec
+
+	// the remote repositories given as targets, cloned until the end of
+	// the run
+	remotes []remoteRepo
+
+	// the package patterns given to -skip, added to those in the config
+	// file's skip list
+	skips []string
+
+	// if non-zero, how long matching each package may take before it's
+	// skipped, and when the package being matched runs out of time
+	pkgTimeout time.Duration
+	deadline   time.Time
+
+	// if non-empty, the comma-separated files with rules to run and
+	// built-in rule packs to run, plus all of their rules
+	rulesPath string
+	packs     string
+	rules     []rule
+
+	// print the rule matches as SARIF or in reviewdog's format,
+	// collecting them until the end, or collect them to be checked by
+	// "gogrep test" or fixed by "gogrep fix"
+	sarif        bool
+	rdjson       bool
+	rdjsonl      bool
+	testingRules bool
+	fixing       bool
+	ruleMatches  []ruleMatch
+
+	// whether requests are being served by "gogrep serve", so that bad
+	// flags are returned as errors instead of exiting, the options of
+	// the packages loaded for the requests, if any, and the metrics of
+	// the requests served
+	serving bool
+	targets *loadParams
+	metrics *serveMetrics
+
+	// whether a served query is a rewritePreview, which needs a -s, and
+	// whether it had any -s, so that the packages must be loaded again
+	previewing  bool
+	substituted bool
+
+	// the exit code caused by the most severe rule match
+	exitCode int
+
+	// which of the nested matches of -x and -or to keep: "all",
+	// "outermost", or "innermost"
+	nested string
+
+	// if non-empty, what to expand the matches to before substituting
+	// or printing them: "stmt" or "line"
+	snap string
+
+	// if non-empty, what to nest the printed matches under: "func" or
+	// "type"
+	group string
+
+	// stop at the first package or file that fails to load or match,
+	// instead of skipping it and reporting all the errors at the end
+	strict  bool
+	pkgErrs []error
+
+	// if set, how long each rule took and how many nodes it visited and
+	// matched, printed to errOut at the end
+	profile  bool
+	profiles map[string]*ruleProfile
+	visited  int
+	errOut   io.Writer
+
+	// notes to print after each of the resulting nodes, such as what
+	// differed in a fuzzy match
+	notes map[nodePosHash]string
+
 	// information about variables (wildcards), by id (which is an
 	// integer starting at 0)
 	vars []varInfo
@@ -87,6 +817,13 @@ type matcher struct {
 	values map[string]ast.Node
 	scope  *types.Scope
 
+	// comment maps and ignore directives for each file, built lazily
+	commentMaps map[*ast.File]ast.CommentMap
+	ignoreDirs  map[*ast.File][]ignoreDirective
+
+	// compiled templates for struct tag values
+	tagTemplates map[string]tagTemplate
+
 	types.Info
 	stdImporter types.Importer
 }
@@ -94,6 +831,7 @@ type matcher struct {
 type varInfo struct {
 	name string
 	any  bool
+	arms bool // in place of arms of an if/else chain, as in "else $*_"
 }
 
 func (m *matcher) info(id int) varInfo {
@@ -135,109 +873,763 @@ func (o *boolCmdFlag) Set(val string) error {
 }
 func (o *boolCmdFlag) IsBoolFlag() bool { return true }
 
+// nestedFlag is one of -all, -outermost, and -innermost, which set the
+// nested matches to keep. The last one given wins.
+type nestedFlag struct {
+	nested *string
+	name   string
+}
+
+func (f *nestedFlag) String() string { return "" }
+func (f *nestedFlag) Set(val string) error {
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return err
+	}
+	switch {
+	case b:
+		*f.nested = f.name
+	case *f.nested == f.name:
+		*f.nested = "all"
+	}
+	return nil
+}
+func (f *nestedFlag) IsBoolFlag() bool { return true }
+
+// depthCmdFlag is like boolCmdFlag, but it also accepts an optional depth
+// via "-name=N".
+type depthCmdFlag struct {
+	name string
+	cmds *[]exprCmd
+}
+
+func (o *depthCmdFlag) String() string { return "" }
+func (o *depthCmdFlag) Set(val string) error {
+	if val == "true" {
+		val = ""
+	}
+	*o.cmds = append(*o.cmds, exprCmd{name: o.name, src: val})
+	return nil
+}
+func (o *depthCmdFlag) IsBoolFlag() bool { return true }
+
 func (m *matcher) fromArgs(args []string) error {
-	cmds, paths, err := m.parseCmds(args)
+	dir := m.configDir
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		dir = wd
+	}
+	cfg, err := findConfig(dir)
 	if err != nil {
 		return err
 	}
-	fset := token.NewFileSet()
-	wd, err := os.Getwd()
+	m.config = cfg
+	m.defaultFlags, m.ruleScopes = nil, nil
+	if cfg != nil {
+		m.defaultFlags, m.ruleScopes = cfg.flags, cfg.scopes
+	}
+	m.pkgErrs = nil
+	defer m.removeRemotes()
+	if err := m.subcommand(args); err != nil {
+		return err
+	}
+	if len(m.pkgErrs) > 0 {
+		return pkgErrors(m.pkgErrs)
+	}
+	return nil
+}
+
+// pkgErrors are the errors of the packages and files which were skipped, as
+// they failed to load or match.
+type pkgErrors []error
+
+func (e pkgErrors) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d packages or files failed:", len(e))
+	for _, err := range e {
+		buf.WriteString("\n\t" + err.Error())
+	}
+	return buf.String()
+}
+
+// subcommand runs the subcommand named by the first argument. If there is no
+// such subcommand, "search" is used.
+func (m *matcher) subcommand(args []string) error {
+	if len(args) == 0 {
+		return m.searchArgs(args)
+	}
+	switch args[0] {
+	case "search":
+		return m.searchArgs(args[1:])
+	case "rewrite":
+		return m.rewriteArgs(args[1:])
+	case "apply":
+		return m.applyArgs(args[1:])
+	case "rules":
+		return m.rulesArgs(args[1:])
+	case "run":
+		return m.runArgs(args[1:])
+	case "diff":
+		return m.diffArgs(args[1:])
+	case "merge":
+		return m.mergeArgs(args[1:])
+	case "migrate":
+		return m.migrateArgs(args[1:])
+	case "fix":
+		return m.fixArgs(args[1:])
+	case "serve":
+		return m.serveArgs(args[1:])
+	case "gen-analyzer":
+		return m.genArgs(args[1:])
+	case "test":
+		return m.testArgs(args[1:])
+	case "completion":
+		return m.completionArgs(args[1:])
+	}
+	return m.searchArgs(args)
+}
+
+// searchArgs implements "gogrep search", printing the resulting nodes.
+func (m *matcher) searchArgs(args []string) error {
+	m.searching = true
+	all, err := m.matchArgs(args)
+	m.searching = false
 	if err != nil {
 		return err
 	}
-	m.loader = nodeLoader{wd, m.ctx, fset}
+	if len(m.execArgs) > 0 {
+		return m.execMatches(all)
+	}
+	switch {
+	case m.ctags != "" && m.etags != "":
+		return fmt.Errorf("cannot use -ctags and -etags at once")
+	case m.ctags != "":
+		return m.printCtags(all)
+	case m.etags != "":
+		return m.printEtags(all)
+	case m.countBy != "":
+		return m.printCountBy(all)
+	}
+	if m.group != "" {
+		m.printGroups(all)
+		return nil
+	}
+	for _, sub := range all {
+		m.printMatch("", sub)
+	}
+	return nil
+}
+
+// printMatch prints a match on a single line, after a prefix such as an
+// indentation.
+func (m *matcher) printMatch(prefix string, sub submatch) {
+	n := sub.node
+	text := singleLinePrint(n)
+	if f, ok := n.(*ast.File); ok {
+		// entire files are shown by their package clause
+		text = "package " + f.Name.Name
+	}
+	if m.normalized {
+		fmt.Fprintln(m.out, prefix+normalizeLine(text)+m.note(n))
+		return
+	}
+	fmt.Fprintf(m.out, "%s%v: %s%s\n", prefix, m.position(sub.span().pos), text, m.note(n))
+}
+
+// rewriteArgs implements "gogrep rewrite", which is like searching with a
+// final -w command, so the substitutions are written back.
+func (m *matcher) rewriteArgs(args []string) error {
+	m.rewrite = true
+	defer func() { m.rewrite = false }()
+	return m.searchArgs(args)
+}
+
+// runArgs implements "gogrep run", which runs an alias from the config file.
+// The alias may start with a subcommand, such as "rewrite".
+func (m *matcher) runArgs(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: gogrep run alias [packages]")
+	}
+	if m.config == nil {
+		return fmt.Errorf("run: no %s found", configName)
+	}
+	alias, ok := m.config.aliases[args[0]]
+	if !ok {
+		return fmt.Errorf("%s: unknown alias %q", m.config.path, args[0])
+	}
+	if len(alias) > 0 && alias[0] == "run" {
+		return fmt.Errorf("%s: alias %q can't run another alias", m.config.path, args[0])
+	}
+	return m.subcommand(append(alias[:len(alias):len(alias)], args[1:]...))
+}
+
+// matchArgs loads the packages and runs the commands as given by args,
+// returning the resulting submatches. Modes which don't match, such as -ignores,
+// print their output directly.
+func (m *matcher) matchArgs(args []string) ([]submatch, error) {
+	args = append(m.defaultFlags[:len(m.defaultFlags):len(m.defaultFlags)], args...)
+	cmds, paths, err := m.parseCmds(args)
+	if err != nil {
+		return nil, err
+	}
+	if m.serving {
+		if err := m.checkServeCmds(cmds, paths); err != nil {
+			return nil, err
+		}
+	}
+	if m.rewrite {
+		if cmds, err = rewriteCmds(cmds); err != nil {
+			return nil, err
+		}
+	}
+	if m.explain {
+		m.explainCmds(cmds)
+		return nil, nil
+	}
+	m.traces = nil
+	if m.trace {
+		defer m.printTraces()
+	}
+	if m.testSrc != "" {
+		return nil, m.testSnippet(cmds)
+	}
+	m.ruleMatches, m.exitCode = nil, 0
+	m.profiles = make(map[string]*ruleProfile)
+	if err := m.loadAllRules(); err != nil {
+		return nil, err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	if !m.serving {
+		// when serving, the packages loaded by loadTargets are kept
+		m.loader = nodeLoader{
+			wd: wd, ctx: m.ctx, fset: token.NewFileSet(),
+			imports: m.imports, strict: m.strict, shard: m.shard,
+		}
+		if m.config != nil {
+			m.loader.skips = append(m.loader.skips, m.config.skip...)
+		}
+		m.loader.skips = append(m.loader.skips, m.skips...)
+	}
+	if (m.checkpointPath != "" || m.resume || m.maxMem > 0 || m.cacheDir != "" || m.maxTime > 0) && !m.batching && !m.estimate {
+		if !m.searching {
+			return nil, fmt.Errorf("-checkpoint, -max-mem, -cache, and -max-time only work when searching or rewriting")
+		}
+		for _, cmd := range cmds {
+			if cmd.name == "w" && m.cacheDir != "" {
+				return nil, fmt.Errorf("cannot use -cache with -w, as cached packages aren't rewritten")
+			}
+		}
+		return nil, m.runBatched(cmds, args[len(m.defaultFlags):], paths)
+	}
+	if !m.serving {
+		if paths, err = m.cloneRemotes(paths, cmds); err != nil {
+			return nil, err
+		}
+		if paths, err = m.loader.buildTargets(paths); err != nil {
+			return nil, err
+		}
+	}
+	m.notes = make(map[nodePosHash]string)
+	m.plan = nil
+	if m.planPath != "" {
+		writes := false
+		for _, cmd := range cmds {
+			writes = writes || cmd.name == "w"
+		}
+		if !writes {
+			return nil, fmt.Errorf("-plan needs -w or gogrep rewrite")
+		}
+		m.plan = &rewritePlan{}
+	}
+	load := true
+	if len(cmds) == 0 && len(m.rules) > 0 && m.cloneSize == 0 && !m.unsafeReport && !m.listIgnores {
+		// only rules will run, so skip the packages they're disabled in
+		paths, load = m.scopedPaths(paths, wd)
+	}
+	if m.estimate {
+		return nil, m.printEstimate(cmds, paths, load)
+	}
 	var pkgs []loadPkg
-	if !m.typed {
+	switch {
+	case m.serving:
+		pkgs = m.pkgs
+	case !load:
+	case !m.typed:
 		pkgs, err = m.loader.untyped(paths, m.recursive)
-	} else {
+	default:
 		pkgs, err = m.loader.typed(paths, m.recursive)
 	}
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if !m.serving {
+		m.pkgErrs = append(m.pkgErrs, m.loader.errs...)
+		m.reportSkipped()
+	}
+	if cmd := m.wholeProgramCmd(cmds); cmd != nil && m.loader.modules > 1 {
+		return nil, fmt.Errorf("cannot use -%s with packages in %d modules; use a go.work file",
+			cmd.name, m.loader.modules)
 	}
 	sort.Slice(pkgs, func(i, j int) bool {
 		return pkgs[i].path < pkgs[j].path
 	})
-	var all []ast.Node
+	m.pkgs = pkgs
+	if m.cloneSize > 0 {
+		m.printClones(pkgs)
+		return nil, nil
+	}
+	if m.unsafeReport {
+		m.printUnsafe(pkgs)
+		return nil, nil
+	}
+	var all []submatch
 	for _, pkg := range pkgs {
 		m.Info = pkg.info
-		all = append(all, m.matches(cmds, pkg.nodes)...)
+		if m.listIgnores {
+			m.printIgnores(pkg.nodes)
+			continue
+		}
+		err := m.guardPkg(pkg.path, func() { m.runRules(pkg.nodes) })
+		if err != nil {
+			m.reportMatchErrors([]error{err})
+		}
+	}
+	if len(cmds) > 0 && !m.listIgnores {
+		all = m.matchPkgs(cmds, pkgs)
+	}
+	if m.plan != nil {
+		if err := m.writePlan(); err != nil {
+			return nil, err
+		}
+	}
+	if m.strict && len(m.pkgErrs) > 0 {
+		return nil, m.pkgErrs[0]
+	}
+	if m.sarif {
+		if err := m.printSARIF(); err != nil {
+			return nil, err
+		}
+	}
+	if m.rdjson || m.rdjsonl {
+		if err := m.printRDJSON(m.rdjsonl); err != nil {
+			return nil, err
+		}
+	}
+	if m.profile {
+		m.printProfiles()
+	}
+	return all, nil
+}
+
+// normalizeLine collapses all whitespace outside of literals into single
+// spaces.
+func normalizeLine(s string) string {
+	var buf bytes.Buffer
+	var quote rune
+	escaped, space := false, false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			switch {
+			case escaped:
+				escaped = false
+			case r == '\\' && quote != '`':
+				escaped = true
+			case r == quote:
+				quote = 0
+			}
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case r == '"', r == '\'', r == '`':
+			quote = r
+		}
+		if space && buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		space = false
+		buf.WriteRune(r)
 	}
-	for _, n := range all {
-		fpos := m.loader.fset.Position(n.Pos())
-		if strings.HasPrefix(fpos.Filename, wd) {
-			fpos.Filename = fpos.Filename[len(wd)+1:]
+	return buf.String()
+}
+
+// rewriteCmds adds the final -w command for "gogrep rewrite", which needs a
+// substitution to write back.
+func rewriteCmds(cmds []exprCmd) ([]exprCmd, error) {
+	subst := false
+	for _, cmd := range cmds {
+		switch cmd.name {
+		case "s":
+			subst = true
+		case "w":
+			return nil, fmt.Errorf("rewrite: -w is implied")
+		}
+	}
+	if !subst {
+		return nil, fmt.Errorf("rewrite: need at least one -s command")
+	}
+	return append(cmds, exprCmd{name: "w"}), nil
+}
+
+// loadAllRules loads the rules from the -rules files and the -pack packs,
+// scoped as per the config file.
+func (m *matcher) loadAllRules() error {
+	m.rules = nil
+	if m.rulesPath != "" {
+		names := make(map[string]string)
+		for _, path := range strings.Split(m.rulesPath, ",") {
+			rules, err := m.loadRules(path)
+			if err != nil {
+				return err
+			}
+			for _, r := range rules {
+				if prev, ok := names[r.Name]; ok {
+					return fmt.Errorf("%s: rule %q already defined in %s",
+						path, r.Name, prev)
+				}
+				names[r.Name] = path
+			}
+			m.rules = append(m.rules, rules...)
+		}
+	}
+	if m.packs != "" {
+		rules, err := m.packRules(m.packs)
+		if err != nil {
+			return err
+		}
+		m.rules = append(m.rules, rules...)
+	}
+	for i := range m.rules {
+		r := &m.rules[i]
+		if scope, ok := m.ruleScopes[r.Name]; ok {
+			r.Enable = append(r.Enable, scope.Enable...)
+			r.Disable = append(r.Disable, scope.Disable...)
 		}
-		fmt.Fprintf(m.out, "%v: %s\n", fpos, singleLinePrint(n))
 	}
 	return nil
 }
 
+// position is like token.FileSet.Position, but it uses paths relative to the
+// working directory when possible. The position is where the source is,
+// ignoring //line directives.
+func (m *matcher) position(pos token.Pos) token.Position {
+	return m.relPosition(m.loader.fset.PositionFor(pos, false))
+}
+
+func (m *matcher) relPosition(fpos token.Position) token.Position {
+	if name, ok := m.remotePosition(fpos.Filename); ok {
+		fpos.Filename = name
+		return fpos
+	}
+	if m.loader.wd != "" && strings.HasPrefix(fpos.Filename, m.loader.wd) {
+		fpos.Filename = fpos.Filename[len(m.loader.wd)+1:]
+	}
+	return fpos
+}
+
+// linePosition returns the position that the //line directives in a file map
+// a position to, such as a line in the yacc grammar that generated the file.
+// It returns false if there is no such directive.
+func (m *matcher) linePosition(pos token.Pos) (token.Position, bool) {
+	fpos := m.loader.fset.PositionFor(pos, false)
+	lpos := m.loader.fset.PositionFor(pos, true)
+	if lpos.Filename == "" {
+		lpos.Filename = fpos.Filename // like "//line :12"
+	}
+	if lpos.Filename == fpos.Filename && lpos.Line == fpos.Line {
+		return lpos, false
+	}
+	return m.relPosition(lpos), true
+}
+
+// lineNote returns the note for a position mapped by //line directives, if
+// they're being followed via -linedirs.
+func (m *matcher) lineNote(pos token.Pos) string {
+	if !m.lineDirs {
+		return ""
+	}
+	if lpos, ok := m.linePosition(pos); ok {
+		return "from " + lpos.String()
+	}
+	return ""
+}
+
+// note returns the suffix to print after a resulting node, if it has a note.
+func (m *matcher) note(node ast.Node) string {
+	note, ok := m.notes[posHash(node)]
+	if lnote := m.lineNote(node.Pos()); lnote != "" {
+		if ok {
+			note += "; "
+		}
+		note, ok = note+lnote, true
+	}
+	if m.loader.degraded[m.loader.fset.PositionFor(node.Pos(), false).Filename] {
+		if ok {
+			note += "; "
+		}
+		note, ok = note+"degraded: the file has syntax errors", true
+	}
+	if !ok {
+		return ""
+	}
+	return " // " + note
+}
+
 func (m *matcher) parseCmds(args []string) ([]exprCmd, []string, error) {
-	flagSet := flag.NewFlagSet("gogrep", flag.ExitOnError)
+	handling := flag.ExitOnError
+	if m.serving {
+		handling = flag.ContinueOnError
+	}
+	flagSet := flag.NewFlagSet("gogrep", handling)
 	flagSet.Usage = usage
-	flagSet.BoolVar(&m.recursive, "r", false, "match all dependencies recursively too")
+	if m.serving {
+		flagSet.Usage = func() {}
+		flagSet.SetOutput(ioutil.Discard)
+	}
+	m.typed = false
+	m.globalFlags(flagSet)
+	args, err := m.parseExec(args)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var cmds []exprCmd
+	cmdFlags(flagSet, &cmds)
+	if err := flagSet.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	paths := flagSet.Args()
+
+	if len(cmds) < 1 && !m.listIgnores && m.cloneSize <= 0 && !m.unsafeReport &&
+		m.rulesPath == "" && m.packs == "" {
+		return nil, nil, fmt.Errorf("need at least one command")
+	}
+	if m.unsafeReport {
+		m.typed = true
+	}
+	if err := m.compileCmds(cmds); err != nil {
+		return nil, nil, err
+	}
+	return cmds, paths, nil
+}
+
+// globalFlags registers the flags which aren't commands.
+func (m *matcher) globalFlags(flagSet *flag.FlagSet) {
+	flagSet.BoolVar(&m.recursive, "r", false, "match all dependencies recursively too")
+	flagSet.StringVar(&m.nolint, "nolint", "", "honor suppression comments with this directive")
+	flagSet.BoolVar(&m.listIgnores, "ignores", false, "list the gogrep:ignore directives")
+	flagSet.StringVar(&m.planPath, "plan", "", "record the edits of -w in a file")
+	flagSet.StringVar(&m.checkpointPath, "checkpoint", "", "record the packages done in a file")
+	flagSet.BoolVar(&m.resume, "resume", false, "skip the packages done in the -checkpoint file")
+	m.maxMem = 0
+	flagSet.Var(&memFlag{&m.maxMem}, "max-mem", "match packages in batches using up to this memory")
+	flagSet.StringVar(&m.cacheDir, "cache", "", "cache the results of each package in a directory")
+	flagSet.DurationVar(&m.maxTime, "max-time", 0, "stop matching packages after this long")
+	flagSet.BoolVar(&m.normalized, "norm", false, "print matches as normalized lines")
+	flagSet.BoolVar(&m.lineDirs, "linedirs", false, "also print where //line directives map matches to")
+	flagSet.BoolVar(&m.interproc, "interproc", false, "follow tainted values into called funcs")
+	flagSet.IntVar(&m.cloneSize, "clones", 0, "report clones of at least this many nodes")
+	flagSet.BoolVar(&m.unsafeReport, "unsafe", false, "report the unsafe conversions by func")
+	flagSet.IntVar(&m.fuzzy, "fuzzy", 0, "also report matches within this many edits")
+	flagSet.BoolVar(&m.verbose, "verbose", false, "print extra details")
+	flagSet.StringVar(&m.rulesPath, "rules", "", "run the rules in the comma-separated files")
+	flagSet.StringVar(&m.packs, "pack", "", "run the built-in rule packs")
+	flagSet.BoolVar(&m.sarif, "sarif", false, "print rule matches as SARIF")
+	flagSet.BoolVar(&m.rdjson, "rdjson", false, "print rule matches as reviewdog diagnostics")
+	flagSet.BoolVar(&m.rdjsonl, "rdjsonl", false, "print rule matches as reviewdog diagnostic lines")
+	flagSet.BoolVar(&m.profile, "profile", false, "print how long each rule took")
+	flagSet.BoolVar(&m.explain, "explain", false, "print how the commands were parsed")
+	flagSet.BoolVar(&m.estimate, "estimate", false, "print what would be loaded instead of matching")
+	flagSet.StringVar(&m.testSrc, "test-src", "", "match a snippet instead of packages")
+	flagSet.StringVar(&m.ctags, "ctags", "", "print a ctags file named after a wildcard")
+	flagSet.StringVar(&m.etags, "etags", "", "print an etags file named after a wildcard")
+	flagSet.StringVar(&m.countBy, "count-by", "", "print how many matches have each value of a wildcard")
+	flagSet.BoolVar(&m.trace, "trace", false, "print where the commands failed to match")
+	flagSet.IntVar(&m.jobs, "j", runtime.GOMAXPROCS(0), "match this many packages at once")
+	flagSet.BoolVar(&m.sorted, "sort", true, "sort matches by file and position")
+	flagSet.BoolVar(&m.strict, "strict", false, "stop at the first package that fails")
+	m.nested = "all"
+	for _, name := range []string{"all", "outermost", "innermost"} {
+		flagSet.Var(&nestedFlag{&m.nested, name}, name, "keep "+name+" nested matches")
+	}
+	m.snap = ""
+	flagSet.Var(&snapFlag{&m.snap}, "snap", "expand the matches to their statements or lines")
+	m.group = ""
+	flagSet.Var(&groupFlag{&m.group}, "group", "nest the matches under their funcs or types")
+	m.onlyIn = nil
+	flagSet.Var(&onlyInFlag{&m.onlyIn}, "only-in", "only report matches within these contexts")
+	m.notIn = nil
+	flagSet.Var(&onlyInFlag{&m.notIn}, "not-in", "skip matches within these contexts")
+	m.imports = nil
+	flagSet.Var(&importFlag{&m.imports}, "import", "make a package available to type constraints")
+	m.shard = shardSpec{}
+	flagSet.Var(&shardFlag{&m.shard}, "shard", "only load the packages in shard i of n")
+	m.skips = nil
+	flagSet.Var(&skipFlag{&m.skips}, "skip", "skip the packages matching these patterns")
+	flagSet.DurationVar(&m.pkgTimeout, "package-timeout", 0, "skip the packages which take longer to match")
+}
+
+// cmdFlags registers all the commands as flags, so that each of them is
+// appended to cmds when parsing.
+func cmdFlags(flagSet *flag.FlagSet, cmds *[]exprCmd) {
+	flagSet.Var(&fileCmdFlag{flagSet}, "f", "read commands from a file")
 	flagSet.Var(&strCmdFlag{
 		name: "x",
-		cmds: &cmds,
-	}, "x", "")
+		cmds: cmds,
+	}, "x", "find all nodes matching a pattern")
+	flagSet.Var(&strCmdFlag{
+		name: "or",
+		cmds: cmds,
+	}, "or", "also find the nodes matching a pattern")
 	flagSet.Var(&strCmdFlag{
 		name: "g",
-		cmds: &cmds,
-	}, "g", "")
+		cmds: cmds,
+	}, "g", "discard nodes not matching a pattern")
 	flagSet.Var(&strCmdFlag{
 		name: "v",
-		cmds: &cmds,
-	}, "v", "")
+		cmds: cmds,
+	}, "v", "discard nodes matching a pattern")
 	flagSet.Var(&strCmdFlag{
 		name: "a",
-		cmds: &cmds,
-	}, "a", "")
+		cmds: cmds,
+	}, "a", "discard nodes without an attribute")
 	flagSet.Var(&strCmdFlag{
 		name: "s",
-		cmds: &cmds,
-	}, "s", "")
+		cmds: cmds,
+	}, "s", "substitute with a given syntax tree")
 	flagSet.Var(&strCmdFlag{
 		name: "p",
-		cmds: &cmds,
-	}, "p", "")
+		cmds: cmds,
+	}, "p", "navigate up a number of node parents")
+	flagSet.Var(&strCmdFlag{
+		name: "comment",
+		cmds: cmds,
+	}, "comment", "find all comments matching a regular expression")
+	flagSet.Var(&boolCmdFlag{
+		name: "refs",
+		cmds: cmds,
+	}, "refs", "find all references to the matched objects")
+	flagSet.Var(&boolCmdFlag{
+		name: "def",
+		cmds: cmds,
+	}, "def", "find the declarations of the matched objects")
+	flagSet.Var(&boolCmdFlag{
+		name: "uses",
+		cmds: cmds,
+	}, "uses", "count the uses of the matched objects")
+	flagSet.Var(&boolCmdFlag{
+		name: "impls",
+		cmds: cmds,
+	}, "impls", "find the implementations of the matched interfaces")
+	flagSet.Var(&strCmdFlag{
+		name: "reach",
+		cmds: cmds,
+	}, "reach", "discard nodes not reachable from funcs matching a pattern")
+	flagSet.Var(&strCmdFlag{
+		name: "taint",
+		cmds: cmds,
+	}, "taint", "find the nodes matching a pattern that use matched values")
+	flagSet.Var(&strCmdFlag{
+		name: "concat",
+		cmds: cmds,
+	}, "concat", "like -taint, but only via string concatenation or formatting")
+	flagSet.Var(&depthCmdFlag{
+		name: "callers",
+		cmds: cmds,
+	}, "callers", "find the funcs calling the matched funcs")
+	flagSet.Var(&depthCmdFlag{
+		name: "callees",
+		cmds: cmds,
+	}, "callees", "find the funcs called by the matched funcs")
+	flagSet.Var(&boolCmdFlag{
+		name: "file",
+		cmds: cmds,
+	}, "file", "replace the matches with their files")
+	flagSet.Var(&boolCmdFlag{
+		name: "pkg",
+		cmds: cmds,
+	}, "pkg", "replace the matches with their package")
 	flagSet.Var(&boolCmdFlag{
 		name: "w",
-		cmds: &cmds,
-	}, "w", "")
-	flagSet.Parse(args)
-	paths := flagSet.Args()
+		cmds: cmds,
+	}, "w", "write the entire source code back")
+}
 
-	if len(cmds) < 1 {
-		return nil, nil, fmt.Errorf("need at least one command")
-	}
+// orFollows holds the commands taking patterns which -or can add to.
+var orFollows = map[string]bool{"x": true, "or": true, "taint": true, "concat": true}
+
+// compileCmds parses the source of each command into its value, such as a
+// pattern's syntax tree. Commands needing type information set m.typed.
+func (m *matcher) compileCmds(cmds []exprCmd) error {
 	for i, cmd := range cmds {
 		switch cmd.name {
-		case "w":
+		case "w", "file", "pkg":
 			continue // no expr
+		case "or":
+			if i == 0 || !orFollows[cmds[i-1].name] {
+				return fmt.Errorf("-or must follow -x, -taint, -concat, or another -or")
+			}
+			node, err := m.parseExpr(cmd.src)
+			if err != nil {
+				return err
+			}
+			cmds[i].value = node
+		case "refs", "def", "impls", "uses":
+			m.typed = true
+		case "taint", "concat", "reach":
+			m.typed = true
+			node, err := m.parseExpr(cmd.src)
+			if err != nil {
+				return err
+			}
+			cmds[i].value = node
+		case "callers", "callees":
+			m.typed = true
+			depth := 0
+			if cmd.src != "" {
+				n, err := strconv.Atoi(cmd.src)
+				if err != nil || n < 1 {
+					return fmt.Errorf("invalid -%s depth: %q", cmd.name, cmd.src)
+				}
+				depth = n
+			}
+			cmds[i].value = depth
 		case "p":
 			n, err := strconv.Atoi(cmd.src)
 			if err != nil {
-				return nil, nil, err
+				return err
 			}
 			cmds[i].value = n
 		case "a":
 			m, err := m.parseAttrs(cmd.src)
 			if err != nil {
-				return nil, nil, fmt.Errorf("cannot parse mods: %v", err)
+				return fmt.Errorf("cannot parse mods: %v", err)
 			}
 			cmds[i].value = m
+		case "comment":
+			rx, err := regexp.Compile(cmd.src)
+			if err != nil {
+				return fmt.Errorf("cannot parse comment regex: %v", err)
+			}
+			cmds[i].value = rx
 		default:
 			node, err := m.parseExpr(cmd.src)
 			if err != nil {
-				return nil, nil, err
+				return err
 			}
 			cmds[i].value = node
 		}
 	}
-	return cmds, paths, nil
+	return nil
 }
 
 type bufferJoinLines struct {
@@ -259,9 +1651,28 @@ func (b *bufferJoinLines) Write(p []byte) (n int, err error) {
 		b.last = "\n"
 		return 1, nil
 	}
-	p = bytes.Trim(p, "\t")
-	n, err = b.Buffer.Write(p)
-	b.last = string(p)
+	n = len(p)
+	if b.last == "\n" {
+		// drop the indentation
+		p = bytes.TrimLeft(p, "\t")
+	}
+	// tabs around tokens align them, so they become a space; those
+	// within tokens, such as in string literals, are kept
+	var line []byte
+	trimmed := bytes.TrimLeft(p, "\t")
+	if len(trimmed) < len(p) {
+		line = append(line, ' ')
+	}
+	rest := bytes.TrimRight(trimmed, "\t")
+	line = append(line, rest...)
+	if len(rest) < len(trimmed) {
+		line = append(line, ' ')
+	}
+	if len(line) == 0 {
+		return n, nil
+	}
+	_, err = b.Buffer.Write(line)
+	b.last = string(line)
 	return
 }
 
@@ -291,6 +1702,9 @@ func inspect(node ast.Node, fn func(ast.Node) bool) {
 var emptyFset = token.NewFileSet()
 
 func singleLinePrint(node ast.Node) string {
+	if files, ok := node.(fileList); ok {
+		return "package " + files[0].Name.Name
+	}
 	var buf bufferJoinLines
 	inspect(node, func(node ast.Node) bool {
 		bl, ok := node.(*ast.BasicLit)
@@ -306,10 +1720,43 @@ func singleLinePrint(node ast.Node) string {
 		bl.Value = strconv.Quote(bl.Value[1 : len(bl.Value)-1])
 		return true
 	})
-	printNode(&buf, emptyFset, node)
+	// comments such as docs would be printed along with the nodes
+	// they're attached to, breaking the single line
+	withoutComments(node, func() {
+		printNode(&buf, emptyFset, node)
+	})
 	return buf.String()
 }
 
+var commentGroupType = reflect.TypeOf((*ast.CommentGroup)(nil))
+
+// withoutComments runs fn while the comment groups attached to the nodes
+// within node are detached from them.
+func withoutComments(node ast.Node, fn func()) {
+	var restore []func()
+	inspect(node, func(node ast.Node) bool {
+		v := reflect.ValueOf(node)
+		if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+			return true
+		}
+		v = v.Elem()
+		for i := 0; i < v.NumField(); i++ {
+			fld := v.Field(i)
+			if fld.Type() != commentGroupType || fld.IsNil() {
+				continue
+			}
+			orig := reflect.ValueOf(fld.Interface())
+			fld.Set(reflect.Zero(commentGroupType))
+			restore = append(restore, func() { fld.Set(orig) })
+		}
+		return true
+	})
+	fn()
+	for _, fn := range restore {
+		fn()
+	}
+}
+
 func printNode(w io.Writer, fset *token.FileSet, node ast.Node) {
 	switch x := node.(type) {
 	case exprList:
@@ -330,6 +1777,55 @@ func printNode(w io.Writer, fset *token.FileSet, node ast.Node) {
 			fmt.Fprintf(w, "; ")
 			printNode(w, fset, n)
 		}
+	case fieldList:
+		for i, n := range x {
+			if i > 0 {
+				fmt.Fprintf(w, "; ")
+			}
+			printNode(w, fset, n)
+		}
+	case chainList:
+		for _, n := range x {
+			printNode(w, fset, n)
+		}
+	case layerList:
+		printNode(w, fset, rebuildLayers(&ast.Ident{}, x))
+	case armList:
+		for i, n := range x {
+			if i > 0 {
+				fmt.Fprintf(w, " else ")
+			}
+			printNode(w, fset, n)
+		}
+	case operandList:
+		for i, n := range x.list {
+			if i > 0 {
+				fmt.Fprintf(w, " %s ", x.op)
+			}
+			printNode(w, fset, n)
+		}
+	case *ast.Field:
+		// not supported by go/printer on its own
+		for i, name := range x.Names {
+			if i > 0 {
+				fmt.Fprintf(w, ", ")
+			}
+			fmt.Fprint(w, name.Name)
+		}
+		if len(x.Names) > 0 {
+			fmt.Fprintf(w, " ")
+		}
+		printNode(w, fset, x.Type)
+		if x.Tag != nil {
+			fmt.Fprintf(w, " %s", x.Tag.Value)
+		}
+	case *ast.CommentGroup:
+		for i, c := range x.List {
+			if i > 0 {
+				fmt.Fprintf(w, " ")
+			}
+			fmt.Fprint(w, strings.Replace(c.Text, "\n", " ", -1))
+		}
 	default:
 		err := printer.Fprint(w, fset, node)
 		if err != nil && strings.Contains(err.Error(), "go/printer: unsupported node type") {
@@ -9,24 +9,116 @@ import (
 	"go/importer"
 	"go/token"
 	"go/types"
+	"math"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 )
 
-func (m *matcher) matches(cmds []exprCmd, nodes []ast.Node) []ast.Node {
+func (m *matcher) matches(cmds []exprCmd, nodes []ast.Node) ([]ast.Node, error) {
+	final, err := m.recoverMatchSubs(cmds, nodes)
+	if err != nil {
+		return nil, err
+	}
+	finalNodes := make([]ast.Node, len(final))
+	for i := range finalNodes {
+		finalNodes[i] = final[i].node
+	}
+	return finalNodes, nil
+}
+
+// matchError is an error found while matching, such as a type in a pattern
+// that can't be resolved. It's raised via failf, and recovered by
+// recoverMatchSubs, so that it only affects the nodes being matched.
+type matchError struct {
+	pos token.Position
+	msg string
+}
+
+func (e *matchError) Error() string {
+	if !e.pos.IsValid() {
+		return e.msg
+	}
+	return fmt.Sprintf("%v: %s", e.pos, e.msg)
+}
+
+// failf raises a matchError at a node, or without a position if the node is
+// nil.
+func (m *matcher) failf(node ast.Node, format string, a ...interface{}) {
+	var pos token.Position
+	if node != nil {
+		pos = m.position(node.Pos())
+	}
+	panic(&matchError{pos, fmt.Sprintf(format, a...)})
+}
+
+// recoverMatchSubs is like matchSubs, but it returns the first matchError
+// raised instead.
+func (m *matcher) recoverMatchSubs(cmds []exprCmd, nodes []ast.Node) (subs []submatch, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			merr, ok := r.(*matchError)
+			if !ok {
+				panic(r)
+			}
+			subs, err = nil, merr
+		}
+	}()
+	return m.matchSubs(cmds, nodes), nil
+}
+
+// tryMatchSubs is like matchSubs, but it returns the matchErrors raised. If
+// there are any, each node is matched on its own, so that only the matches in
+// the nodes that raised them are lost, such as a file in a package.
+func (m *matcher) tryMatchSubs(cmds []exprCmd, nodes []ast.Node) ([]submatch, []error) {
+	subs, err := m.recoverMatchSubs(cmds, nodes)
+	if err == nil {
+		return subs, nil
+	}
+	if len(nodes) == 1 {
+		return nil, []error{err}
+	}
+	var errs []error
+	for _, node := range nodes {
+		nodeSubs, err := m.recoverMatchSubs(cmds, []ast.Node{node})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		subs = append(subs, nodeSubs...)
+	}
+	return subs, errs
+}
+
+// reportMatchErrors records the errors raised while matching, to be reported
+// along with the load errors at the end.
+func (m *matcher) reportMatchErrors(errs []error) {
+	m.pkgErrs = append(m.pkgErrs, errs...)
+}
+
+// matchSubs is like matches, but it returns the submatches, which include the
+// values captured by the wildcards.
+func (m *matcher) matchSubs(cmds []exprCmd, nodes []ast.Node) []submatch {
 	m.parents = make(map[ast.Node]ast.Node)
 	m.fillParents(nodes...)
+	m.pkgFiles = nil
+	for _, node := range nodes {
+		if f, ok := node.(*ast.File); ok {
+			m.pkgFiles = append(m.pkgFiles, f)
+		}
+	}
 	initial := make([]submatch, len(nodes))
 	for i, node := range nodes {
 		initial[i].node = node
 		initial[i].values = make(map[string]ast.Node)
 	}
-	final := m.submatches(cmds, initial)
-	finalNodes := make([]ast.Node, len(final))
-	for i := range finalNodes {
-		finalNodes[i] = final[i].node
+	if m.snap == "" {
+		return m.submatches(cmds, initial)
 	}
-	return finalNodes
+	before, after := snapCmds(cmds)
+	return m.submatches(after, m.snapSubs(m.submatches(before, initial)))
 }
 
 func (m *matcher) fillParents(nodes ...ast.Node) {
@@ -43,12 +135,33 @@ func (m *matcher) fillParents(nodes ...ast.Node) {
 			stack = append(stack, node)
 			return true
 		})
+		if f, ok := node.(*ast.File); ok {
+			// comments not attached to any node
+			for _, cg := range f.Comments {
+				if m.parents[cg] == nil {
+					m.parents[cg] = f
+				}
+			}
+		}
 	}
 }
 
 type submatch struct {
 	node   ast.Node
 	values map[string]ast.Node
+
+	// orig is the source range that node replaced, if it was
+	// substituted via -s.
+	orig nodePosHash
+}
+
+// span returns the source range of a submatch, which is the range it
+// replaced if it was substituted.
+func (s submatch) span() nodePosHash {
+	if s.orig.pos.IsValid() {
+		return s.orig
+	}
+	return posHash(s.node)
 }
 
 func valsCopy(values map[string]ast.Node) map[string]ast.Node {
@@ -63,11 +176,18 @@ func (m *matcher) submatches(cmds []exprCmd, subs []submatch) []submatch {
 	if len(cmds) == 0 {
 		return subs
 	}
-	cmd := cmds[0]
+	cmd, rest := cmds[0], cmds[1:]
 	var fn func(exprCmd, []submatch) []submatch
 	switch cmd.name {
 	case "x":
-		fn = m.cmdRange
+		union := []exprCmd{cmd}
+		for len(rest) > 0 && rest[0].name == "or" {
+			union = append(union, rest[0])
+			rest = rest[1:]
+		}
+		fn = func(_ exprCmd, subs []submatch) []submatch {
+			return m.cmdUnion(union, subs)
+		}
 	case "g":
 		fn = m.cmdFilter(true)
 	case "v":
@@ -78,18 +198,51 @@ func (m *matcher) submatches(cmds []exprCmd, subs []submatch) []submatch {
 		fn = m.cmdAttr
 	case "p":
 		fn = m.cmdParents
+	case "file":
+		fn = m.cmdFile
+	case "pkg":
+		fn = m.cmdPkg
+	case "comment":
+		fn = m.cmdComment
+	case "refs":
+		fn = m.cmdRefs
+	case "def":
+		fn = m.cmdDef
+	case "uses":
+		fn = m.cmdUses
+	case "impls":
+		fn = m.cmdImpls
+	case "callers", "callees":
+		fn = m.cmdCalls
+	case "taint", "concat":
+		sinks := []exprCmd{cmd}
+		for len(rest) > 0 && rest[0].name == "or" {
+			sinks = append(sinks, rest[0])
+			rest = rest[1:]
+		}
+		fn = func(_ exprCmd, subs []submatch) []submatch {
+			return m.cmdTaint(sinks, subs)
+		}
+	case "reach":
+		fn = m.cmdReach
 	case "w":
 		if len(cmds) > 1 {
-			panic("-w must be the last command")
+			m.failf(nil, "-w must be the last command")
 		}
 		fn = m.cmdWrite
 	default:
-		panic(fmt.Sprintf("unknown command: %q", cmd.name))
+		m.failf(nil, "unknown command: %q", cmd.name)
 	}
-	return m.submatches(cmds[1:], fn(cmd, subs))
+	return m.submatches(rest, fn(cmd, subs))
 }
 
 func (m *matcher) cmdRange(cmd exprCmd, subs []submatch) []submatch {
+	return m.cmdUnion([]exprCmd{cmd}, subs)
+}
+
+// cmdUnion finds the nodes matching any of the patterns in cmds, as given by
+// -x and -or. With more than one pattern, the matches are sorted by position.
+func (m *matcher) cmdUnion(cmds []exprCmd, subs []submatch) []submatch {
 	var matches []submatch
 	seen := map[nodePosHash]bool{}
 
@@ -98,12 +251,23 @@ func (m *matcher) cmdRange(cmd exprCmd, subs []submatch) []submatch {
 	// submatches would share the same map and have side effects.
 	var startValues map[string]ast.Node
 
+	var cmd exprCmd // the current pattern
 	match := func(exprNode, node ast.Node) {
 		if node == nil {
 			return
 		}
+		m.visited++
+		m.checkDeadline()
 		m.values = valsCopy(startValues)
 		found := m.topNode(exprNode, node)
+		if found == nil && m.fuzzy > 0 {
+			m.values = valsCopy(startValues)
+			found = m.fuzzyNode(exprNode, node)
+		}
+		if found == nil && m.trace {
+			m.values = valsCopy(startValues)
+			m.traceMiss(cmd, exprNode, node)
+		}
 		if found == nil {
 			return
 		}
@@ -117,12 +281,69 @@ func (m *matcher) cmdRange(cmd exprCmd, subs []submatch) []submatch {
 		}
 	}
 	for _, sub := range subs {
-		startValues = valsCopy(sub.values)
-		m.walkWithLists(cmd.value.(ast.Node), sub.node, match)
+		for _, cmd = range cmds {
+			startValues = valsCopy(sub.values)
+			m.walkWithLists(cmd.value.(ast.Node), sub.node, match)
+		}
+	}
+	if len(cmds) > 1 {
+		sort.SliceStable(matches, func(i, j int) bool {
+			return matches[i].node.Pos() < matches[j].node.Pos()
+		})
+	}
+	if m.nested != "all" {
+		matches = m.dropNested(matches)
 	}
 	return matches
 }
 
+// dropNested drops the matches within another match with -outermost, or the
+// matches containing another match with -innermost. The order of the rest is
+// kept.
+func (m *matcher) dropNested(matches []submatch) []submatch {
+	order := make([]int, len(matches))
+	for i := range order {
+		order[i] = i
+	}
+	// by position, with the outer ones first
+	sort.Slice(order, func(i, j int) bool {
+		ni, nj := matches[order[i]].node, matches[order[j]].node
+		if ni.Pos() != nj.Pos() {
+			return ni.Pos() < nj.Pos()
+		}
+		return ni.End() > nj.End()
+	})
+	drop := make([]bool, len(matches))
+	var maxEnd token.Pos
+	for i, oi := range order {
+		node := matches[oi].node
+		if m.nested == "outermost" {
+			drop[oi] = node.End() <= maxEnd
+			if node.End() > maxEnd {
+				maxEnd = node.End()
+			}
+			continue
+		}
+		for _, oj := range order[i+1:] {
+			inner := matches[oj].node
+			if inner.Pos() >= node.End() {
+				break
+			}
+			if inner.End() <= node.End() {
+				drop[oi] = true
+				break
+			}
+		}
+	}
+	kept := matches[:0]
+	for i, sub := range matches {
+		if !drop[i] {
+			kept = append(kept, sub)
+		}
+	}
+	return kept
+}
+
 func (m *matcher) cmdFilter(wantAny bool) func(exprCmd, []submatch) []submatch {
 	return func(cmd exprCmd, subs []submatch) []submatch {
 		var matches []submatch
@@ -142,6 +363,8 @@ func (m *matcher) cmdFilter(wantAny bool) func(exprCmd, []submatch) []submatch {
 			m.walkWithLists(cmd.value.(ast.Node), sub.node, match)
 			if any == wantAny {
 				matches = append(matches, sub)
+			} else if m.trace {
+				m.traceReject(cmd, sub.node)
 			}
 		}
 		return matches
@@ -154,11 +377,217 @@ func (m *matcher) cmdAttr(cmd exprCmd, subs []submatch) []submatch {
 		m.values = sub.values
 		if m.attrApplies(sub.node, cmd.value.(attribute)) {
 			matches = append(matches, sub)
+		} else if m.trace {
+			m.traceReject(cmd, sub.node)
 		}
 	}
 	return matches
 }
 
+// recvMatches reports whether a node is within a method whose receiver type
+// matches the check's pattern. Its wildcards are only captured if it does.
+func (m *matcher) recvMatches(node ast.Node, check recvCheck) bool {
+	fn, _ := m.enclosingDecl(node).(*ast.FuncDecl)
+	if fn == nil || fn.Recv == nil || len(fn.Recv.List) != 1 {
+		return false
+	}
+	values := m.values
+	m.values = valsCopy(values)
+	matched := m.node(check.expr, fn.Recv.List[0].Type)
+	if matched {
+		for name, node := range m.values {
+			values[name] = node
+		}
+	}
+	m.values = values
+	return matched
+}
+
+// countMatches returns how many distinct nodes within a node match a
+// pattern, like -x would find. No wildcards are captured.
+func (m *matcher) countMatches(node, expr ast.Node) int {
+	values := m.values
+	seen := make(map[nodePosHash]bool)
+	m.walkWithLists(expr, node, func(expr, node ast.Node) {
+		if node == nil {
+			return
+		}
+		m.values = valsCopy(values)
+		if found := m.topNode(expr, node); found != nil {
+			seen[posHash(found)] = true
+		}
+	})
+	m.values = values
+	return len(seen)
+}
+
+// labelTargeted reports whether a node is a labeled statement whose label is
+// used by a break, continue, or goto statement. Labels are scoped to the
+// function body, so function literals within it are skipped.
+func (m *matcher) labelTargeted(node ast.Node) bool {
+	ls, _ := node.(*ast.LabeledStmt)
+	if ls == nil {
+		return false
+	}
+	var body ast.Node = ls
+	for node = m.parentOf(ls); node != nil; node = m.parentOf(node) {
+		body = node
+		if _, ok := node.(*ast.FuncLit); ok {
+			break
+		}
+		if _, ok := node.(*ast.FuncDecl); ok {
+			break
+		}
+	}
+	found := false
+	inspect(body, func(node ast.Node) bool {
+		switch x := node.(type) {
+		case *ast.FuncLit:
+			return x == body
+		case *ast.BranchStmt:
+			if x.Label != nil && x.Label.Name == ls.Label.Name {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
+
+// anonType reports whether a node is a struct, interface, or func type which
+// isn't named by a type declaration, nor the signature of a func or method.
+func (m *matcher) anonType(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.StructType, *ast.InterfaceType, *ast.FuncType:
+	default:
+		return false
+	}
+	switch x := m.parentOf(node).(type) {
+	case *ast.TypeSpec:
+		return x.Type != node
+	case *ast.FuncDecl, *ast.FuncLit:
+		return false
+	case *ast.Field:
+		// methods in interfaces
+		_, ok := m.parentOf(m.parentOf(x)).(*ast.InterfaceType)
+		return !ok
+	}
+	return true
+}
+
+// stringValue returns the value of a string literal.
+func stringValue(node ast.Node) (string, bool) {
+	if es, ok := node.(*ast.ExprStmt); ok {
+		node = es.X
+	}
+	lit, ok := node.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+	value, err := strconv.Unquote(lit.Value)
+	return value, err == nil
+}
+
+// entropy returns the Shannon entropy of a string, in bits per character.
+// Random tokens like keys tend to be above 4, while words are below 3.5.
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+	h := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(total)
+		h -= p * math.Log2(p)
+	}
+	return h
+}
+
+// looksEncoded reports whether a string looks like a base64 or hex encoded
+// token of at least 16 characters, using either base64 alphabet. Both
+// letters and digits must be present, to leave out words and numbers.
+func looksEncoded(s string) bool {
+	s = strings.TrimRight(s, "=")
+	if len(s) < 16 {
+		return false
+	}
+	letter, digit := false, false
+	for _, r := range s {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+			letter = true
+		case '0' <= r && r <= '9':
+			digit = true
+		case r == '+', r == '/', r == '-', r == '_':
+		default:
+			return false
+		}
+	}
+	return letter && digit
+}
+
+// depth returns how many nodes of a kind enclose a node, not counting the
+// node itself. See depthKinds.
+func (m *matcher) depth(node ast.Node, kind string) int {
+	n := 0
+	for node = m.parentOf(node); node != nil; node = m.parentOf(node) {
+		switch node.(type) {
+		case *ast.FuncLit, *ast.FuncDecl:
+			if kind != "func" {
+				return n
+			}
+			n++
+		case *ast.IfStmt:
+			if kind == "if" || kind == "block" {
+				n++
+			}
+		case *ast.ForStmt, *ast.RangeStmt:
+			if kind == "loop" || kind == "block" {
+				n++
+			}
+		case *ast.SwitchStmt, *ast.TypeSwitchStmt, *ast.SelectStmt:
+			if kind == "switch" || kind == "block" {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// cmdFile replaces the matches with the files containing them, once each.
+func (m *matcher) cmdFile(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	seen := make(map[*ast.File]bool)
+	add := func(f *ast.File, sub submatch) {
+		if f != nil && !seen[f] {
+			seen[f] = true
+			matches = append(matches, submatch{node: f, values: sub.values})
+		}
+	}
+	for _, sub := range subs {
+		if files, ok := sub.node.(fileList); ok {
+			for _, f := range files {
+				add(f, sub)
+			}
+			continue
+		}
+		add(m.fileOf(sub.node), sub)
+	}
+	return matches
+}
+
+// cmdPkg replaces the matches with the package containing them, made of all
+// the files being matched, so that the following commands work on entire
+// packages.
+func (m *matcher) cmdPkg(cmd exprCmd, subs []submatch) []submatch {
+	if len(subs) == 0 || len(m.pkgFiles) == 0 {
+		return nil
+	}
+	return []submatch{{node: m.pkgFiles, values: subs[0].values}}
+}
+
 func (m *matcher) cmdParents(cmd exprCmd, subs []submatch) []submatch {
 	for i := range subs {
 		sub := &subs[i]
@@ -170,12 +599,109 @@ func (m *matcher) cmdParents(cmd exprCmd, subs []submatch) []submatch {
 	return subs
 }
 
+// typeOf is like types.Info.TypeOf, but it returns the type of the value in
+// a comma-ok expression like m[k], <-c, or x.(T), which go/types records as a
+// (T, bool) tuple when it's used in a two-value assignment.
+func (m *matcher) typeOf(expr ast.Expr) types.Type {
+	t := m.Info.TypeOf(expr)
+	tuple, ok := t.(*types.Tuple)
+	if !ok || tuple.Len() != 2 {
+		return t
+	}
+	switch x := expr.(type) {
+	case *ast.IndexExpr, *ast.TypeAssertExpr:
+		return tuple.At(0).Type()
+	case *ast.UnaryExpr:
+		if x.Op == token.ARROW {
+			return tuple.At(0).Type()
+		}
+	}
+	return t
+}
+
 func (m *matcher) attrApplies(node ast.Node, attr interface{}) bool {
+	if list, ok := node.(nodeList); ok && list.len() == 1 {
+		// $x can match a list of one node, such as a call's only
+		// argument, which has the same position as the node
+		node = list.at(0)
+	}
+	switch x := attr.(type) {
+	case negAttr:
+		return !m.attrApplies(node, x.attr)
+	case docCheck:
+		return m.docApplies(node, x)
+	case buildCheck:
+		return m.buildApplies(node, x)
+	case commentCheck:
+		return m.commentNear(node, x)
+	case recvCheck:
+		return m.recvMatches(node, x)
+	case labelCheck:
+		return m.labelTargeted(node)
+	case anonCheck:
+		return m.anonType(node)
+	case pkgDirCheck:
+		f := m.fileOf(node)
+		if f == nil {
+			return false
+		}
+		// the directory must be absolute, as the file's position may
+		// be relative to a working directory within the package
+		dir := filepath.Base(m.nodeDir(f))
+		name := strings.TrimSuffix(f.Name.Name, "_test")
+		return name == dir
+	case countCheck:
+		return x.holds(float64(m.countMatches(node, x.expr)))
+	case depthCheck:
+		return x.holds(float64(m.depth(node, x.kind)))
+	case entropyCheck:
+		value, ok := stringValue(node)
+		return ok && x.holds(entropy(value))
+	case base64Check:
+		value, ok := stringValue(node)
+		return ok && looksEncoded(value)
+	case loopVarCheck:
+		return m.loopVarCaptured(node)
+	case unlockCheck:
+		return m.unlockedOnReturn(node)
+	case ctxCheck:
+		return m.dropsContext(node)
+	case sizeCheck:
+		n, ok := m.typeSize(node, x.align)
+		return ok && x.holds(float64(n))
+	case formatCheck:
+		problem := m.formatProblem(node)
+		if problem == "" {
+			return false
+		}
+		m.notes[posHash(node)] = "badformat: " + problem
+		return true
+	case objProperty:
+		obj := m.objectOf(node)
+		return obj != nil && m.deprecated(obj)
+	case constArgCheck:
+		return m.constArgApplies(node, x)
+	case objCheck:
+		obj := m.objectOf(node)
+		if obj == nil || obj.Pkg() == nil {
+			return false
+		}
+		path := obj.Pkg().Path()
+		for _, want := range x {
+			if want == path || want == path+"."+obj.Name() {
+				return true
+			}
+		}
+		return false
+	}
 	if rx, ok := attr.(*regexp.Regexp); ok {
-		if exprStmt, ok := node.(*ast.ExprStmt); ok {
+		switch x := node.(type) {
+		case *ast.ExprStmt:
 			// since we prefer matching entire statements, get the
 			// ident from the ExprStmt
-			node = exprStmt.X
+			node = x.X
+		case *ast.File:
+			node = x.Name // files are named by their package
 		}
 		ident, ok := node.(*ast.Ident)
 		return ok && rx.MatchString(ident.Name)
@@ -184,14 +710,17 @@ func (m *matcher) attrApplies(node ast.Node, attr interface{}) bool {
 	if expr == nil {
 		return false // only exprs have types
 	}
-	t := m.Info.TypeOf(expr)
+	t := m.typeOf(expr)
 	if t == nil {
 		return false // an expr, but no type?
 	}
 	tv := m.Info.Types[expr]
 	switch x := attr.(type) {
 	case typeCheck:
-		want := m.resolveType(m.scope, x.expr)
+		want, err := m.resolveType(m.scope, x.expr)
+		if err != nil {
+			m.failf(node, "%v", err)
+		}
 		switch {
 		case x.op == "type" && !types.Identical(t, want):
 			return false
@@ -246,7 +775,7 @@ func (m *matcher) walkWithLists(exprNode, node ast.Node, fn func(exprNode, node
 				// so that "$*a" will match "a, b"
 				fn(exprList([]ast.Expr{id}), list)
 				// so that "$*a" will match "a; b"
-				fn(toStmtList(id), list)
+				fn(m.toStmtList(id), list)
 			}
 		}
 		return true
@@ -255,6 +784,13 @@ func (m *matcher) walkWithLists(exprNode, node ast.Node, fn func(exprNode, node
 }
 
 func (m *matcher) topNode(exprNode, node ast.Node) ast.Node {
+	if al, ok := exprNode.(anchoredList); ok {
+		sts, ok := node.(stmtList)
+		if !ok {
+			return nil
+		}
+		return m.anchoredNodes(al, sts)
+	}
 	sts1, ok1 := exprNode.(stmtList)
 	sts2, ok2 := node.(stmtList)
 	if ok1 && ok2 {
@@ -267,11 +803,40 @@ func (m *matcher) topNode(exprNode, node ast.Node) ast.Node {
 	return nil
 }
 
+// anchoredList is a statement list pattern which must be at the start or the
+// end of a block, unlike the partial matches at the top level.
+type anchoredList struct {
+	stmtList
+	listAnchors
+}
+
+// anchoredNodes matches an anchored pattern with a block's statements,
+// returning the shortest match like the partial ones in topNode.
+func (m *matcher) anchoredNodes(expr anchoredList, list stmtList) ast.Node {
+	values := m.values
+	for i := 0; i < len(list); i++ {
+		if expr.start && i > 0 {
+			break
+		}
+		for j := i + 1; j <= len(list); j++ {
+			if expr.end && j < len(list) {
+				continue
+			}
+			m.values = valsCopy(values)
+			if m.nodes(expr.stmtList, list[i:j], false) != nil {
+				return list[i:j]
+			}
+		}
+	}
+	m.values = values
+	return nil
+}
+
 // optNode is like node, but for those nodes that can be nil and are not
 // part of a list. For example, init and post statements in a for loop.
 func (m *matcher) optNode(expr, node ast.Node) bool {
 	if ident := m.wildAnyIdent(expr); ident != nil {
-		if m.node(toStmtList(ident), toStmtList(node)) {
+		if m.node(m.toStmtList(ident), m.toStmtList(node)) {
 			return true
 		}
 	}
@@ -306,6 +871,10 @@ func (m *matcher) node(expr, node ast.Node) bool {
 
 	case *ast.File:
 		y, ok := node.(*ast.File)
+		if ok && len(x.Decls) == 0 && len(x.Imports) == 0 {
+			// only a package clause, to match any file by its name
+			return m.node(x.Name, y.Name)
+		}
 		if !ok || !m.node(x.Name, y.Name) || len(x.Decls) != len(y.Decls) ||
 			len(x.Imports) != len(y.Imports) {
 			return false
@@ -326,6 +895,9 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		y, yok := node.(*ast.Ident)
 		if !isWildName(x.Name) {
 			// not a wildcard
+			if f := m.etaReduced(node); f != nil && m.aggressive {
+				return m.node(x, f)
+			}
 			return yok && x.Name == y.Name
 		}
 		if _, ok := node.(ast.Node); !ok {
@@ -356,6 +928,24 @@ func (m *matcher) node(expr, node ast.Node) bool {
 	case stmtList:
 		y, ok := node.(stmtList)
 		return ok && m.stmts(x, y)
+	case fieldList:
+		y, ok := node.(fieldList)
+		return ok && m.nodesMatch(x, y)
+	case anchoredList:
+		y, ok := node.(stmtList)
+		return ok && m.anchoredNodes(x, y) != nil
+	case chainList:
+		y, ok := node.(chainList)
+		return ok && m.nodesMatch(x, y)
+	case operandList:
+		y, ok := node.(operandList)
+		return ok && x.op == y.op && m.nodesMatch(x, y)
+	case armList:
+		y, ok := node.(armList)
+		return ok && m.nodesMatch(x, y)
+	case layerList:
+		y, ok := node.(layerList)
+		return ok && m.nodesMatch(x, y)
 
 	// lits
 	case *ast.BasicLit:
@@ -366,6 +956,9 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		return ok && m.node(x.Type, y.Type) && m.exprs(x.Elts, y.Elts)
 	case *ast.FuncLit:
 		y, ok := node.(*ast.FuncLit)
+		if f := m.etaReduced(x); f != nil && m.aggressive && !ok {
+			return m.node(f, node)
+		}
 		return ok && m.node(x.Type, y.Type) && m.node(x.Body, y.Body)
 
 	// types
@@ -379,9 +972,9 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		y, ok := node.(*ast.StructType)
 		return ok && m.fields(x.Fields, y.Fields)
 	case *ast.Field:
-		// TODO: tags?
 		y, ok := node.(*ast.Field)
-		return ok && m.idents(x.Names, y.Names) && m.node(x.Type, y.Type)
+		return ok && m.idents(x.Names, y.Names) && m.node(x.Type, y.Type) &&
+			m.tag(x.Tag, y.Tag)
 	case *ast.FuncType:
 		y, ok := node.(*ast.FuncType)
 		return ok && m.fields(x.Params, y.Params) &&
@@ -404,12 +997,21 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		y, ok := node.(*ast.UnaryExpr)
 		return ok && x.Op == y.Op && m.node(x.X, y.X)
 	case *ast.BinaryExpr:
+		if m.hasOperandsWild(x) {
+			return m.operandsMatch(x, node)
+		}
 		y, ok := node.(*ast.BinaryExpr)
 		return ok && x.Op == y.Op && m.node(x.X, y.X) && m.node(x.Y, y.Y)
 	case *ast.CallExpr:
+		if m.layersWild(x) != nil {
+			return m.layersMatch(x, node)
+		}
+		if m.hasChainWild(x) {
+			return m.nodesMatch(m.flattenChain(x), m.flattenChain(node))
+		}
 		y, ok := node.(*ast.CallExpr)
 		return ok && m.node(x.Fun, y.Fun) && m.exprs(x.Args, y.Args) &&
-			bothValid(x.Ellipsis, y.Ellipsis)
+			m.spreads(x, y)
 	case *ast.KeyValueExpr:
 		y, ok := node.(*ast.KeyValueExpr)
 		return ok && m.node(x.Key, y.Key) && m.node(x.Value, y.Value)
@@ -417,7 +1019,13 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		y, ok := node.(*ast.StarExpr)
 		return ok && m.node(x.X, y.X)
 	case *ast.SelectorExpr:
+		if m.hasChainWild(x) {
+			return m.nodesMatch(m.flattenChain(x), m.flattenChain(node))
+		}
 		y, ok := node.(*ast.SelectorExpr)
+		if f := m.etaReduced(node); f != nil && m.aggressive {
+			return m.node(x, f)
+		}
 		return ok && m.node(x.X, y.X) && m.node(x.Sel, y.Sel)
 	case *ast.IndexExpr:
 		y, ok := node.(*ast.IndexExpr)
@@ -437,9 +1045,14 @@ func (m *matcher) node(expr, node ast.Node) bool {
 	case *ast.FuncDecl:
 		y, ok := node.(*ast.FuncDecl)
 		return ok && m.fields(x.Recv, y.Recv) && m.node(x.Name, y.Name) &&
-			m.node(x.Type, y.Type) && m.node(x.Body, y.Body)
+			m.node(x.Type, y.Type) &&
+			m.node(maybeNilBlock(x.Body), maybeNilBlock(y.Body))
 
 	// specs
+	case *ast.ImportSpec:
+		y, ok := node.(*ast.ImportSpec)
+		return ok && m.optNode(maybeNilIdent(x.Name), maybeNilIdent(y.Name)) &&
+			m.importPath(x.Path, y.Path)
 	case *ast.ValueSpec:
 		y, ok := node.(*ast.ValueSpec)
 		if !ok || !m.node(x.Type, y.Type) {
@@ -504,7 +1117,7 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		return ok && m.exprs(x.Results, y.Results)
 	case *ast.BranchStmt:
 		y, ok := node.(*ast.BranchStmt)
-		return ok && x.Tok == y.Tok && m.node(maybeNilIdent(x.Label), maybeNilIdent(y.Label))
+		return ok && x.Tok == y.Tok && m.optNode(maybeNilIdent(x.Label), maybeNilIdent(y.Label))
 	case *ast.BlockStmt:
 		if m.aggressive && m.node(stmtList(x.List), node) {
 			return true
@@ -512,6 +1125,9 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		y, ok := node.(*ast.BlockStmt)
 		return ok && (m.cases(x.List, y.List) || m.stmts(x.List, y.List))
 	case *ast.IfStmt:
+		if m.hasArmsWild(x) {
+			return m.armsMatch(x, node)
+		}
 		y, ok := node.(*ast.IfStmt)
 		if !ok {
 			return false
@@ -519,8 +1135,8 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		condAny := m.wildAnyIdent(x.Cond)
 		if condAny != nil && x.Init == nil {
 			// if $*x { ... } on the left
-			left := toStmtList(condAny)
-			return m.node(left, toStmtList(y.Init, y.Cond)) &&
+			left := m.toStmtList(condAny)
+			return m.node(left, m.toStmtList(y.Init, y.Cond)) &&
 				m.node(x.Body, y.Body) && m.optNode(x.Else, y.Else)
 		}
 		return m.optNode(x.Init, y.Init) && m.node(x.Cond, y.Cond) &&
@@ -536,8 +1152,8 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		tagAny := m.wildAnyIdent(x.Tag)
 		if tagAny != nil && x.Init == nil {
 			// switch $*x { ... } on the left
-			left := toStmtList(tagAny)
-			return m.node(left, toStmtList(y.Init, y.Tag)) &&
+			left := m.toStmtList(tagAny)
+			return m.node(left, m.toStmtList(y.Init, y.Tag)) &&
 				m.node(x.Body, y.Body)
 		}
 		return m.optNode(x.Init, y.Init) && m.node(x.Tag, y.Tag) && m.node(x.Body, y.Body)
@@ -554,14 +1170,14 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		condIdent := m.wildAnyIdent(x.Cond)
 		if condIdent != nil && x.Init == nil && x.Post == nil {
 			// "for $*x { ... }" on the left
-			left := toStmtList(condIdent)
+			left := m.toStmtList(condIdent)
 			// also accept RangeStmt on the right
 			switch y := node.(type) {
 			case *ast.ForStmt:
-				return m.node(left, toStmtList(y.Init, y.Cond, y.Post)) &&
+				return m.node(left, m.toStmtList(y.Init, y.Cond, y.Post)) &&
 					m.node(x.Body, y.Body)
 			case *ast.RangeStmt:
-				return m.node(left, toStmtList(y.Key, y.Value, y.X)) &&
+				return m.node(left, m.toStmtList(y.Key, y.Value, y.X)) &&
 					m.node(x.Body, y.Body)
 			default:
 				return false
@@ -578,11 +1194,17 @@ func (m *matcher) node(expr, node ast.Node) bool {
 		return ok && m.node(x.Key, y.Key) && m.node(x.Value, y.Value) &&
 			m.node(x.X, y.X) && m.node(x.Body, y.Body)
 
-	case *ast.TypeSpec, *ast.FieldList:
+	case *ast.TypeSpec:
+		y, ok := node.(*ast.TypeSpec)
+		return ok && m.node(x.Name, y.Name) && m.node(x.Type, y.Type) &&
+			bothValid(x.Assign, y.Assign)
+
+	case *ast.FieldList:
 		// we ignore these, for now
 		return false
 	default:
-		panic(fmt.Sprintf("unexpected node: %T", x))
+		m.failf(node, "cannot match a pattern with %T", x)
+		return false
 	}
 }
 
@@ -602,8 +1224,50 @@ func (m *matcher) wildAnyIdent(node ast.Node) *ast.Ident {
 	return nil
 }
 
-// resolveType resolves a type expression from a given scope.
-func (m *matcher) resolveType(scope *types.Scope, expr ast.Expr) types.Type {
+// objectOf returns the object that a node refers to or declares, if any.
+// For example, a call refers to the function being called.
+func (m *matcher) objectOf(node ast.Node) types.Object {
+	switch x := node.(type) {
+	case *ast.Ident:
+		if obj := m.Info.Uses[x]; obj != nil {
+			return obj
+		}
+		return m.Info.Defs[x]
+	case *ast.ExprStmt:
+		return m.objectOf(x.X)
+	case *ast.ParenExpr:
+		return m.objectOf(x.X)
+	case *ast.CallExpr:
+		return m.objectOf(x.Fun)
+	case *ast.GoStmt:
+		return m.objectOf(x.Call)
+	case *ast.DeferStmt:
+		return m.objectOf(x.Call)
+	case *ast.SelectorExpr:
+		return m.objectOf(x.Sel)
+	case *ast.KeyValueExpr:
+		return m.objectOf(x.Key)
+	case *ast.StarExpr:
+		return m.objectOf(x.X)
+	case *ast.FuncDecl:
+		return m.objectOf(x.Name)
+	case *ast.TypeSpec:
+		return m.objectOf(x.Name)
+	case *ast.ValueSpec:
+		return m.objectOf(x.Names[0])
+	case *ast.GenDecl:
+		if len(x.Specs) == 1 {
+			return m.objectOf(x.Specs[0])
+		}
+	case *ast.DeclStmt:
+		return m.objectOf(x.Decl)
+	}
+	return nil
+}
+
+// resolveType resolves a type expression from a given scope. A nil type is
+// returned if a name isn't found, including any types containing it.
+func (m *matcher) resolveType(scope *types.Scope, expr ast.Expr) (types.Type, error) {
 	switch x := expr.(type) {
 	case *ast.Ident:
 		_, obj := scope.LookupParent(x.Name, token.NoPos)
@@ -611,36 +1275,194 @@ func (m *matcher) resolveType(scope *types.Scope, expr ast.Expr) types.Type {
 			// TODO: error if all resolveType calls on a type
 			// expression fail? or perhaps resolve type expressions
 			// across the entire program?
-			return nil
+			return nil, nil
 		}
-		return obj.Type()
+		return obj.Type(), nil
+	case *ast.ParenExpr:
+		return m.resolveType(scope, x.X)
 	case *ast.ArrayType:
-		elt := m.resolveType(scope, x.Elt)
+		elt, err := m.resolveType(scope, x.Elt)
+		if elt == nil || err != nil {
+			return nil, err
+		}
 		if x.Len == nil {
-			return types.NewSlice(elt)
+			return types.NewSlice(elt), nil
 		}
 		bl, ok := x.Len.(*ast.BasicLit)
 		if !ok || bl.Kind != token.INT {
-			panic(fmt.Sprintf("TODO: %T", x))
+			return nil, fmt.Errorf("unsupported array length in type: %T", x.Len)
 		}
 		len, _ := strconv.ParseInt(bl.Value, 0, 0)
-		return types.NewArray(elt, len)
+		return types.NewArray(elt, len), nil
 	case *ast.StarExpr:
-		return types.NewPointer(m.resolveType(scope, x.X))
+		elem, err := m.resolveType(scope, x.X)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		return types.NewPointer(elem), nil
+	case *ast.MapType:
+		key, err := m.resolveType(scope, x.Key)
+		if key == nil || err != nil {
+			return nil, err
+		}
+		elem, err := m.resolveType(scope, x.Value)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		return types.NewMap(key, elem), nil
+	case *ast.ChanType:
+		elem, err := m.resolveType(scope, x.Value)
+		if elem == nil || err != nil {
+			return nil, err
+		}
+		dir := types.SendRecv
+		switch x.Dir {
+		case ast.SEND:
+			dir = types.SendOnly
+		case ast.RECV:
+			dir = types.RecvOnly
+		}
+		return types.NewChan(dir, elem), nil
+	case *ast.FuncType:
+		sig, err := m.resolveSignature(scope, x)
+		if sig == nil || err != nil {
+			return nil, err
+		}
+		return sig, nil
+	case *ast.StructType:
+		vars, tags, err := m.resolveFields(scope, x.Fields)
+		if vars == nil || err != nil {
+			return nil, err
+		}
+		return types.NewStruct(vars, tags), nil
+	case *ast.InterfaceType:
+		var methods []*types.Func
+		var embeddeds []types.Type
+		for _, field := range x.Methods.List {
+			if len(field.Names) == 0 {
+				typ, err := m.resolveType(scope, field.Type)
+				if typ == nil || err != nil {
+					return nil, err
+				}
+				embeddeds = append(embeddeds, typ)
+				continue
+			}
+			sig, err := m.resolveSignature(scope, field.Type.(*ast.FuncType))
+			if sig == nil || err != nil {
+				return nil, err
+			}
+			pkg := m.scopePkg(scope)
+			for _, name := range field.Names {
+				methods = append(methods, types.NewFunc(token.NoPos, pkg, name.Name, sig))
+			}
+		}
+		return types.NewInterfaceType(methods, embeddeds).Complete(), nil
 	case *ast.SelectorExpr:
-		scope = m.findScope(scope, x.X)
+		scope, err := m.findScope(scope, x.X)
+		if err != nil {
+			return nil, err
+		}
 		return m.resolveType(scope, x.Sel)
 	default:
-		panic(fmt.Sprintf("resolveType TODO: %T", x))
+		return nil, fmt.Errorf("unsupported type: %T", x)
+	}
+}
+
+// resolveSignature resolves a func type, which may be variadic.
+func (m *matcher) resolveSignature(scope *types.Scope, ft *ast.FuncType) (*types.Signature, error) {
+	variadic := false
+	if list := ft.Params.List; len(list) > 0 {
+		_, variadic = list[len(list)-1].Type.(*ast.Ellipsis)
+	}
+	params, _, err := m.resolveFields(scope, ft.Params)
+	if params == nil || err != nil {
+		return nil, err
+	}
+	results, _, err := m.resolveFields(scope, ft.Results)
+	if results == nil || err != nil {
+		return nil, err
+	}
+	return types.NewSignature(nil, types.NewTuple(params...),
+		types.NewTuple(results...), variadic), nil
+}
+
+// resolveFields resolves a list of fields, such as a struct's or a func's
+// parameters, with their tags. A nil list is returned if a type isn't found,
+// and an empty list if there are no fields.
+func (m *matcher) resolveFields(scope *types.Scope, fields *ast.FieldList) ([]*types.Var, []string, error) {
+	vars, tags := []*types.Var{}, []string{}
+	if fields == nil {
+		return vars, tags, nil
+	}
+	pkg := m.scopePkg(scope)
+	for _, field := range fields.List {
+		typExpr := field.Type
+		if ell, ok := typExpr.(*ast.Ellipsis); ok {
+			typExpr = &ast.ArrayType{Elt: ell.Elt}
+		}
+		typ, err := m.resolveType(scope, typExpr)
+		if typ == nil || err != nil {
+			return nil, nil, err
+		}
+		tag := ""
+		if field.Tag != nil {
+			tag, _ = strconv.Unquote(field.Tag.Value)
+		}
+		if len(field.Names) == 0 {
+			// an embedded field is named after its type
+			name, under := "", typ
+			if ptr, ok := under.(*types.Pointer); ok {
+				under = ptr.Elem()
+			}
+			switch under := under.(type) {
+			case *types.Named:
+				name = under.Obj().Name()
+			case *types.Basic:
+				name = under.Name()
+			}
+			vars = append(vars, types.NewField(token.NoPos, pkg, name, typ, name != ""))
+			tags = append(tags, tag)
+			continue
+		}
+		for _, name := range field.Names {
+			vars = append(vars, types.NewField(token.NoPos, pkg, name.Name, typ, false))
+			tags = append(tags, tag)
+		}
+	}
+	return vars, tags, nil
+}
+
+// scopePkg returns the package that a scope belongs to, so that unexported
+// names in resolved types can be identical to the ones in it.
+func (m *matcher) scopePkg(scope *types.Scope) *types.Package {
+	for ; scope != nil && scope != types.Universe; scope = scope.Parent() {
+		if scope.Parent() != types.Universe {
+			continue
+		}
+		for _, name := range scope.Names() {
+			if pkg := scope.Lookup(name).Pkg(); pkg != nil {
+				return pkg
+			}
+		}
+	}
+	// no names at the package level, but there might be local ones
+	for _, obj := range m.Info.Defs {
+		if obj != nil && obj.Pkg() != nil {
+			return obj.Pkg()
+		}
 	}
+	return nil
 }
 
-func (m *matcher) findScope(scope *types.Scope, expr ast.Expr) *types.Scope {
+func (m *matcher) findScope(scope *types.Scope, expr ast.Expr) (*types.Scope, error) {
 	switch x := expr.(type) {
 	case *ast.Ident:
 		_, obj := scope.LookupParent(x.Name, token.NoPos)
 		if pkg, ok := obj.(*types.PkgName); ok {
-			return pkg.Imported().Scope()
+			return pkg.Imported().Scope(), nil
+		}
+		if scope := m.loader.importScopes[x.Name]; scope != nil {
+			return scope, nil
 		}
 		// try to fall back to std
 		if m.stdImporter == nil {
@@ -652,11 +1474,11 @@ func (m *matcher) findScope(scope *types.Scope, expr ast.Expr) *types.Scope {
 		}
 		pkg, err := m.stdImporter.Import(path)
 		if err != nil {
-			panic(fmt.Sprintf("findScope err: %v", err))
+			return nil, fmt.Errorf("cannot find package %s: %v", x.Name, err)
 		}
-		return pkg.Scope()
+		return pkg.Scope(), nil
 	default:
-		panic(fmt.Sprintf("findScope TODO: %T", x))
+		return nil, fmt.Errorf("unsupported package in type: %T", x)
 	}
 }
 
@@ -783,6 +1605,39 @@ func maybeNilIdent(x *ast.Ident) ast.Node {
 	return x
 }
 
+func maybeNilBlock(x *ast.BlockStmt) ast.Node {
+	if x == nil {
+		return nil
+	}
+	return x
+}
+
+// spreads reports whether a call pattern matches whether a call spreads a
+// slice as its variadic argument, like f(xs...). A pattern ending in $*name
+// matches either, as the wildcard stands for any arguments, and so do all
+// patterns with ~, which treats f(xs...) like a call with the slice's
+// elements as arguments.
+func (m *matcher) spreads(pattern, call *ast.CallExpr) bool {
+	if bothValid(pattern.Ellipsis, call.Ellipsis) || m.aggressive {
+		return true
+	}
+	if pattern.Ellipsis.IsValid() || len(pattern.Args) == 0 {
+		return false
+	}
+	return m.info(fromWildNode(pattern.Args[len(pattern.Args)-1])).any
+}
+
+// isSpread reports whether a list of arguments ends in the slice spread by
+// a call, like xs in f(a, xs...).
+func (m *matcher) isSpread(list exprList) bool {
+	if len(list) == 0 {
+		return false
+	}
+	last := list[len(list)-1]
+	call, ok := m.parentOf(last).(*ast.CallExpr)
+	return ok && call.Ellipsis.IsValid() && call.Args[len(call.Args)-1] == last
+}
+
 func bothValid(p1, p2 token.Pos) bool {
 	return p1.IsValid() == p2.IsValid()
 }
@@ -861,6 +1716,11 @@ func (m *matcher) nodes(ns1, ns2 nodeList, partial bool) ast.Node {
 		if i1 < ns1len {
 			n1 := ns1.at(i1)
 			id := fromWildNode(n1)
+			if f, ok := n1.(*ast.Field); ok && len(f.Names) == 0 && f.Tag == nil {
+				// $*_ in a list of fields, which would
+				// otherwise be an embedded field
+				id = fromWildNode(f.Type)
+			}
 			info := m.info(id)
 			if info.any {
 				// keep track of where this wildcard
@@ -920,7 +1780,7 @@ func (m *matcher) idents(ids1, ids2 []*ast.Ident) bool {
 	return m.nodesMatch(identList(ids1), identList(ids2))
 }
 
-func toStmtList(nodes ...ast.Node) stmtList {
+func (m *matcher) toStmtList(nodes ...ast.Node) stmtList {
 	var stmts []ast.Stmt
 	for _, node := range nodes {
 		switch x := node.(type) {
@@ -930,7 +1790,7 @@ func toStmtList(nodes ...ast.Node) stmtList {
 		case ast.Expr:
 			stmts = append(stmts, &ast.ExprStmt{X: x})
 		default:
-			panic(fmt.Sprintf("unexpected node type: %T", x))
+			m.failf(x, "unexpected node type: %T", x)
 		}
 	}
 	return stmtList(stmts)
@@ -991,18 +1851,23 @@ func (m *matcher) specs(specs1, specs2 []ast.Spec) bool {
 }
 
 func (m *matcher) fields(fields1, fields2 *ast.FieldList) bool {
-	if fields1 == nil || fields2 == nil {
-		return fields1 == fields2
+	// a missing list, like a func's results, is like an empty one
+	var list1, list2 fieldList
+	if fields1 != nil {
+		list1 = fieldList(fields1.List)
 	}
-	if len(fields1.List) != len(fields2.List) {
-		return false
-	}
-	for i, f1 := range fields1.List {
-		if !m.node(f1, fields2.List[i]) {
-			return false
-		}
+	if fields2 != nil {
+		list2 = fieldList(fields2.List)
 	}
-	return true
+	return m.nodesMatch(list1, list2)
+}
+
+// importPath matches an import path, which may contain wildcards like $path
+// or $(path /regexp/), as in struct tags.
+func (m *matcher) importPath(expr, node *ast.BasicLit) bool {
+	exprPath, err1 := strconv.Unquote(expr.Value)
+	nodePath, err2 := strconv.Unquote(node.Value)
+	return err1 == nil && err2 == nil && m.tagValue(exprPath, nodePath)
 }
 
 func fromWildNode(node ast.Node) int {
@@ -1051,28 +1916,42 @@ type exprList []ast.Expr
 type identList []*ast.Ident
 type stmtList []ast.Stmt
 type specList []ast.Spec
+type fieldList []*ast.Field
+
+// fileList is a package, as the list of its files.
+type fileList []*ast.File
 
 func (l exprList) len() int  { return len(l) }
 func (l identList) len() int { return len(l) }
 func (l stmtList) len() int  { return len(l) }
 func (l specList) len() int  { return len(l) }
+func (l fieldList) len() int { return len(l) }
+func (l fileList) len() int  { return len(l) }
 
 func (l exprList) at(i int) ast.Node  { return l[i] }
 func (l identList) at(i int) ast.Node { return l[i] }
 func (l stmtList) at(i int) ast.Node  { return l[i] }
 func (l specList) at(i int) ast.Node  { return l[i] }
+func (l fieldList) at(i int) ast.Node { return l[i] }
+func (l fileList) at(i int) ast.Node  { return l[i] }
 
 func (l exprList) slice(i, j int) nodeList  { return l[i:j] }
 func (l identList) slice(i, j int) nodeList { return l[i:j] }
 func (l stmtList) slice(i, j int) nodeList  { return l[i:j] }
 func (l specList) slice(i, j int) nodeList  { return l[i:j] }
+func (l fieldList) slice(i, j int) nodeList { return l[i:j] }
+func (l fileList) slice(i, j int) nodeList  { return l[i:j] }
 
 func (l exprList) Pos() token.Pos  { return l[0].Pos() }
 func (l identList) Pos() token.Pos { return l[0].Pos() }
 func (l stmtList) Pos() token.Pos  { return l[0].Pos() }
 func (l specList) Pos() token.Pos  { return l[0].Pos() }
+func (l fieldList) Pos() token.Pos { return l[0].Pos() }
+func (l fileList) Pos() token.Pos  { return l[0].Pos() }
 
 func (l exprList) End() token.Pos  { return l[len(l)-1].End() }
 func (l identList) End() token.Pos { return l[len(l)-1].End() }
 func (l stmtList) End() token.Pos  { return l[len(l)-1].End() }
 func (l specList) End() token.Pos  { return l[len(l)-1].End() }
+func (l fieldList) End() token.Pos { return l[len(l)-1].End() }
+func (l fileList) End() token.Pos  { return l[len(l)-1].End() }
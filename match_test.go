@@ -25,8 +25,10 @@ func TestMatch(t *testing.T) {
 		anyWant interface{}
 	}{
 		// expr tokenize errors
-		{[]string{"-x", "$"}, "a", tokErr(`1:2: $ must be followed by ident, got EOF`)},
-		{[]string{"-x", `"`}, "a", tokErr(`1:1: string literal not terminated`)},
+		{[]string{"-x", "$"}, "a", tokErr("1:2: $ must be followed by ident or number, got EOF\n\t$\n\t ^")},
+		{[]string{"-x", "$ +"}, "a", tokErr("1:3: $ must be followed by ident or number, got +\n\t$ +\n\t  ^")},
+		{[]string{"-x", "$0x1"}, "a", tokErr("1:2: $ must be followed by ident or number, got INT\n\t$0x1\n\t ^")},
+		{[]string{"-x", `"`}, "a", tokErr("1:1: string literal not terminated\n\t\"\n\t^")},
 		{[]string{"-x", ""}, "a", parseErr(`empty source code`)},
 		{[]string{"-x", "\t"}, "a", parseErr(`empty source code`)},
 		{
@@ -61,14 +63,28 @@ func TestMatch(t *testing.T) {
 			[]string{"-x", "$x", "-a", "is(slice) etc"},
 			"a", modErr(`1:11: wanted EOF, got IDENT`),
 		},
+		{
+			[]string{"-x", "$x", "-a", "!docname etc"},
+			"a", modErr(`1:10: wanted EOF, got IDENT`),
+		},
+		{
+			[]string{"-x", "$x", "-a", `comment(".", x)`},
+			"a", modErr(`1:14: wanted a number of lines`),
+		},
+		{
+			[]string{"-x", "$x", "-a", "doc(foo)"},
+			"a", modErr(`1:5: invalid syntax`),
+		},
 
 		// expr parse errors
-		{[]string{"-x", "foo)"}, "a", parseErr(`1:4: expected statement, found ')'`)},
-		{[]string{"-x", "{"}, "a", parseErr(`1:4: expected '}', found 'EOF'`)},
-		{[]string{"-x", "$x)"}, "a", parseErr(`1:3: expected statement, found ')'`)},
-		{[]string{"-x", "$x("}, "a", parseErr(`1:5: expected operand, found '}'`)},
-		{[]string{"-x", "$*x)"}, "a", parseErr(`1:4: expected statement, found ')'`)},
-		{[]string{"-x", "a\n$x)"}, "a", parseErr(`2:3: expected statement, found ')'`)},
+		{[]string{"-x", "foo)"}, "a", parseErr("1:4: expected statement, found ')'\n\tfoo)\n\t   ^ unmatched ')' at 1:4")},
+		{[]string{"-x", "{"}, "a", parseErr("1:2: expected '}', found end of pattern\n\t{\n\t ^ unclosed '{' at 1:1")},
+		{[]string{"-x", "$x)"}, "a", parseErr("1:3: expected statement, found ')'\n\t$x)\n\t  ^ unmatched ')' at 1:3")},
+		{[]string{"-x", "$x("}, "a", parseErr("1:4: expected operand, found end of pattern\n\t$x(\n\t   ^ unclosed '(' at 1:3")},
+		{[]string{"-x", "$*x)"}, "a", parseErr("1:4: expected statement, found ')'\n\t$*x)\n\t   ^ unmatched ')' at 1:4")},
+		{[]string{"-x", "$x + $*x"}, "a", tokErr("1:6: $x and $*x can't be mixed, as it's either one node or any number of them\n\t$x + $*x\n\t     ^")},
+		{[]string{"-x", "f($_, $*_)"}, "f(a, b)", 1},
+		{[]string{"-x", "a\n$x)"}, "a", parseErr("2:3: expected statement, found ')'\n\t$x)\n\t  ^ unmatched ')' at 2:3")},
 
 		// basic lits
 		{[]string{"-x", "123"}, "123", 1},
@@ -120,6 +136,103 @@ func TestMatch(t *testing.T) {
 			[]string{"-x", "$x", "-a", "rx(`.*foo.*`)", "-a", "rx(`.*bar.*`)"},
 			"foobar; barfoo; foo; barbar", 2,
 		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`foo|bar`)"},
+			"foox; xbar; foo", 1,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`^foo$`)"},
+			"foox; foo", 1,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`\\p{Lu}.*`)"},
+			"Ñame; ñame; Name", 2,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`\\pL+`)"},
+			"naïve; naive; _naive", 2,
+		},
+		{
+			[]string{"-x", "$x", "-a", "rx(`\\w+`)"},
+			"naïve; naive", 1,
+		},
+
+		// union of patterns
+		{
+			[]string{"-x", "foo($x)", "-or", "bar($x)"},
+			"foo(1); bar(2); baz(3)", 2,
+		},
+		{
+			[]string{"-x", "foo($_)", "-or", "foo(1)", "-or", "bar()"},
+			"foo(1); foo(2); bar()", 3,
+		},
+		{
+			[]string{"-x", "foo($x)", "-or", "bar($x)", "-g", "1"},
+			"foo(1); bar(1); bar(2)", 2,
+		},
+		{
+			[]string{"-or", "foo"},
+			"foo", wantErr("-or must follow -x, -taint, -concat, or another -or"),
+		},
+
+		// nested matches
+		{[]string{"-x", "g($_)"}, "f(g(g(1)), g(2))", 3},
+		{[]string{"-outermost", "-x", "g($_)"}, "f(g(g(1)), g(2))", 2},
+		{[]string{"-innermost", "-x", "g($_)"}, "f(g(g(1)), g(2))", 2},
+		{[]string{"-outermost", "-x", "g($_)"}, "g(g(1))", "g(g(1))"},
+		{[]string{"-innermost", "-x", "g($_)"}, "g(g(1))", "g(1)"},
+		{[]string{"-innermost", "-all", "-x", "g($_)"}, "g(g(1))", 2},
+		{[]string{"-x", "$x.$*_.Close()"}, "b.Close(); b.Foo(1).Bar().Close(); b.Foo().Flush()", 2},
+		{[]string{"-x", "$x.$*_.Close()"}, "b.Foo(1).Bar.Close()", 1},
+		{[]string{"-x", "b.$*_.Bar()"}, "b.Foo(1).Bar().Close(); c.Bar()", "b.Foo(1).Bar()"},
+		{[]string{"-x", "$x.$*c.Close()", "-s", "$x.$*c.Flush()"}, "b.Foo(1).Bar().Close()", "b.Foo(1).Bar().Flush()"},
+		{[]string{"-x", "$x.$*c.Close()", "-s", "$x.$*c.Flush()"}, "b.Close()", "b.Flush()"},
+		{[]string{"-x", "$x.$*c.A($y.$*c.B())"}, "x.Foo().A(y.Foo().B())", 1},
+		{[]string{"-x", "$x.$*c.A($y.$*c.B())"}, "x.Foo().A(y.Bar().B())", 0},
+		{[]string{"-x", "$pkg.$*_.Port"}, "f(config.Server.HTTP.Port, config.Port, Port)", 2},
+		{[]string{"-x", "$*_.Port"}, "f(config.Server.HTTP.Port, config.Port, Port)", 2},
+		{[]string{"-x", "$*p.Port", "-s", "$*p.Addr"}, "config.Server.Port", "config.Server.Addr"},
+		{[]string{"-x", "$*p.Port", "-s", "$*p.Addr"}, "config.Port", "config.Addr"},
+		{[]string{"-x", "$*p.Port", "-x", "$*p.HTTP"}, "config.Server.HTTP.Port", 0},
+		{[]string{"-x", "$*_ + c + $*_"}, "a + b + c + d", 1},
+		{[]string{"-x", "$*_ + c + $*_"}, "a + b + c; c + d; c; a - c - d", 2},
+		{[]string{"-x", "$*_ + c"}, "a + b + c + d", 0},
+		{[]string{"-x", "$*_ + b"}, "a + b + c; a + (b + c)", 0},
+		{[]string{"-x", "$*_ + b*c + $*_"}, "a + b*c + d; a*b + c", 1},
+		{[]string{"-x", "$*_ && ok && $*_"}, "a && ok && b || c; x || ok", 1},
+		{[]string{"-x", "$*a + c + $*b", "-s", "$*b + c + $*a"}, "a + b + c + d + e", "d + e + c + a + b"},
+		{[]string{"-x", "$*a + c + $*b", "-s", "$*a + $*b"}, "c + d", "d"},
+		{[]string{"-x", "$*a + c + $*b", "-s", "f($*a + 1)"}, "a + b + c", "f(a + b + 1)"},
+		{[]string{"-x", "$*a + c + $*b", "-s", "$*a"}, "a + c", wantErr("$*a matched operands of +, so it must be one too")},
+		{[]string{"-x", "$*a + c + $*b", "-s", "$*a - c"}, "a + c", wantErr("$*a didn't match operands of -")},
+		{[]string{"-x", "$*_ else if b {} else $*_"}, "if a {} else if b {} else {}", 1},
+		{[]string{"-x", "$*_ else if b {} else $*_"}, "if b {}; if a {} else if c {}", 1},
+		{[]string{"-x", "$*_ else if b {} else $*_"}, "if a {} else if c {} else if b {}", 1},
+		{[]string{"-x", "$*_ else if b {}"}, "if a {} else if b {} else {}", 0},
+		{[]string{"-x", "if a {} else $*_"}, "if a {} else if b {} else if c {}; if b {} else if a {}", 1},
+		{[]string{"-x", "$_ else $_ else $*_"}, "if a {}; if a {} else {}; if a {} else if b {} else {}", 2},
+		{[]string{"-x", "$_ else $_ else $_"}, "if a {} else if b {} else if c {} else {}", 0},
+		{[]string{"-x", "$*_ else { $*_ }"}, "if a {} else if b {} else { c() }; if a {} else if b {}", 1},
+		{[]string{"-x", "$*_ else if $*_ { return err } else $*_"}, "if a {} else if err := f(); err != nil { return err }", 1},
+		{[]string{"-x", "if a {} else $x"}, "if a {} else if b {}; if a {} else if b {} else {}", 1},
+		{[]string{"-x", "if a {} else $x", "-s", "$x"}, "if a {} else if b { c() }", "if b { c(); }"},
+		{[]string{"-x", "if a {} else $*x", "-s", "if !a {} else $*x"}, "if a {} else if b {} else { c() }", "if !a { } else if b { } else { c(); }"},
+		{[]string{"-x", "$*x else if b {} else $*y", "-s", "if b {} else $*x else $*y"}, "if a {} else if b {} else if c {}", "if b { } else if a { } else if c { }"},
+		{[]string{"-x", "$*x else if b {} else $*y", "-s", "$*y else $*x"}, "if a {} else if b {} else {}", wantErr("an else block must be the last arm of an if")},
+		{[]string{"-x", "if a {} else $*x", "-s", "$*x"}, "if a {} else if b {}", wantErr("$*x matched arms of an if, so it must be one too")},
+		{[]string{"-x", "$*_(m)"}, "f(m, m[a], (*m)[a][b], *m[a], (m))", 5},
+		{[]string{"-x", "$*_(m)"}, "f(x[m], n[a], m.f, &m)", 3},
+		{[]string{"-x", "$*_(m[$_])"}, "f(m, m[a], (*m)[a], *m[a][b])", 2},
+		{[]string{"-x", "$*_($x[a])", "-x", "$x"}, "(*m)[a][b]", 1},
+		{[]string{"-x", "$*w(m)", "-s", "$*w(n)"}, "(*m)[a][b]", "(*n)[a][b]"},
+		{[]string{"-x", "$*w(m)", "-s", "$*w(n)"}, "m", "n"},
+		{[]string{"-x", "$*w($x[a])", "-s", "$*w(get($x, a))"}, "*m[a][b]", "*get(m, a)[b]"},
+		{[]string{"-x", "f($*w(m), $*w(n))"}, "f(*m, *n); f(m[a], n[b]); f(m[a], n[a])", 2},
+		{[]string{"-x", "$*w(m)", "-s", "$*w"}, "*m", wantErr("$*w matched layers, so it must wrap an expression as in $*w(x)")},
+		{[]string{"-snap", "stmt", "-x", "g($_)"}, "x := g(1) + g(2); y := 3", "x := g(1) + g(2)"},
+		{[]string{"-snap", "stmt", "-x", "g($_)"}, "f(); if g(1) > 0 { g(2) }", 2},
+		{[]string{"-snap", "stmt", "-x", "g($x)", "-s", "h($x)"}, "var y = []int{g(4)}", "h(4)"},
+		{[]string{"-innermost", "-x", "g($_)", "-or", "g(g($_))"}, "g(g(1))", "g(1)"},
 
 		// type equality
 		{
@@ -142,6 +255,62 @@ func TestMatch(t *testing.T) {
 			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type([2]int)"},
 			"package p; var _ = [...]int{1, 2}", 1,
 		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(map[string][]byte)"},
+			"package p; var _ = map[string][]byte{}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(map[string][]int)"},
+			"package p; var _ = map[string][]byte{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(<-chan int)"},
+			"package p; var _ = make(<-chan int)", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(chan int)"},
+			"package p; var _ = make(<-chan int)", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(func(int, ...string) error)"},
+			"package p; var _ = func(n int, s ...string) error { return nil }", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(func(int, []string) error)"},
+			"package p; var _ = func(n int, s ...string) error { return nil }", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(struct{ a, b int })"},
+			"package p; var _ = struct{ a, b int }{}", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type(struct{ a, b int `json:\"b\"` })"},
+			"package p; var _ = struct{ a, b int }{}", 0,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "asgn(interface{ String() string })"},
+			"package p; type T int; func (T) String() string { return \"\" }; var _ = T(0)", 1,
+		},
+		{
+			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "asgn(interface{ String() string })"},
+			"package p; type T int; var _ = T(0)", 0,
+		},
+		{
+			[]string{"-x", "$_, $_ = $x", "-x", "$x", "-a", "type(int)"},
+			"package p; func f(m map[string]int, c chan int) { _, _ = m[\"\"]; _, _ = <-c }", 2,
+		},
+		{
+			[]string{"-x", "$_, $_ = $x", "-x", "$x", "-a", "type(string)"},
+			"package p; func f(i interface{}) { _, _ = i.(string) }", 1,
+		},
+		{
+			[]string{"-x", "$_, $_ = $x", "-x", "$x", "-a", "type(int)"},
+			"package p; func f() (int, error) { _, _ = f(); return 0, nil }", 0,
+		},
+		{
+			[]string{"-x", "$x", "-a", "type(f())"},
+			"package p; var i int", wantErr("unsupported type: *ast.CallExpr"),
+		},
 		{
 			[]string{"-x", "var _ = $x", "-x", "$x", "-a", "type([2]int)"},
 			"package p; var _ = []int{1, 2}", 0,
@@ -364,6 +533,36 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "struct{field $t}"}, "struct{field int}", 1},
 		{[]string{"-x", "struct{field $t}"}, "struct{other int}", 0},
 		{[]string{"-x", "struct{field $t}"}, "struct{f1, f2 int}", 0},
+		{[]string{"-x", "struct{$*_; sync.Mutex; $*_}"}, "struct{a int; sync.Mutex}", 1},
+		{[]string{"-x", "struct{$*_; sync.Mutex; $*_}"}, "struct{mu sync.Mutex}", 0},
+		{[]string{"-x", "struct{$*_; $_ sync.Mutex; $*_}"}, "struct{mu sync.Mutex; b int}", 1},
+		{[]string{"-x", "struct{$*_; $_ sync.Mutex; $*_}"}, "struct{sync.Mutex}", 0},
+		{[]string{"-x", "struct{$*_; *$_}"}, "struct{a int; *T}", 1},
+		{[]string{"-x", "interface{$*_; context.Context; $*_}"}, "interface{context.Context; Foo()}", 1},
+		{[]string{"-x", "interface{$*_; context.Context; $*_}"}, "interface{Ctx() context.Context}", 0},
+		{[]string{"-x", "struct{$*x}", "-s", "struct{$*x; b int}"}, "struct{a int}", "struct { a int; b int; }"},
+		{[]string{"-x", "struct{a int; $*x}", "-s", "struct{$*x}"}, "struct{a int}", "struct { }"},
+		{[]string{"-x", "$x.Error()", "-s", "$x"}, "err.Error()", "err"},
+		{[]string{"-x", "f($*_)"}, "f(); f(a, b); f(xs...); f(a, xs...)", 4},
+		{[]string{"-x", "f($x, $*_)"}, "f(xs...)", 1},
+		{[]string{"-x", "f($*x)", "-s", "g($*x)"}, "f(a, xs...)", "g(a, xs...)"},
+		{[]string{"-x", "f($*x)", "-s", "g($*x)"}, "f(a, b)", "g(a, b)"},
+		{[]string{"-x", "f($x...)", "-s", "g($x...)"}, "f(xs...)", "g(xs...)"},
+		{[]string{"-x", "$1 + $2", "-s", "$2 + $1"}, "a + b", "b + a"},
+		{[]string{"-x", "$1.Error()", "-s", "$1"}, "err.Error()", "err"},
+		{[]string{"-x", "f($x)", "-s", "g($x)"}, "f(\"a\t\tb\")", "g(\"a\t\tb\")"},
+		{[]string{"-x", "$1.imag", "-s", "$1"}, "z.imag", "z"},
+		{[]string{"-x", "$1.pkg", "-s", "$1"}, "x.pkg", "x"},
+		{[]string{"-x", "$1.e5 + $*2.x"}, "a.e5 + b.x", 1},
+		{[]string{"-x", "$1.5"}, "a", tokErr("1:2: $ must be followed by ident or number, got FLOAT\n\t$1.5\n\t ^")},
+		{[]string{"-x", "$1 + $1"}, "a + b; c + c", 1},
+		{[]string{"-x", "f($*1)", "-s", "g($*1)"}, "f(a, b)", "g(a, b)"},
+		{[]string{"-x", "map[$k]struct{$*_}"}, "map[string]struct{a, b int; c T}", 1},
+		{[]string{"-x", "func($*_) $*_"}, "func() {}", 1},
+		{[]string{"-x", "func($_, $_, $*_) $*_"}, "func(f func(int, int) error, g func(int))", 1},
+		{[]string{"-x", "struct{$*_}", "-a", "anon"}, "package p; type T struct{}; var x struct{}", 1},
+		{[]string{"-x", "func($*_) $*_", "-a", "anon"}, "package p; func f(g func()) { _ = func() {} }", "func()"},
+		{[]string{"-x", "func($*_) $*_", "-a", "anon"}, "package p; type I interface { F() }; type F func()", 0},
 		{[]string{"-x", "interface{$x() int}"}, "interface{i() int}", 1},
 		{[]string{"-x", "chan $x"}, "chan bool", 1},
 		{[]string{"-x", "<-chan $x"}, "chan bool", 0},
@@ -438,6 +637,21 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "$x := $_; $x = $_"}, "a := n; b := n; b = m", "b := n; b = m"},
 		{[]string{"-x", "$x := $_; $*_; $x = $_"}, "a := n; b := n; b = m", "b := n; b = m"},
 
+		// anchored statements
+		{[]string{"-x", "^; b; c"}, "a; b; c; d", 0},
+		{[]string{"-x", "^; a; b"}, "a; b; c; d", "a; b"},
+		{[]string{"-x", "^\na"}, "{a; b}; {b; a}", "a"},
+		{[]string{"-x", "c; d; $"}, "a; b; c; d", "c; d"},
+		{[]string{"-x", "b; c; $"}, "a; b; c; d", 0},
+		{[]string{"-x", "^; b; $"}, "{b}; {b; b}", 1},
+		{[]string{"-x", "^; $x; $*_; $x; $"}, "{a; b; a}; {a; b}", 1},
+		{[]string{"-x", "{ ^; a }"}, "{a}", parseErr("1:4: expected operand, found ';'\n\t{ ^; a }\n\t   ^")},
+		{[]string{"-x", "^; func f() {}"}, "a", wantErr("anchors only apply to statements, not *ast.FuncDecl")},
+		{[]string{"-x", "^b"}, "^b; b", "^b"},
+		{[]string{"-x", "b $"}, "a; b", tokErr("1:4: $ must be followed by ident or number, got ;\n\tb $\n\t   ^")},
+		{[]string{"-x", "^; $"}, "a", tokErr("1:5: $ must be followed by ident or number, got EOF\n\t^; $\n\t    ^")},
+		{[]string{"-x", "if a { b; $ }"}, "a", tokErr("1:13: $ must be followed by ident or number, got }\n\tif a { b; $ }\n\t            ^")},
+
 		// mixing lists
 		{[]string{"-x", "$x, $y"}, "1; 2", 0},
 		{[]string{"-x", "$x; $y"}, "1, 2", 0},
@@ -457,6 +671,37 @@ func TestMatch(t *testing.T) {
 			[]string{"-x", "func $_($x $y) $y { return $x }"},
 			"func a(i int) int { return i }", 1,
 		},
+		{[]string{"-x", "func $_() {}"}, "func a()", 0},
+		{[]string{"-x", "func $_()"}, "func a() {}", 0},
+		{[]string{"-x", "func $_()"}, "func a()", 1},
+		{[]string{"-x", "type $x int"}, "type a int", 1},
+		{[]string{"-x", "type $x int"}, "type (a int)", 1},
+		{[]string{"-x", "type $x int"}, "type (a int; b uint)", 0},
+		{[]string{"-x", "type $x = int"}, "type a int", 0},
+		{[]string{"-x", "type $x $_"}, "type a = int", 0},
+
+		// struct fields and tags
+		{[]string{"-x", "struct{ $_ int }"}, "struct{ a int `json:\"a\"` }", 1},
+		{[]string{"-x", "$_ $_ `json:\"$_\"`"}, "type T struct { A int `json:\"a\"`; B int }", 1},
+		{
+			[]string{"-x", "$_ $_ `json:\"$_,omitempty\"`"},
+			"type T struct { A int `json:\"a,omitempty\" xml:\"b\"`; B int `json:\"b\"` }", 1,
+		},
+		{
+			[]string{"-x", "$n $_ `json:\"$n\"`"},
+			"type T struct { A int `json:\"A\"`; B int `json:\"b\"` }",
+			"A int `json:\"A\"`",
+		},
+		{
+			[]string{"-x", "$_ $_ `json:\"$t\" xml:\"$t\"`"},
+			"type T struct { A int `json:\"a\" xml:\"a\"`; B int `json:\"b\" xml:\"c\"` }", 1,
+		},
+		{
+			[]string{"-x", "$_ $_ `json:\"$(_ /[a-z]+_[a-z]+/)\"`"},
+			"type T struct { A int `json:\"foo_bar\"`; B int `json:\"fooBar\"` }", 1,
+		},
+		{[]string{"-x", "$_ $_ `yaml:\"$_\"`"}, "type T struct { A int `json:\"a\"` }", 0},
+		{[]string{"-x", "$_ $_ `foo`"}, "type T struct { A int `foo`; B int `bar` }", 1},
 
 		// value specs
 		{[]string{"-x", "$_ int"}, "var a int", 1},
@@ -467,8 +712,128 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "$_ int"}, "func(i int) { println(i) }", 0},
 
 		// entire files
-		{[]string{"-x", "package $_"}, "package p; var a = 1", 0},
+		{[]string{"-x", "package $_"}, "package p; var a = 1", 1},
+		{[]string{"-x", "package q"}, "package p; var a = 1", 0},
+		{[]string{"-x", "package $_", "-a", `rx("p.*")`}, "package p_test", 1},
+		{[]string{"-x", "package $_", "-a", `rx("q.*")`}, "package p_test", 0},
+		{[]string{"-x", "package $_", "-a", "dirname"}, "package p", 0},
 		{[]string{"-x", "package $_; func Foo() { $*_ }"}, "package p; func Foo() {}", 1},
+		{[]string{"-x", `import "$p"`}, `package p; import ("a"; "b")`, 2},
+		{[]string{"-x", `import _ "$p"`}, `package p; import ("a"; _ "b")`, `_ "b"`},
+		{[]string{"-x", `import $x "$(_ /a.*/)"`}, `package p; import (a "ab"; b "ba")`, `a "ab"`},
+		{[]string{"-x", `import $*_ "a"`}, `package p; import (a "a"; "a")`, 2},
+		{[]string{"-x", `import ("a")`}, `package p; import ("a"; "b")`, 0},
+		{[]string{"-x", "func $_() {}", "-file"}, "package p; func a() {}; func b() {}", 1},
+		{[]string{"-x", "func $_() {}", "-pkg"}, "package p; func a() {}; func b() {}", "package p"},
+		{[]string{"-a", "count(func $_() {}) != 2"}, "package p; func a() {}; func b() {}", 0},
+		{[]string{"-a", "count(func $_() {}) <= 2"}, "package p; func a() {}; func b() {}", 1},
+		{[]string{"-a", "count($x) 1"}, "package p", modErr(`1:11: wanted a comparison, got INT`)},
+		{[]string{"-a", "count($x) = 1"}, "package p", modErr(`1:11: wanted a comparison, got =`)},
+		{[]string{"-x", "return", "-a", "depth(loop) > 1"}, "for { return; for { return } }", 1},
+		{[]string{"-x", "return", "-a", "depth(block) == 2"}, "for { if a { return }; switch { default: return } }", 2},
+		{[]string{"-x", "return", "-a", "depth(loop) > 0"}, "for { func() { return }() }", 0},
+		{[]string{"-x", "func() {}", "-a", "depth(func) >= 2"}, "func() { func() { func() {} } }", 1},
+		{[]string{"-a", "depth(foo) > 1"}, "package p", modErr(`1:7: unknown depth kind: "foo"`)},
+		{[]string{"-a", "depth(if) > -1"}, "package p", modErr(`1:13: wanted a depth`)},
+		{[]string{"-x", "$l: $_;"}, "a: for {}; b: {}", 2},
+		{[]string{"-x", "break $*_"}, "for { break }; a: for { break a }", 2},
+		{[]string{"-x", "$l: $_;", "-a", "targeted"}, "a: for { break a }; b: for { break }", 1},
+		{[]string{"-x", "$l: $_;", "-a", "!targeted"}, "a: for { break a }; b: for { break }", 1},
+		{[]string{"-x", "$l: $_;", "-a", "targeted"}, "goto a; a: {}", 1},
+		{[]string{"-x", "$l: $_;", "-a", "targeted"}, "a: for { func() { goto a }() }", 0},
+		{[]string{"-x", "$_", "-a", "targeted"}, "foo", 0},
+		{[]string{"-x", "$x", "-a", "entropy > 4"}, `f("AKIAIOSFODNN7EXAMPLEwJalrXUtnFEMI", "hello world")`, `"AKIAIOSFODNN7EXAMPLEwJalrXUtnFEMI"`},
+		{[]string{"-x", "$x", "-a", "entropy < 1"}, `f("aaaa", "ab", aaaa)`, `"aaaa"`},
+		{[]string{"-x", "$x", "-a", "entropy >= 1.5"}, `f("aaaa", "abc")`, `"abc"`},
+		{[]string{"-x", "$x", "-a", "entropy > x"}, "foo", modErr(`1:11: wanted a number of bits`)},
+		{[]string{"-x", "$x", "-a", "base64"}, `f("9f86d081884c7d659a2feaa0c55ad015", "aaaaaaaaaaaaaaaaaaaa")`, `"9f86d081884c7d659a2feaa0c55ad015"`},
+		{[]string{"-x", "$x", "-a", "base64"}, `f("c2VjcmV0IGtleSAxMjM0NQ==", "1234567890123456", "not base64 at all!")`, `"c2VjcmV0IGtleSAxMjM0NQ=="`},
+
+		// objects by package path
+		{
+			[]string{"-x", "$_.$_", "-a", `obj("io.EOF", "os")`},
+			`package p; import ("io"; o "os"); var _, _, _ = io.EOF, io.SeekEnd, o.Args`, 2,
+		},
+		{
+			[]string{"-x", "$_.io", "-a", `obj("io")`},
+			`package p; import "io"; type t struct{ io int }; var _, _ = t{}.io, io.EOF`, 0,
+		},
+		{[]string{"-x", "$x", "-a", "obj(io)"}, "foo", modErr(`1:5: wanted a quoted path`)},
+		{[]string{"-x", "$x", "-a", "constarg(x)"}, "foo", modErr(`1:10: wanted a constant`)},
+		{[]string{"-x", "$x", "-a", "constarg(-nil)"}, "foo", modErr(`1:11: wanted a constant`)},
+
+		// loop variables captured by goroutines
+		{
+			[]string{"-x", "go $f()", "-a", "loopvar"},
+			`package p; func f(xs []int) { for _, x := range xs { go func() { println(x) }(); go func() { x := 1; println(x) }() } }`,
+			`go func() { println(x); }()`,
+		},
+		{
+			[]string{"-x", "func() { $*_ }", "-a", "loopvar"},
+			`package p; func f() { x := 0; for { go func() { println(x) }() } }`, 0,
+		},
+
+		// contexts not propagated
+		{
+			[]string{"-x", "$_($*_)", "-a", "dropsctx"},
+			`package p; import "context"; func g(context.Context, int) {}; func f(ctx context.Context) { g(ctx, 1); g(context.TODO(), 2); func() { g(context.Background(), 3) }() }`,
+			2,
+		},
+		{
+			[]string{"-x", "$_($*_)", "-a", "dropsctx"},
+			`package p; import "context"; func g(context.Context) {}; func f(_ context.Context) { g(context.Background()) }; func h() { g(context.TODO()) }`,
+			0,
+		},
+
+		// type sizes and alignments
+		{
+			[]string{"-x", "$_.Put($x)", "-x", "$x", "-a", "sizeof > 16"},
+			`package p; type pool struct{}; func (pool) Put(interface{}) {}; func f(p pool) { var a [4]int64; var b [2]int32; p.Put(a); p.Put(b); p.Put(&a) }`,
+			"a",
+		},
+		{
+			[]string{"-x", "type $t $_", "-x", "$t", "-a", "alignof == 4"},
+			`package p; type a struct{ x, y int32 }; type b struct{ x byte }; type c [3]int32`,
+			2,
+		},
+		{
+			[]string{"-x", "var $x $_", "-x", "$x", "-a", "sizeof == 2"},
+			`package p; var x int16; var y int32; var z = 2`,
+			"x",
+		},
+		{
+			[]string{"-x", "type $t $_", "-x", "$t", "-a", "sizeof < 1"},
+			`package p; type e struct{}; type g[T any] struct{ t T }`,
+			"e",
+		},
+		{[]string{"-x", "$x", "-a", "sizeof > x"}, "foo", modErr(`1:10: wanted a number of bytes`)},
+
+		// printf formats
+		{
+			[]string{"-x", "logf($*_)", "-a", "badformat"},
+			`package p; func logf(format string, args ...interface{}) {}; func f() { logf("%d %s", 1, "a"); logf("%d", "a") }`,
+			`logf("%d", "a")`,
+		},
+		{
+			[]string{"-x", "logf($*_)", "-a", "badformat"},
+			`package p; func logf(format string, args ...interface{}) {}; func f() { logf("%d%%", 1); logf("%d %d", 1); logf("%d", 1, 2) }`,
+			2,
+		},
+		{
+			[]string{"-x", "$_.Infof($*_)", "-a", "badformat"},
+			`package p; type L struct{}; func (L) Infof(n int, format string, args ...interface{}) {}; func f(l L, e error, p *struct{ s string }) { l.Infof(1, "%s %v %q", e, nil, p); l.Infof(2, "%*d %x", 3, 4, []byte{}) }`,
+			0,
+		},
+		{
+			[]string{"-x", "$_.Infof($*_)", "-a", "badformat"},
+			`package p; type L struct{}; func (L) Infof(n int, format string, args ...interface{}) {}; func f(l L, b bool, c chan int) { l.Infof(1, "%t %s", b, c); l.Infof(2, "%z", 3) }`,
+			2,
+		},
+		{
+			[]string{"-x", "logf($*_)", "-a", "badformat"},
+			`package p; func logf(format string, args ...interface{}) {}; func f(s string, xs []interface{}) { logf(s, 1); logf("%d", xs...) }`,
+			0,
+		},
 
 		// blocks
 		{[]string{"-x", "{ $x }"}, "{ a() }", 1},
@@ -477,6 +842,13 @@ func TestMatch(t *testing.T) {
 		// assigns
 		{[]string{"-x", "$x = $y"}, "a = b", 1},
 		{[]string{"-x", "$x := $y"}, "a, b := c()", 0},
+		{[]string{"-x", "$x, $y := $f($*_)"}, "a, err := c(d)", 1},
+		{[]string{"-x", "$x, $y := $f($*_)"}, "a, b := c, d", 0},
+		{[]string{"-x", "$x, $ok := $m[$k]"}, "v, ok := m[k]", 1},
+		{[]string{"-x", "$x, $ok := <-$c"}, "v, ok := <-ch", 1},
+		{[]string{"-x", "$x, $ok := $i.($T)"}, "v, ok := i.(T)", 1},
+		{[]string{"-x", "$*lhs = $rhs"}, "a, b = c(); d = e; f, g = h, i", 2},
+		{[]string{"-x", "$*_, $err := $_"}, "a, b, err := c()", 1},
 
 		// if stmts
 		{[]string{"-x", "if $x != nil { $y }"}, "if p != nil { p.foo() }", 1},
@@ -628,6 +1000,19 @@ func TestMatch(t *testing.T) {
 		{[]string{"-x", "a := b"}, "a = b; a := b", 1},
 		{[]string{"-x", "~ a = b"}, "a = b; a := b; var a = b", 3},
 		{[]string{"-x", "~ a := b"}, "a = b; a := b; var a = b", 3},
+		{[]string{"-x", "f($x)"}, "f(a); f(xs...)", 1},
+		{[]string{"-x", "~ f($x)"}, "f(a); f(xs...)", 2},
+		{[]string{"-x", "~ f($x...)"}, "f(a); f(xs...); f(a, b)", 2},
+		{[]string{"-x", "g(f)"}, "g(f); g(func(x int) { f(x) })", 1},
+		{[]string{"-x", "~ g(f)"}, "g(f); g(func(x int) { f(x) })", 2},
+		{[]string{"-x", "~ g(s.f)"}, "g(s.f); g(func(x, y int) int { return s.f(x, y) })", 2},
+		{[]string{"-x", "~ g(f)"}, "g(func(x, y int) { f(y, x) }); g(func(x int) int { f(x) })", 0},
+		{[]string{"-x", "~ g(f)"}, "g(func(x int) { f(x, x) }); g(func(_ int) { f() })", 0},
+		{[]string{"-x", "~ g(f)"}, "g(func(xs ...int) { f(xs...) }); g(func(xs ...int) { f(xs) })", 1},
+		{[]string{"-x", "~ g(x.f)"}, "g(func(x int) { x.f(x) }); g(func(x int) { h().f(x) })", 0},
+		{[]string{"-x", "~ g(func(x T) { f(x) })"}, "g(f); g(s.f); g(func(y T) { f(y) })", 1},
+		{[]string{"-x", "~ g(func($x T) { $f($x) })"}, "g(f); g(func(y T) { f(y) })", 2},
+		{[]string{"-x", "~ g($f)", "-s", "h($f)"}, "g(func(x int) { f(x) })", "h(func(x int) { f(x); })"},
 
 		// many cmds
 		{
@@ -761,7 +1146,7 @@ func grepTest(t *testing.T, args interface{}, src string, anyWant interface{}) {
 	terr := func(format string, a ...interface{}) {
 		t.Errorf("%v | %s: %s", args, src, fmt.Sprintf(format, a...))
 	}
-	m := matcher{}
+	m := matcher{notes: make(map[nodePosHash]string)}
 	cmds, paths, err := m.parseCmds(strs)
 	if len(paths) > 0 {
 		t.Fatalf("non-zero paths: %v", paths)
@@ -786,7 +1171,10 @@ func grepTest(t *testing.T, args interface{}, src string, anyWant interface{}) {
 		}
 	}
 	m.loader.fset = emptyFset
-	matches := m.matches(cmds, []ast.Node{srcNode})
+	var matches []ast.Node
+	if err == nil {
+		matches, err = m.matches(cmds, []ast.Node{srcNode})
+	}
 	switch want := anyWant.(type) {
 	case wantErr:
 		if err == nil {
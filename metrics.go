@@ -0,0 +1,128 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the buckets of the match latency
+// histogram, in seconds, as in the Prometheus client libraries.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// serveMetrics records what "gogrep serve" has done, to be exposed in the
+// Prometheus text format via -metrics. It's safe to use from many
+// goroutines, as the metrics are scraped while requests are served.
+type serveMetrics struct {
+	mu sync.Mutex
+
+	// the requests served, by method and outcome, which is "ok" or
+	// "error"
+	requests map[[2]string]int
+
+	// the packages loaded and kept for the queries, how many queries were
+	// answered from them, and how many times they had to be loaded
+	packages int
+	hits     int
+	misses   int
+
+	// the histogram of how long matching took for each query, with a
+	// count per bucket, plus the sum and count of all observations
+	buckets []int
+	sum     float64
+	count   int
+}
+
+func newServeMetrics() *serveMetrics {
+	return &serveMetrics{
+		requests: make(map[[2]string]int),
+		buckets:  make([]int, len(latencyBuckets)),
+	}
+}
+
+// request records a request served. Unknown methods are recorded together,
+// so that bad requests can't add any number of series.
+func (s *serveMetrics) request(method string, ok bool) {
+	switch method {
+	case "loadTargets", "query", "rewritePreview":
+	default:
+		method = "unknown"
+	}
+	outcome := "ok"
+	if !ok {
+		outcome = "error"
+	}
+	s.mu.Lock()
+	s.requests[[2]string{method, outcome}]++
+	s.mu.Unlock()
+}
+
+// loaded records that the packages were loaded, which is a cache miss.
+func (s *serveMetrics) loaded(pkgs int) {
+	s.mu.Lock()
+	s.packages = pkgs
+	s.misses++
+	s.mu.Unlock()
+}
+
+// matched records a query answered from the loaded packages, which is a
+// cache hit, along with how long matching them took.
+func (s *serveMetrics) matched(took time.Duration) {
+	secs := took.Seconds()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits++
+	for i, bound := range latencyBuckets {
+		if secs <= bound {
+			s.buckets[i]++
+		}
+	}
+	s.sum += secs
+	s.count++
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format. The cache hit
+// rate is the ratio of hits to hits plus misses.
+func (s *serveMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("gogrep_requests_total", "counter", "Requests served, by method and outcome.")
+	keys := make([][2]string, 0, len(s.requests))
+	for key := range s.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "gogrep_requests_total{method=%q,outcome=%q} %d\n",
+			key[0], key[1], s.requests[key])
+	}
+	metric("gogrep_packages_loaded", "gauge", "Packages loaded and kept for the queries.")
+	fmt.Fprintf(w, "gogrep_packages_loaded %d\n", s.packages)
+	metric("gogrep_cache_hits_total", "counter", "Queries answered from the loaded packages.")
+	fmt.Fprintf(w, "gogrep_cache_hits_total %d\n", s.hits)
+	metric("gogrep_cache_misses_total", "counter", "Times the packages were loaded, by loadTargets or after substitutions.")
+	fmt.Fprintf(w, "gogrep_cache_misses_total %d\n", s.misses)
+	metric("gogrep_match_duration_seconds", "histogram", "How long matching the loaded packages took per query.")
+	for i, bound := range latencyBuckets {
+		fmt.Fprintf(w, "gogrep_match_duration_seconds_bucket{le=%q} %d\n",
+			strconv.FormatFloat(bound, 'g', -1, 64), s.buckets[i])
+	}
+	fmt.Fprintf(w, "gogrep_match_duration_seconds_bucket{le=\"+Inf\"} %d\n", s.count)
+	fmt.Fprintf(w, "gogrep_match_duration_seconds_sum %g\n", s.sum)
+	fmt.Fprintf(w, "gogrep_match_duration_seconds_count %d\n", s.count)
+}
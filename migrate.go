@@ -0,0 +1,334 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// migrationsFile is the format of a migrations file, encoded as JSON.
+type migrationsFile struct {
+	Migrations []migration `json:"migrations"`
+}
+
+// migration is a rules file to apply when a module required in go.mod is
+// upgraded, such as from "<v2" to ">=v2".
+type migration struct {
+	Name string `json:"name"`
+
+	// Module is the path of the module, such as "example.com/foo/v2".
+	Module string `json:"module"`
+
+	// From and To are the version constraints that the module's old and
+	// new versions must meet, such as "<v2" and ">=v2.0.0,<v3". Empty
+	// constraints are met by any version, and an empty From is also met
+	// if the module wasn't required before.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Rules is the rules file to apply, relative to the migrations file.
+	// Rules with a -s command are rewritten, and the matches of the rest
+	// are reported to be migrated by hand.
+	Rules string `json:"rules"`
+}
+
+// migrateArgs implements "gogrep migrate [-from rev] migrations [packages]",
+// which compares the module versions required in go.mod with those at a git
+// revision, defaulting to HEAD, and applies the migrations for the modules
+// that were upgraded or downgraded. The revision may also be an old go.mod
+// file.
+func (m *matcher) migrateArgs(args []string) error {
+	const usage = "usage: gogrep migrate [-from rev] migrations [packages]"
+	flagSet := flag.NewFlagSet("gogrep migrate", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	from := flagSet.String("from", "HEAD", "")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() < 1 {
+		return fmt.Errorf(usage)
+	}
+	path, pkgs := flagSet.Arg(0), flagSet.Args()[1:]
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var file migrationsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for _, mg := range file.Migrations {
+		if mg.Name == "" || mg.Module == "" || mg.Rules == "" {
+			return fmt.Errorf("%s: migrations need a name, a module, and rules", path)
+		}
+		for _, cons := range []string{mg.From, mg.To} {
+			if _, err := parseConstraint(cons); err != nil {
+				return fmt.Errorf("%s: migration %q: %v", path, mg.Name, err)
+			}
+		}
+	}
+	newMod, err := ioutil.ReadFile("go.mod")
+	if err != nil {
+		return err
+	}
+	oldMod, err := oldGoMod(*from)
+	if err != nil {
+		return err
+	}
+	oldReqs, newReqs := goModRequires(oldMod), goModRequires(newMod)
+	for _, mg := range file.Migrations {
+		oldVer, newVer := oldReqs[mg.Module], newReqs[mg.Module]
+		if newVer == "" || oldVer == newVer {
+			continue
+		}
+		if oldVer == "" && mg.From != "" {
+			continue
+		}
+		if !meetsConstraint(oldVer, mg.From) || !meetsConstraint(newVer, mg.To) {
+			continue
+		}
+		if oldVer == "" {
+			oldVer = "none"
+		}
+		fmt.Fprintf(m.out, "%s: %s %s => %s\n", mg.Name, mg.Module, oldVer, newVer)
+		rulesPath := filepath.Join(filepath.Dir(path), mg.Rules)
+		rules, err := m.loadRules(rulesPath)
+		if err != nil {
+			return err
+		}
+		for _, r := range rules {
+			substs := false
+			for _, cmd := range r.cmds {
+				substs = substs || cmd.name == "s"
+			}
+			if !substs {
+				continue
+			}
+			pipeline := r.Pipeline[:len(r.Pipeline):len(r.Pipeline)]
+			if err := m.rewriteArgs(append(pipeline, pkgs...)); err != nil {
+				return fmt.Errorf("%s: rule %q: %v", rulesPath, r.Name, err)
+			}
+		}
+		// report what's left to migrate by hand
+		if err := m.searchArgs(append([]string{"-rules", rulesPath}, pkgs...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// oldGoMod returns the go.mod file in the current directory at a git
+// revision, or the contents of a file if rev is one.
+func oldGoMod(rev string) ([]byte, error) {
+	if info, err := os.Stat(rev); err == nil && !info.IsDir() {
+		return ioutil.ReadFile(rev)
+	}
+	cmd := exec.Command("git", "show", rev+":./go.mod")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git show %s:./go.mod: %v: %s", rev, err,
+			strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// goModRequires returns the versions of the modules required by a go.mod
+// file, by module path.
+func goModRequires(data []byte) map[string]string {
+	reqs := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	inBlock := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !inBlock:
+			continue
+		}
+		if len(fields) == 2 {
+			path, err := strconv.Unquote(fields[0])
+			if err != nil {
+				path = fields[0]
+			}
+			reqs[path] = fields[1]
+		}
+	}
+	return reqs
+}
+
+// versionCmp is a comparison of a version constraint, like ">=v1.2.0".
+type versionCmp struct {
+	op      string
+	version string
+}
+
+// parseConstraint parses a constraint made of comma-separated comparisons,
+// each with one of the operators "<", "<=", ">", ">=", and "=". A version
+// without an operator must be equal.
+func parseConstraint(s string) ([]versionCmp, error) {
+	var cmps []versionCmp
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		op := "="
+		for _, prefix := range []string{"<=", ">=", "<", ">", "="} {
+			if strings.HasPrefix(part, prefix) {
+				op, part = prefix, strings.TrimSpace(part[len(prefix):])
+				break
+			}
+		}
+		if _, ok := parseVersion(part); !ok {
+			return nil, fmt.Errorf("invalid version %q in constraint %q", part, s)
+		}
+		cmps = append(cmps, versionCmp{op, part})
+	}
+	return cmps, nil
+}
+
+// meetsConstraint reports whether a version meets a constraint, which was
+// already checked to be valid.
+func meetsConstraint(version, constraint string) bool {
+	cmps, _ := parseConstraint(constraint)
+	for _, cmp := range cmps {
+		c := compareVersions(version, cmp.version)
+		var ok bool
+		switch cmp.op {
+		case "<":
+			ok = c < 0
+		case "<=":
+			ok = c <= 0
+		case ">":
+			ok = c > 0
+		case ">=":
+			ok = c >= 0
+		default:
+			ok = c == 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// semVersion is a parsed semantic version, such as v1.2.3-pre.1.
+type semVersion struct {
+	nums [3]int
+	pre  string
+}
+
+// parseVersion parses a semantic version with a "v" prefix. Missing minor
+// and patch numbers are zero, so "v2" is the same as "v2.0.0". Build
+// metadata is ignored.
+func parseVersion(s string) (semVersion, bool) {
+	var v semVersion
+	if !strings.HasPrefix(s, "v") {
+		return v, false
+	}
+	s = s[1:]
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s, v.pre = s[:i], s[i+1:]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.nums[i] = n
+	}
+	return v, true
+}
+
+// compareVersions returns -1, 0, or 1 depending on whether a is lower than,
+// equal to, or higher than b, following semantic versioning. Invalid
+// versions are lower than any valid one.
+func compareVersions(a, b string) int {
+	va, okA := parseVersion(a)
+	vb, okB := parseVersion(b)
+	switch {
+	case !okA && !okB:
+		return 0
+	case !okA:
+		return -1
+	case !okB:
+		return 1
+	}
+	for i := range va.nums {
+		if c := compareInts(va.nums[i], vb.nums[i]); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case va.pre == vb.pre:
+		return 0
+	case va.pre == "":
+		return 1 // a release is higher than its pre-releases
+	case vb.pre == "":
+		return -1
+	}
+	idsA, idsB := strings.Split(va.pre, "."), strings.Split(vb.pre, ".")
+	for i := 0; i < len(idsA) && i < len(idsB); i++ {
+		na, errA := strconv.Atoi(idsA[i])
+		nb, errB := strconv.Atoi(idsB[i])
+		var c int
+		switch {
+		case errA == nil && errB == nil:
+			c = compareInts(na, nb)
+		case errA == nil:
+			c = -1 // numeric identifiers are lower
+		case errB == nil:
+			c = 1
+		default:
+			c = strings.Compare(idsA[i], idsB[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(idsA), len(idsB))
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// moduleRoot returns the directory of the go.mod file of the module that
+// contains a directory, or "" if there is none.
+func moduleRoot(dir string) string {
+	for {
+		if info, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil && !info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// inWorkspace reports whether a directory is within a go.work workspace, in
+// which case the go command already knows about all of its modules.
+func inWorkspace(dir string) bool {
+	switch os.Getenv("GOWORK") {
+	case "off":
+		return false
+	case "":
+	default:
+		return true
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.work")); err == nil {
+			return true
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// moduleGroups groups the packages by the root of the module containing
+// each of them, such as in a monorepo with many go.mod files and no go.work
+// file. Packages given as import paths go with the module of the working
+// directory, as the go command resolves them from there. If all packages
+// are in the working directory's module, it returns nil.
+func (l *nodeLoader) moduleGroups(paths []string) map[string][]string {
+	if os.Getenv("GO111MODULE") == "off" || len(l.targets) > 0 || inWorkspace(l.wd) {
+		return nil
+	}
+	wdRoot := moduleRoot(l.wd)
+	groups := make(map[string][]string)
+	for _, path := range paths {
+		root := wdRoot
+		if isLocalPattern(path) {
+			root = moduleRoot(l.pkgDir(path))
+		}
+		groups[root] = append(groups[root], path)
+	}
+	if len(groups) < 2 && groups[wdRoot] != nil {
+		return nil
+	}
+	return groups
+}
+
+// loadModules loads the packages in each of the modules on their own, via
+// load, when they are in more than one module. The go command resolves the
+// imports of each group from its module's root, instead of failing to find
+// the imports of the modules other than the working directory's. It reports
+// whether the packages were loaded, and the results are gathered as if they
+// had all been loaded at once, except for the type-checked programs of many
+// modules, which can't be joined.
+func (l *nodeLoader) loadModules(paths []string, recurse bool,
+	load func(*nodeLoader, []string, bool) ([]loadPkg, error)) ([]loadPkg, bool, error) {
+	if l.module != "" {
+		return nil, false, nil // already loading a single module
+	}
+	groups := l.moduleGroups(paths)
+	if groups == nil {
+		return nil, false, nil
+	}
+	roots := make([]string, 0, len(groups))
+	for root := range groups {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	l.modules = len(roots)
+	l.importScopes = nil
+	var pkgs []loadPkg
+	for _, root := range roots {
+		ctx := *l.ctx
+		ctx.Dir = root
+		if root == "" {
+			ctx.Dir = l.wd // outside of any module
+		}
+		sub := &nodeLoader{
+			wd: l.wd, ctx: &ctx, fset: l.fset,
+			imports: l.imports, strict: l.strict, shard: l.shard,
+			skips: l.skips, module: ctx.Dir,
+		}
+		modPkgs, err := load(sub, groups[root], recurse)
+		if err != nil {
+			return nil, true, err
+		}
+		pkgs = append(pkgs, modPkgs...)
+		l.errs = append(l.errs, sub.errs...)
+		l.files = append(l.files, sub.files...)
+		l.matched = append(l.matched, sub.matched...)
+		for name := range sub.degraded {
+			if l.degraded == nil {
+				l.degraded = make(map[string]bool)
+			}
+			l.degraded[name] = true
+		}
+		l.skipped = append(l.skipped, sub.skipped...)
+		if l.importScopes == nil {
+			l.importScopes = make(map[string]*types.Scope)
+		}
+		for name, scope := range sub.importScopes {
+			if _, ok := l.importScopes[name]; !ok {
+				l.importScopes[name] = scope
+			}
+		}
+		if len(roots) == 1 {
+			l.prog = sub.prog // a single program, like when loaded at once
+		}
+	}
+	return pkgs, true, nil
+}
+
+// wholeProgramCmd returns the first of the commands, or of those of the
+// rules, which needs all the loaded packages at once, such as to find the
+// references to an object or to build the SSA form. Such commands can't be
+// run when the packages were loaded one module or one batch at a time. If
+// none does, it returns nil.
+func (m *matcher) wholeProgramCmd(cmds []exprCmd) *exprCmd {
+	all := cmds[:len(cmds):len(cmds)]
+	for _, r := range m.rules {
+		all = append(all, r.cmds...)
+	}
+	for i, cmd := range all {
+		switch cmd.name {
+		case "refs", "def", "uses", "impls",
+			"callers", "callees", "reach", "taint", "concat":
+			return &all[i]
+		case "a":
+			if needsProgram(cmd.value) || needsAllPkgs(cmd.value) {
+				return &all[i]
+			}
+		}
+	}
+	return nil
+}
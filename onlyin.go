@@ -0,0 +1,112 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// onlyInContexts lists the contexts which -only-in and -not-in accept.
+var onlyInContexts = []string{"tests", "test files", "func init()", "func main()", "package main"}
+
+// onlyInFlag collects the contexts given to -only-in or -not-in, which can be
+// repeated or comma-separated.
+type onlyInFlag struct {
+	contexts *[]string
+}
+
+func (o *onlyInFlag) String() string { return "" }
+func (o *onlyInFlag) Set(val string) error {
+	for _, ctx := range strings.Split(val, ",") {
+		ctx = strings.Join(strings.Fields(ctx), " ")
+		if err := checkContext(ctx); err != nil {
+			return err
+		}
+		*o.contexts = append(*o.contexts, ctx)
+	}
+	return nil
+}
+
+func checkContext(ctx string) error {
+	quoted := make([]string, len(onlyInContexts))
+	for i, c := range onlyInContexts {
+		if c == ctx {
+			return nil
+		}
+		quoted[i] = strconv.Quote(c)
+	}
+	return fmt.Errorf("unknown context %q; available: %s",
+		ctx, strings.Join(quoted, ", "))
+}
+
+// inContext reports whether a node is within any of the contexts given to
+// -only-in, and outside all of those given to -not-in.
+func (m *matcher) inContext(node ast.Node) bool {
+	if m.withinAny(node, m.notIn) {
+		return false
+	}
+	return len(m.onlyIn) == 0 || m.withinAny(node, m.onlyIn)
+}
+
+// withinAny reports whether a node is within any of the contexts. Those are
+// the top-level funcs that run tests, benchmarks, and examples, the test
+// files, the init and main funcs, and the files in main packages.
+func (m *matcher) withinAny(node ast.Node, contexts []string) bool {
+	if len(contexts) == 0 {
+		return false
+	}
+	fn, _ := m.enclosingDecl(node).(*ast.FuncDecl)
+	testFile := strings.HasSuffix(m.position(node.Pos()).Filename, "_test.go")
+	for _, ctx := range contexts {
+		switch ctx {
+		case "tests":
+			if fn != nil && fn.Recv == nil && isTestFunc(fn.Name.Name) && testFile {
+				return true
+			}
+		case "test files":
+			if testFile {
+				return true
+			}
+		case "func init()":
+			if fn != nil && fn.Recv == nil && fn.Name.Name == "init" {
+				return true
+			}
+		case "func main()":
+			if fn != nil && fn.Recv == nil && fn.Name.Name == "main" &&
+				m.inMainPackage(node) {
+				return true
+			}
+		case "package main":
+			if m.inMainPackage(node) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTestFunc reports whether a func name is one that "go test" runs, such as
+// TestFoo, but not Testfoo.
+func isTestFunc(name string) bool {
+	for _, prefix := range []string{"Test", "Benchmark", "Example", "Fuzz"} {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+		if r == utf8.RuneError || !unicode.IsLower(r) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *matcher) inMainPackage(node ast.Node) bool {
+	f := m.fileOf(node)
+	return f != nil && f.Name.Name == "main"
+}
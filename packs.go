@@ -0,0 +1,170 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// packs holds the built-in rule packs, by name.
+var packs = map[string][]rule{
+	"concurrency": {
+		{
+			Name:     "defer-in-loop",
+			Pipeline: []string{"-x", "defer $f($*_)", "-a", "depth(loop) > 0"},
+			Message:  "deferred calls only run when the func returns, not at the end of each iteration",
+		},
+		{
+			Name:     "mutex-copy",
+			Pipeline: []string{"-x", "$_ := $x", "-x", "$x", "-a", "type(sync.Mutex)"},
+			Message:  "sync.Mutex copied by value",
+		},
+		{
+			Name:     "mutex-copy-assign",
+			Pipeline: []string{"-x", "$_ = $x", "-x", "$x", "-a", "type(sync.Mutex)"},
+			Message:  "sync.Mutex copied by value",
+		},
+	},
+	"crypto": {
+		{
+			Name:     "weak-hash",
+			Pipeline: []string{"-x", "$_.$_", "-a", `obj("crypto/md5", "crypto/sha1")`},
+			NotIn:    []string{"test files"},
+			Message:  "MD5 and SHA-1 are broken; use SHA-256 or better for security",
+		},
+		{
+			Name:     "weak-cipher",
+			Pipeline: []string{"-x", "$_.$_", "-a", `obj("crypto/des", "crypto/rc4")`},
+			NotIn:    []string{"test files"},
+			Message:  "DES and RC4 are broken; use AES-GCM or ChaCha20-Poly1305",
+		},
+		{
+			Name:     "insecure-rand-read",
+			Pipeline: []string{"-x", "$_.$_", "-a", `obj("math/rand.Read")`},
+			NotIn:    []string{"test files"},
+			Message:  "math/rand is predictable; use crypto/rand for keys and tokens",
+		},
+		{
+			Name: "insecure-rand-key",
+			Pipeline: []string{
+				"-x", "func ($*_) $f($*_) $*_ { $*_ }", "-x", "$f",
+				"-a", `rx("(?i).*(key|token|secret|password|salt|nonce).*")`, "-p", "1",
+				"-x", "$_.$_", "-a", `obj("math/rand", "math/rand/v2")`,
+				"-a", `!obj("math/rand.Read")`, // already reported
+			},
+			NotIn:   []string{"test files"},
+			Message: "math/rand is predictable; use crypto/rand for keys and tokens",
+		},
+		{
+			Name:     "insecure-skip-verify",
+			Pipeline: []string{"-x", "$k: true", "-or", "$_.$k = true", "-x", "$k", "-a", `obj("crypto/tls.InsecureSkipVerify")`},
+			NotIn:    []string{"test files"},
+			Severity: "error",
+			Message:  "TLS certificate verification is disabled",
+		},
+	},
+	"errors": {
+		{
+			Name:     "unchecked-error",
+			Pipeline: []string{"-x", "$f($*_);", "-x", "$f($*_)", "-a", "type(error)"},
+			Message:  "error result is not checked",
+		},
+		{
+			Name:     "discarded-error",
+			Pipeline: []string{"-x", "_ = $x", "-x", "$x", "-a", "type(error)"},
+			Severity: "info",
+			Message:  "error result is explicitly discarded",
+		},
+		{
+			Name:     "error-string-compare",
+			Pipeline: []string{"-x", "$x.Error() == $_"},
+			Message:  "errors should be compared directly, not via their messages",
+		},
+	},
+	"sqli": {
+		{
+			Name:     "sql-injection",
+			Pipeline: concatPipeline(sqliSources, sqliSinks),
+			Severity: "error",
+			Message:  "SQL query built from untrusted input; pass it as a query argument instead",
+		},
+	},
+}
+
+// sqliSources are the untrusted inputs of the sqli pack, such as request
+// parameters, environment variables, and flags.
+var sqliSources = []string{
+	"$_.FormValue($_)", "$_.PostFormValue($_)", "$_.PathValue($_)",
+	"$_.URL.Query()", "$_.URL.Path", "$_.URL.RawQuery", "$_.Header.Get($_)",
+	"$_.Cookie($_)", "$_.Form", "$_.PostForm",
+	"os.Getenv($_)", "os.LookupEnv($_)", "os.Args",
+	"flag.Arg($_)", "flag.Args()", "flag.String($*_)",
+}
+
+// sqliSinks are the calls of the sqli pack running a query, as done by
+// database/sql.
+var sqliSinks = []string{
+	"$_.Query($*_)", "$_.QueryContext($*_)", "$_.QueryRow($*_)", "$_.QueryRowContext($*_)",
+	"$_.Exec($*_)", "$_.ExecContext($*_)", "$_.Prepare($*_)", "$_.PrepareContext($*_)",
+}
+
+// concatPipeline returns the commands finding the sinks which use values
+// from any of the sources via string concatenation or formatting.
+func concatPipeline(sources, sinks []string) []string {
+	var args []string
+	for i, src := range sources {
+		if i == 0 {
+			args = append(args, "-x", src)
+		} else {
+			args = append(args, "-or", src)
+		}
+	}
+	for i, sink := range sinks {
+		if i == 0 {
+			args = append(args, "-concat", sink)
+		} else {
+			args = append(args, "-or", sink)
+		}
+	}
+	return args
+}
+
+// packRules compiles the rules in a comma-separated list of packs.
+func (m *matcher) packRules(names string) ([]rule, error) {
+	var rules []rule
+	for _, name := range strings.Split(names, ",") {
+		pack, ok := packs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown pack %q; available: %s",
+				name, strings.Join(packNames(), ", "))
+		}
+		for _, r := range pack {
+			r.cmds = nil
+			if name == "sqli" && m.config != nil {
+				// the project can add its own sources and sinks,
+				// such as wrappers of database/sql
+				sqli := m.config.sqli
+				r.Pipeline = concatPipeline(
+					append(sqliSources[:len(sqliSources):len(sqliSources)], sqli.sources...),
+					append(sqliSinks[:len(sqliSinks):len(sqliSinks)], sqli.sinks...))
+			}
+			if err := m.compileRule(&r); err != nil {
+				return nil, fmt.Errorf("pack %s: %v", name, err)
+			}
+			rules = append(rules, r)
+		}
+	}
+	return rules, nil
+}
+
+func packNames() []string {
+	names := make([]string, 0, len(packs))
+	for name := range packs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
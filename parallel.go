@@ -0,0 +1,148 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"sort"
+	"sync"
+)
+
+// matchPkgs runs the commands on each of the packages, skipping the ignored
+// and suppressed matches. Up to m.jobs packages are matched at once, each
+// with a copy of the matcher, and the results are kept in the order of the
+// packages. If m.sorted is set, they are then sorted by file and position.
+// Commands which need all the packages at once may find the same node from
+// more than one package, in which case it's only kept once.
+func (m *matcher) matchPkgs(cmds []exprCmd, pkgs []loadPkg) []submatch {
+	jobs := m.jobs
+	if jobs < 1 || m.trace {
+		jobs = 1 // traces are shared
+	}
+	for _, cmd := range cmds {
+		switch cmd.name {
+		case "callers", "callees":
+			m.loader.callGraph() // built once, not by each copy
+		case "reach":
+			m.reachableFrom(cmd)
+		case "taint", "concat":
+			m.loader.program()
+		case "a":
+			if needsProgram(cmd.value) {
+				m.loader.program()
+			}
+		}
+	}
+	results := make([][]submatch, len(pkgs))
+	notes := make([]map[nodePosHash]string, len(pkgs))
+	errs := make([][]error, len(pkgs))
+	matchPkg := func(mc *matcher, i int) {
+		pkg := pkgs[i]
+		mc.Info = pkg.info
+		var subs []submatch
+		err := mc.guardPkg(pkg.path, func() {
+			subs, errs[i] = mc.tryMatchSubs(cmds, pkg.nodes)
+		})
+		if err != nil {
+			errs[i] = []error{err}
+			return
+		}
+		for _, sub := range subs {
+			if mc.ignored(sub.node, defaultRuleName) {
+				continue
+			}
+			if mc.nolint != "" && mc.suppressed(sub.node, "") {
+				continue
+			}
+			if !mc.inContext(sub.node) {
+				continue
+			}
+			results[i] = append(results[i], sub)
+		}
+	}
+	if jobs == 1 {
+		for i := range pkgs {
+			matchPkg(m, i)
+		}
+	} else {
+		var wg sync.WaitGroup
+		next := make(chan int)
+		for j := 0; j < jobs; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					mc := *m
+					mc.notes = make(map[nodePosHash]string)
+					// the lazily built caches can't be shared
+					mc.commentMaps, mc.ignoreDirs, mc.tagTemplates = nil, nil, nil
+					matchPkg(&mc, i)
+					notes[i] = mc.notes
+				}
+			}()
+		}
+		for i := range pkgs {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
+	// commands like -refs may reach the same nodes from any of the
+	// packages, so only keep the first of each
+	dedup := m.wholeProgramCmd(cmds) != nil
+	seen := make(map[nodePosHash]bool)
+	var all []submatch
+	for i := range pkgs {
+		for hash, note := range notes[i] {
+			m.notes[hash] = note
+		}
+		for _, sub := range results[i] {
+			if dedup {
+				hash := posHash(sub.node)
+				if seen[hash] {
+					continue
+				}
+				seen[hash] = true
+			}
+			all = append(all, sub)
+		}
+		m.reportMatchErrors(errs[i])
+	}
+	if m.sorted {
+		sort.SliceStable(all, func(i, j int) bool {
+			pi := m.loader.fset.PositionFor(all[i].node.Pos(), false)
+			pj := m.loader.fset.PositionFor(all[j].node.Pos(), false)
+			if pi.Filename != pj.Filename {
+				return pi.Filename < pj.Filename
+			}
+			return pi.Offset < pj.Offset
+		})
+	}
+	return all
+}
+
+// needsProgram reports whether an attribute uses the SSA form of the program.
+func needsProgram(attr interface{}) bool {
+	switch x := attr.(type) {
+	case negAttr:
+		return needsProgram(x.attr)
+	case unlockCheck:
+		return true
+	}
+	return false
+}
+
+// needsAllPkgs reports whether an attribute looks beyond the package of the
+// node it's checked on, like constarg at the calls of a func, or deprecated
+// at the doc of a declaration in another package.
+func needsAllPkgs(attr interface{}) bool {
+	switch x := attr.(type) {
+	case negAttr:
+		return needsAllPkgs(x.attr)
+	case constArgCheck:
+		return true
+	case objProperty:
+		return x == "deprecated"
+	}
+	return false
+}
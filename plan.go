@@ -0,0 +1,128 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// rewritePlan holds the edits that -w would make, as recorded via -plan, so
+// that they can be reviewed and made later by "gogrep apply".
+type rewritePlan struct {
+	mu    sync.Mutex
+	Files []planFile `json:"files"`
+}
+
+// planFile holds the edits to a file, along with the SHA-256 of its source
+// when they were planned. Paths are relative to the working directory when
+// possible.
+type planFile struct {
+	Path   string    `json:"path"`
+	SHA256 string    `json:"sha256"`
+	Edits  []srcEdit `json:"edits"`
+}
+
+// add records the edits making the substitutions within a file. It's safe to
+// call from many matchers at once.
+func (p *rewritePlan) add(m *matcher, file *ast.File, subs []submatch) error {
+	src, err := ioutil.ReadFile(m.loader.fset.File(file.Package).Name())
+	if err != nil {
+		return err
+	}
+	edits, err := m.substRanges(file, src, subs)
+	if err != nil {
+		return err
+	}
+	pf := planFile{
+		Path:   m.position(file.Package).Filename,
+		SHA256: srcHash(src),
+		Edits:  edits,
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, prev := range p.Files {
+		if prev.Path == pf.Path {
+			return fmt.Errorf("%s: planned to be edited twice", pf.Path)
+		}
+	}
+	p.Files = append(p.Files, pf)
+	return nil
+}
+
+// writePlan writes the plan recorded via -plan to its file.
+func (m *matcher) writePlan() error {
+	plan := m.plan
+	m.plan = nil
+	sort.Slice(plan.Files, func(i, j int) bool {
+		return plan.Files[i].Path < plan.Files[j].Path
+	})
+	if plan.Files == nil {
+		plan.Files = []planFile{}
+	}
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.planPath, append(data, '\n'), 0o666)
+}
+
+// applyArgs implements "gogrep apply plan", which makes the edits recorded in
+// a plan via -plan. If any of the files changed since the plan was made, as
+// per their hashes, none of them are written.
+func (m *matcher) applyArgs(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gogrep apply plan")
+	}
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var plan rewritePlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+	srcs := make([][]byte, len(plan.Files))
+	var stale []string
+	for i, pf := range plan.Files {
+		src, err := ioutil.ReadFile(pf.Path)
+		if err != nil {
+			return err
+		}
+		if srcHash(src) != pf.SHA256 {
+			stale = append(stale, pf.Path)
+			continue
+		}
+		last := 0
+		for _, edit := range pf.Edits {
+			if edit.Offset < last || edit.End < edit.Offset || edit.End > len(src) {
+				return fmt.Errorf("%s: %s: invalid edit at offset %d", args[0], pf.Path, edit.Offset)
+			}
+			last = edit.End
+		}
+		srcs[i] = src
+	}
+	if len(stale) > 0 {
+		return fmt.Errorf("%s: files changed since the plan was made: %s",
+			args[0], strings.Join(stale, ", "))
+	}
+	for i, pf := range plan.Files {
+		if err := ioutil.WriteFile(pf.Path, spliceEdits(srcs[i], pf.Edits), 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func srcHash(src []byte) string {
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatCheck checks whether a call to a printf-like function has a format
+// string which doesn't agree with its arguments.
+type formatCheck struct{}
+
+// printfArgs returns the constant format string of a call to a printf-like
+// function, along with the arguments it formats. A function is printf-like
+// if its last two parameters are a string and a ...interface{}.
+func (m *matcher) printfArgs(node ast.Node) (string, []ast.Expr, bool) {
+	call, ok := node.(*ast.CallExpr)
+	if !ok || call.Ellipsis.IsValid() {
+		return "", nil, false
+	}
+	sig, ok := m.Info.TypeOf(call.Fun).(*types.Signature)
+	if !ok || !sig.Variadic() {
+		return "", nil, false
+	}
+	params := sig.Params()
+	n := params.Len()
+	if n < 2 || len(call.Args) < n-1 {
+		return "", nil, false
+	}
+	last := params.At(n - 1).Type().(*types.Slice).Elem()
+	if iface, ok := last.Underlying().(*types.Interface); !ok || iface.NumMethods() > 0 {
+		return "", nil, false
+	}
+	if b, ok := params.At(n - 2).Type().Underlying().(*types.Basic); !ok || b.Info()&types.IsString == 0 {
+		return "", nil, false
+	}
+	tv := m.Info.Types[call.Args[n-2]]
+	if tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", nil, false
+	}
+	return constant.StringVal(tv.Value), call.Args[n-1:], true
+}
+
+// formatProblem returns what is wrong with a printf-like call's format and
+// arguments, or an empty string if it isn't a printf-like call or if they
+// agree.
+func (m *matcher) formatProblem(node ast.Node) string {
+	format, args, ok := m.printfArgs(node)
+	if !ok {
+		return ""
+	}
+	argNum := 0
+	nextArg := func() ast.Expr {
+		argNum++
+		if argNum > len(args) {
+			return nil
+		}
+		return args[argNum-1]
+	}
+	for i := 0; i < len(format); {
+		r, w := utf8.DecodeRuneInString(format[i:])
+		i += w
+		if r != '%' {
+			continue
+		}
+		start := i - 1
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		// the width and precision, which may be taken from arguments
+		for part := 0; part < 2; part++ {
+			if part == 1 {
+				if i >= len(format) || format[i] != '.' {
+					break
+				}
+				i++
+			}
+			if i < len(format) && format[i] == '[' {
+				return "" // explicit argument indexes aren't supported
+			}
+			if i < len(format) && format[i] == '*' {
+				i++
+				arg := nextArg()
+				if arg == nil {
+					return fmt.Sprintf("format %s is missing an argument", format[start:i])
+				}
+				if !argFits(m.Info.TypeOf(arg), 'c', 0) {
+					return fmt.Sprintf("format %s has * argument %s which is not an int",
+						format[start:i], singleLinePrint(arg))
+				}
+				continue
+			}
+			for i < len(format) && '0' <= format[i] && format[i] <= '9' {
+				i++
+			}
+		}
+		if i >= len(format) {
+			return fmt.Sprintf("format %s is missing a verb", format[start:])
+		}
+		if format[i] == '[' {
+			return ""
+		}
+		verb, w := utf8.DecodeRuneInString(format[i:])
+		i += w
+		verbStr := format[start:i]
+		if verb == '%' {
+			continue
+		}
+		if strings.IndexRune("bcdeEfFgGoOpqstTUvwxX", verb) < 0 {
+			return fmt.Sprintf("format %s has an unknown verb %c", verbStr, verb)
+		}
+		arg := nextArg()
+		if arg == nil {
+			return fmt.Sprintf("format %s is missing an argument", verbStr)
+		}
+		if t := m.Info.TypeOf(arg); !argFits(t, verb, 0) {
+			return fmt.Sprintf("format %s has argument %s of wrong type %s",
+				verbStr, singleLinePrint(arg), t)
+		}
+	}
+	if extra := len(args) - argNum; extra > 0 {
+		return fmt.Sprintf("format %q has %d extra argument(s)", format, extra)
+	}
+	return ""
+}
+
+// argFits reports whether a value of type t can be formatted with a verb,
+// following the rules of the fmt package. It errs on the side of accepting
+// types it can't be sure about, such as interfaces. depth is how deep t is
+// within the argument's type.
+func argFits(t types.Type, verb rune, depth int) bool {
+	if t == nil || depth > 8 {
+		return true
+	}
+	switch verb {
+	case 'v', 'T':
+		return true
+	}
+	if _, ok := t.Underlying().(*types.Interface); ok {
+		return true // the dynamic type could be anything
+	}
+	if hasMethod(t, "Format") {
+		return true // fmt.Formatter
+	}
+	if verb == 'w' {
+		return hasMethod(t, "Error")
+	}
+	if strings.ContainsRune("sqxX", verb) && (hasMethod(t, "Error") || hasMethod(t, "String")) {
+		return true
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		info := u.Info()
+		switch {
+		case u.Kind() == types.UnsafePointer:
+			return strings.ContainsRune("bdoxXp", verb)
+		case info&types.IsBoolean != 0:
+			return verb == 't'
+		case info&types.IsInteger != 0:
+			return strings.ContainsRune("bcdoOqxXU", verb)
+		case info&types.IsFloat != 0, info&types.IsComplex != 0:
+			return strings.ContainsRune("beEfFgGxX", verb)
+		case info&types.IsString != 0:
+			return strings.ContainsRune("sqxX", verb)
+		}
+		return true
+	case *types.Pointer:
+		if verb == 'p' || strings.ContainsRune("bdoxX", verb) {
+			return true
+		}
+		if depth > 0 {
+			return false
+		}
+		// fmt prints the contents of pointers to composite values, but
+		// only at the top level
+		switch u.Elem().Underlying().(type) {
+		case *types.Struct, *types.Array, *types.Slice, *types.Map:
+			return argFits(u.Elem(), verb, depth+1)
+		}
+		return false
+	case *types.Signature, *types.Chan:
+		return verb == 'p'
+	case *types.Slice:
+		if verb == 'p' {
+			return true
+		}
+		if b, ok := u.Elem().Underlying().(*types.Basic); ok && b.Kind() == types.Byte &&
+			strings.ContainsRune("sqxX", verb) {
+			return true
+		}
+		return argFits(u.Elem(), verb, depth+1)
+	case *types.Array:
+		return argFits(u.Elem(), verb, depth+1)
+	case *types.Map:
+		return verb == 'p' || argFits(u.Key(), verb, depth+1) && argFits(u.Elem(), verb, depth+1)
+	case *types.Struct:
+		for i := 0; i < u.NumFields(); i++ {
+			if !argFits(u.Field(i).Type(), verb, depth+1) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasMethod reports whether a value of type t has a method with the given
+// name, either directly or via its pointer.
+func hasMethod(t types.Type, name string) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, true, nil, name)
+	_, ok := obj.(*types.Func)
+	return ok
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// priorityRecent is the entry of a priority list which matches any package,
+// ordering those it matches by when they last changed in git, newest first.
+const priorityRecent = "recent"
+
+// prioritize sorts the packages to match one batch at a time by the config
+// file's priority list, so that the most relevant ones are matched first,
+// such as before -max-time cuts a run short. Packages are ordered by the
+// first entry matching them, followed by those matching none, and otherwise
+// keep their order.
+func (m *matcher) prioritize(paths []string) []string {
+	if m.config == nil || len(m.config.priority) == 0 {
+		return paths
+	}
+	patterns, recent := m.config.priority, -1
+	for i, entry := range patterns {
+		if entry == priorityRecent {
+			// as it matches all packages, no later entry matters
+			patterns, recent = patterns[:i], i
+			break
+		}
+	}
+	ranks := make(map[string]int, len(paths))
+	changed := make(map[string]int64)
+	for _, path := range paths {
+		rank := m.loader.firstMatch(patterns, path)
+		if rank < 0 {
+			rank = len(patterns) // the same as recent, if it's there
+		}
+		ranks[path] = rank
+		if rank == recent {
+			changed[path] = gitChanged(m.loader.wd, m.loader.pkgDir(path))
+		}
+	}
+	sorted := append([]string(nil), paths...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		ri, rj := ranks[sorted[i]], ranks[sorted[j]]
+		if ri != rj {
+			return ri < rj
+		}
+		return changed[sorted[i]] > changed[sorted[j]]
+	})
+	return sorted
+}
+
+// gitChanged returns the time of the last commit changing a directory, as a
+// Unix timestamp, or zero if it can't be found, such as outside of git.
+func gitChanged(wd, dir string) int64 {
+	cmd := exec.Command("git", "log", "-1", "--format=%ct", "--", dir)
+	cmd.Dir = wd
+	out, err := cmd.Output()
+	if err != nil {
+		return 0
+	}
+	t, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return t
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"go/ast"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// The subset of reviewdog's diagnostic format needed to report rule matches,
+// known as rdjson when printed as a single result and as rdjsonl when
+// printed as one diagnostic per line.
+
+type rdResult struct {
+	Source      rdSource       `json:"source"`
+	Diagnostics []rdDiagnostic `json:"diagnostics"`
+}
+
+type rdSource struct {
+	Name string `json:"name"`
+}
+
+type rdDiagnostic struct {
+	Message     string         `json:"message"`
+	Location    rdLocation     `json:"location"`
+	Severity    string         `json:"severity"`
+	Source      rdSource       `json:"source"`
+	Code        rdCode         `json:"code"`
+	Suggestions []rdSuggestion `json:"suggestions,omitempty"`
+}
+
+type rdLocation struct {
+	Path  string  `json:"path"`
+	Range rdRange `json:"range"`
+}
+
+type rdRange struct {
+	Start rdPosition `json:"start"`
+	End   rdPosition `json:"end"`
+}
+
+// rdPosition is a position with a line and a column in bytes, both starting
+// at 1.
+type rdPosition struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+type rdCode struct {
+	Value string `json:"value"`
+	URL   string `json:"url,omitempty"`
+}
+
+type rdSuggestion struct {
+	Range rdRange `json:"range"`
+	Text  string  `json:"text"`
+}
+
+// printRDJSON prints the rule matches collected so far in reviewdog's
+// diagnostic format, as a single result or as one diagnostic per line. The
+// matches of rules with a -s command suggest their substitutions as fixes.
+func (m *matcher) printRDJSON(lines bool) error {
+	diags := []rdDiagnostic{}
+	srcs := make(map[string][]byte)
+	for _, rm := range m.ruleMatches {
+		diag := rdDiagnostic{
+			Message: rm.msg,
+			Location: rdLocation{
+				Path: filepath.ToSlash(rm.pos.Filename),
+				Range: rdRange{
+					rdPosition{rm.pos.Line, rm.pos.Column},
+					rdPosition{rm.end.Line, rm.end.Column},
+				},
+			},
+			Severity: strings.ToUpper(rm.rule.Severity),
+			Source:   rdSource{"gogrep"},
+			Code:     rdCode{rm.rule.Name, rm.rule.Docs},
+		}
+		if file := m.substFile(rm.sub); file != nil {
+			path := m.loader.fset.PositionFor(rm.sub.orig.pos, false).Filename
+			src, ok := srcs[path]
+			if !ok {
+				var err error
+				if src, err = ioutil.ReadFile(path); err != nil {
+					return err
+				}
+				srcs[path] = src
+			}
+			repl, err := m.substSrc(file, src, rm.sub)
+			if err != nil {
+				return err
+			}
+			diag.Suggestions = []rdSuggestion{{diag.Location.Range, string(repl)}}
+		}
+		diags = append(diags, diag)
+	}
+	enc := json.NewEncoder(m.out)
+	if lines {
+		for _, diag := range diags {
+			if err := enc.Encode(diag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	enc.SetIndent("", "  ")
+	return enc.Encode(rdResult{Source: rdSource{"gogrep"}, Diagnostics: diags})
+}
+
+// substFile returns the file in which a match was substituted, or nil if it
+// wasn't.
+func (m *matcher) substFile(sub submatch) *ast.File {
+	if !sub.orig.pos.IsValid() {
+		return nil
+	}
+	return m.loadedFile(sub.orig.pos)
+}
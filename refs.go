@@ -0,0 +1,274 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+)
+
+// cmdRefs replaces each submatch with all the references to the object it
+// refers to or declares, across all the loaded packages.
+func (m *matcher) cmdRefs(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	for _, sub := range subs {
+		obj := m.objectOf(sub.node)
+		if obj == nil {
+			continue
+		}
+		for _, id := range m.refsTo(obj) {
+			hash := posHash(id)
+			if seen[hash] {
+				continue
+			}
+			seen[hash] = true
+			matches = append(matches, submatch{
+				node:   id,
+				values: valsCopy(sub.values),
+			})
+		}
+	}
+	return matches
+}
+
+// cmdDef replaces each submatch with the declaration of the object it refers
+// to, as long as it's part of the loaded files.
+func (m *matcher) cmdDef(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	for _, sub := range subs {
+		obj := m.objectOf(sub.node)
+		if obj == nil {
+			continue
+		}
+		decl := m.declNode(obj)
+		if decl == nil {
+			continue
+		}
+		hash := posHash(decl)
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		matches = append(matches, submatch{
+			node:   decl,
+			values: valsCopy(sub.values),
+		})
+	}
+	return matches
+}
+
+// cmdUses keeps the submatches referring to or declaring an object, noting
+// how many times the object is used across the loaded files and packages.
+// With -verbose, the uses per file are listed too.
+func (m *matcher) cmdUses(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	for _, sub := range subs {
+		obj := m.objectOf(sub.node)
+		if obj == nil {
+			continue
+		}
+		uses := 0
+		files := make(map[string]int)
+		pkgs := make(map[string]bool)
+		for _, pkg := range m.pkgs {
+			for id, obj2 := range pkg.info.Uses {
+				if obj2 != obj {
+					continue
+				}
+				uses++
+				files[m.position(id.Pos()).Filename]++
+				pkgs[pkg.path] = true
+			}
+		}
+		note := fmt.Sprintf("uses: %d, files: %d, packages: %d",
+			uses, len(files), len(pkgs))
+		if m.verbose {
+			names := make([]string, 0, len(files))
+			for name := range files {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				note += fmt.Sprintf("\n  %s: %d", name, files[name])
+			}
+		}
+		m.notes[posHash(sub.node)] = note
+		matches = append(matches, sub)
+	}
+	return matches
+}
+
+// declNode is like declOf, but it prefers whole declarations like
+// "var a int" over specs like "a int" when they're equivalent.
+func (m *matcher) declNode(obj types.Object) ast.Node {
+	decl := m.declOf(obj)
+	switch decl.(type) {
+	case *ast.ValueSpec, *ast.TypeSpec:
+		gd, ok := m.parentOf(decl).(*ast.GenDecl)
+		if ok && !gd.Lparen.IsValid() {
+			return gd
+		}
+	}
+	return decl
+}
+
+// cmdImpls replaces each submatch referring to an interface type with the
+// declarations of the concrete types implementing it. Similarly, submatches
+// referring to an interface method are replaced by the declarations of that
+// method in the implementing types.
+func (m *matcher) cmdImpls(cmd exprCmd, subs []submatch) []submatch {
+	var matches []submatch
+	seen := map[nodePosHash]bool{}
+	add := func(obj types.Object, sub submatch) {
+		decl := m.declNode(obj)
+		if decl == nil {
+			return
+		}
+		hash := posHash(decl)
+		if seen[hash] {
+			return
+		}
+		seen[hash] = true
+		matches = append(matches, submatch{
+			node:   decl,
+			values: valsCopy(sub.values),
+		})
+	}
+	for _, sub := range subs {
+		switch obj := m.objectOf(sub.node).(type) {
+		case *types.TypeName:
+			iface, ok := obj.Type().Underlying().(*types.Interface)
+			if !ok {
+				continue
+			}
+			for _, tn := range m.concreteTypes() {
+				if implements(tn.Type(), iface) {
+					add(tn, sub)
+				}
+			}
+		case *types.Func:
+			recv := obj.Type().(*types.Signature).Recv()
+			if recv == nil {
+				continue
+			}
+			iface, ok := recv.Type().Underlying().(*types.Interface)
+			if !ok {
+				continue
+			}
+			for _, tn := range m.concreteTypes() {
+				if !implements(tn.Type(), iface) {
+					continue
+				}
+				method, _, _ := types.LookupFieldOrMethod(tn.Type(),
+					true, obj.Pkg(), obj.Name())
+				if method != nil {
+					add(method, sub)
+				}
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].node.Pos() < matches[j].node.Pos()
+	})
+	return matches
+}
+
+// concreteTypes returns all the named non-interface types declared at the
+// top level of the packages being matched.
+func (m *matcher) concreteTypes() []*types.TypeName {
+	var tns []*types.TypeName
+	for _, info := range m.allInfos() {
+		for _, obj := range info.Defs {
+			tn, ok := obj.(*types.TypeName)
+			if !ok || tn.IsAlias() || tn.Parent() != tn.Pkg().Scope() {
+				continue
+			}
+			if _, ok := tn.Type().Underlying().(*types.Interface); ok {
+				continue
+			}
+			tns = append(tns, tn)
+		}
+	}
+	return tns
+}
+
+func implements(t types.Type, iface *types.Interface) bool {
+	return types.Implements(t, iface) ||
+		types.Implements(types.NewPointer(t), iface)
+}
+
+// refsTo returns all the identifiers referring to an object, sorted by their
+// position.
+func (m *matcher) refsTo(obj types.Object) []*ast.Ident {
+	var ids []*ast.Ident
+	for _, info := range m.allInfos() {
+		for id, obj2 := range info.Uses {
+			if obj2 == obj {
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i].Pos() < ids[j].Pos()
+	})
+	for _, id := range ids {
+		// so that further commands can navigate them
+		m.ensureParents(m.fileAt(id.Pos()))
+	}
+	return ids
+}
+
+// allInfos returns the type information for all the packages being matched.
+func (m *matcher) allInfos() []*types.Info {
+	if len(m.pkgs) == 0 {
+		return []*types.Info{&m.Info}
+	}
+	infos := make([]*types.Info, len(m.pkgs))
+	for i := range m.pkgs {
+		infos[i] = &m.pkgs[i].info
+	}
+	return infos
+}
+
+// loadedRoots returns a submatch for each of the root nodes of the packages
+// being matched, such as files. Useful for commands that need to search
+// beyond the current submatches.
+func (m *matcher) loadedRoots() []submatch {
+	var roots []submatch
+	for _, pkg := range m.pkgs {
+		for _, node := range pkg.nodes {
+			if f, ok := node.(*ast.File); ok {
+				m.ensureParents(f)
+			}
+			roots = append(roots, submatch{
+				node:   node,
+				values: map[string]ast.Node{},
+			})
+		}
+	}
+	return roots
+}
+
+// fileAt returns the loaded file containing a position, if any.
+func (m *matcher) fileAt(pos token.Pos) *ast.File {
+	for _, f := range m.loader.files {
+		if pos >= f.Pos() && pos < f.End() {
+			return f
+		}
+	}
+	return nil
+}
+
+// ensureParents records the parents of a file's nodes, if that wasn't done
+// already. This is useful for files that we aren't matching on.
+func (m *matcher) ensureParents(f *ast.File) {
+	if f != nil && m.parents[f.Name] == nil {
+		m.fillParents(f)
+	}
+}
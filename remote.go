@@ -0,0 +1,143 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// remoteSchemes are the URL schemes of the remote repositories which can be
+// given instead of packages.
+var remoteSchemes = []string{"https://", "http://", "ssh://", "git://", "file://"}
+
+// remoteRepo is a remote git repository given as a target, such as
+// "https://github.com/org/repo@v1.2.0", and the temporary directory it was
+// cloned into.
+type remoteRepo struct {
+	url, ref string
+	dir      string
+}
+
+// parseRemote parses a remote repository target, which is a URL with an
+// optional "@ref" suffix naming a branch, tag, or commit. It returns false
+// if the target isn't a URL.
+func parseRemote(target string) (remoteRepo, bool) {
+	for _, scheme := range remoteSchemes {
+		if !strings.HasPrefix(target, scheme) {
+			continue
+		}
+		// an @ before the path is user info, not a ref
+		rest := target[len(scheme):]
+		slash := strings.IndexByte(rest, '/')
+		if at := strings.LastIndexByte(rest, '@'); at > slash && slash >= 0 {
+			return remoteRepo{url: target[:len(scheme)+at], ref: rest[at+1:]}, true
+		}
+		return remoteRepo{url: target}, true
+	}
+	return remoteRepo{}, false
+}
+
+// name returns how the repository's files are shown in positions, which is
+// its URL without the scheme, plus the ref if any.
+func (r remoteRepo) name() string {
+	name := r.url[strings.Index(r.url, "://")+3:]
+	name = strings.TrimSuffix(name, ".git")
+	if r.ref != "" {
+		name += "@" + r.ref
+	}
+	return name
+}
+
+// cloneRemotes shallow-clones the remote repositories among the packages to
+// temporary directories, replacing each of them with all of its packages.
+// The directories are removed by removeRemotes.
+func (m *matcher) cloneRemotes(paths []string, cmds []exprCmd) ([]string, error) {
+	var expanded []string
+	for _, path := range paths {
+		repo, ok := parseRemote(path)
+		if !ok {
+			expanded = append(expanded, path)
+			continue
+		}
+		for _, cmd := range cmds {
+			if cmd.name == "w" {
+				return nil, fmt.Errorf("cannot write to remote repository %s", path)
+			}
+		}
+		if strings.HasPrefix(repo.url, "-") || strings.HasPrefix(repo.ref, "-") {
+			// they'd be taken as options by git
+			return nil, fmt.Errorf("invalid remote repository %s: URL and ref cannot start with -", path)
+		}
+		dir, err := ioutil.TempDir("", "gogrep-remote-")
+		if err != nil {
+			return nil, err
+		}
+		repo.dir = dir
+		m.remotes = append(m.remotes, repo)
+		if err := repo.clone(); err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(m.loader.wd, dir)
+		if err != nil {
+			return nil, err
+		}
+		if !build.IsLocalImport(rel) {
+			rel = "." + string(filepath.Separator) + rel
+		}
+		expanded = append(expanded, filepath.ToSlash(rel)+"/...")
+	}
+	return expanded, nil
+}
+
+// clone fetches only the commit at the repository's ref, or at its default
+// branch if none was given. Unlike "git clone --branch", this also works for
+// commit hashes, as long as the server allows fetching them.
+func (r remoteRepo) clone() error {
+	ref := r.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", "--", r.url, ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = r.dir
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("cloning %s: git %s: %v: %s", r.name(), args[0], err,
+				strings.TrimSpace(stderr.String()))
+		}
+	}
+	return nil
+}
+
+// remotePosition shows a file within a cloned remote repository as part of
+// the repository, such as "github.com/org/repo@v1.2.0/foo/bar.go".
+func (m *matcher) remotePosition(filename string) (string, bool) {
+	for _, repo := range m.remotes {
+		if strings.HasPrefix(filename, repo.dir+string(filepath.Separator)) {
+			return repo.name() + filepath.ToSlash(filename[len(repo.dir):]), true
+		}
+	}
+	return "", false
+}
+
+// removeRemotes removes the directories that the remote repositories were
+// cloned into.
+func (m *matcher) removeRemotes() {
+	for _, repo := range m.remotes {
+		os.RemoveAll(repo.dir)
+	}
+	m.remotes = nil
+}
@@ -0,0 +1,443 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kisielk/gotool"
+)
+
+// rule is a named command pipeline, as read from a rules file.
+type rule struct {
+	Name string `json:"name"`
+
+	// Pipeline holds the commands as they would be given in the
+	// command line, such as ["-x", "$x == nil", "-v", "err == nil"].
+	Pipeline []string `json:"pipeline"`
+
+	// Severity is one of "error", "warning", or "info". Defaults to
+	// "warning".
+	Severity string `json:"severity"`
+
+	// Message describes each of the matches. Defaults to the source of
+	// the matched node.
+	Message string `json:"message"`
+
+	// Docs is a URL documenting the rule, such as why it exists and how
+	// to fix its matches.
+	Docs string `json:"docs,omitempty"`
+
+	// NotIn lists the contexts in which matches aren't reported, as
+	// given to -not-in, such as "test files".
+	NotIn []string `json:"not_in,omitempty"`
+
+	ruleScope
+
+	cmds []exprCmd
+}
+
+// ruleScope limits the packages a rule is run on. If Enable is not empty,
+// the rule is only run on the packages whose directories, or any of their
+// parent directories, match one of its path globs. It is never run on those
+// matching one of the Disable globs. A trailing "/..." is ignored, so
+// "internal/..." is the same as "internal".
+type ruleScope struct {
+	Enable  []string `json:"enable,omitempty"`
+	Disable []string `json:"disable,omitempty"`
+}
+
+// enabledIn reports whether the rule should be run on the package in the
+// given absolute directory.
+func (s *ruleScope) enabledIn(dir string) bool {
+	if len(s.Enable) > 0 && !globsMatch(s.Enable, dir) {
+		return false
+	}
+	return !globsMatch(s.Disable, dir)
+}
+
+func globsMatch(globs []string, dir string) bool {
+	for _, glob := range globs {
+		glob = strings.TrimSuffix(glob, string(filepath.Separator)+"...")
+		for d := dir; ; d = filepath.Dir(d) {
+			if ok, _ := filepath.Match(glob, d); ok {
+				return true
+			}
+			if filepath.Dir(d) == d {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// absGlobs makes relative globs relative to dir.
+func absGlobs(dir string, globs []string) []string {
+	abs := make([]string, len(globs))
+	for i, glob := range globs {
+		glob = filepath.FromSlash(glob)
+		if !filepath.IsAbs(glob) {
+			glob = filepath.Join(dir, glob)
+		}
+		abs[i] = glob
+	}
+	return abs
+}
+
+// checkGlobs returns an error if any of the globs is malformed.
+func checkGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf("bad path glob %q", glob)
+		}
+	}
+	return nil
+}
+
+// rulesFile is the format of a rules file, encoded as JSON.
+type rulesFile struct {
+	// Macros are pattern fragments that the rules' pipelines can use as
+	// "${name}".
+	Macros ruleMacros `json:"macros,omitempty"`
+
+	Rules []rule `json:"rules"`
+}
+
+// severities maps each severity to the exit code it causes, and to its
+// level in SARIF output.
+var severities = map[string]struct {
+	exitCode   int
+	sarifLevel string
+}{
+	"error":   {3, "error"},
+	"warning": {2, "warning"},
+	"info":    {0, "note"},
+}
+
+// loadRules reads and compiles the rules in a file. Files ending in ".go"
+// are read as Go rules files, and any other file as JSON.
+func (m *matcher) loadRules(path string) ([]rule, error) {
+	if strings.HasSuffix(path, ".go") {
+		return m.loadGoRules(path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file rulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for i := range file.Rules {
+		r := &file.Rules[i]
+		if err := file.Macros.expandRule(r); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		if err := m.compileRule(r); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		r.Enable = absGlobs(filepath.Dir(abs), r.Enable)
+		r.Disable = absGlobs(filepath.Dir(abs), r.Disable)
+		if names[r.Name] {
+			return nil, fmt.Errorf("%s: duplicate rule %q", path, r.Name)
+		}
+		names[r.Name] = true
+	}
+	return file.Rules, nil
+}
+
+func (m *matcher) compileRule(r *rule) error {
+	if r.Name == "" {
+		return fmt.Errorf("rule without a name")
+	}
+	if r.Severity == "" {
+		r.Severity = "warning"
+	}
+	if err := checkGlobs(append(r.Enable, r.Disable...)); err != nil {
+		return fmt.Errorf("rule %q: %v", r.Name, err)
+	}
+	if _, ok := severities[r.Severity]; !ok {
+		return fmt.Errorf("rule %q: unknown severity %q", r.Name, r.Severity)
+	}
+	for _, ctx := range r.NotIn {
+		if err := checkContext(ctx); err != nil {
+			return fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	flagSet := flag.NewFlagSet(r.Name, flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	cmdFlags(flagSet, &r.cmds)
+	if err := flagSet.Parse(r.Pipeline); err != nil {
+		return fmt.Errorf("rule %q: %v", r.Name, err)
+	}
+	if args := flagSet.Args(); len(args) > 0 {
+		return fmt.Errorf("rule %q: unexpected argument %q", r.Name, args[0])
+	}
+	if len(r.cmds) == 0 {
+		return fmt.Errorf("rule %q: need at least one command", r.Name)
+	}
+	for _, cmd := range r.cmds {
+		if cmd.name == "w" {
+			return fmt.Errorf("rule %q: cannot use -w", r.Name)
+		}
+	}
+	if err := m.compileCmds(r.cmds); err != nil {
+		return fmt.Errorf("rule %q: %v", r.Name, err)
+	}
+	for _, sm := range rxInterp.FindAllStringSubmatch(r.Message, -1) {
+		if sm[1] != "" {
+			m.typed = true // {{type $x}}
+		}
+	}
+	return nil
+}
+
+// ruleMatch is a node matched by a rule.
+type ruleMatch struct {
+	rule *rule
+	sub  submatch
+	pos  token.Position
+	end  token.Position
+	msg  string
+}
+
+// runRules runs all the rules on a package's nodes, and prints their matches
+// sorted by position, unless they are to be collected for SARIF, reviewdog,
+// testing, or fixing. Matches can be skipped with the rule names in
+// //gogrep:ignore directives and suppression comments.
+func (m *matcher) runRules(nodes []ast.Node) {
+	var all []ruleMatch
+	for i := range m.rules {
+		r := &m.rules[i]
+		start, visited := time.Now(), m.visited
+		subs, errs := m.tryMatchSubs(r.cmds, m.scopedNodes(r, nodes))
+		m.reportMatchErrors(errs)
+		if m.profile {
+			prof := m.profiles[r.Name]
+			if prof == nil {
+				prof = &ruleProfile{name: r.Name}
+				m.profiles[r.Name] = prof
+			}
+			prof.took += time.Since(start)
+			prof.visited += m.visited - visited
+			prof.matched += len(subs)
+		}
+		for _, sub := range subs {
+			if m.ignored(sub.node, r.Name) {
+				continue
+			}
+			if m.nolint != "" && m.suppressed(sub.node, r.Name) {
+				continue
+			}
+			if !m.inContext(sub.node) || m.withinAny(sub.node, r.NotIn) {
+				continue
+			}
+			all = append(all, ruleMatch{
+				rule: r,
+				sub:  sub,
+				pos:  m.position(sub.span().pos),
+				end:  m.position(sub.span().end),
+				msg:  m.ruleMessage(r, sub),
+			})
+			if code := severities[r.Severity].exitCode; code > m.exitCode {
+				m.exitCode = code
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].sub.node.Pos() < all[j].sub.node.Pos()
+	})
+	if m.sarif || m.rdjson || m.rdjsonl || m.testingRules || m.fixing {
+		m.ruleMatches = append(m.ruleMatches, all...)
+		return
+	}
+	for _, rm := range all {
+		note := m.lineNote(rm.sub.span().pos)
+		if note != "" {
+			note = " // " + note
+		}
+		fmt.Fprintf(m.out, "%v: %s: %s (%s)%s\n", rm.pos, rm.rule.Severity,
+			rm.msg, rm.rule.Name, note)
+	}
+}
+
+// ruleProfile records the cost of running a rule on all packages.
+type ruleProfile struct {
+	name    string
+	took    time.Duration
+	visited int
+	matched int
+}
+
+// printProfiles prints the rule profiles, slowest first.
+func (m *matcher) printProfiles() {
+	profiles := make([]*ruleProfile, 0, len(m.profiles))
+	for _, prof := range m.profiles {
+		profiles = append(profiles, prof)
+	}
+	sort.Slice(profiles, func(i, j int) bool {
+		pi, pj := profiles[i], profiles[j]
+		if pi.took != pj.took {
+			return pi.took > pj.took
+		}
+		return pi.name < pj.name
+	})
+	tw := tabwriter.NewWriter(m.errOut, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "rule\ttime\tvisited\tmatched")
+	for _, prof := range profiles {
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%d\n", prof.name,
+			prof.took.Round(time.Microsecond), prof.visited, prof.matched)
+	}
+	tw.Flush()
+}
+
+// scopedNodes returns the nodes in the directories the rule is enabled in.
+func (m *matcher) scopedNodes(r *rule, nodes []ast.Node) []ast.Node {
+	if len(r.Enable) == 0 && len(r.Disable) == 0 {
+		return nodes
+	}
+	var scoped []ast.Node
+	for _, node := range nodes {
+		if r.enabledIn(m.nodeDir(node)) {
+			scoped = append(scoped, node)
+		}
+	}
+	return scoped
+}
+
+// nodeDir returns the absolute directory of the file containing a node.
+func (m *matcher) nodeDir(node ast.Node) string {
+	dir := filepath.Dir(m.loader.fset.PositionFor(node.Pos(), false).Filename)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(m.loader.wd, dir)
+	}
+	return dir
+}
+
+// scopedPaths drops the packages which none of the rules are enabled in, so
+// that they aren't loaded nor type-checked. It returns false if no packages
+// are left.
+func (m *matcher) scopedPaths(paths []string, wd string) ([]string, bool) {
+	gctx := gotool.Context{BuildContext: *m.ctx}
+	var kept []string
+	for _, path := range gctx.ImportPaths(paths) {
+		dir := filepath.Dir(path)
+		if !strings.HasSuffix(path, ".go") {
+			pkg, err := m.ctx.Import(path, wd, build.FindOnly)
+			if err != nil {
+				// let the loader report the error
+				kept = append(kept, path)
+				continue
+			}
+			dir = pkg.Dir
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(wd, dir)
+		}
+		for i := range m.rules {
+			if m.rules[i].enabledIn(dir) {
+				kept = append(kept, path)
+				break
+			}
+		}
+	}
+	return kept, len(kept) > 0
+}
+
+// rulesArgs implements "gogrep rules test", and "gogrep rules list" which
+// prints the rules given by the flags, one per line or as a JSON array.
+func (m *matcher) rulesArgs(args []string) error {
+	if len(args) > 0 && args[0] == "test" {
+		return m.testArgs(args[1:])
+	}
+	if len(args) < 1 || args[0] != "list" {
+		return fmt.Errorf("usage: gogrep rules list [-json] [flags]")
+	}
+	flagSet := flag.NewFlagSet("gogrep rules list", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	m.globalFlags(flagSet)
+	var cmds []exprCmd
+	cmdFlags(flagSet, &cmds) // only to allow them in the default flags
+	asJSON := flagSet.Bool("json", false, "")
+	args = append(m.defaultFlags[:len(m.defaultFlags):len(m.defaultFlags)], args[1:]...)
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf("usage: gogrep rules list [-json] [flags]")
+	}
+	if err := m.loadAllRules(); err != nil {
+		return err
+	}
+	if *asJSON {
+		rules := m.rules
+		if rules == nil {
+			rules = []rule{}
+		}
+		enc := json.NewEncoder(m.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rules)
+	}
+	tw := tabwriter.NewWriter(m.out, 0, 8, 2, ' ', 0)
+	for _, r := range m.rules {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Name, r.Severity, joinWords(r.Pipeline))
+	}
+	return tw.Flush()
+}
+
+// rxInterp matches the interpolations in rule messages, which are either
+// "{{$x}}" for the source of a wildcard's value, or "{{type $x}}" for its
+// type.
+var rxInterp = regexp.MustCompile(`\{\{\s*(type\s+)?\$(\w+)\s*\}\}`)
+
+// ruleMessage returns the message for a rule's match, with its
+// interpolations replaced. If the rule has no message, the matched node's
+// source is used.
+func (m *matcher) ruleMessage(r *rule, sub submatch) string {
+	if r.Message == "" {
+		return singleLinePrint(sub.node)
+	}
+	return rxInterp.ReplaceAllStringFunc(r.Message, func(s string) string {
+		sm := rxInterp.FindStringSubmatch(s)
+		node, ok := sub.values[sm[2]]
+		if !ok {
+			return s // unknown wildcard; leave as is
+		}
+		if sm[1] == "" {
+			return singleLinePrint(node)
+		}
+		if es, ok := node.(*ast.ExprStmt); ok {
+			node = es.X
+		}
+		expr, ok := node.(ast.Expr)
+		if !ok {
+			return "?"
+		}
+		t := m.typeOf(expr)
+		if t == nil {
+			return "?"
+		}
+		return types.TypeString(t, func(pkg *types.Package) string {
+			return pkg.Name()
+		})
+	})
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// testKey identifies the matches of a rule on a line.
+type testKey struct {
+	file string
+	line int
+	rule string
+}
+
+// testArgs implements "gogrep rules test dir", which runs the rules in all the
+// JSON and Go files in dir on the packages in dir/testdata, checking their
+// matches against the "// want rule-name" comments found there.
+func (m *matcher) testArgs(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: gogrep rules test dir")
+	}
+	dir := args[0]
+	var paths []string
+	for _, glob := range []string{"*.json", "*.go"} {
+		matches, err := filepath.Glob(filepath.Join(dir, glob))
+		if err != nil {
+			return err
+		}
+		paths = append(paths, matches...)
+	}
+	if len(paths) == 0 {
+		return fmt.Errorf("no rules files found in %s", dir)
+	}
+	// "..." skips testdata directories, so find the packages ourselves
+	pkgDirs := make(map[string]bool)
+	err := filepath.Walk(filepath.Join(dir, "testdata"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(path, ".go") {
+			pkgDirs[filepath.Dir(path)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	testArgs := []string{"-rules", strings.Join(paths, ",")}
+	for pkgDir := range pkgDirs {
+		if !filepath.IsAbs(pkgDir) && !build.IsLocalImport(filepath.ToSlash(pkgDir)) {
+			pkgDir = "." + string(filepath.Separator) + pkgDir
+		}
+		testArgs = append(testArgs, pkgDir)
+	}
+	sort.Strings(testArgs[2:])
+
+	// the config's flags and rule scopes are meant for real code
+	m.defaultFlags, m.ruleScopes = nil, nil
+	m.testingRules = true
+	defer func() { m.testingRules = false }()
+	if _, err := m.matchArgs(testArgs); err != nil {
+		return err
+	}
+	m.exitCode = 0
+
+	known := make(map[string]bool)
+	for _, r := range m.rules {
+		known[r.Name] = true
+	}
+	want := make(map[testKey]int)
+	cases := make(map[string]int)
+	for _, pkg := range m.pkgs {
+		for _, node := range pkg.nodes {
+			file, ok := node.(*ast.File)
+			if !ok {
+				continue
+			}
+			for _, cg := range file.Comments {
+				for _, c := range cg.List {
+					fields := strings.Fields(strings.TrimPrefix(c.Text, "//"))
+					if len(fields) == 0 || fields[0] != "want" {
+						continue
+					}
+					pos := m.position(c.Pos())
+					for _, name := range fields[1:] {
+						if !known[name] {
+							return fmt.Errorf("%s:%d: unknown rule %q",
+								pos.Filename, pos.Line, name)
+						}
+						want[testKey{pos.Filename, pos.Line, name}]++
+						cases[name]++
+					}
+				}
+			}
+		}
+	}
+	got := make(map[testKey]int)
+	msgs := make(map[testKey]string)
+	for _, rm := range m.ruleMatches {
+		key := testKey{rm.pos.Filename, rm.pos.Line, rm.rule.Name}
+		got[key]++
+		msgs[key] = rm.msg
+	}
+	var keys []testKey
+	for key := range want {
+		keys = append(keys, key)
+	}
+	for key := range got {
+		if _, ok := want[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ki, kj := keys[i], keys[j]
+		if ki.file != kj.file {
+			return ki.file < kj.file
+		}
+		return ki.line < kj.line
+	})
+	problems := make(map[string][]string)
+	for _, key := range keys {
+		var problem string
+		switch diff := got[key] - want[key]; {
+		case diff > 0:
+			problem = fmt.Sprintf("%s:%d: unexpected match: %s",
+				key.file, key.line, msgs[key])
+		case diff < 0:
+			problem = fmt.Sprintf("%s:%d: missing match", key.file, key.line)
+		default:
+			continue
+		}
+		problems[key.rule] = append(problems[key.rule], problem)
+	}
+
+	failed := 0
+	for _, r := range m.rules {
+		switch {
+		case len(problems[r.Name]) > 0:
+			failed++
+			fmt.Fprintf(m.out, "FAIL %s\n", r.Name)
+			for _, problem := range problems[r.Name] {
+				fmt.Fprintf(m.out, "  %s\n", problem)
+			}
+		case cases[r.Name] == 0:
+			fmt.Fprintf(m.out, "?    %s [no test cases]\n", r.Name)
+		default:
+			fmt.Fprintf(m.out, "ok   %s\n", r.Name)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rules failed", failed, len(m.rules))
+	}
+	return nil
+}
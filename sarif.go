@@ -0,0 +1,106 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+)
+
+// The subset of SARIF 2.1.0 needed to report rule matches.
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name  string      `json:"name"`
+	Rules []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string          `json:"id"`
+	ShortDescription *sarifMessage   `json:"shortDescription,omitempty"`
+	DefaultConfig    sarifRuleConfig `json:"defaultConfiguration"`
+}
+
+type sarifRuleConfig struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           sarifRegion   `json:"region"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+// printSARIF prints the rule matches collected so far as a SARIF log.
+func (m *matcher) printSARIF() error {
+	driver := sarifDriver{Name: "gogrep", Rules: []sarifRule{}}
+	for _, r := range m.rules {
+		sr := sarifRule{
+			ID:            r.Name,
+			DefaultConfig: sarifRuleConfig{severities[r.Severity].sarifLevel},
+		}
+		if r.Message != "" {
+			sr.ShortDescription = &sarifMessage{r.Message}
+		}
+		driver.Rules = append(driver.Rules, sr)
+	}
+	run := sarifRun{Tool: sarifTool{driver}, Results: []sarifResult{}}
+	for _, rm := range m.ruleMatches {
+		run.Results = append(run.Results, sarifResult{
+			RuleID:  rm.rule.Name,
+			Level:   severities[rm.rule.Severity].sarifLevel,
+			Message: sarifMessage{rm.msg},
+			Locations: []sarifLocation{{sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifact{filepath.ToSlash(rm.pos.Filename)},
+				Region:           sarifRegion{rm.pos.Line, rm.pos.Column, rm.end.Line, rm.end.Column},
+			}}},
+		})
+	}
+	enc := json.NewEncoder(m.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	})
+}
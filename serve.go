@@ -0,0 +1,381 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rpcRequest is a JSON-RPC 2.0 request, or a notification if it has no ID.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response, holding either a result or an
+// error.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// The JSON-RPC 2.0 error codes, plus rpcFailed for the requests which were
+// valid but failed, such as a pattern which doesn't parse.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcNoMethod       = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+)
+
+// loadParams are the params of "loadTargets", which loads the packages that
+// the following requests match on.
+type loadParams struct {
+	Packages  []string `json:"packages"`
+	Recursive bool     `json:"recursive,omitempty"`
+}
+
+type loadResult struct {
+	Packages []string `json:"packages"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+// queryParams are the params of "query" and "rewritePreview", holding the
+// commands as they would be given in the command line.
+type queryParams struct {
+	Args []string `json:"args"`
+}
+
+type rpcMatch struct {
+	File      string `json:"file"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+	Text      string `json:"text"`
+	Note      string `json:"note,omitempty"`
+}
+
+type queryResult struct {
+	Matches []rpcMatch `json:"matches"`
+	Errors  []string   `json:"errors,omitempty"`
+}
+
+type rewriteResult struct {
+	Edits  []fixEdit `json:"edits"`
+	Errors []string  `json:"errors,omitempty"`
+}
+
+// serveArgs implements "gogrep serve [-metrics addr]", which reads JSON-RPC
+// 2.0 requests from standard input, one per line, and writes a response line
+// for each of them. The packages loaded by "loadTargets" are kept between
+// requests, so that many queries only load them once. With -metrics, the
+// metrics of the requests served are exposed over HTTP at addr's /metrics.
+func (m *matcher) serveArgs(args []string) error {
+	flagSet := flag.NewFlagSet("gogrep serve", flag.ContinueOnError)
+	flagSet.SetOutput(ioutil.Discard)
+	metricsAddr := flagSet.String("metrics", "", "")
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+	if flagSet.NArg() > 0 {
+		return fmt.Errorf("usage: gogrep serve [-metrics addr]")
+	}
+	m.serving = true
+	defer func() { m.serving = false }()
+	m.metrics = newServeMetrics()
+	if *metricsAddr != "" {
+		ln, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m.metrics)
+		go http.Serve(ln, mux)
+		fmt.Fprintf(m.errOut, "serving metrics on http://%s/metrics\n", ln.Addr())
+	}
+	in := m.in
+	if in == nil {
+		in = os.Stdin
+	}
+	r := bufio.NewReader(in)
+	enc := json.NewEncoder(m.out)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if resp := m.serveRequest(line); resp != nil {
+				if err := enc.Encode(resp); err != nil {
+					return err
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// serveRequest handles a single request, returning nil for notifications.
+func (m *matcher) serveRequest(line []byte) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		m.metrics.request("", false)
+		return &rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{rpcParseError, err.Error()}}
+	}
+	resp := &rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	if len(req.ID) == 0 {
+		resp.ID = json.RawMessage("null")
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		resp.Error = &rpcError{rpcInvalidRequest, `want "jsonrpc": "2.0" and a method`}
+		m.metrics.request(req.Method, false)
+		return resp
+	}
+	var handle func() (interface{}, error)
+	switch req.Method {
+	case "loadTargets":
+		var params loadParams
+		handle = func() (interface{}, error) { return m.loadTargets(params) }
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = &rpcError{rpcInvalidParams, err.Error()}
+		}
+	case "query", "rewritePreview":
+		var params queryParams
+		handle = func() (interface{}, error) { return m.serveQuery(params.Args, req.Method == "rewritePreview") }
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			resp.Error = &rpcError{rpcInvalidParams, err.Error()}
+		}
+	default:
+		resp.Error = &rpcError{rpcNoMethod, fmt.Sprintf("unknown method %q", req.Method)}
+	}
+	if resp.Error == nil {
+		result, err := handle()
+		if err != nil {
+			resp.Error = &rpcError{rpcFailed, err.Error()}
+		} else {
+			resp.Result = result
+		}
+	}
+	m.metrics.request(req.Method, resp.Error == nil)
+	if len(req.ID) == 0 {
+		return nil // a notification
+	}
+	return resp
+}
+
+// loadTargets loads the packages with types, replacing those loaded before.
+func (m *matcher) loadTargets(params loadParams) (*loadResult, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	m.loader = nodeLoader{
+		wd: wd, ctx: m.ctx, fset: token.NewFileSet(),
+		imports: m.imports, strict: m.strict,
+	}
+	m.pkgs, m.targets = nil, nil
+	paths, err := m.loader.buildTargets(params.Packages)
+	if err != nil {
+		return nil, err
+	}
+	pkgs, err := m.loader.typed(paths, params.Recursive)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pkgs, func(i, j int) bool {
+		return pkgs[i].path < pkgs[j].path
+	})
+	m.pkgs, m.targets = pkgs, &params
+	m.metrics.loaded(len(pkgs))
+	res := &loadResult{Packages: []string{}}
+	for _, pkg := range pkgs {
+		res.Packages = append(res.Packages, pkg.path)
+	}
+	res.Errors = errorStrings(m.loader.errs)
+	return res, nil
+}
+
+// serveQuery runs the commands on the loaded packages via matchArgs, like
+// the command line would, returning their matches. If rewrite is true, the
+// edits made by the substitutions are returned instead. Since substitutions
+// replace nodes within the syntax trees, the packages are loaded again after
+// any of them.
+func (m *matcher) serveQuery(args []string, rewrite bool) (interface{}, error) {
+	m.previewing, m.substituted = rewrite, false
+	m.pkgErrs = nil
+	start := time.Now()
+	subs, err := m.matchArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	m.metrics.matched(time.Since(start))
+	errs := errorStrings(m.pkgErrs)
+	m.pkgErrs = nil
+	var res interface{}
+	if rewrite {
+		edits, err := m.substEdits(subs)
+		if err != nil {
+			return nil, err
+		}
+		res = &rewriteResult{Edits: append([]fixEdit{}, edits...), Errors: errs}
+	} else {
+		matches := []rpcMatch{}
+		for _, sub := range subs {
+			start := m.position(sub.span().pos)
+			end := m.position(sub.span().end)
+			text := singleLinePrint(sub.node)
+			if f, ok := sub.node.(*ast.File); ok {
+				text = "package " + f.Name.Name
+			}
+			matches = append(matches, rpcMatch{
+				File:      start.Filename,
+				Line:      start.Line,
+				Column:    start.Column,
+				EndLine:   end.Line,
+				EndColumn: end.Column,
+				Text:      text,
+				Note:      strings.TrimPrefix(m.note(sub.node), " // "),
+			})
+		}
+		res = &queryResult{Matches: matches, Errors: errs}
+	}
+	if m.substituted {
+		// load them again, as the substitutions replaced nodes in the
+		// syntax trees
+		if _, err := m.loadTargets(*m.targets); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// checkServeCmds is called by matchArgs when serving, to reject the queries
+// which can't be served: those given packages, which are loaded by
+// loadTargets instead, those using modes which print their output, such as
+// -explain, and those with options for loading packages, such as -skip.
+func (m *matcher) checkServeCmds(cmds []exprCmd, paths []string) error {
+	if m.targets == nil {
+		return fmt.Errorf("no packages loaded; use loadTargets first")
+	}
+	if len(paths) > 0 {
+		return fmt.Errorf("packages are given to loadTargets, not as arguments")
+	}
+	if m.explain || m.estimate || m.cloneSize > 0 || m.unsafeReport || m.listIgnores ||
+		m.testSrc != "" || len(m.execArgs) > 0 || m.rulesPath != "" || m.packs != "" ||
+		m.ctags != "" || m.etags != "" || m.countBy != "" || m.group != "" {
+		return fmt.Errorf("only commands are supported, not modes like -explain or -rules")
+	}
+	if m.recursive || len(m.imports) > 0 || m.shard.count > 0 || len(m.skips) > 0 ||
+		m.checkpointPath != "" || m.resume || m.maxMem > 0 || m.cacheDir != "" || m.maxTime > 0 {
+		return fmt.Errorf("cannot use -r, -import, -shard, -skip, or batching options like " +
+			"-max-time, as the packages are loaded by loadTargets")
+	}
+	for _, cmd := range cmds {
+		switch cmd.name {
+		case "w":
+			return fmt.Errorf("cannot use -w; use rewritePreview")
+		case "s":
+			m.substituted = true
+		}
+	}
+	if m.previewing && !m.substituted {
+		return fmt.Errorf("rewritePreview: need at least one -s command")
+	}
+	return nil
+}
+
+// substEdits returns the edits replacing the source ranges of substitutions
+// with their new source, without writing them to the files. Substitutions
+// within others are skipped, as the outer edit includes them.
+func (m *matcher) substEdits(subs []submatch) ([]fixEdit, error) {
+	var substs []submatch
+	for _, sub := range subs {
+		if sub.orig.pos.IsValid() {
+			substs = append(substs, sub)
+		}
+	}
+	sort.SliceStable(substs, func(i, j int) bool {
+		pi := m.loader.fset.PositionFor(substs[i].orig.pos, false)
+		pj := m.loader.fset.PositionFor(substs[j].orig.pos, false)
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	var edits []fixEdit
+	srcs := make(map[*ast.File][]byte)
+	var last token.Position
+	for _, sub := range substs {
+		file := m.loadedFile(sub.orig.pos)
+		if file == nil {
+			continue
+		}
+		start := m.loader.fset.PositionFor(sub.orig.pos, false)
+		end := m.loader.fset.PositionFor(sub.orig.end, false)
+		if start.Filename == last.Filename && start.Offset < last.Offset {
+			continue
+		}
+		last = end
+		src, ok := srcs[file]
+		if !ok {
+			var err error
+			if src, err = ioutil.ReadFile(start.Filename); err != nil {
+				return nil, err
+			}
+			srcs[file] = src
+		}
+		repl, err := m.substSrc(file, src, sub)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, fixEdit{
+			File:      m.position(sub.orig.pos).Filename,
+			Offset:    start.Offset,
+			End:       end.Offset,
+			Line:      start.Line,
+			Column:    start.Column,
+			EndLine:   end.Line,
+			EndColumn: end.Column,
+			NewText:   string(repl),
+		})
+	}
+	return edits, nil
+}
+
+func errorStrings(errs []error) []string {
+	var list []string
+	for _, err := range errs {
+		list = append(list, err.Error())
+	}
+	return list
+}
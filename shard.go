@@ -0,0 +1,209 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// shardSpec is a shard of the packages given via -shard i/n, where i starts
+// at 1. The zero value keeps all packages.
+type shardSpec struct {
+	index, count int
+}
+
+// keeps reports whether a package or file belongs to the shard. Packages are
+// partitioned by a hash of their path, so that the same package always goes
+// to the same shard, even as others are added or removed.
+func (s shardSpec) keeps(path string) bool {
+	if s.count == 0 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return int(h.Sum32()%uint32(s.count)) == s.index-1
+}
+
+// shardFlag parses the shard given to -shard.
+type shardFlag struct {
+	shard *shardSpec
+}
+
+func (f *shardFlag) String() string { return "" }
+func (f *shardFlag) Set(val string) error {
+	i := strings.IndexByte(val, '/')
+	if i < 0 {
+		return fmt.Errorf("shard %q is not like 2/5", val)
+	}
+	index, err1 := strconv.Atoi(val[:i])
+	count, err2 := strconv.Atoi(val[i+1:])
+	if err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return fmt.Errorf("shard %q is not like 2/5, from 1/n to n/n", val)
+	}
+	*f.shard = shardSpec{index, count}
+	return nil
+}
+
+// mergeArgs implements "gogrep merge files...", which merges the SARIF or
+// reviewdog results printed by many runs, such as each of the -shard runs,
+// into one. Results found by more than one run are only kept once, and the
+// merged results are sorted by file and position.
+func (m *matcher) mergeArgs(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("usage: gogrep merge files...")
+	}
+	var format string
+	var logs []sarifLog
+	var diags []rdDiagnostic
+	for _, path := range args {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var probe struct {
+			Runs        json.RawMessage `json:"runs"`
+			Diagnostics json.RawMessage `json:"diagnostics"`
+		}
+		fileFormat := "rdjsonl"
+		if err := json.Unmarshal(data, &probe); err == nil {
+			switch {
+			case probe.Runs != nil:
+				fileFormat = "sarif"
+			case probe.Diagnostics != nil:
+				fileFormat = "rdjson"
+			}
+		}
+		if format != "" && fileFormat != format {
+			return fmt.Errorf("%s: cannot merge %s with %s", path, fileFormat, format)
+		}
+		format = fileFormat
+		switch format {
+		case "sarif":
+			var log sarifLog
+			if err := json.Unmarshal(data, &log); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			logs = append(logs, log)
+		case "rdjson":
+			var res rdResult
+			if err := json.Unmarshal(data, &res); err != nil {
+				return fmt.Errorf("%s: %v", path, err)
+			}
+			diags = append(diags, res.Diagnostics...)
+		default:
+			scanner := bufio.NewScanner(bytes.NewReader(data))
+			scanner.Buffer(nil, 1<<24)
+			for scanner.Scan() {
+				line := bytes.TrimSpace(scanner.Bytes())
+				if len(line) == 0 {
+					continue
+				}
+				var diag rdDiagnostic
+				if err := json.Unmarshal(line, &diag); err != nil {
+					return fmt.Errorf("%s: not SARIF, rdjson, or rdjsonl: %v", path, err)
+				}
+				diags = append(diags, diag)
+			}
+			if err := scanner.Err(); err != nil {
+				return err
+			}
+		}
+	}
+	enc := json.NewEncoder(m.out)
+	switch format {
+	case "sarif":
+		enc.SetIndent("", "  ")
+		return enc.Encode(mergeSARIF(logs))
+	case "rdjson":
+		enc.SetIndent("", "  ")
+		return enc.Encode(rdResult{Source: rdSource{"gogrep"}, Diagnostics: mergeRDJSON(diags)})
+	}
+	for _, diag := range mergeRDJSON(diags) {
+		if err := enc.Encode(diag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeSARIF merges the runs of many SARIF logs into a single run, with all
+// of their rules, in the order they're first found, and their results.
+func mergeSARIF(logs []sarifLog) sarifLog {
+	driver := sarifDriver{Name: "gogrep", Rules: []sarifRule{}}
+	run := sarifRun{Results: []sarifResult{}}
+	seenRules := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, log := range logs {
+		for _, r := range log.Runs {
+			for _, rule := range r.Tool.Driver.Rules {
+				if !seenRules[rule.ID] {
+					seenRules[rule.ID] = true
+					driver.Rules = append(driver.Rules, rule)
+				}
+			}
+			for _, res := range r.Results {
+				key, _ := json.Marshal(res)
+				if !seen[string(key)] {
+					seen[string(key)] = true
+					run.Results = append(run.Results, res)
+				}
+			}
+		}
+	}
+	sort.SliceStable(run.Results, func(i, j int) bool {
+		return sarifLess(run.Results[i], run.Results[j])
+	})
+	run.Tool = sarifTool{driver}
+	return sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}
+}
+
+func sarifLess(a, b sarifResult) bool {
+	if len(a.Locations) == 0 || len(b.Locations) == 0 {
+		return len(a.Locations) < len(b.Locations)
+	}
+	la, lb := a.Locations[0].PhysicalLocation, b.Locations[0].PhysicalLocation
+	if la.ArtifactLocation.URI != lb.ArtifactLocation.URI {
+		return la.ArtifactLocation.URI < lb.ArtifactLocation.URI
+	}
+	if la.Region.StartLine != lb.Region.StartLine {
+		return la.Region.StartLine < lb.Region.StartLine
+	}
+	return la.Region.StartColumn < lb.Region.StartColumn
+}
+
+// mergeRDJSON removes the duplicate diagnostics, sorting the rest.
+func mergeRDJSON(diags []rdDiagnostic) []rdDiagnostic {
+	merged := []rdDiagnostic{}
+	seen := make(map[string]bool)
+	for _, diag := range diags {
+		key, _ := json.Marshal(diag)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			merged = append(merged, diag)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		li, lj := merged[i].Location, merged[j].Location
+		if li.Path != lj.Path {
+			return li.Path < lj.Path
+		}
+		if li.Range.Start.Line != lj.Range.Start.Line {
+			return li.Range.Start.Line < lj.Range.Start.Line
+		}
+		return li.Range.Start.Column < lj.Range.Start.Column
+	})
+	return merged
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/build"
+	"go/types"
+)
+
+// typeSize returns the size in bytes of a node's type, or its alignment if
+// align is true, on the target platform given by GOARCH. Type expressions,
+// type declarations, and declared names count as well as values, so that both a struct type
+// and the values of that type can be matched.
+func (m *matcher) typeSize(node ast.Node, align bool) (int64, bool) {
+	var t types.Type
+	switch x := node.(type) {
+	case *ast.TypeSpec:
+		if obj := m.Info.Defs[x.Name]; obj != nil {
+			t = obj.Type()
+		}
+	case *ast.Ident:
+		// declared names, like params, aren't in Types
+		switch obj := m.Info.ObjectOf(x).(type) {
+		case *types.Var, *types.TypeName, *types.Const:
+			t = obj.Type()
+		}
+	case ast.Expr:
+		if tv, ok := m.Info.Types[x]; ok && !tv.IsVoid() {
+			t = tv.Type
+		}
+	}
+	if !sizeable(t, 0) {
+		return 0, false
+	}
+	sizes := m.sizes()
+	if align {
+		return sizes.Alignof(t), true
+	}
+	return sizes.Sizeof(t), true
+}
+
+// sizes returns the type sizes of the gc compiler for the target GOARCH.
+func (m *matcher) sizes() types.Sizes {
+	ctx := m.ctx
+	if ctx == nil {
+		ctx = &build.Default
+	}
+	if sizes := types.SizesFor("gc", ctx.GOARCH); sizes != nil {
+		return sizes
+	}
+	return types.SizesFor("gc", "amd64")
+}
+
+// sizeable reports whether a type has a known size, which isn't the case for
+// untyped constants, tuples, or types depending on type parameters.
+func sizeable(t types.Type, depth int) bool {
+	if t == nil || depth > 8 {
+		return t != nil
+	}
+	switch x := t.(type) {
+	case *types.Basic:
+		return x.Info()&types.IsUntyped == 0 && x.Kind() != types.Invalid
+	case *types.Tuple, *types.TypeParam:
+		return false
+	case *types.Named:
+		if x.TypeParams().Len() > x.TypeArgs().Len() {
+			return false // a generic type which isn't instantiated
+		}
+		return sizeable(x.Underlying(), depth+1)
+	case *types.Array:
+		return sizeable(x.Elem(), depth+1)
+	case *types.Struct:
+		for i := 0; i < x.NumFields(); i++ {
+			if !sizeable(x.Field(i).Type(), depth+1) {
+				return false
+			}
+		}
+	}
+	return true
+}
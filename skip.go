@@ -0,0 +1,146 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// skipFlag collects the package patterns given to -skip.
+type skipFlag struct {
+	patterns *[]string
+}
+
+func (s *skipFlag) String() string { return "" }
+func (s *skipFlag) Set(val string) error {
+	for _, pattern := range strings.Split(val, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			*s.patterns = append(*s.patterns, pattern)
+		}
+	}
+	return nil
+}
+
+// matchPkgPattern reports whether a package path matches a pattern of the
+// skip or priority lists, where "..." matches any string like with "go
+// list". As with it, "foo/..." also matches "foo" itself.
+func matchPkgPattern(pattern, path string) bool {
+	if !strings.Contains(pattern, "...") {
+		return pattern == path
+	}
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\.\.\.`, `.*`, -1)
+	if strings.HasSuffix(expr, "/.*") {
+		expr = strings.TrimSuffix(expr, "/.*") + "(/.*)?"
+	}
+	return regexp.MustCompile("^" + expr + "$").MatchString(path)
+}
+
+// isLocalPattern reports whether a package pattern is a directory,
+// such as "./gen/...", instead of an import path.
+func isLocalPattern(pattern string) bool {
+	return build.IsLocalImport(pattern) || filepath.IsAbs(pattern)
+}
+
+// skipListed reports whether a package is in the skip list, recording it to
+// be reported if so.
+func (l *nodeLoader) skipListed(path string) bool {
+	if l.firstMatch(l.skips, path) < 0 {
+		return false
+	}
+	l.skipped = append(l.skipped, path)
+	return true
+}
+
+// firstMatch returns the index of the first pattern matching a package, or
+// -1 if none does. Directory patterns are matched against the package's
+// directory, so that they also match the packages found via -r.
+func (l *nodeLoader) firstMatch(patterns []string, path string) int {
+	dir := ""
+	for i, pattern := range patterns {
+		target := path
+		if isLocalPattern(pattern) {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(l.wd, pattern)
+			}
+			if dir == "" {
+				dir = l.pkgDir(path)
+			}
+			pattern, target = filepath.ToSlash(pattern), filepath.ToSlash(dir)
+		}
+		if matchPkgPattern(pattern, target) {
+			return i
+		}
+	}
+	return -1
+}
+
+// pkgDir returns the absolute directory of a package, or "-" if it can't be
+// found.
+func (l *nodeLoader) pkgDir(path string) string {
+	if isLocalPattern(path) {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(l.wd, path)
+		}
+		return path
+	}
+	pkg, err := l.ctx.Import(path, l.wd, build.FindOnly)
+	if err != nil {
+		return "-"
+	}
+	return pkg.Dir
+}
+
+// reportSkipped prints the packages left out as per the skip list. They
+// aren't errors, as the user asked for them to be skipped.
+func (m *matcher) reportSkipped() {
+	for _, path := range m.loader.skipped {
+		fmt.Fprintf(m.errOut, "skipped %s: in the skip list\n", path)
+	}
+	m.loader.skipped = nil
+}
+
+// pkgTimedOut is raised when matching a package takes longer than the
+// duration given via -package-timeout.
+type pkgTimedOut struct{}
+
+// checkDeadline raises pkgTimedOut if the package being matched is past its
+// deadline. As it's called for every node visited, the clock is only read
+// every so often.
+func (m *matcher) checkDeadline() {
+	if m.deadline.IsZero() || m.visited%1024 != 0 {
+		return
+	}
+	if time.Now().After(m.deadline) {
+		panic(pkgTimedOut{})
+	}
+}
+
+// guardPkg runs fn to match a package, returning an error instead if it
+// takes longer than -package-timeout or if it panics, so that a single
+// pathological package is skipped and reported rather than stalling or
+// crashing the entire run.
+func (m *matcher) guardPkg(path string, fn func()) (err error) {
+	if m.pkgTimeout > 0 {
+		m.deadline = time.Now().Add(m.pkgTimeout)
+	}
+	defer func() {
+		m.deadline = time.Time{}
+		r := recover()
+		switch r.(type) {
+		case nil:
+		case pkgTimedOut:
+			err = fmt.Errorf("%s: timed out after %v; skipped", path, m.pkgTimeout)
+		default:
+			err = fmt.Errorf("%s: panic while matching: %v; skipped", path, r)
+		}
+	}()
+	fn()
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+)
+
+// snapFlag parses the granularity given to -snap, which is either "stmt" or
+// "line".
+type snapFlag struct {
+	snap *string
+}
+
+func (f *snapFlag) String() string { return "" }
+func (f *snapFlag) Set(val string) error {
+	switch val {
+	case "stmt", "line":
+	default:
+		return fmt.Errorf("unknown snap %q; want stmt or line", val)
+	}
+	*f.snap = val
+	return nil
+}
+
+// snapCmds splits the commands where the matches are snapped via -snap,
+// which is before the first one substituting or writing them, or after all
+// of them.
+func snapCmds(cmds []exprCmd) (before, after []exprCmd) {
+	for i, cmd := range cmds {
+		if cmd.name == "s" || cmd.name == "w" {
+			return cmds[:i], cmds[i:]
+		}
+	}
+	return cmds, nil
+}
+
+// snapSubs expands each match to its enclosing statement, or to the nodes
+// spanning its entire lines, as per -snap. Matches which end up snapped to
+// the same node are only kept once, with the wildcard values of the first.
+func (m *matcher) snapSubs(subs []submatch) []submatch {
+	seen := make(map[nodePosHash]bool)
+	snapped := subs[:0]
+	for _, sub := range subs {
+		if m.snap == "stmt" {
+			sub.node = m.snapStmt(sub.node)
+		} else {
+			sub.node = m.snapLine(sub.node)
+		}
+		if hash := posHash(sub.node); !seen[hash] {
+			seen[hash] = true
+			snapped = append(snapped, sub)
+		}
+	}
+	return snapped
+}
+
+// snapStmt returns the statement enclosing a node, or the declaration if it
+// is not within a func body. Statements, lists of them, and nodes outside of
+// both are left as is.
+func (m *matcher) snapStmt(node ast.Node) ast.Node {
+	for n := node; n != nil; n = m.parentOf(n) {
+		switch n.(type) {
+		case ast.Decl:
+			if ds, ok := m.parentOf(n).(*ast.DeclStmt); ok {
+				return ds
+			}
+			return n
+		case ast.Stmt, stmtList:
+			return n
+		case *ast.File:
+			return node
+		}
+	}
+	return node
+}
+
+// snapLine returns the outermost node enclosing a node which starts on its
+// first line and ends on its last line, such that printing it shows the
+// whole lines, like "x := f()" when matching "f()".
+func (m *matcher) snapLine(node ast.Node) ast.Node {
+	fset := m.loader.fset
+	first := fset.Position(node.Pos()).Line
+	last := fset.Position(node.End()).Line
+	for {
+		parent := m.parentOf(node)
+		if parent == nil || !parent.Pos().IsValid() {
+			return node
+		}
+		if _, ok := parent.(*ast.File); ok {
+			return node
+		}
+		if fset.Position(parent.Pos()).Line != first || fset.Position(parent.End()).Line != last {
+			return node
+		}
+		node = parent
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+// testSnippet matches the commands against the source in m.testSrc, which
+// can be a file, declarations, statements, or expressions. It prints each
+// match followed by the values of its wildcards, or "no match" while exiting
+// with a status of 1.
+func (m *matcher) testSnippet(cmds []exprCmd) error {
+	if len(cmds) == 0 {
+		return fmt.Errorf("-test-src needs at least one command")
+	}
+	if m.typed {
+		return fmt.Errorf("-test-src can't be used with type information")
+	}
+	src := m.testSrc
+	if src == "-" {
+		data, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		src = string(data)
+	}
+	node, err := parseDetectingNode(src)
+	if err != nil {
+		return fmt.Errorf("cannot parse snippet: %v", err)
+	}
+	m.loader = nodeLoader{fset: token.NewFileSet()}
+	m.Info = types.Info{}
+	m.notes = make(map[nodePosHash]string)
+	m.exitCode = 0
+	subs, err := m.recoverMatchSubs(cmds, []ast.Node{node})
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		fmt.Fprintln(m.out, "no match")
+		m.exitCode = 1
+		return nil
+	}
+	for _, sub := range subs {
+		fmt.Fprintf(m.out, "match: %s%s\n", singleLinePrint(sub.node), m.note(sub.node))
+		var names []string
+		for name := range sub.values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(m.out, "  $%s = %s\n", name, singleLinePrint(sub.values[name]))
+		}
+	}
+	return nil
+}
@@ -4,7 +4,6 @@
 package main
 
 import (
-	"fmt"
 	"go/ast"
 	"go/token"
 	"reflect"
@@ -17,9 +16,29 @@ func (m *matcher) cmdSubst(cmd exprCmd, subs []submatch) []submatch {
 		// since we'll want to set positions within the file's
 		// FileSet
 		scrubPositions(nodeCopy)
+		if x, ok := nodeCopy.(*ast.BinaryExpr); ok && m.hasOperandsWild(x) {
+			// operands at the root have no parent to be replaced
+			// within either
+			nodeCopy = m.fillOperands(x, sub.values)
+		}
+		if x, ok := nodeCopy.(*ast.IfStmt); ok && m.hasArmsWild(x) {
+			nodeCopy = m.fillArms(x, sub.values)
+		}
+		if x, ok := nodeCopy.(*ast.CallExpr); ok && m.layersWild(x) != nil {
+			nodeCopy = m.fillLayers(x, sub.values)
+		}
 
 		m.fillParents(nodeCopy)
 		m.fillValues(nodeCopy, sub.values)
+		if id, ok := nodeCopy.(*ast.Ident); ok {
+			// a lone wildcard has no parent to be replaced within
+			if prev, ok := sub.values[m.info(fromWildName(id.Name)).name]; ok {
+				nodeCopy = prev
+			}
+		}
+		if !sub.orig.pos.IsValid() {
+			sub.orig = posHash(sub.node)
+		}
 		m.substNode(sub.node, nodeCopy)
 		sub.node = nodeCopy
 	}
@@ -28,15 +47,68 @@ func (m *matcher) cmdSubst(cmd exprCmd, subs []submatch) []submatch {
 
 func (m *matcher) fillValues(node ast.Node, values map[string]ast.Node) {
 	inspect(node, func(node ast.Node) bool {
+		if x, ok := node.(*ast.BinaryExpr); ok && m.hasOperandsWild(x) {
+			// $*x as operands, to be replaced by the operands
+			filled := m.fillOperands(x, values)
+			m.fillParents(filled)
+			m.substNode(x, filled)
+			m.fillValues(filled, values)
+			return false
+		}
+		if x, ok := node.(*ast.IfStmt); ok && m.hasArmsWild(x) {
+			// arms, to be replaced by the arms
+			filled := m.fillArms(x, values)
+			m.fillParents(filled)
+			m.substNode(x, filled)
+			m.fillValues(filled, values)
+			return false
+		}
+		if x, ok := node.(*ast.CallExpr); ok && m.layersWild(x) != nil {
+			// layers, to be rebuilt around the expression
+			filled := m.fillLayers(x, values)
+			m.fillParents(filled)
+			m.substNode(x, filled)
+			m.fillValues(filled, values)
+			return false
+		}
 		id := fromWildNode(node)
 		info := m.info(id)
 		if info.name == "" {
 			return true
 		}
 		prev := values[info.name]
-		switch prev.(type) {
+		switch prev := prev.(type) {
+		case chainList:
+			// $*x as selectors or as the base of a chain, to be
+			// replaced by the chain
+			sel, ok := m.parentOf(node).(*ast.SelectorExpr)
+			switch {
+			case ok && sel.Sel == node:
+				m.substNode(sel, rebuildChain(sel.X, prev))
+			case ok && sel.X == node && len(prev) > 0 && !isChainLink(prev[0]):
+				m.substNode(node, rebuildChain(nil, prev))
+			default:
+				m.failf(node, "$*%s matched selectors, so it must be one too", info.name)
+			}
+			return true
+		case operandList:
+			m.failf(node, "$*%s matched operands of %s, so it must be one too", info.name, prev.op)
+		case armList:
+			m.failf(node, "$*%s matched arms of an if, so it must be one too", info.name)
+		case layerList:
+			m.failf(node, "$*%s matched layers, so it must wrap an expression as in $*%s(x)",
+				info.name, info.name)
 		case exprList:
+			if call, ok := m.parentOf(node).(*ast.CallExpr); ok && m.isSpread(prev) &&
+				call.Args[len(call.Args)-1] == node && !call.Ellipsis.IsValid() {
+				// $*x matched the arguments of f(xs...), so keep
+				// spreading the last one
+				call.Ellipsis = spreadPos
+			}
 			node = exprList([]ast.Expr{node.(*ast.Ident)})
+		case fieldList:
+			// $*x as a field, to be replaced by the fields
+			node = fieldList{m.parentOf(node).(*ast.Field)}
 		}
 		m.substNode(node, prev)
 		return true
@@ -62,7 +134,7 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 		case ast.Stmt:
 			*x = y
 		default:
-			panic(fmt.Sprintf("cannot replace stmt with %T", y))
+			m.failf(oldNode, "cannot replace a statement with %T", y)
 		}
 	case *[]ast.Expr:
 		oldList := oldNode.(exprList)
@@ -80,7 +152,7 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 		case exprList:
 			*x = append(first, y...)
 		default:
-			panic(fmt.Sprintf("cannot replace exprs with %T", y))
+			m.failf(oldNode, "cannot replace expressions with %T", y)
 		}
 		*x = append(*x, last...)
 	case *[]ast.Stmt:
@@ -103,13 +175,28 @@ func (m *matcher) substNode(oldNode, newNode ast.Node) {
 		case stmtList:
 			*x = append(first, y...)
 		default:
-			panic(fmt.Sprintf("cannot replace stmts with %T", y))
+			m.failf(oldNode, "cannot replace statements with %T", y)
 		}
 		*x = append(*x, last...)
+	case *[]*ast.Field:
+		oldList := oldNode.(fieldList)
+		var first, last []*ast.Field
+		for i, field := range *x {
+			if field == oldList[0] {
+				first = (*x)[:i]
+				last = (*x)[i+len(oldList):]
+				break
+			}
+		}
+		y, ok := newNode.(fieldList)
+		if !ok {
+			m.failf(oldNode, "cannot replace fields with %T", newNode)
+		}
+		*x = append(append(first, y...), last...)
 	case nil:
 		return
 	default:
-		panic(fmt.Sprintf("unsupported substitution: %T", x))
+		m.failf(oldNode, "cannot substitute %T", newNode)
 	}
 	// the new nodes have scrubbed positions, so try our best to use
 	// sensible ones
@@ -121,7 +208,11 @@ func (m *matcher) parentOf(node ast.Node) ast.Node {
 	if ok {
 		node = list.at(0)
 	}
-	return m.parents[node]
+	parent := m.parents[node]
+	if _, ok := parent.(nodeList); ok && list != nil {
+		return nil // the list is the root, being its own parent
+	}
+	return parent
 }
 
 func (m *matcher) setParentOf(node, parent ast.Node) {
@@ -183,8 +274,15 @@ func posHash(node ast.Node) nodePosHash {
 
 var posType = reflect.TypeOf(token.NoPos)
 
+// spreadPos marks the "..." of a call whose positions were scrubbed, as a
+// zero position would drop it. fixPositions moves it after the last argument.
+const spreadPos = token.Pos(1)
+
 func scrubPositions(node ast.Node) {
 	inspect(node, func(node ast.Node) bool {
+		if call, ok := node.(*ast.CallExpr); ok && call.Ellipsis.IsValid() {
+			defer func() { call.Ellipsis = spreadPos }()
+		}
 		v := reflect.ValueOf(node)
 		if v.Kind() != reflect.Ptr {
 			return true
@@ -214,6 +312,10 @@ func fixPositions(node ast.Node) {
 		switch x := node.(type) {
 		case *ast.GoStmt:
 			fallback(&x.Go, x.Call.Pos())
+		case *ast.CallExpr:
+			if x.Ellipsis == spreadPos && len(x.Args) > 0 {
+				x.Ellipsis = x.Args[len(x.Args)-1].End()
+			}
 		}
 		return true
 	})
@@ -0,0 +1,192 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"sort"
+	"strings"
+)
+
+// defaultRuleName is the name used for //gogrep:ignore directives when the
+// matches don't come from a named rule.
+const defaultRuleName = "gogrep"
+
+// suppressed reports whether a match is silenced by a comment such as
+// "//nolint" or "//nolint:name", either on the line where the match starts
+// or attached to its enclosing declaration. The name is that of the rule
+// which the match comes from; matches from commands have no name, so only
+// a comment without names silences them.
+func (m *matcher) suppressed(node ast.Node, name string) bool {
+	f := m.fileOf(node)
+	if f == nil {
+		return false
+	}
+	fset := m.loader.fset
+	line := fset.PositionFor(node.Pos(), false).Line
+	decl := m.enclosingDecl(node)
+	declLine := 0
+	if decl != nil {
+		declLine = fset.PositionFor(decl.Pos(), false).Line
+	}
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if !m.suppresses(c.Text, name) {
+				continue
+			}
+			switch fset.PositionFor(c.Pos(), false).Line {
+			case line, declLine:
+				return true
+			}
+			if decl != nil && cg == m.docOf(decl) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// suppresses reports whether a comment's text is a suppression directive
+// which applies to the given name. A directive without a list of names, like
+// "//nolint", applies to all names.
+func (m *matcher) suppresses(text, name string) bool {
+	text = strings.TrimPrefix(text, "//")
+	if !strings.HasPrefix(text, m.nolint) {
+		return false
+	}
+	text = text[len(m.nolint):]
+	if text == "" || text[0] == ' ' {
+		return true
+	}
+	if text[0] != ':' {
+		return false // e.g. "nolintfoo"
+	}
+	if i := strings.IndexByte(text, ' '); i >= 0 {
+		text = text[:i] // drop any explanation
+	}
+	for _, field := range strings.Split(text[1:], ",") {
+		if field == name && name != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// enclosingDecl returns the top-level declaration containing a node, if any.
+func (m *matcher) enclosingDecl(node ast.Node) ast.Decl {
+	var decl ast.Decl
+	for ; node != nil; node = m.parentOf(node) {
+		if d, ok := node.(ast.Decl); ok {
+			decl = d
+		}
+	}
+	return decl
+}
+
+const ignorePrefix = "//gogrep:ignore"
+
+// ignoreDirective is a //gogrep:ignore comment, covering a range of lines.
+type ignoreDirective struct {
+	comment  *ast.Comment
+	scope    string // "line", "block", "file"
+	from, to int    // lines
+	name     string // "*" if it applies to all
+	reason   string
+}
+
+// ignored reports whether a match is within the lines covered by a
+// //gogrep:ignore directive that applies to the given name.
+func (m *matcher) ignored(node ast.Node, name string) bool {
+	f := m.fileOf(node)
+	if f == nil {
+		return false
+	}
+	line := m.loader.fset.PositionFor(node.Pos(), false).Line
+	for _, dir := range m.ignoreDirectives(f) {
+		if line < dir.from || line > dir.to {
+			continue
+		}
+		if dir.name == "*" || dir.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *matcher) ignoreDirectives(f *ast.File) []ignoreDirective {
+	if dirs, ok := m.ignoreDirs[f]; ok {
+		return dirs
+	}
+	fset := m.loader.fset
+	lineOf := func(pos token.Pos) int { return fset.PositionFor(pos, false).Line }
+	var dirs []ignoreDirective
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if c.Text != ignorePrefix &&
+				!strings.HasPrefix(c.Text, ignorePrefix+" ") {
+				continue
+			}
+			dir := ignoreDirective{comment: c, name: "*"}
+			fields := strings.Fields(c.Text[len(ignorePrefix):])
+			if len(fields) > 0 {
+				dir.name = fields[0]
+				dir.reason = strings.Join(fields[1:], " ")
+			}
+			line := lineOf(c.Pos())
+			dir.scope, dir.from, dir.to = "line", line, line
+			if cg.End() < f.Package {
+				dir.scope, dir.from, dir.to = "file", 1, lineOf(f.End())
+			} else if node := m.commentedNode(f, cg); node != nil &&
+				lineOf(node.Pos()) > line {
+				dir.scope, dir.to = "block", lineOf(node.End())
+			}
+			dirs = append(dirs, dir)
+		}
+	}
+	if m.ignoreDirs == nil {
+		m.ignoreDirs = make(map[*ast.File][]ignoreDirective)
+	}
+	m.ignoreDirs[f] = dirs
+	return dirs
+}
+
+// commentedNode returns the outermost node that a comment group is associated
+// with, if any. Of the nodes starting first, the one ending last is used.
+func (m *matcher) commentedNode(f *ast.File, cg *ast.CommentGroup) ast.Node {
+	var nodes []ast.Node
+	for node, cgs := range m.commentMap(f) {
+		for _, cg2 := range cgs {
+			if cg2 == cg {
+				nodes = append(nodes, node)
+				break
+			}
+		}
+	}
+	if len(nodes) == 0 {
+		return nil
+	}
+	// the map's order is random, so pick by position alone
+	sort.Slice(nodes, func(i, j int) bool {
+		if pi, pj := nodes[i].Pos(), nodes[j].Pos(); pi != pj {
+			return pi < pj
+		}
+		return nodes[i].End() > nodes[j].End()
+	})
+	return nodes[0]
+}
+
+func (m *matcher) printIgnores(nodes []ast.Node) {
+	for _, node := range nodes {
+		f, ok := node.(*ast.File)
+		if !ok {
+			continue
+		}
+		for _, dir := range m.ignoreDirectives(f) {
+			fmt.Fprintf(m.out, "%v: %s %s\n", m.position(dir.comment.Pos()),
+				dir.scope, dir.comment.Text)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// tag matches a field's tag. A pattern without a tag matches any field, and
+// a pattern tag is matched key by key, such that `json:"$name,omitempty"`
+// matches a field with a tag containing that json key, among others. Values
+// may contain wildcards like $name, or $(name /regexp/).
+func (m *matcher) tag(expr, node *ast.BasicLit) bool {
+	if expr == nil {
+		return true
+	}
+	if node == nil {
+		return false
+	}
+	exprTag, err1 := strconv.Unquote(expr.Value)
+	nodeTag, err2 := strconv.Unquote(node.Value)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	pairs := tagPairs(exprTag)
+	if len(pairs) == 0 {
+		// not in the conventional format
+		return m.tagValue(exprTag, nodeTag)
+	}
+	for _, pair := range pairs {
+		value, ok := reflect.StructTag(nodeTag).Lookup(pair[0])
+		if !ok || !m.tagValue(pair[1], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// tagPairs splits a tag into its key and value pairs, following the format
+// described in reflect.StructTag.
+func tagPairs(tag string) [][2]string {
+	var pairs [][2]string
+	for tag != "" {
+		tag = strings.TrimLeft(tag, " ")
+		i := 0
+		for i < len(tag) && tag[i] > ' ' && tag[i] != ':' && tag[i] != '"' {
+			i++
+		}
+		if i == 0 || i+1 >= len(tag) || tag[i] != ':' || tag[i+1] != '"' {
+			return nil
+		}
+		key := tag[:i]
+		tag = tag[i+1:]
+		i = 1
+		for i < len(tag) && tag[i] != '"' {
+			if tag[i] == '\\' {
+				i++
+			}
+			i++
+		}
+		if i >= len(tag) {
+			return nil
+		}
+		value, err := strconv.Unquote(tag[:i+1])
+		if err != nil {
+			return nil
+		}
+		pairs = append(pairs, [2]string{key, value})
+		tag = tag[i+1:]
+	}
+	return pairs
+}
+
+type tagTemplate struct {
+	rx    *regexp.Regexp
+	names []string // wildcard name for each submatch
+}
+
+// rxTagWildcard matches $name and $(name /regexp/), where the regexp can only
+// contain "/)" if escaped, so that import paths don't need escaping.
+var rxTagWildcard = regexp.MustCompile(`\$(\w+)|\$\((\w+) /((?:[^/]|\\/|/[^)])*)/\)`)
+
+// tagValue matches a tag value against a template which may contain
+// wildcards, recording their values.
+func (m *matcher) tagValue(tmpl, value string) bool {
+	tt, ok := m.tagTemplates[tmpl]
+	if !ok {
+		var buf strings.Builder
+		buf.WriteString("^")
+		last := 0
+		for _, loc := range rxTagWildcard.FindAllStringSubmatchIndex(tmpl, -1) {
+			buf.WriteString(regexp.QuoteMeta(tmpl[last:loc[0]]))
+			last = loc[1]
+			if loc[2] >= 0 { // $name
+				tt.names = append(tt.names, tmpl[loc[2]:loc[3]])
+				buf.WriteString("(.*?)")
+				continue
+			}
+			// $(name /regexp/)
+			tt.names = append(tt.names, tmpl[loc[4]:loc[5]])
+			rx := strings.Replace(tmpl[loc[6]:loc[7]], `\/`, `/`, -1)
+			buf.WriteString("(" + rx + ")")
+		}
+		buf.WriteString(regexp.QuoteMeta(tmpl[last:]))
+		buf.WriteString("$")
+		// an invalid regexp simply never matches
+		tt.rx, _ = regexp.Compile(buf.String())
+		if m.tagTemplates == nil {
+			m.tagTemplates = make(map[string]tagTemplate)
+		}
+		m.tagTemplates[tmpl] = tt
+	}
+	if tt.rx == nil {
+		return false
+	}
+	sub := tt.rx.FindStringSubmatch(value)
+	if sub == nil {
+		return false
+	}
+	for i, name := range tt.names {
+		if name == "_" {
+			continue
+		}
+		// sub[0] is the entire match
+		val := sub[i+1]
+		prev, ok := m.values[name]
+		if !ok {
+			m.values[name] = &ast.BasicLit{
+				Kind:  token.STRING,
+				Value: strconv.Quote(val),
+			}
+			continue
+		}
+		switch x := prev.(type) {
+		case *ast.Ident:
+			// e.g. a field name also used in its tag
+			if x.Name != val {
+				return false
+			}
+		case *ast.BasicLit:
+			if x.Kind != token.STRING || x.Value != strconv.Quote(val) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
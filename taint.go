@@ -0,0 +1,305 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/ssa"
+)
+
+// cmdTaint replaces the submatches, which are the sources, with the nodes
+// matching any of the patterns in cmds, which are the sinks. Only the sinks
+// which may use a value coming from one of the sources are kept. With
+// -concat, the value must have gone through a string concatenation or
+// formatting on its way to the sink.
+func (m *matcher) cmdTaint(cmds []exprCmd, subs []submatch) []submatch {
+	prog := m.loader.program()
+	tainted := taintSet{}
+	for _, sub := range subs {
+		expr := sourceExpr(sub.node)
+		if expr == nil {
+			continue
+		}
+		if v := m.ssaValue(prog, expr); v != nil {
+			tainted.add(v, m.interproc)
+		}
+	}
+	if cmds[0].name == "concat" {
+		tainted = tainted.concats(m.interproc)
+	}
+	if len(tainted) == 0 {
+		return nil
+	}
+	// the sinks may be anywhere in the loaded packages, not just
+	// within the sources
+	var matches []submatch
+	for _, sub := range m.cmdUnion(cmds, m.loadedRoots()) {
+		if m.sinkTainted(prog, tainted, sub.node) {
+			matches = append(matches, sub)
+		}
+	}
+	return matches
+}
+
+func sourceExpr(node ast.Node) ast.Expr {
+	switch x := node.(type) {
+	case ast.Expr:
+		return x
+	case *ast.ExprStmt:
+		return x.X
+	}
+	return nil
+}
+
+// sinkTainted reports whether any of the expressions within a node may hold
+// a tainted value.
+func (m *matcher) sinkTainted(prog *ssa.Program, tainted taintSet, node ast.Node) bool {
+	found := false
+	inspect(node, func(node ast.Node) bool {
+		if found {
+			return false
+		}
+		if expr, ok := node.(ast.Expr); ok {
+			v := m.ssaValue(prog, expr)
+			found = v != nil && tainted[v]
+		}
+		return !found
+	})
+	return found
+}
+
+// ssaValue returns the SSA value of an expression, if any. The value may be
+// the address of the expression, if it's addressable.
+func (m *matcher) ssaValue(prog *ssa.Program, expr ast.Expr) ssa.Value {
+	fn := m.ssaFunc(prog, expr)
+	if fn == nil {
+		return nil
+	}
+	v, _ := fn.ValueForExpr(expr)
+	return v
+}
+
+// ssaFunc returns the innermost SSA function enclosing a node, if any. Note
+// that package-level variable declarations are enclosed by the package's init
+// function.
+func (m *matcher) ssaFunc(prog *ssa.Program, node ast.Node) *ssa.Function {
+	idx := m.loader.ssaIndexOf(prog)
+	var fn *ssa.Function
+	for _, fn2 := range idx.funcs[m.loader.fset.File(node.Pos())] {
+		syntax := fn2.Syntax()
+		if node.Pos() < syntax.Pos() || node.End() > syntax.End() {
+			continue
+		}
+		// funcs start after those they're nested in
+		if fn == nil || syntax.Pos() > fn.Syntax().Pos() {
+			fn = fn2
+		}
+	}
+	if fn != nil {
+		return fn
+	}
+	f := m.fileAt(node.Pos())
+	pkg := idx.pkgs[f]
+	if pkg == nil {
+		return nil
+	}
+	path, _ := astutil.PathEnclosingInterval(f, node.Pos(), node.End())
+	if n := len(path); n >= 3 {
+		if decl, ok := path[n-2].(*ast.GenDecl); ok && decl.Tok == token.VAR {
+			return pkg.Func("init")
+		}
+	}
+	return nil
+}
+
+// ssaIndex holds the SSA package of each loaded file, and the SSA funcs
+// declared in each file, so that ssaFunc doesn't need to search the whole
+// program for every node.
+type ssaIndex struct {
+	pkgs  map[*ast.File]*ssa.Package
+	funcs map[*token.File][]*ssa.Function
+}
+
+// ssaIndexOf returns the ssaIndex of the SSA program, building it the first
+// time it's needed.
+func (l *nodeLoader) ssaIndexOf(prog *ssa.Program) *ssaIndex {
+	if l.ssaIndex != nil {
+		return l.ssaIndex
+	}
+	idx := &ssaIndex{
+		pkgs:  make(map[*ast.File]*ssa.Package),
+		funcs: make(map[*token.File][]*ssa.Function),
+	}
+	for tpkg, info := range l.prog.AllPackages {
+		pkg := prog.Package(tpkg)
+		for _, f := range info.Files {
+			idx.pkgs[f] = pkg
+		}
+	}
+	var add func(fn *ssa.Function)
+	add = func(fn *ssa.Function) {
+		if fn == nil || fn.Syntax() == nil {
+			return
+		}
+		file := l.fset.File(fn.Syntax().Pos())
+		idx.funcs[file] = append(idx.funcs[file], fn)
+		for _, anon := range fn.AnonFuncs {
+			add(anon)
+		}
+	}
+	for _, pkg := range prog.AllPackages() {
+		for _, mem := range pkg.Members {
+			switch mem := mem.(type) {
+			case *ssa.Function:
+				add(mem)
+			case *ssa.Type:
+				named, ok := mem.Type().(*types.Named)
+				if !ok {
+					break
+				}
+				for i := 0; i < named.NumMethods(); i++ {
+					add(prog.FuncValue(named.Method(i)))
+				}
+			}
+		}
+		// the init funcs declared in the source aren't members, but
+		// are called by the package's own init
+		init := pkg.Func("init")
+		if init == nil {
+			continue
+		}
+		for _, block := range init.Blocks {
+			for _, instr := range block.Instrs {
+				call, ok := instr.(*ssa.Call)
+				if !ok {
+					continue
+				}
+				fn, ok := call.Call.Value.(*ssa.Function)
+				if !ok || fn.Pkg != pkg {
+					continue
+				}
+				if decl, ok := fn.Syntax().(*ast.FuncDecl); ok && decl.Recv == nil && decl.Name.Name == "init" {
+					add(fn)
+				}
+			}
+		}
+	}
+	l.ssaIndex = idx
+	return idx
+}
+
+// taintSet is a set of SSA values which may hold data coming from a source.
+type taintSet map[ssa.Value]bool
+
+// add taints a value, as well as all the values that may be derived from it.
+// Captured variables also taint the free variables of the closures capturing
+// them, as those are written within the same func. If interproc is true,
+// tainted arguments also taint the parameters of the functions receiving
+// them.
+func (t taintSet) add(v ssa.Value, interproc bool) {
+	if v == nil || t[v] {
+		return
+	}
+	t[v] = true
+	refs := v.Referrers()
+	if refs == nil {
+		return
+	}
+	for _, instr := range *refs {
+		switch x := instr.(type) {
+		case *ssa.Store:
+			if x.Val != v {
+				break
+			}
+			// storing into a field or element taints the
+			// entire value holding it
+			addr := x.Addr
+			for addr != nil {
+				t.add(addr, interproc)
+				switch y := addr.(type) {
+				case *ssa.FieldAddr:
+					addr = y.X
+				case *ssa.IndexAddr:
+					addr = y.X
+				default:
+					addr = nil
+				}
+			}
+		case *ssa.MapUpdate:
+			if x.Key == v || x.Value == v {
+				t.add(x.Map, interproc)
+			}
+		case *ssa.Send:
+			if x.X == v {
+				t.add(x.Chan, interproc)
+			}
+		case *ssa.MakeClosure:
+			fn := x.Fn.(*ssa.Function)
+			for i, b := range x.Bindings {
+				if b == v {
+					t.add(fn.FreeVars[i], interproc)
+				}
+			}
+		case ssa.CallInstruction:
+			common := x.Common()
+			if callee := common.StaticCallee(); interproc && callee != nil {
+				for i, arg := range common.Args {
+					if arg == v && i < len(callee.Params) {
+						t.add(callee.Params[i], interproc)
+					}
+				}
+			}
+			// the results of a call may depend on any of its
+			// arguments
+			if val, ok := x.(ssa.Value); ok {
+				t.add(val, interproc)
+			}
+		case ssa.Value:
+			t.add(x, interproc)
+		}
+	}
+}
+
+// concats returns the values derived from a tainted string concatenation or
+// formatting, such as "a" + s or fmt.Sprintf("%s", s), which are the ones
+// that may be used to build a query or command.
+func (t taintSet) concats(interproc bool) taintSet {
+	concat := taintSet{}
+	for v := range t {
+		if isConcat(v) {
+			concat.add(v, interproc)
+		}
+	}
+	return concat
+}
+
+// isConcat reports whether a value is built by concatenating or formatting
+// strings.
+func isConcat(v ssa.Value) bool {
+	switch x := v.(type) {
+	case *ssa.BinOp:
+		b, ok := x.Type().Underlying().(*types.Basic)
+		return ok && x.Op == token.ADD && b.Info()&types.IsString != 0
+	case *ssa.Call:
+		callee := x.Call.StaticCallee()
+		if callee == nil || callee.Pkg == nil || callee.Signature.Recv() != nil {
+			return false
+		}
+		switch path := callee.Pkg.Pkg.Path(); {
+		case path == "fmt":
+			return strings.HasPrefix(callee.Name(), "Sprint")
+		case path == "strings":
+			switch callee.Name() {
+			case "Join", "Repeat", "Replace", "ReplaceAll":
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,7 @@
+//go:build ignore
+
+package rules
+
+func bad(m gogrep.Matcher) {
+	m.Match(`foo`).Foo("bar")
+}
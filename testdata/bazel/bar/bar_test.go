@@ -0,0 +1,3 @@
+package bar
+
+func helper() string { return "bar" }
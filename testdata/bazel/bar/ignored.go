@@ -0,0 +1,5 @@
+//go:build ignore
+
+package bar
+
+func Ignored() int { return 3 }
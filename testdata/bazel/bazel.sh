@@ -0,0 +1,8 @@
+#!/bin/sh
+# A fake bazel, answering the commands run by gogrep for testdata/bazel.
+cd "$(dirname "$0")" || exit 1
+case "$1" in
+info) pwd ;;
+query) cat query.xml ;;
+*) echo "unknown command $1" >&2; exit 2 ;;
+esac
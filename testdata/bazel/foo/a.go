@@ -0,0 +1,3 @@
+package foo
+
+func A() int { return 1 }
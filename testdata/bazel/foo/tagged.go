@@ -0,0 +1,5 @@
+//go:build special
+
+package foo
+
+func Tagged() int { return 2 }
@@ -0,0 +1,5 @@
+//go:build linux
+
+package p1
+
+var _ = "linux"
@@ -0,0 +1,3 @@
+package p1
+
+var _ = "none"
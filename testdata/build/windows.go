@@ -0,0 +1,5 @@
+// +build windows,!arm
+
+package p1
+
+var _ = "windows"
@@ -0,0 +1,12 @@
+package p1
+
+func leaf() {}
+
+func mid() { leaf() }
+
+func top() {
+	mid()
+	leaf()
+}
+
+func unused() { top() }
@@ -0,0 +1,21 @@
+package p1
+
+func a(x int) int {
+	if x > 10 {
+		x = x * 2
+		return x + 1
+	}
+	return x
+}
+
+func b(y int) int {
+	if y > 20 {
+		y = y * 3
+		return y + 1
+	}
+	return 0
+}
+
+func c() {
+	println("short")
+}
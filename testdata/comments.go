@@ -0,0 +1,9 @@
+package p1
+
+// TODO: document this
+var _ = "foo"
+
+func _() {
+	// FIXME: remove
+	bar() // nothing to see here
+}
@@ -0,0 +1,6 @@
+package p1
+
+func f() {
+	println("a")
+	println("")
+}
@@ -0,0 +1,5 @@
+package generated
+
+func init() {
+	println("generated")
+}
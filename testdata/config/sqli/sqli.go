@@ -0,0 +1,31 @@
+package sqli
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+type store struct{ db *sql.DB }
+
+func (s *store) mustExec(query string) {}
+
+func handle(db *sql.DB, s *store, r *http.Request) {
+	id := r.FormValue("id")
+	db.Query("SELECT * FROM users WHERE id = " + id)
+	db.Query("SELECT * FROM users WHERE id = ?", id)
+	q := fmt.Sprintf("DELETE FROM %s", os.Getenv("TABLE"))
+	db.Exec(q)
+	s.mustExec("DROP TABLE " + r.URL.Query().Get("t"))
+	db.Exec("SELECT 1")
+}
+
+func (s *store) closures(r *http.Request) {
+	id := r.FormValue("id")
+	func() { s.db.Query("SELECT * FROM users WHERE id = ?", id) }()
+	go func() { s.db.Exec("DELETE FROM users WHERE id = " + id) }()
+	table := &id
+	s.db.Query("SELECT * FROM " + *table)
+	s.db.Query("SELECT * FROM users")
+}
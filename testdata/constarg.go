@@ -0,0 +1,22 @@
+package p1
+
+import "time"
+
+func dial(addr string, timeout time.Duration) {}
+
+func retry(n int, timeout time.Duration) {}
+
+func never(timeout time.Duration) {}
+
+type client struct{}
+
+func (c *client) get(path string, limit int) {}
+
+func calls(d time.Duration, c *client) {
+	dial("a", 0)
+	dial("b", 0)
+	retry(3, 0)
+	retry(5, d)
+	c.get("/x", -1)
+	c.get("/y", -1)
+}
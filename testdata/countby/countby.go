@@ -0,0 +1,19 @@
+package countby
+
+import "context"
+
+type client struct{}
+
+func (client) get(ctx context.Context, path string) {}
+func (client) put(ctx context.Context, path string) {}
+
+func fetch(ctx context.Context) {}
+
+func calls(c, other client) {
+	c.get(context.TODO(), "/a")
+	other.get(context.TODO(), "/b")
+	c.put(context.TODO(), "/c")
+	fetch(context.TODO())
+	fetch(context.TODO())
+	fetch(context.Background())
+}
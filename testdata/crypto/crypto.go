@@ -0,0 +1,55 @@
+package crypto
+
+import (
+	"crypto/des"
+	hash "crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"math/rand"
+)
+
+type sha1 struct{}
+
+func (sha1) Sum(b []byte) {}
+
+func weak(b []byte) {
+	hash.Sum(b)
+	sha256.Sum256(b)
+	des.NewCipher(b)
+	var m sha1
+	m.Sum(b)
+}
+
+func newKey() []byte {
+	key := make([]byte, 16)
+	rand.Read(key)
+	for i := range key {
+		key[i] = byte(rand.Intn(256))
+	}
+	return key
+}
+
+func shuffle(xs []int) { rand.Shuffle(len(xs), func(i, j int) {}) }
+
+func client() *tls.Config {
+	cfg := &tls.Config{InsecureSkipVerify: true}
+	cfg.InsecureSkipVerify = true
+	cfg.InsecureSkipVerify = false
+	return cfg
+}
+
+type keys struct{ n int }
+
+func (k *keys) token() int { return rand.Intn(k.n) }
+
+func (k *keys) pick(xs []int) int {
+	key := func() int { return len(xs) }
+	return xs[rand.Intn(key())]
+}
+
+func server(verify bool) *tls.Config {
+	cfg := &tls.Config{InsecureSkipVerify: verify}
+	skip := &cfg.InsecureSkipVerify
+	*skip = false
+	return cfg
+}
@@ -0,0 +1,14 @@
+package handlers
+
+import "net/http"
+
+func register(mux *http.ServeMux) {
+	mux.HandleFunc("/users", users)
+	mux.HandleFunc("/admin", admin)
+	mux.HandleFunc("/users", users)
+	mux.Handle("/static", nil)
+}
+
+func users(http.ResponseWriter, *http.Request) {}
+
+func admin(http.ResponseWriter, *http.Request) {}
@@ -0,0 +1,17 @@
+package p1
+
+import "io/ioutil"
+
+// Old does things.
+//
+// Deprecated: use New instead.
+func Old() {}
+
+// New does things.
+func New() {}
+
+func _() {
+	Old()
+	New()
+	ioutil.ReadAll(nil)
+}
@@ -0,0 +1,6 @@
+package p1
+
+func f() {
+	foo(2)
+	foo(3)
+}
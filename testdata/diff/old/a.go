@@ -0,0 +1,7 @@
+package p1
+
+func f() {
+	foo(1)
+	foo(2)
+	foo(2)
+}
@@ -0,0 +1,16 @@
+package p1
+
+import _ "unsafe"
+
+//go:generate echo hello
+
+//go:noinline
+func noinline() {}
+
+// linked is linked.
+//
+//go:linkname linked runtime.nanotime
+func linked() int64
+
+// plain has no directives, not even go:noinline.
+func plain() {}
@@ -0,0 +1,14 @@
+package p1
+
+// Documented does things.
+func Documented() {}
+
+// this doc doesn't follow the convention
+func Undocumented() {}
+
+func NoDoc() {}
+
+// A Thing is a thing.
+type Thing int
+
+type Other int
@@ -0,0 +1,13 @@
+package p
+
+func f() (int, error) {
+	n, err := g()
+	if err != nil {
+		return 0, err
+	}
+	var err2 error
+	if err2 != nil {
+		return 1, err2
+	}
+	return n, nil
+}
@@ -0,0 +1,9 @@
+package one
+
+import "testing"
+
+func init() {}
+
+func init() {}
+
+var _ = testing.Short
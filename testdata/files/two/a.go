@@ -0,0 +1,7 @@
+package two
+
+import "fmt"
+
+func init() {}
+
+var _ = fmt.Sprint
@@ -0,0 +1,11 @@
+package fix
+
+import "fmt"
+
+func wrap(err error) error {
+	return fmt.Errorf("wrap: %s", err.Error())
+}
+
+func nested(a, b []int) bool {
+	return len(a) == 0 || len(b) == 0
+}
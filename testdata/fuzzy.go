@@ -0,0 +1,10 @@
+package p1
+
+func f() {
+	foo(a, b)
+	foo(a, c)
+	foo(a)
+	foo(a, b, d)
+	bar(x, y)
+	foo(a, b+1)
+}
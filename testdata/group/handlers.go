@@ -0,0 +1,7 @@
+package group
+
+import "log"
+
+func (s Server) handle() {
+	log.Printf("handling")
+}
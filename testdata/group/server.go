@@ -0,0 +1,22 @@
+package group
+
+import "log"
+
+type Server struct {
+	logger func(string, ...interface{})
+}
+
+func (s *Server) Start() {
+	log.Printf("starting")
+	log.Printf("started")
+}
+
+func (s *Server) Stop() {
+	log.Printf("stopping")
+}
+
+func main() {
+	log.Printf("main")
+}
+
+var _ = log.Printf
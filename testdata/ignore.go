@@ -0,0 +1,16 @@
+package p1
+
+func _() {
+	foo() //gogrep:ignore
+	foo() //gogrep:ignore other
+	//gogrep:ignore gogrep some reason
+	if true {
+		foo()
+	}
+	foo()
+}
+
+//gogrep:ignore
+func _() {
+	foo()
+}
@@ -0,0 +1,5 @@
+//gogrep:ignore * generated
+
+package p1
+
+func _() { foo() }
@@ -0,0 +1,15 @@
+package p1
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct{ side float64 }
+
+func (s Square) Area() float64 { return s.side * s.side }
+
+type Circle struct{ r float64 }
+
+func (c *Circle) Area() float64 { return 3 * c.r * c.r }
+
+type Point struct{}
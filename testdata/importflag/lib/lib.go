@@ -0,0 +1,5 @@
+package lib
+
+type Doer interface {
+	Do()
+}
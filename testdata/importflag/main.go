@@ -0,0 +1,9 @@
+package p
+
+type T struct{}
+
+func (T) Do() {}
+
+var t T
+
+var n int
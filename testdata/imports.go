@@ -0,0 +1,12 @@
+package p
+
+import (
+	"fmt"
+	str "strings"
+
+	_ "github.com/org/internal/driver"
+	. "github.com/org/internal/dsl"
+	"github.com/org/public"
+)
+
+import "os"
@@ -0,0 +1,55 @@
+package p1
+
+import "sync"
+
+type store struct {
+	mu sync.RWMutex
+	m  map[string]int
+}
+
+func (s *store) get(k string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.m[k]
+}
+
+func (s *store) set(k string, v int) {
+	s.mu.Lock()
+	s.m[k] = v
+	s.mu.Unlock()
+}
+
+func (s *store) leak(k string) int {
+	s.mu.Lock()
+	if v, ok := s.m[k]; ok {
+		return v
+	}
+	s.mu.Unlock()
+	return 0
+}
+
+func (s *store) wrong(mu *sync.Mutex) {
+	s.mu.Lock()
+	mu.Unlock()
+}
+
+func (s *store) loop(ks []string) {
+	for _, k := range ks {
+		s.mu.Lock()
+		if k == "" {
+			panic("empty")
+		}
+		s.m[k]++
+		s.mu.Unlock()
+	}
+}
+
+func (s *store) branch(k string) int {
+	if k == "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return 0
+	}
+	s.mu.Lock()
+	return s.m[k]
+}
@@ -0,0 +1,3 @@
+module newer
+
+go 1.22
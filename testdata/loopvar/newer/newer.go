@@ -0,0 +1,21 @@
+package newer
+
+func work(int) {}
+
+func loops(xs []int) {
+	for _, x := range xs {
+		go func() { work(x) }()
+		go func(x int) { work(x) }(x)
+	}
+	for i := 0; i < 3; i++ {
+		defer func() { work(i) }()
+		func() { work(i) }()
+	}
+	for _, x := range xs {
+		for j := range xs {
+			go func() {
+				defer func() { work(x + j) }()
+			}()
+		}
+	}
+}
@@ -0,0 +1,5 @@
+package a
+
+import "example.com/a/util"
+
+func F() { util.Do() }
@@ -0,0 +1,5 @@
+package b
+
+import "fmt"
+
+func G() { fmt.Println("x") }
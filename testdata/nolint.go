@@ -0,0 +1,16 @@
+package p1
+
+func _() {
+	println() //nolint
+	println() //nolint:println
+	println() //nolint:other,println // explanation
+	println() //nolint:other
+	println() //nolintfoo
+	println()
+	println() //nolint:gogrep
+}
+
+//nolint:println // generated
+func _() {
+	println()
+}
@@ -0,0 +1,9 @@
+package p1
+
+var _ = struct {
+	a    int
+	bcde string // comment
+}{
+	a:    1,
+	bcde: "x  y",
+}
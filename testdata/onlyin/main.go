@@ -0,0 +1,13 @@
+package main
+
+func init() {
+	println("init")
+}
+
+func main() {
+	println("main")
+}
+
+func helper() {
+	println("helper")
+}
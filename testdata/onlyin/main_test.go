@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestHelper(t *testing.T) {
+	println("test")
+}
+
+func testHelper() {
+	println("not a test")
+}
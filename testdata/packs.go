@@ -0,0 +1,49 @@
+package p1
+
+import (
+	"errors"
+	"sync"
+)
+
+func f() error { return errors.New("x") }
+
+func g() {
+	for i := 0; i < 3; i++ {
+		defer println(i)
+	}
+	var mu sync.Mutex
+	mu2 := mu
+	mu2 = mu
+	_ = mu2
+	f()
+	_ = f()
+	if err := f(); err != nil && err.Error() == "x" {
+	}
+}
+
+type guarded struct {
+	mu sync.Mutex
+}
+
+func (g *guarded) noCopies() {
+	for i := 0; i < 3; i++ {
+		func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+		}()
+		go func() {
+			defer println(i)
+		}()
+	}
+	p := &g.mu
+	p2 := p
+	p2 = &g.mu
+	g2 := g
+	_, _ = p2, g2
+}
+
+func (g *guarded) copies() {
+	mu := g.mu
+	mu = *(&g.mu)
+	_ = mu
+}
@@ -0,0 +1,14 @@
+package p1
+
+func main() {
+	used()
+	func() { inner() }()
+}
+
+func used() { println("a") }
+
+func inner() { println("b") }
+
+func dead() { println("c") }
+
+func init() { println("d") }
@@ -0,0 +1,30 @@
+package p
+
+import "sync"
+
+type Server struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (s *Server) Inc() {
+	s.mu.Lock()
+	s.n++
+	s.mu.Unlock()
+}
+
+type client struct {
+	s *Server
+}
+
+func (c client) Inc() {
+	c.s.mu.Lock()
+	c.s.n++
+	c.s.mu.Unlock()
+}
+
+func inc(s *Server) {
+	s.mu.Lock()
+	s.n++
+	s.mu.Unlock()
+}
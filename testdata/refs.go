@@ -0,0 +1,12 @@
+package p1
+
+var global = 1
+
+func fn(x int) int {
+	return x + global
+}
+
+func _() {
+	_ = fn(global)
+	_ = fn(2)
+}
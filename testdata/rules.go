@@ -0,0 +1,9 @@
+package p1
+
+func f(err error, p *int) {
+	println("a")
+	if p == nil && err == nil {
+	}
+	//gogrep:ignore println
+	println("b")
+}
@@ -0,0 +1,12 @@
+//go:build ignore
+
+package rules
+
+// noPrintln warns about leftover debugging.
+func noPrintln(m gogrep.Matcher) {
+	m.Match(`println($*_)`).Severity("info").Report("avoid println")
+}
+
+func nilCompare(m gogrep.Matcher) {
+	m.Match(`$x == nil`).Exclude(`err == nil`)
+}
@@ -0,0 +1,19 @@
+package p1
+
+import "os"
+
+func f() {
+	_, err := os.Open("a")
+	if err != nil {
+		println(err)
+	}
+	os.ExpandEnv("${HOME}")
+}
+
+func g() error {
+	_, err := os.Open("b")
+	if err != nil {
+		return err
+	}
+	return nil
+}
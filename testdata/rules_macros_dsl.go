@@ -0,0 +1,10 @@
+//go:build ignore
+
+package rules
+
+const call = `$f($*_)`
+
+func unchecked(m gogrep.Matcher) {
+	errCall := `$_, err := ` + call
+	m.Match(errCall + `; if err != nil { $*_ }`).Exclude(`${errCall}; if err != nil { return $*_ }`).Report("error handled without returning")
+}
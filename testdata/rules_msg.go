@@ -0,0 +1,8 @@
+package p1
+
+import "os"
+
+func f() {
+	os.Remove("foo")
+	println("bar")
+}
@@ -0,0 +1,8 @@
+package p
+
+func f(p *int) {
+	println("a")
+	if p == nil { // want nil-compare nil-compare
+		println(p == nil) // want println
+	}
+}
@@ -0,0 +1,8 @@
+package p
+
+func f(p *int) {
+	println("a")  // want println
+	if p == nil { // want nil-compare
+		println(p == nil) // want println nil-compare
+	}
+}
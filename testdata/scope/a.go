@@ -0,0 +1,5 @@
+package scope
+
+func f() {
+	println("scope")
+}
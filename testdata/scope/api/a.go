@@ -0,0 +1,5 @@
+package api
+
+func f() {
+	println("api")
+}
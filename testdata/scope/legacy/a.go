@@ -0,0 +1,7 @@
+package legacy
+
+func f() {
+	println("legacy")
+	// never loaded, as no rules are enabled here
+	syntax error
+}
@@ -0,0 +1,12 @@
+package p1
+
+import "time"
+
+func _() {
+	// wait for the server to start
+	time.Sleep(time.Second)
+
+	time.Sleep(time.Second) // let the OS catch up
+
+	time.Sleep(time.Second)
+}
@@ -0,0 +1,10 @@
+package snap
+
+func f() {
+	x := g(1) + g(2)
+	if g(3) > 0 {
+		_ = x
+	}
+}
+
+func g(int) int { return 0 }
@@ -0,0 +1,7 @@
+package a
+
+func Leaf() {}
+
+func Branch() { Leaf() }
+
+type Grower interface{ Grow() }
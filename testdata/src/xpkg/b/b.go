@@ -0,0 +1,11 @@
+package b
+
+import "xpkg/a"
+
+func Caller() { a.Leaf() }
+
+type Tree struct{}
+
+func (Tree) Grow() {}
+
+var _ a.Grower = Tree{}
@@ -0,0 +1,40 @@
+package p1
+
+func source() string { return "" }
+func clean() string  { return "x" }
+func sink(s string)  {}
+
+func direct() {
+	s := source()
+	sink(s)
+}
+
+func through() {
+	s := source()
+	t := "prefix: " + s
+	sink(t)
+}
+
+func safe() {
+	s := source()
+	_ = s
+	sink(clean())
+}
+
+func viaField() {
+	var x struct{ f string }
+	x.f = source()
+	sink(x.f)
+}
+
+func helper(s string) { sink(s) }
+
+func across() { helper(source()) }
+
+func sink2(s string) {}
+
+func both() {
+	s := source()
+	sink2(s)
+	sink2(s + "!")
+}
@@ -0,0 +1,32 @@
+package p1
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+var global = uintptr(unsafe.Pointer(&x))
+
+var x int
+
+type buf struct{ data []byte }
+
+func (b *buf) str() string {
+	return *(*string)(unsafe.Pointer(&b.data))
+}
+
+func header(s []byte) int {
+	h := (*reflect.SliceHeader)(unsafe.Pointer(&s))
+	_ = unsafe.Sizeof(s)
+	return h.Len
+}
+
+func bytesOf(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+func use(b *buf) {
+	_ = b.str()
+	_ = header(nil)
+	_ = b.str()
+}
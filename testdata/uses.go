@@ -0,0 +1,3 @@
+package p1
+
+func useGlobal() int { return global + fn(global) }
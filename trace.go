@@ -0,0 +1,115 @@
+// Copyright (c) 2018, Daniel Martí <mvdan@mvdan.cc>
+// See LICENSE for licensing information
+
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// traceLimit is how many failed matches are kept for each command.
+const traceLimit = 5
+
+// cmdTrace records where a command failed to match, for -trace.
+type cmdTrace struct {
+	header  string
+	entries []traceEntry
+}
+
+type traceEntry struct {
+	pos  string
+	text string
+	dist int
+}
+
+// cmdTrace returns the trace for a command, creating it if needed.
+func (m *matcher) cmdTrace(cmd exprCmd, header string) *cmdTrace {
+	key := "-" + cmd.name + " " + joinWords([]string{cmd.src})
+	for _, tr := range m.traces {
+		if strings.HasPrefix(tr.header, key+" ") {
+			return tr
+		}
+	}
+	tr := &cmdTrace{header: key + " " + header}
+	m.traces = append(m.traces, tr)
+	return tr
+}
+
+// traceMiss records a node of the same kind as a pattern which didn't match
+// it, if it's among the closest ones so far. What differed is found the same
+// way as with -fuzzy.
+func (m *matcher) traceMiss(cmd exprCmd, expr, node ast.Node) {
+	if _, ok := expr.(nodeList); ok {
+		return
+	}
+	if reflect.TypeOf(expr) != reflect.TypeOf(node) {
+		return
+	}
+	tr := m.cmdTrace(cmd, "got closest to matching:")
+	budget := 1 << 10
+	if len(tr.entries) == traceLimit {
+		budget = tr.entries[traceLimit-1].dist - 1
+	}
+	values := m.values
+	dist, diffs := m.fuzzyDist(expr, node, budget)
+	m.values = values
+	if dist == 0 || dist > budget {
+		return
+	}
+	tr.entries = append(tr.entries, traceEntry{
+		pos:  m.position(node.Pos()).String(),
+		text: singleLinePrint(node) + ": " + strings.Join(diffs, "; "),
+		dist: dist,
+	})
+	sort.SliceStable(tr.entries, func(i, j int) bool {
+		return tr.entries[i].dist < tr.entries[j].dist
+	})
+	if len(tr.entries) > traceLimit {
+		tr.entries = tr.entries[:traceLimit]
+	}
+}
+
+// traceReject records a node which was discarded by a command, such as an
+// attribute, along with its type if it has one.
+func (m *matcher) traceReject(cmd exprCmd, node ast.Node) {
+	tr := m.cmdTrace(cmd, "discarded:")
+	if len(tr.entries) == traceLimit {
+		return
+	}
+	text := singleLinePrint(node)
+	if es, ok := node.(*ast.ExprStmt); ok {
+		node = es.X
+	}
+	if expr, ok := node.(ast.Expr); ok && m.Info.Types != nil {
+		if t := m.typeOf(expr); t != nil {
+			text += ": type is " + types.TypeString(t, func(pkg *types.Package) string {
+				return pkg.Name()
+			})
+		} else {
+			text += ": no type"
+		}
+	}
+	tr.entries = append(tr.entries, traceEntry{
+		pos:  m.position(node.Pos()).String(),
+		text: text,
+	})
+}
+
+// printTraces prints the recorded traces, in the order of their commands.
+func (m *matcher) printTraces() {
+	for _, tr := range m.traces {
+		fmt.Fprintf(m.errOut, "trace: %s\n", tr.header)
+		for _, entry := range tr.entries {
+			if entry.pos == "-" { // e.g. with -test-src
+				fmt.Fprintf(m.errOut, "  %s\n", entry.text)
+				continue
+			}
+			fmt.Fprintf(m.errOut, "  %s: %s\n", entry.pos, entry.text)
+		}
+	}
+}
@@ -4,41 +4,46 @@
 package main
 
 import (
+	"bytes"
 	"go/ast"
 	"go/printer"
-	"os"
+	"go/token"
+	"io/ioutil"
+	"sort"
 )
 
+// cmdWrite writes the substitutions back to their files. Only the source
+// ranges that were replaced change, so the rest of each file is kept as is.
+// With -plan, the edits are recorded in the plan instead.
 func (m *matcher) cmdWrite(cmd exprCmd, subs []submatch) []submatch {
 	seenRoot := make(map[nodePosHash]bool)
-	filePaths := make(map[*ast.File]string)
+	fileSubs := make(map[*ast.File][]submatch)
 	var next []submatch
 	for _, sub := range subs {
 		root := m.nodeRoot(sub.node)
+		file, ok := root.(*ast.File)
+		if ok && m.loader.fset.PositionFor(file.Package, false).Filename != "" {
+			if sub.orig.pos.IsValid() {
+				fileSubs[file] = append(fileSubs[file], sub)
+			}
+			continue
+		}
 		hash := posHash(root)
 		if seenRoot[hash] {
 			continue // avoid dups
 		}
 		seenRoot[hash] = true
-		file, ok := root.(*ast.File)
-		if ok {
-			path := m.loader.fset.Position(file.Package).Filename
-			if path != "" {
-				// write to disk
-				filePaths[file] = path
-				continue
-			}
-		}
 		// pass it on, to print to stdout
 		next = append(next, submatch{node: root})
 	}
-	for file, path := range filePaths {
-		f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
-		if err != nil {
-			// TODO: return errors instead
-			panic(err)
+	for file, subs := range fileSubs {
+		if m.plan != nil {
+			if err := m.plan.add(m, file, subs); err != nil {
+				panic(err)
+			}
+			continue
 		}
-		if err := printConfig.Fprint(f, m.loader.fset, file); err != nil {
+		if err := m.writeSubsts(file, subs); err != nil {
 			// TODO: return errors instead
 			panic(err)
 		}
@@ -46,6 +51,142 @@ func (m *matcher) cmdWrite(cmd exprCmd, subs []submatch) []submatch {
 	return next
 }
 
+// writeSubsts splices the substitutions made within a file into its source,
+// replacing exactly the range of source that each one replaced.
+func (m *matcher) writeSubsts(file *ast.File, subs []submatch) error {
+	path := m.loader.fset.File(file.Package).Name()
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	out, err := m.spliceSubsts(file, src, subs)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, out, 0)
+}
+
+// spliceSubsts returns a file's source with the substitutions made within it
+// spliced in.
+func (m *matcher) spliceSubsts(file *ast.File, src []byte, subs []submatch) ([]byte, error) {
+	edits, err := m.substRanges(file, src, subs)
+	if err != nil {
+		return nil, err
+	}
+	return spliceEdits(src, edits), nil
+}
+
+// srcEdit replaces the source between two byte offsets with new text.
+type srcEdit struct {
+	Offset  int    `json:"offset"`
+	End     int    `json:"end"`
+	NewText string `json:"new_text"`
+}
+
+// substRanges returns the edits making the substitutions within a file,
+// sorted by offset. Substitutions within others are skipped, as the outer
+// edit includes them.
+func (m *matcher) substRanges(file *ast.File, src []byte, subs []submatch) ([]srcEdit, error) {
+	tfile := m.loader.fset.File(file.Package)
+	sort.Slice(subs, func(i, j int) bool {
+		return subs[i].orig.pos < subs[j].orig.pos
+	})
+	var edits []srcEdit
+	last := 0
+	for _, sub := range subs {
+		start := tfile.Offset(sub.orig.pos)
+		end := tfile.Offset(sub.orig.end)
+		if start < last {
+			// within a replacement that was already made,
+			// which includes this one
+			continue
+		}
+		repl, err := m.substSrc(file, src, sub)
+		if err != nil {
+			return nil, err
+		}
+		edits = append(edits, srcEdit{start, end, string(repl)})
+		last = end
+	}
+	return edits, nil
+}
+
+// spliceEdits returns the source with sorted, non-overlapping edits made.
+func spliceEdits(src []byte, edits []srcEdit) []byte {
+	var buf bytes.Buffer
+	last := 0
+	for _, edit := range edits {
+		buf.Write(src[last:edit.Offset])
+		buf.WriteString(edit.NewText)
+		last = edit.End
+	}
+	buf.Write(src[last:])
+	return buf.Bytes()
+}
+
+// substSrc returns the source replacing the range of a substitution within a
+// file, with its lines after the first one at the original indentation.
+func (m *matcher) substSrc(file *ast.File, src []byte, sub submatch) ([]byte, error) {
+	start := m.loader.fset.File(file.Package).Offset(sub.orig.pos)
+	lineStart := bytes.LastIndexByte(src[:start], '\n') + 1
+	indent := src[lineStart:start]
+	indent = indent[:len(indent)-len(bytes.TrimLeft(indent, " \t"))]
+	var comments []*ast.CommentGroup
+	for _, cg := range file.Comments {
+		if cg.Pos() >= sub.orig.pos && cg.End() <= sub.orig.end {
+			comments = append(comments, cg)
+		}
+	}
+	repl, err := replacementSrc(m.loader.fset, sub.node, comments)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Replace(repl, []byte("\n"), append([]byte("\n"), indent...), -1), nil
+}
+
+// replacementSrc prints a node which replaced another, along with the
+// comments that were within the replaced source. Comments which aren't
+// within the node's own range are printed before it.
+func replacementSrc(fset *token.FileSet, node ast.Node, comments []*ast.CommentGroup) ([]byte, error) {
+	var buf bytes.Buffer
+	var inner []*ast.CommentGroup
+	for _, cg := range comments {
+		if node.Pos().IsValid() && cg.Pos() >= node.Pos() && cg.End() <= node.End() {
+			inner = append(inner, cg)
+			continue
+		}
+		for _, c := range cg.List {
+			buf.WriteString(c.Text + "\n")
+		}
+	}
+	switch x := node.(type) {
+	case stmtList:
+		// print the statements as a block, to separate them by
+		// lines, and then drop the braces and their indentation
+		var block bytes.Buffer
+		err := printConfig.Fprint(&block, fset, &printer.CommentedNode{
+			Node: &ast.BlockStmt{List: x}, Comments: inner,
+		})
+		if err != nil {
+			return nil, err
+		}
+		lines := bytes.Split(block.Bytes(), []byte("\n"))
+		lines = lines[1 : len(lines)-1]
+		for i, line := range lines {
+			lines[i] = bytes.TrimPrefix(line, []byte("\t"))
+		}
+		buf.Write(bytes.Join(lines, []byte("\n")))
+		return buf.Bytes(), nil
+	case nodeList:
+		printNode(&buf, fset, node)
+		return buf.Bytes(), nil
+	}
+	err := printConfig.Fprint(&buf, fset, &printer.CommentedNode{
+		Node: node, Comments: inner,
+	})
+	return buf.Bytes(), err
+}
+
 var printConfig = printer.Config{
 	Mode:     printer.UseSpaces | printer.TabIndent,
 	Tabwidth: 8,
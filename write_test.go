@@ -10,13 +10,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestWriteFiles(t *testing.T) {
 	argsList := [][]string{
-		{"-x", "foo", "-s", "bar"},
-		{"-x", "go func() { $f($*a) }()", "-s", "go $f($*a)"},
+		{"-x", "foo", "-s", "bar", "-w"},
+		{"rewrite", "-x", "go func() { $f($*a) }()", "-s", "go $f($*a)"},
+		{"-x", "a(); b($*_)", "-s", "c()", "-w"},
 	}
 	files := []struct{ orig, want string }{
 		{
@@ -40,13 +42,15 @@ func f() {
 			`package p
 
 func f() {
-
 	// comment
 	go fn(0)
-
 }
 `,
 		},
+		{
+			"package p\n\nvar  x = 1\n\nfunc f() {\n\ta()\n\tb(1,\n\t\t2)\n\td()\n}\n",
+			"package p\n\nvar  x = 1\n\nfunc f() {\n\tc()\n\td()\n}\n",
+		},
 	}
 	dir, err := ioutil.TempDir("", "gogrep-write")
 	if err != nil {
@@ -62,7 +66,6 @@ func f() {
 		paths = append(paths, path)
 	}
 	for _, args := range argsList {
-		args = append(args, "-w")
 		args = append(args, paths...)
 
 		m := matcher{ctx: &build.Default}
@@ -90,3 +93,87 @@ func f() {
 		}
 	}
 }
+
+func TestPlanApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogrep-plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	orig := "package p\n\nfunc f(a []int) bool { return len(a) == 0 }\n"
+	want := "package p\n\nfunc f(a []int) bool { return a == nil }\n"
+	path := filepath.Join(dir, "f.go")
+	planPath := filepath.Join(dir, "out.plan")
+	if err := ioutil.WriteFile(path, []byte(orig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := matcher{ctx: &build.Default, out: ioutil.Discard}
+	args := []string{"rewrite", "-plan", planPath, "-x", "len($s) == 0", "-s", "$s == nil", path}
+	if err := m.fromArgs(args); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != orig {
+		t.Fatalf("-plan wrote the file:\n%s", got)
+	}
+	if err := m.fromArgs([]string{"apply", planPath}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(path); string(got) != want {
+		t.Fatalf("want:\n%sgot:\n%s", want, got)
+	}
+	// the file changed since, so the plan is stale
+	err = m.fromArgs([]string{"apply", planPath})
+	if err == nil || !strings.Contains(err.Error(), "files changed since the plan was made") {
+		t.Fatalf("wanted a stale plan error, got %v", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gogrep-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	goMod := "module example.com/app\n\nrequire (\n\texample.com/foo v2.1.0\n\texample.com/bar v1.1.0 // indirect\n)\n"
+	files := map[string]string{
+		"go.mod":     goMod,
+		"go.mod.old": strings.Replace(goMod, "v2.1.0", "v1.4.0", 1),
+		"migrations.json": `{"migrations": [
+			{"name": "foo-v2", "module": "example.com/foo", "from": "<v2", "to": ">=v2.0.0,<v3", "rules": "foo.json"},
+			{"name": "bar-v2", "module": "example.com/bar", "to": ">=v2", "rules": "foo.json"}
+		]}`,
+		"foo.json": `{"rules": [
+			{"name": "rename", "pipeline": ["-x", "foo.Old($x)", "-s", "foo.New($x)"]},
+			{"name": "removed", "pipeline": ["-x", "foo.Removed()"], "message": "foo.Removed is gone"}
+		]}`,
+		"a.go": "package app\n\nfunc f() {\n\tfoo.Old(1)\n\tfoo.Removed()\n}\n",
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var buf bytes.Buffer
+	m := matcher{ctx: &build.Default, out: &buf, errOut: ioutil.Discard}
+	if err := m.fromArgs([]string{"migrate", "-from", "go.mod.old", "migrations.json", "."}); err != nil {
+		t.Fatal(err)
+	}
+	wantOut := "foo-v2: example.com/foo v1.4.0 => v2.1.0\n" +
+		"a.go:5:2: warning: foo.Removed is gone (removed)\n"
+	if got := buf.String(); got != wantOut {
+		t.Fatalf("want output:\n%sgot:\n%s", wantOut, got)
+	}
+	want := "package app\n\nfunc f() {\n\tfoo.New(1)\n\tfoo.Removed()\n}\n"
+	if got, _ := ioutil.ReadFile("a.go"); string(got) != want {
+		t.Fatalf("want:\n%sgot:\n%s", want, got)
+	}
+}